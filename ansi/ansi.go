@@ -0,0 +1,32 @@
+package ansi
+
+import (
+	"io"
+	"regexp"
+)
+
+// escapePattern matches the SGR (color and style) escape sequences.
+var escapePattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// Strip removes the color escape sequences from s.
+func Strip(s string) string {
+	return escapePattern.ReplaceAllString(s, "")
+}
+
+// Writer removes the color escape sequences from everything written through it.
+type Writer struct {
+	writer io.Writer
+}
+
+// NewWriter ...
+func NewWriter(writer io.Writer) Writer {
+	return Writer{writer: writer}
+}
+
+// Write ...
+func (w Writer) Write(p []byte) (int, error) {
+	if _, err := w.writer.Write([]byte(Strip(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
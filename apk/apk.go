@@ -0,0 +1,39 @@
+package apk
+
+import (
+	"archive/zip"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// NativeABIs returns the ABIs the APK ships native libraries for (`lib/<abi>/*.so`).
+// An APK without native libraries runs on any ABI, in this case the list is empty.
+func NativeABIs(pth string) ([]string, error) {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open APK (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close APK (%s), error: %s", pth, err)
+		}
+	}()
+
+	abis := map[string]bool{}
+	for _, file := range reader.File {
+		parts := strings.Split(file.Name, "/")
+		if len(parts) == 3 && parts[0] == "lib" && strings.HasSuffix(parts[2], ".so") {
+			abis[parts[1]] = true
+		}
+	}
+
+	var list []string
+	for abi := range abis {
+		list = append(list, abi)
+	}
+	sort.Strings(list)
+	return list, nil
+}
@@ -0,0 +1,113 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// MaxSize is the size limit of the APKs in Test Lab.
+const MaxSize = 4 << 30
+
+// the magic at the end of the APK Signing Block (v2+ signature scheme), which precedes the central directory
+var signingBlockMagic = []byte("APK Sig Block 42")
+
+const (
+	endOfCentralDirSignature = 0x06054b50
+	endOfCentralDirSize      = 22
+	maxZipCommentSize        = 0xFFFF
+)
+
+// Check verifies that the APK is a valid zip, it is signed and it is not larger than MaxSize.
+func Check(pth string) error {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to get file info of (%s), error: %s", pth, err)
+	}
+	if info.Size() > MaxSize {
+		return fmt.Errorf("%s is %d MB, larger than the %d MB limit of Test Lab", pth, info.Size()>>20, MaxSize>>20)
+	}
+
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid APK, error: %s", pth, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close APK (%s), error: %s", pth, err)
+		}
+	}()
+
+	for _, file := range reader.File {
+		// v1 (JAR) signature
+		if dir, name := path.Split(file.Name); dir == "META-INF/" {
+			switch strings.ToUpper(path.Ext(name)) {
+			case ".RSA", ".DSA", ".EC":
+				return nil
+			}
+		}
+	}
+
+	signed, err := hasSigningBlock(pth)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid APK, error: %s", pth, err)
+	}
+	if !signed {
+		return fmt.Errorf("%s is not signed, unsigned APKs can not be installed on the devices", pth)
+	}
+	return nil
+}
+
+// hasSigningBlock checks if the APK has an APK Signing Block (v2+ signature schemes) before the central directory.
+func hasSigningBlock(pth string) (bool, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close APK (%s), error: %s", pth, err)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// the end of central directory record is at the end of the file, followed by an optional comment
+	tailSize := int64(endOfCentralDirSize + maxZipCommentSize)
+	if tailSize > info.Size() {
+		tailSize = info.Size()
+	}
+	tail := make([]byte, tailSize)
+	if _, err := file.ReadAt(tail, info.Size()-tailSize); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	for i := len(tail) - endOfCentralDirSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != endOfCentralDirSignature {
+			continue
+		}
+
+		centralDirOffset := int64(binary.LittleEndian.Uint32(tail[i+16:]))
+		if centralDirOffset < int64(len(signingBlockMagic)) {
+			return false, nil
+		}
+
+		magic := make([]byte, len(signingBlockMagic))
+		if _, err := file.ReadAt(magic, centralDirOffset-int64(len(magic))); err != nil {
+			return false, err
+		}
+		return bytes.Equal(magic, signingBlockMagic), nil
+	}
+
+	return false, fmt.Errorf("end of central directory not found")
+}
@@ -0,0 +1,138 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeV2SignedAPK writes an APK with an APK Signing Block before the central directory, and a zip comment after it.
+func writeV2SignedAPK(t *testing.T, pth string) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	w, err := writer.Create("AndroidManifest.xml")
+	if err != nil {
+		t.Fatalf("Failed to add AndroidManifest.xml to the APK, error: %s", err)
+	}
+	if _, err := w.Write([]byte("manifest")); err != nil {
+		t.Fatalf("Failed to write AndroidManifest.xml to the APK, error: %s", err)
+	}
+	if err := writer.SetComment("built by the tests"); err != nil {
+		t.Fatalf("Failed to set the zip comment, error: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write APK, error: %s", err)
+	}
+
+	data := buf.Bytes()
+	eocd := bytes.LastIndex(data, []byte{0x50, 0x4b, 0x05, 0x06})
+	centralDirOffset := binary.LittleEndian.Uint32(data[eocd+16:])
+
+	// the size fields and the signatures of the block are not checked
+	block := append(make([]byte, 32), signingBlockMagic...)
+	apk := append([]byte{}, data[:centralDirOffset]...)
+	apk = append(apk, block...)
+	apk = append(apk, data[centralDirOffset:]...)
+	binary.LittleEndian.PutUint32(apk[eocd+len(block)+16:], centralDirOffset+uint32(len(block)))
+
+	if err := ioutil.WriteFile(pth, apk, 0644); err != nil {
+		t.Fatalf("Failed to write APK, error: %s", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apk-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		write   func(pth string)
+		wantErr string
+	}{
+		{
+			name: "v1 signature",
+			write: func(pth string) {
+				writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": nil, "META-INF/CERT.RSA": nil})
+			},
+		},
+		{
+			name: "v1 signature with an EC key",
+			write: func(pth string) {
+				writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": nil, "META-INF/KEY0.ec": nil})
+			},
+		},
+		{
+			name:  "v2 signature",
+			write: func(pth string) { writeV2SignedAPK(t, pth) },
+		},
+		{
+			name: "unsigned",
+			write: func(pth string) {
+				writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": nil, "META-INF/MANIFEST.MF": nil})
+			},
+			wantErr: "is not signed",
+		},
+		{
+			name: "signature file outside of META-INF",
+			write: func(pth string) {
+				writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": nil, "META-INF/keys/CERT.RSA": nil})
+			},
+			wantErr: "is not signed",
+		},
+		{
+			name: "not a zip",
+			write: func(pth string) {
+				if err := ioutil.WriteFile(pth, []byte("<manifest/>"), 0644); err != nil {
+					t.Fatalf("Failed to write file, error: %s", err)
+				}
+			},
+			wantErr: "is not a valid APK",
+		},
+		{
+			name: "larger than the limit",
+			write: func(pth string) {
+				writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": nil, "META-INF/CERT.RSA": nil})
+				// a sparse file, the size is checked before the content
+				if err := os.Truncate(pth, MaxSize+1); err != nil {
+					t.Fatalf("Failed to resize file, error: %s", err)
+				}
+			},
+			wantErr: "larger than the 4096 MB limit of Test Lab",
+		},
+		{
+			name:    "missing",
+			write:   func(pth string) {},
+			wantErr: "Failed to get file info",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1)+".apk")
+			tt.write(pth)
+
+			err := Check(pth)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Check() unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package apk
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Manifest is the part of the AndroidManifest.xml the step is interested in.
+type Manifest struct {
+	Package          string
+	Debuggable       bool
+	Instrumentations []Instrumentation
+	// MinSdkVersion and TargetSdkVersion are the API levels of the <uses-sdk> element, 0 if not set
+	MinSdkVersion    int
+	TargetSdkVersion int
+}
+
+// Instrumentation is an <instrumentation> element of the manifest.
+type Instrumentation struct {
+	Name          string
+	TargetPackage string
+}
+
+// RunnerClass returns the fully-qualified class name of the last instrumentation runner of the manifest,
+// or an empty string if the manifest has no instrumentation.
+func (manifest Manifest) RunnerClass() string {
+	if len(manifest.Instrumentations) == 0 {
+		return ""
+	}
+	return manifest.Instrumentations[len(manifest.Instrumentations)-1].Name
+}
+
+// binary XML chunk types
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkResourceMap  = 0x0180
+	chunkStartElement = 0x0102
+)
+
+// android attribute resource IDs, used if the attribute names are stripped from the string pool
+const (
+	attrName          = 0x01010003
+	attrDebuggable    = 0x0101000f
+	attrTargetPackage = 0x01010021
+	attrMinSdk        = 0x0101020c
+	attrTargetSdk     = 0x01010270
+)
+
+const (
+	noIndex        = 0xFFFFFFFF
+	typeString     = 0x03
+	typeIntDec     = 0x10
+	typeIntHex     = 0x11
+	typeBoolean    = 0x12
+	utf8StringFlag = 1 << 8
+)
+
+// ReadManifest reads the compiled AndroidManifest.xml of the APK.
+func ReadManifest(pth string) (Manifest, error) {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("Failed to open APK (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close APK (%s), error: %s", pth, err)
+		}
+	}()
+
+	for _, file := range reader.File {
+		if file.Name != "AndroidManifest.xml" {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return Manifest{}, fmt.Errorf("Failed to open AndroidManifest.xml of (%s), error: %s", pth, err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		if cerr := rc.Close(); cerr != nil {
+			log.Warnf("Failed to close AndroidManifest.xml of (%s), error: %s", pth, cerr)
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("Failed to read AndroidManifest.xml of (%s), error: %s", pth, err)
+		}
+
+		manifest, err := parseManifest(data)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("Failed to parse AndroidManifest.xml of (%s), error: %s", pth, err)
+		}
+		return manifest, nil
+	}
+
+	return Manifest{}, fmt.Errorf("No AndroidManifest.xml found in (%s)", pth)
+}
+
+// parseManifest walks the chunks of the binary XML (AXML) and picks the attributes
+// of the <manifest>, <uses-sdk>, <application> and <instrumentation> elements.
+func parseManifest(data []byte) (Manifest, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data) != chunkXML {
+		return Manifest{}, fmt.Errorf("not a binary XML")
+	}
+	// the chunks are only checked one by one, a truncated file could end on a chunk boundary
+	if size := binary.LittleEndian.Uint32(data[4:]); int64(size) > int64(len(data)) {
+		return Manifest{}, fmt.Errorf("truncated binary XML, %d of %d bytes", len(data), size)
+	}
+
+	var manifest Manifest
+	var pool []string
+	var resourceIDs []uint32
+
+	offset := int(binary.LittleEndian.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		chunkType := binary.LittleEndian.Uint16(data[offset:])
+		headerSize := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if size < 8 || offset+size > len(data) {
+			return Manifest{}, fmt.Errorf("invalid chunk size (%d) at offset %d", size, offset)
+		}
+		chunk := data[offset : offset+size]
+
+		switch chunkType {
+		case chunkStringPool:
+			var err error
+			if pool, err = parseStringPool(chunk); err != nil {
+				return Manifest{}, err
+			}
+		case chunkResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resourceIDs = append(resourceIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case chunkStartElement:
+			element, err := parseStartElement(chunk, headerSize, pool, resourceIDs)
+			if err != nil {
+				return Manifest{}, err
+			}
+
+			switch element.name {
+			case "manifest":
+				manifest.Package = element.attributes["package"]
+			case "uses-sdk":
+				// the preview codenames are not API levels, they are left 0
+				manifest.MinSdkVersion, _ = strconv.Atoi(element.attributes["minSdkVersion"])
+				manifest.TargetSdkVersion, _ = strconv.Atoi(element.attributes["targetSdkVersion"])
+			case "application":
+				manifest.Debuggable = element.attributes["debuggable"] == "true"
+			case "instrumentation":
+				manifest.Instrumentations = append(manifest.Instrumentations, Instrumentation{
+					Name:          element.attributes["name"],
+					TargetPackage: element.attributes["targetPackage"],
+				})
+			}
+		}
+
+		offset += size
+	}
+
+	// relative class names are relative to the package of the manifest
+	for i, instrumentation := range manifest.Instrumentations {
+		if strings.HasPrefix(instrumentation.Name, ".") {
+			manifest.Instrumentations[i].Name = manifest.Package + instrumentation.Name
+		}
+	}
+
+	return manifest, nil
+}
+
+type element struct {
+	name       string
+	attributes map[string]string
+}
+
+func parseStartElement(chunk []byte, headerSize int, pool []string, resourceIDs []uint32) (element, error) {
+	// the header is followed by the namespace, name, attributeStart, attributeSize and attributeCount
+	if headerSize+14 > len(chunk) {
+		return element{}, fmt.Errorf("invalid start element chunk")
+	}
+	ext := chunk[headerSize:]
+	el := element{
+		name:       poolString(pool, binary.LittleEndian.Uint32(ext[4:])),
+		attributes: map[string]string{},
+	}
+	attributeStart := int(binary.LittleEndian.Uint16(ext[8:]))
+	attributeSize := int(binary.LittleEndian.Uint16(ext[10:]))
+	attributeCount := int(binary.LittleEndian.Uint16(ext[12:]))
+	if attributeSize < 20 {
+		attributeSize = 20
+	}
+
+	for i := 0; i < attributeCount; i++ {
+		attr := headerSize + attributeStart + i*attributeSize
+		if attr+20 > len(chunk) {
+			return element{}, fmt.Errorf("invalid attribute in element (%s)", el.name)
+		}
+
+		nameIndex := binary.LittleEndian.Uint32(chunk[attr+4:])
+		name := poolString(pool, nameIndex)
+		if int(nameIndex) < len(resourceIDs) {
+			switch resourceIDs[nameIndex] {
+			case attrName:
+				name = "name"
+			case attrDebuggable:
+				name = "debuggable"
+			case attrTargetPackage:
+				name = "targetPackage"
+			case attrMinSdk:
+				name = "minSdkVersion"
+			case attrTargetSdk:
+				name = "targetSdkVersion"
+			}
+		}
+
+		value := ""
+		if rawValue := binary.LittleEndian.Uint32(chunk[attr+8:]); rawValue != noIndex {
+			value = poolString(pool, rawValue)
+		} else {
+			switch data := binary.LittleEndian.Uint32(chunk[attr+16:]); chunk[attr+15] {
+			case typeString:
+				value = poolString(pool, data)
+			case typeIntDec, typeIntHex:
+				value = strconv.Itoa(int(int32(data)))
+			case typeBoolean:
+				value = strconv.FormatBool(data != 0)
+			}
+		}
+
+		el.attributes[name] = value
+	}
+
+	return el, nil
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("invalid string pool")
+	}
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	flags := binary.LittleEndian.Uint32(chunk[16:])
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	if headerSize+count*4 > len(chunk) {
+		return nil, fmt.Errorf("invalid string pool")
+	}
+
+	pool := make([]string, count)
+	for i := range pool {
+		start := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+i*4:]))
+		if start >= len(chunk) {
+			return nil, fmt.Errorf("invalid string offset in string pool")
+		}
+
+		var err error
+		if flags&utf8StringFlag != 0 {
+			pool[i], err = decodeUTF8(chunk[start:])
+		} else {
+			pool[i], err = decodeUTF16(chunk[start:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+func decodeUTF8(data []byte) (string, error) {
+	// the UTF-16 length, then the UTF-8 length, both 1 or 2 bytes long
+	_, n := utf8Length(data)
+	length, m := utf8Length(data[n:])
+	start := n + m
+	if start+length > len(data) {
+		return "", fmt.Errorf("invalid string in string pool")
+	}
+	return string(data[start : start+length]), nil
+}
+
+func utf8Length(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	if data[0]&0x80 != 0 && len(data) > 1 {
+		return int(data[0]&0x7F)<<8 | int(data[1]), 2
+	}
+	return int(data[0]), 1
+}
+
+func decodeUTF16(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", fmt.Errorf("invalid string in string pool")
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	start := 2
+	if length&0x8000 != 0 {
+		if len(data) < 4 {
+			return "", fmt.Errorf("invalid string in string pool")
+		}
+		length = (length&0x7FFF)<<16 | int(binary.LittleEndian.Uint16(data[2:]))
+		start = 4
+	}
+	if start+length*2 > len(data) {
+		return "", fmt.Errorf("invalid string in string pool")
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[start+i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func poolString(pool []string, index uint32) string {
+	if int(index) >= len(pool) {
+		return ""
+	}
+	return pool[index]
+}
@@ -0,0 +1,381 @@
+package apk
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// axmlAttribute is an attribute of a test manifest, a string if typ is typeString.
+type axmlAttribute struct {
+	name       string
+	resourceID uint32
+	typ        byte
+	str        string
+	data       uint32
+}
+
+type axmlElement struct {
+	name       string
+	attributes []axmlAttribute
+}
+
+func put16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func put32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// buildManifest compiles the elements to a binary XML, like aapt does. The attributes with a resource ID
+// are listed first in the string pool, their names are left empty if stripNames is set.
+func buildManifest(elements []axmlElement, utf8Pool, stripNames bool) []byte {
+	var pool []string
+	var resourceIDs []uint32
+	attributeIndex := map[string]uint32{}
+	for _, el := range elements {
+		for _, attr := range el.attributes {
+			if _, ok := attributeIndex[attr.name]; attr.resourceID == 0 || ok {
+				continue
+			}
+			attributeIndex[attr.name] = uint32(len(pool))
+			name := attr.name
+			if stripNames {
+				name = ""
+			}
+			pool = append(pool, name)
+			resourceIDs = append(resourceIDs, attr.resourceID)
+		}
+	}
+	index := func(s string) uint32 {
+		for i := len(resourceIDs); i < len(pool); i++ {
+			if pool[i] == s {
+				return uint32(i)
+			}
+		}
+		pool = append(pool, s)
+		return uint32(len(pool) - 1)
+	}
+
+	var body []byte
+	for _, el := range elements {
+		name := index(el.name)
+		body = put16(body, chunkStartElement)
+		body = put16(body, 16)
+		body = put32(body, uint32(16+20+20*len(el.attributes)))
+		body = put32(body, 1)
+		body = put32(body, noIndex)
+		body = put32(body, noIndex)
+		body = put32(body, name)
+		body = put16(body, 20)
+		body = put16(body, 20)
+		body = put16(body, uint16(len(el.attributes)))
+		body = put16(body, 0)
+		body = put16(body, 0)
+		body = put16(body, 0)
+		for _, attr := range el.attributes {
+			nameIndex, ok := attributeIndex[attr.name]
+			if !ok {
+				nameIndex = index(attr.name)
+			}
+			rawValue, data := uint32(noIndex), attr.data
+			if attr.typ == typeString {
+				rawValue = index(attr.str)
+				data = rawValue
+			}
+			body = put32(body, noIndex)
+			body = put32(body, nameIndex)
+			body = put32(body, rawValue)
+			body = put16(body, 8)
+			body = append(body, 0, attr.typ)
+			body = put32(body, data)
+		}
+
+		// the end element chunks are skipped
+		body = put16(body, 0x0103)
+		body = put16(body, 16)
+		body = put32(body, 24)
+		body = put32(body, 1)
+		body = put32(body, noIndex)
+		body = put32(body, noIndex)
+		body = put32(body, name)
+	}
+
+	var offsets, stringData []byte
+	for _, s := range pool {
+		offsets = put32(offsets, uint32(len(stringData)))
+		if utf8Pool {
+			for _, length := range []int{len(utf16.Encode([]rune(s))), len(s)} {
+				if length > 0x7F {
+					stringData = append(stringData, byte(length>>8|0x80))
+				}
+				stringData = append(stringData, byte(length))
+			}
+			stringData = append(append(stringData, s...), 0)
+			continue
+		}
+		units := utf16.Encode([]rune(s))
+		if len(units) > 0x7FFF {
+			stringData = put16(stringData, uint16(len(units)>>16|0x8000))
+		}
+		stringData = put16(stringData, uint16(len(units)))
+		for _, unit := range units {
+			stringData = put16(stringData, unit)
+		}
+		stringData = put16(stringData, 0)
+	}
+	for len(stringData)%4 != 0 {
+		stringData = append(stringData, 0)
+	}
+
+	var flags uint32
+	if utf8Pool {
+		flags = utf8StringFlag
+	}
+	var stringPool []byte
+	stringPool = put16(stringPool, chunkStringPool)
+	stringPool = put16(stringPool, 28)
+	stringPool = put32(stringPool, uint32(28+len(offsets)+len(stringData)))
+	stringPool = put32(stringPool, uint32(len(pool)))
+	stringPool = put32(stringPool, 0)
+	stringPool = put32(stringPool, flags)
+	stringPool = put32(stringPool, uint32(28+len(offsets)))
+	stringPool = put32(stringPool, 0)
+	stringPool = append(append(stringPool, offsets...), stringData...)
+
+	var resourceMap []byte
+	resourceMap = put16(resourceMap, chunkResourceMap)
+	resourceMap = put16(resourceMap, 8)
+	resourceMap = put32(resourceMap, uint32(8+4*len(resourceIDs)))
+	for _, id := range resourceIDs {
+		resourceMap = put32(resourceMap, id)
+	}
+
+	var manifest []byte
+	manifest = put16(manifest, chunkXML)
+	manifest = put16(manifest, 8)
+	manifest = put32(manifest, uint32(8+len(stringPool)+len(resourceMap)+len(body)))
+	manifest = append(manifest, stringPool...)
+	manifest = append(manifest, resourceMap...)
+	return append(manifest, body...)
+}
+
+// testManifestElements are the elements of an instrumentation test APK's manifest, with a relative runner class name.
+func testManifestElements() []axmlElement {
+	return []axmlElement{
+		{name: "manifest", attributes: []axmlAttribute{
+			{name: "versionCode", resourceID: 0x0101021b, typ: typeIntDec, data: 1},
+			{name: "package", typ: typeString, str: "com.example.test"},
+		}},
+		{name: "uses-sdk", attributes: []axmlAttribute{
+			{name: "minSdkVersion", resourceID: attrMinSdk, typ: typeIntDec, data: 21},
+			{name: "targetSdkVersion", resourceID: attrTargetSdk, typ: typeIntHex, data: 0x21},
+		}},
+		{name: "application", attributes: []axmlAttribute{
+			{name: "debuggable", resourceID: attrDebuggable, typ: typeBoolean, data: 0xFFFFFFFF},
+		}},
+		{name: "instrumentation", attributes: []axmlAttribute{
+			{name: "name", resourceID: attrName, typ: typeString, str: ".runner.CustomRunner"},
+			{name: "targetPackage", resourceID: attrTargetPackage, typ: typeString, str: "com.example"},
+		}},
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	longRunner := "com.example." + strings.Repeat("nested.", 20) + "Runner"
+	releaseElements := []axmlElement{
+		{name: "manifest", attributes: []axmlAttribute{{name: "package", typ: typeString, str: "com.example"}}},
+		{name: "uses-sdk", attributes: []axmlAttribute{
+			{name: "minSdkVersion", resourceID: attrMinSdk, typ: typeIntDec, data: 24},
+			{name: "targetSdkVersion", resourceID: attrTargetSdk, typ: typeString, str: "UpsideDownCake"},
+		}},
+		{name: "application", attributes: []axmlAttribute{
+			{name: "debuggable", resourceID: attrDebuggable, typ: typeBoolean, data: 0},
+		}},
+		{name: "instrumentation", attributes: []axmlAttribute{
+			{name: "name", resourceID: attrName, typ: typeString, str: "androidx.test.runner.AndroidJUnitRunner"},
+		}},
+		{name: "instrumentation", attributes: []axmlAttribute{
+			{name: "name", resourceID: attrName, typ: typeString, str: longRunner},
+			{name: "targetPackage", resourceID: attrTargetPackage, typ: typeString, str: "com.example"},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		manifest   []byte
+		want       Manifest
+		wantRunner string
+	}{
+		{
+			name:     "UTF-16 string pool",
+			manifest: buildManifest(testManifestElements(), false, false),
+			want: Manifest{
+				Package:          "com.example.test",
+				Debuggable:       true,
+				Instrumentations: []Instrumentation{{Name: "com.example.test.runner.CustomRunner", TargetPackage: "com.example"}},
+				MinSdkVersion:    21,
+				TargetSdkVersion: 33,
+			},
+			wantRunner: "com.example.test.runner.CustomRunner",
+		},
+		{
+			name:     "UTF-8 string pool with stripped attribute names",
+			manifest: buildManifest(testManifestElements(), true, true),
+			want: Manifest{
+				Package:          "com.example.test",
+				Debuggable:       true,
+				Instrumentations: []Instrumentation{{Name: "com.example.test.runner.CustomRunner", TargetPackage: "com.example"}},
+				MinSdkVersion:    21,
+				TargetSdkVersion: 33,
+			},
+			wantRunner: "com.example.test.runner.CustomRunner",
+		},
+		{
+			name:     "release build with a preview target SDK and a long runner name",
+			manifest: buildManifest(releaseElements, true, false),
+			want: Manifest{
+				Package: "com.example",
+				Instrumentations: []Instrumentation{
+					{Name: "androidx.test.runner.AndroidJUnitRunner"},
+					{Name: longRunner, TargetPackage: "com.example"},
+				},
+				MinSdkVersion: 24,
+			},
+			wantRunner: longRunner,
+		},
+		{
+			name:     "UTF-16 string pool with a long runner name",
+			manifest: buildManifest(releaseElements, false, true),
+			want: Manifest{
+				Package: "com.example",
+				Instrumentations: []Instrumentation{
+					{Name: "androidx.test.runner.AndroidJUnitRunner"},
+					{Name: longRunner, TargetPackage: "com.example"},
+				},
+				MinSdkVersion: 24,
+			},
+			wantRunner: longRunner,
+		},
+		{
+			name:     "no instrumentation",
+			manifest: buildManifest(testManifestElements()[:3], false, false),
+			want: Manifest{
+				Package:          "com.example.test",
+				Debuggable:       true,
+				MinSdkVersion:    21,
+				TargetSdkVersion: 33,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseManifest(tt.manifest)
+			if err != nil {
+				t.Fatalf("parseManifest() unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseManifest() = %+v, want %+v", got, tt.want)
+			}
+			if runner := got.RunnerClass(); runner != tt.wantRunner {
+				t.Errorf("RunnerClass() = %s, want %s", runner, tt.wantRunner)
+			}
+		})
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	manifest := buildManifest(testManifestElements(), false, false)
+
+	invalidChunkSize := append([]byte{}, manifest...)
+	// the size of the string pool chunk
+	invalidChunkSize[12] = 0xFF
+
+	tests := []struct {
+		name     string
+		manifest []byte
+		wantErr  string
+	}{
+		{name: "empty", manifest: nil, wantErr: "not a binary XML"},
+		{name: "text XML", manifest: []byte(`<?xml version="1.0" encoding="utf-8"?><manifest/>`), wantErr: "not a binary XML"},
+		{name: "invalid chunk size", manifest: invalidChunkSize, wantErr: "invalid chunk size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseManifest(tt.manifest)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("parseManifest() error = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, utf8Pool := range []bool{false, true} {
+			manifest := buildManifest(testManifestElements(), utf8Pool, false)
+			for length := 0; length < len(manifest); length++ {
+				if _, err := parseManifest(manifest[:length]); err == nil {
+					t.Errorf("parseManifest() of the first %d of %d bytes succeeded, want an error", length, len(manifest))
+				}
+			}
+		}
+	})
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apk-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	pth := filepath.Join(dir, "app-androidTest.apk")
+	writeZip(t, pth, map[string][]byte{"AndroidManifest.xml": buildManifest(testManifestElements(), false, false)})
+	manifest, err := ReadManifest(pth)
+	if err != nil {
+		t.Fatalf("ReadManifest() unexpected error: %s", err)
+	}
+	if manifest.Package != "com.example.test" || manifest.RunnerClass() != "com.example.test.runner.CustomRunner" {
+		t.Errorf("ReadManifest() = %+v", manifest)
+	}
+
+	noManifest := filepath.Join(dir, "no-manifest.apk")
+	writeZip(t, noManifest, map[string][]byte{"classes.dex": []byte("dex")})
+	if _, err := ReadManifest(noManifest); err == nil || !strings.HasPrefix(err.Error(), "No AndroidManifest.xml found") {
+		t.Errorf("ReadManifest() error = %v, want no manifest found", err)
+	}
+}
+
+// writeZip writes the files to a zip archive at pth.
+func writeZip(t *testing.T, pth string, files map[string][]byte) {
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("Failed to create zip, error: %s", err)
+	}
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to the zip, error: %s", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("Failed to write %s to the zip, error: %s", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write zip, error: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close zip, error: %s", err)
+	}
+}
@@ -0,0 +1,210 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/glob"
+)
+
+// downloadAttempts is the number of times a test asset download is tried, waiting attempt*downloadRetryWait between the attempts.
+const downloadAttempts = 3
+
+var downloadRetryWait = 5 * time.Second
+
+// Downloader lists and downloads the test assets.
+type Downloader interface {
+	GetAssets(ctx context.Context) (map[string]string, error)
+	DownloadFile(ctx context.Context, fileURL, pth string) error
+}
+
+// PulledFiles selects the pulled files to download from the pulled directories.
+type PulledFiles struct {
+	// Directories are downloaded with every file
+	Directories []string
+	// Patterns select the files to download from the directories pulled for them
+	Patterns []string
+}
+
+// Wanted returns false for the pulled files which were pulled only for the directory of a pattern, but do not match any pattern.
+// The pulled files are stored in the `<device ID>/artifacts` directory of the test assets, along with their device path.
+func (pulled PulledFiles) Wanted(fileName string) bool {
+	parts := strings.SplitN(fileName, "/", 3)
+	if len(parts) < 3 || parts[1] != "artifacts" {
+		return true
+	}
+	devicePath := "/" + parts[2]
+
+	for _, dir := range pulled.Directories {
+		if isUnder(devicePath, dir) {
+			return true
+		}
+	}
+	for _, pattern := range pulled.Patterns {
+		if glob.Match(pattern, devicePath) {
+			return true
+		}
+	}
+	for _, pattern := range pulled.Patterns {
+		if isUnder(devicePath, glob.Root(pattern)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isUnder(pth, dir string) bool {
+	dir = path.Clean(dir)
+	return strings.HasPrefix(path.Clean(pth), strings.TrimSuffix(dir, "/")+"/")
+}
+
+// Download downloads the test assets wanted by pulled into dir.
+// The files of the devices in prefixDevices are renamed to `<device ID>_<file name>`,
+// so that the generic file names (like `video.mp4` or `logcat`) tell which device they belong to.
+// A file which fails to download even when retried does not stop the download of the others,
+// the errors of such files are returned keyed by the asset name.
+func Download(ctx context.Context, downloader Downloader, dir string, prefixDevices map[string]bool, pulled PulledFiles) (map[string]error, error) {
+	files, err := downloader.GetAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := map[string]error{}
+	for fileName, fileURL := range files {
+		if !pulled.Wanted(fileName) {
+			continue
+		}
+
+		pth := filepath.Join(dir, filepath.FromSlash(PrefixedName(fileName, prefixDevices)))
+		// the assets of a device are grouped under a Model-Version-Locale-Orientation directory
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create directory, error: %s", err)
+		}
+
+		if err := downloadFile(ctx, downloader, fileURL, pth); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			failed[fileName] = err
+		}
+	}
+
+	return failed, nil
+}
+
+// downloadFile downloads the file, retrying it downloadAttempts times. The partially downloaded file is removed if every attempt fails.
+func downloadFile(ctx context.Context, downloader Downloader, fileURL, pth string) error {
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if err = downloader.DownloadFile(ctx, fileURL, pth); err == nil {
+			return nil
+		}
+		if attempt == downloadAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * downloadRetryWait):
+		}
+	}
+
+	if rerr := os.Remove(pth); rerr != nil && !os.IsNotExist(rerr) {
+		return fmt.Errorf("%s, and failed to remove the partial file, error: %s", err, rerr)
+	}
+	return err
+}
+
+// PrefixedName returns the asset name with the device ID prefixed to the file name,
+// if the asset is in the directory of a device in prefixDevices.
+func PrefixedName(fileName string, prefixDevices map[string]bool) string {
+	parts := strings.SplitN(fileName, "/", 2)
+	if len(parts) < 2 || !prefixDevices[parts[0]] {
+		return fileName
+	}
+	return path.Join(path.Dir(fileName), parts[0]+"_"+path.Base(fileName))
+}
+
+// ReadTestResults returns the content of the JUnit XML reports of the device.
+func ReadTestResults(ctx context.Context, reader FileReader, files map[string]string, deviceID string) ([][]byte, error) {
+	contents, err := ReadDeviceFiles(ctx, reader, files, deviceID, "test_result_*.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	var results [][]byte
+	for _, content := range contents {
+		results = append(results, content)
+	}
+	return results, nil
+}
+
+// ReadDeviceFiles returns the content of the device's assets whose file name matches pattern, keyed by the file name.
+func ReadDeviceFiles(ctx context.Context, reader FileReader, files map[string]string, deviceID, pattern string) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	for fileName, fileURL := range files {
+		if !strings.HasPrefix(fileName, deviceID+"/") {
+			continue
+		}
+		if match, err := path.Match(pattern, path.Base(fileName)); err != nil || !match {
+			continue
+		}
+
+		content, err := reader.ReadFile(ctx, fileURL, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s, error: %s", fileName, err)
+		}
+		contents[fileName] = content
+	}
+	return contents, nil
+}
+
+// DownloadDeviceFiles downloads the assets matching any of the patterns into a per-device subdirectory of dir,
+// and returns the downloaded file paths, and the errors of the assets which failed to download keyed by the asset name.
+func DownloadDeviceFiles(ctx context.Context, downloader Downloader, dir string, patterns ...string) ([]string, map[string]error, error) {
+	files, err := downloader.GetAssets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var downloaded []string
+	failed := map[string]error{}
+	for fileName, fileURL := range files {
+		// the assets of a device are grouped under a Model-Version-Locale-Orientation directory
+		parts := strings.SplitN(fileName, "/", 2)
+		if len(parts) < 2 || !matchesAny(path.Base(fileName), patterns) {
+			continue
+		}
+
+		deviceDir := filepath.Join(dir, parts[0])
+		if err := os.MkdirAll(deviceDir, 0755); err != nil {
+			return nil, nil, fmt.Errorf("Failed to create directory, error: %s", err)
+		}
+
+		pth := filepath.Join(deviceDir, path.Base(fileName))
+		if err := downloadFile(ctx, downloader, fileURL, pth); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			failed[fileName] = err
+			continue
+		}
+		downloaded = append(downloaded, pth)
+	}
+	return downloaded, failed, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if match, err := path.Match(pattern, name); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
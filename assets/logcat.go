@@ -0,0 +1,73 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// FileReader lists the test assets and reads them from a given offset.
+type FileReader interface {
+	GetAssets(ctx context.Context) (map[string]string, error)
+	ReadFile(ctx context.Context, fileURL string, offset int64) ([]byte, error)
+}
+
+// DeviceID returns the ID of a device, as used in the test asset names (`Model-Version-Locale-Orientation`).
+func DeviceID(dimensions map[string]string) string {
+	return strings.Join([]string{dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"]}, "-")
+}
+
+// LogcatStreamer tails the logcat of the running devices.
+type LogcatStreamer struct {
+	reader  FileReader
+	offsets map[string]int64
+	partial map[string]string
+}
+
+// NewLogcatStreamer ...
+func NewLogcatStreamer(reader FileReader) *LogcatStreamer {
+	return &LogcatStreamer{
+		reader:  reader,
+		offsets: map[string]int64{},
+		partial: map[string]string{},
+	}
+}
+
+// Stream prints the logcat lines written since the last call for the given devices, prefixed with the device ID.
+func (s *LogcatStreamer) Stream(ctx context.Context, out io.Writer, deviceIDs []string) error {
+	files, err := s.reader.GetAssets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, deviceID := range deviceIDs {
+		fileURL := ""
+		for fileName, url := range files {
+			if path.Base(fileName) == "logcat" && strings.HasPrefix(fileName, deviceID+"/") {
+				fileURL = url
+				break
+			}
+		}
+		if fileURL == "" {
+			continue
+		}
+
+		content, err := s.reader.ReadFile(ctx, fileURL, s.offsets[deviceID])
+		if err != nil {
+			return fmt.Errorf("Failed to read logcat of %s, error: %s", deviceID, err)
+		}
+		s.offsets[deviceID] += int64(len(content))
+
+		lines := strings.Split(s.partial[deviceID]+string(content), "\n")
+		// the last line is incomplete until a newline arrives
+		s.partial[deviceID] = lines[len(lines)-1]
+
+		for _, line := range lines[:len(lines)-1] {
+			fmt.Fprintf(out, "[%s] %s\n", deviceID, strings.TrimRight(line, "\r"))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+var screenshotExtensions = []string{".png", ".jpg", ".jpeg", ".webp"}
+
+// CollectScreenshots copies the screenshots of the downloaded assets into a per-device subdirectory of dir.
+// It returns the copied screenshot file names grouped by device ID.
+func CollectScreenshots(assetsDir, dir string) (map[string][]string, error) {
+	screenshots := map[string][]string{}
+
+	err := filepath.Walk(assetsDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isScreenshot(pth) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(assetsDir, pth)
+		if err != nil {
+			return err
+		}
+		// assets outside of a device directory can not be assigned to a device
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+		if len(parts) < 2 {
+			return nil
+		}
+		deviceID := parts[0]
+
+		deviceDir := filepath.Join(dir, deviceID)
+		if err := os.MkdirAll(deviceDir, 0755); err != nil {
+			return fmt.Errorf("Failed to create directory, error: %s", err)
+		}
+
+		name := uniqueName(screenshots[deviceID], filepath.Base(pth))
+		if err := copyFile(pth, filepath.Join(deviceDir, name)); err != nil {
+			return err
+		}
+		screenshots[deviceID] = append(screenshots[deviceID], name)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to collect screenshots, error: %s", err)
+	}
+
+	for _, names := range screenshots {
+		sort.Strings(names)
+	}
+	return screenshots, nil
+}
+
+func isScreenshot(pth string) bool {
+	ext := strings.ToLower(filepath.Ext(pth))
+	for _, screenshotExt := range screenshotExtensions {
+		if ext == screenshotExt {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName prefixes the name with a counter if a screenshot with the same name was already collected for the device.
+func uniqueName(names []string, name string) string {
+	candidate := name
+	for i := 1; ; i++ {
+		taken := false
+		for _, existing := range names {
+			if existing == candidate {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%d_%s", i, name)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Failed to open file (%s), error: %s", src, err)
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", src, err)
+		}
+	}()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("Failed to create file (%s), error: %s", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("Failed to copy file (%s), error: %s", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,73 @@
+package assets
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Zip compresses the files of dir into the zip archive at pth, keeping their paths relative to dir.
+func Zip(dir, pth string) (err error) {
+	archive, err := os.Create(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to create zip file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if cerr := archive.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Failed to close zip file (%s), error: %s", pth, cerr)
+		}
+	}()
+
+	writer := zip.NewWriter(archive)
+	if err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyInto(entry, file)
+	}); err != nil {
+		return fmt.Errorf("Failed to zip (%s), error: %s", dir, err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("Failed to write zip file (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+func copyInto(w io.Writer, pth string) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,201 @@
+package catalog
+
+import (
+	"strings"
+)
+
+// Catalog is the catalog of the available test environments.
+type Catalog struct {
+	AndroidDeviceCatalog        *AndroidDeviceCatalog        `json:"androidDeviceCatalog,omitempty"`
+	NetworkConfigurationCatalog *NetworkConfigurationCatalog `json:"networkConfigurationCatalog,omitempty"`
+}
+
+// AndroidDeviceCatalog ...
+type AndroidDeviceCatalog struct {
+	Models               []*AndroidModel              `json:"models,omitempty"`
+	Versions             []*AndroidVersion            `json:"versions,omitempty"`
+	RuntimeConfiguration *AndroidRuntimeConfiguration `json:"runtimeConfiguration,omitempty"`
+}
+
+// AndroidModel ...
+type AndroidModel struct {
+	ID                  string            `json:"id,omitempty"`
+	Name                string            `json:"name,omitempty"`
+	Manufacturer        string            `json:"manufacturer,omitempty"`
+	Form                string            `json:"form,omitempty"`
+	FormFactor          string            `json:"formFactor,omitempty"`
+	SupportedVersionIDs []string          `json:"supportedVersionIds,omitempty"`
+	SupportedAbis       []string          `json:"supportedAbis,omitempty"`
+	Tags                []string          `json:"tags,omitempty"`
+	PerVersionInfo      []*PerVersionInfo `json:"perVersionInfo,omitempty"`
+}
+
+// PerVersionInfo ...
+type PerVersionInfo struct {
+	VersionID      string `json:"versionId,omitempty"`
+	DeviceCapacity string `json:"deviceCapacity,omitempty"`
+}
+
+// AndroidVersion ...
+type AndroidVersion struct {
+	ID            string   `json:"id,omitempty"`
+	VersionString string   `json:"versionString,omitempty"`
+	APILevel      int      `json:"apiLevel,omitempty"`
+	CodeName      string   `json:"codeName,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// AndroidRuntimeConfiguration ...
+type AndroidRuntimeConfiguration struct {
+	Locales      []*Locale      `json:"locales,omitempty"`
+	Orientations []*Orientation `json:"orientations,omitempty"`
+}
+
+// Locale ...
+type Locale struct {
+	ID     string   `json:"id,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Region string   `json:"region,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// Orientation ...
+type Orientation struct {
+	ID   string   `json:"id,omitempty"`
+	Name string   `json:"name,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// NetworkConfigurationCatalog ...
+type NetworkConfigurationCatalog struct {
+	Configurations []*NetworkConfiguration `json:"configurations,omitempty"`
+}
+
+// NetworkConfiguration ...
+type NetworkConfiguration struct {
+	ID       string       `json:"id,omitempty"`
+	UpRule   *TrafficRule `json:"upRule,omitempty"`
+	DownRule *TrafficRule `json:"downRule,omitempty"`
+}
+
+// TrafficRule ...
+type TrafficRule struct {
+	// Bandwidth is in kbit/s
+	Bandwidth       float64 `json:"bandwidth,omitempty"`
+	Delay           string  `json:"delay,omitempty"`
+	PacketLossRatio float64 `json:"packetLossRatio,omitempty"`
+}
+
+// LocaleIDs returns the IDs of the locales, or nil if the catalog does not list any,
+// so that the locales are not validated against an empty list.
+func (c *Catalog) LocaleIDs() []string {
+	if c.AndroidDeviceCatalog == nil || c.AndroidDeviceCatalog.RuntimeConfiguration == nil || len(c.AndroidDeviceCatalog.RuntimeConfiguration.Locales) == 0 {
+		return nil
+	}
+	ids := []string{}
+	for _, locale := range c.AndroidDeviceCatalog.RuntimeConfiguration.Locales {
+		ids = append(ids, locale.ID)
+	}
+	return ids
+}
+
+// DefaultOrientation returns the ID of the orientation tagged as default, or an empty string if the catalog does not tell.
+func (c *Catalog) DefaultOrientation() string {
+	if c.AndroidDeviceCatalog == nil || c.AndroidDeviceCatalog.RuntimeConfiguration == nil {
+		return ""
+	}
+	for _, orientation := range c.AndroidDeviceCatalog.RuntimeConfiguration.Orientations {
+		for _, tag := range orientation.Tags {
+			if tag == "default" {
+				return orientation.ID
+			}
+		}
+	}
+	return ""
+}
+
+// NetworkProfileIDs returns the IDs of the network profiles, or nil if the catalog does not contain them.
+func (c *Catalog) NetworkProfileIDs() []string {
+	if c.NetworkConfigurationCatalog == nil {
+		return nil
+	}
+	ids := []string{}
+	for _, configuration := range c.NetworkConfigurationCatalog.Configurations {
+		ids = append(ids, configuration.ID)
+	}
+	return ids
+}
+
+// Model returns the model with the given ID, or nil if the catalog does not contain it.
+func (c *Catalog) Model(id string) *AndroidModel {
+	if c.AndroidDeviceCatalog == nil {
+		return nil
+	}
+	for _, model := range c.AndroidDeviceCatalog.Models {
+		if model.ID == id {
+			return model
+		}
+	}
+	return nil
+}
+
+// IsPhysical returns true if the model is a physical device, rather than a virtual one.
+func (m *AndroidModel) IsPhysical() bool {
+	return m.Form == "PHYSICAL"
+}
+
+// Capacity returns the device capacity of the given version of the model (high, medium, low),
+// or an empty string if the catalog does not tell.
+func (m *AndroidModel) Capacity(versionID string) string {
+	for _, info := range m.PerVersionInfo {
+		if info.VersionID == versionID {
+			switch info.DeviceCapacity {
+			case "DEVICE_CAPACITY_HIGH":
+				return "high"
+			case "DEVICE_CAPACITY_MEDIUM":
+				return "medium"
+			case "DEVICE_CAPACITY_LOW":
+				return "low"
+			case "DEVICE_CAPACITY_NONE":
+				return "none"
+			}
+		}
+	}
+	return ""
+}
+
+// SupportsAnyABI returns true if the model supports any of the given ABIs, or if no ABI is given.
+func (m *AndroidModel) SupportsAnyABI(abis []string) bool {
+	if len(abis) == 0 {
+		return true
+	}
+	for _, abi := range abis {
+		for _, supported := range m.SupportedAbis {
+			if abi == supported {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeprecatedVersions returns the versions of the model tagged as deprecated (tag: `deprecated=<version>`).
+func (m *AndroidModel) DeprecatedVersions() []string {
+	var versions []string
+	for _, tag := range m.Tags {
+		if strings.HasPrefix(tag, "deprecated=") {
+			versions = append(versions, strings.TrimPrefix(tag, "deprecated="))
+		}
+	}
+	return versions
+}
+
+// IsDeprecated returns true if the given version of the model is deprecated.
+func (m *AndroidModel) IsDeprecated(versionID string) bool {
+	for _, version := range m.DeprecatedVersions() {
+		if version == versionID {
+			return true
+		}
+	}
+	return false
+}
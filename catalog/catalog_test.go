@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocaleIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog *Catalog
+		want    []string
+	}{
+		{
+			name:    "no device catalog",
+			catalog: &Catalog{},
+			want:    nil,
+		},
+		{
+			name:    "no runtime configuration",
+			catalog: &Catalog{AndroidDeviceCatalog: &AndroidDeviceCatalog{}},
+			want:    nil,
+		},
+		{
+			// like the catalog of a backend listing only the orientations, every locale is accepted
+			name: "no locales",
+			catalog: &Catalog{AndroidDeviceCatalog: &AndroidDeviceCatalog{RuntimeConfiguration: &AndroidRuntimeConfiguration{
+				Orientations: []*Orientation{{ID: "portrait", Tags: []string{"default"}}},
+			}}},
+			want: nil,
+		},
+		{
+			name: "locales",
+			catalog: &Catalog{AndroidDeviceCatalog: &AndroidDeviceCatalog{RuntimeConfiguration: &AndroidRuntimeConfiguration{
+				Locales: []*Locale{{ID: "en"}, {ID: "en_GB"}, {ID: "de"}},
+			}}},
+			want: []string{"en", "en_GB", "de"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.catalog.LocaleIDs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LocaleIDs() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	locales := []string{"en", "en_GB", "en_US", "de", "de_AT", "fr"}
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "en_gb", want: []string{"en_GB", "en"}},
+		{value: "en-GB", want: []string{"en_GB", "en"}},
+		{value: "dee", want: []string{"de"}},
+		{value: "ja", want: []string{}},
+		{value: "de_DE", want: []string{"de"}},
+	}
+
+	for _, tt := range tests {
+		if got := Suggest(tt.value, locales); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Suggest(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
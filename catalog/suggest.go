@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the maximum number of suggestions returned by Suggest.
+const maxSuggestions = 3
+
+// Suggest returns the candidates closest to the mistyped value, the closest first.
+// The candidates are compared case-insensitively, by their edit distance, the distant ones are not suggested.
+func Suggest(value string, candidates []string) []string {
+	type suggestion struct {
+		candidate string
+		distance  int
+		prefix    bool
+	}
+
+	value = strings.ToLower(value)
+	// a third of the value can be mistyped, but at least a character
+	maxDistance := len(value) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	var suggestions []suggestion
+	for _, candidate := range candidates {
+		lowerCandidate := strings.ToLower(candidate)
+		s := suggestion{candidate: candidate, distance: editDistance(value, lowerCandidate)}
+		// a prefix, like `en` of `en_GB`, is close enough, but it is suggested after the similar candidates
+		if s.distance > maxDistance && (strings.HasPrefix(lowerCandidate, value) || strings.HasPrefix(value, lowerCandidate)) {
+			s.distance, s.prefix = maxDistance, true
+		}
+		if s.distance <= maxDistance {
+			suggestions = append(suggestions, s)
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return !suggestions[i].prefix && suggestions[j].prefix
+	})
+
+	closest := []string{}
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		closest = append(closest, suggestions[i].candidate)
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
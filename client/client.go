@@ -0,0 +1,512 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/catalog"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/redact"
+)
+
+// Client is the virtual device testing API used by the step.
+// Every call is bound to the given context, cancelling it aborts the in-flight request.
+type Client interface {
+	GetUploadURLs(ctx context.Context) (*UploadURLRequest, error)
+	UploadFile(ctx context.Context, uploadURL, pth string) error
+	StartTest(ctx context.Context, testMatrix *matrix.TestMatrix) error
+	ListSteps(ctx context.Context) (*ListStepsResponse, error)
+	CancelTest(ctx context.Context) error
+	GetAssets(ctx context.Context) (map[string]string, error)
+	DownloadFile(ctx context.Context, fileURL, pth string) error
+	ReadFile(ctx context.Context, fileURL string, offset int64) ([]byte, error)
+	GetQuota(ctx context.Context) (*Quota, error)
+	GetCatalog(ctx context.Context) (*catalog.Catalog, error)
+}
+
+// StatusError is returned if the API responds with a non successful status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Failed to get http response, status code: %d", err.StatusCode)
+}
+
+// Options ...
+type Options struct {
+	Transport       http.RoundTripper
+	APITimeout      time.Duration
+	TransferTimeout time.Duration
+	// TokenFile is read for the API token before every request, if set
+	TokenFile string
+	// DumpDir is set to save the JSON responses of the API into it
+	DumpDir string
+}
+
+// HTTPClient implements Client on top of the virtual device testing HTTP API.
+type HTTPClient struct {
+	baseURL   string
+	appSlug   string
+	buildSlug string
+	token     string
+	tokenFile string
+
+	apiClient      *http.Client
+	transferClient *http.Client
+
+	// tokenInPath is set once the API turned out to accept the token only as the last segment of the URL path.
+	tokenInPath bool
+	// tokenInHeader is set once the API accepted the token in the request header,
+	// from then on a 401/403 is an error of the endpoint and not of the authentication.
+	tokenInHeader bool
+
+	// cachedResponses are the last responses of the GET requests with an ETag, keyed by the request path,
+	// they are sent as conditional requests, and a 304 Not Modified response is served from the cache.
+	cachedResponses map[string]cachedResponse
+	cacheMu         sync.Mutex
+}
+
+type cachedResponse struct {
+	etag string
+	body []byte
+}
+
+// New ...
+func New(baseURL, appSlug, buildSlug, token string, options Options) *HTTPClient {
+	return &HTTPClient{
+		baseURL:         baseURL,
+		appSlug:         appSlug,
+		buildSlug:       buildSlug,
+		token:           token,
+		tokenFile:       options.TokenFile,
+		apiClient:       &http.Client{Transport: NewDumpingTransport(options.Transport, options.DumpDir), Timeout: options.APITimeout},
+		transferClient:  &http.Client{Transport: options.Transport, Timeout: options.TransferTimeout},
+		cachedResponses: map[string]cachedResponse{},
+	}
+}
+
+func (c *HTTPClient) testsPath() string {
+	return "/" + c.appSlug + "/" + c.buildSlug
+}
+
+func (c *HTTPClient) assetsPath() string {
+	return "/assets/" + c.appSlug + "/" + c.buildSlug
+}
+
+func (c *HTTPClient) quotaPath() string {
+	return "/quota/" + c.appSlug
+}
+
+func (c *HTTPClient) catalogPath() string {
+	return "/catalog/" + c.appSlug
+}
+
+// GetUploadURLs ...
+func (c *HTTPClient) GetUploadURLs(ctx context.Context) (*UploadURLRequest, error) {
+	responseModel := &UploadURLRequest{}
+	if err := c.doJSON(ctx, "POST", c.assetsPath(), nil, responseModel); err != nil {
+		return nil, err
+	}
+	return responseModel, nil
+}
+
+// StartTest ...
+func (c *HTTPClient) StartTest(ctx context.Context, testMatrix *matrix.TestMatrix) error {
+	return c.doJSON(ctx, "POST", c.testsPath(), testMatrix, nil)
+}
+
+// ListSteps returns the steps of every page.
+func (c *HTTPClient) ListSteps(ctx context.Context) (*ListStepsResponse, error) {
+	steps := &ListStepsResponse{}
+	pageToken := ""
+	for {
+		path := c.testsPath()
+		if pageToken != "" {
+			path += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+
+		responseModel := &ListStepsResponse{}
+		if err := c.doJSON(ctx, "GET", path, nil, responseModel); err != nil {
+			return nil, err
+		}
+		steps.Steps = append(steps.Steps, responseModel.Steps...)
+		steps.State = responseModel.State
+		steps.InvalidMatrixDetails = responseModel.InvalidMatrixDetails
+
+		if responseModel.NextPageToken == "" {
+			return steps, nil
+		}
+		if responseModel.NextPageToken == pageToken {
+			return nil, fmt.Errorf("Failed to list steps, the API returned the same page token (%s) twice", pageToken)
+		}
+		pageToken = responseModel.NextPageToken
+	}
+}
+
+// CancelTest ...
+func (c *HTTPClient) CancelTest(ctx context.Context) error {
+	return c.doJSON(ctx, "DELETE", c.testsPath(), nil, nil)
+}
+
+// GetAssets returns the test asset download URLs by file name.
+func (c *HTTPClient) GetAssets(ctx context.Context) (map[string]string, error) {
+	responseModel := map[string]string{}
+	if err := c.doJSON(ctx, "GET", c.assetsPath(), nil, &responseModel); err != nil {
+		return nil, err
+	}
+	return responseModel, nil
+}
+
+// GetQuota returns the remaining quota and the concurrent matrix limits of the app.
+func (c *HTTPClient) GetQuota(ctx context.Context) (*Quota, error) {
+	responseModel := &Quota{}
+	if err := c.doJSON(ctx, "GET", c.quotaPath(), nil, responseModel); err != nil {
+		return nil, err
+	}
+	return responseModel, nil
+}
+
+// GetCatalog returns the catalog of the available devices and test environments.
+func (c *HTTPClient) GetCatalog(ctx context.Context) (*catalog.Catalog, error) {
+	responseModel := &catalog.Catalog{}
+	if err := c.doJSON(ctx, "GET", c.catalogPath(), nil, responseModel); err != nil {
+		return nil, err
+	}
+	return responseModel, nil
+}
+
+func (c *HTTPClient) doJSON(ctx context.Context, method, path string, requestModel, responseModel interface{}) error {
+	var body []byte
+	if requestModel != nil {
+		jsonByte, err := json.Marshal(requestModel)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal request body, error: %s", err)
+		}
+		body = jsonByte
+	}
+
+	header := http.Header{}
+	cached, isCached := c.cachedResponse(method, path)
+	if isCached {
+		header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.apiRequest(ctx, method, path, body, header)
+	if err != nil {
+		return fmt.Errorf("Failed to get http response, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	// the response did not change since the cached one
+	if resp.StatusCode == http.StatusNotModified && isCached {
+		return unmarshalResponse(cached.body, responseModel)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	if responseModel == nil {
+		return nil
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" && method == "GET" {
+		c.cacheResponse(path, cachedResponse{etag: etag, body: responseBody})
+	}
+
+	return unmarshalResponse(responseBody, responseModel)
+}
+
+func unmarshalResponse(responseBody []byte, responseModel interface{}) error {
+	if responseModel == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(responseBody, responseModel); err != nil {
+		return fmt.Errorf("Failed to unmarshal response body, error: %s, body: %s", err, string(responseBody))
+	}
+
+	return nil
+}
+
+// cachedResponse returns the cached response of a GET request, to send the request conditionally.
+func (c *HTTPClient) cachedResponse(method, path string) (cachedResponse, bool) {
+	if method != "GET" {
+		return cachedResponse{}, false
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	cached, ok := c.cachedResponses[path]
+	return cached, ok
+}
+
+func (c *HTTPClient) cacheResponse(path string, response cachedResponse) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	c.cachedResponses[path] = response
+}
+
+// apiRequest sends an authenticated request to the API.
+// The token is sent in the Authorization and X-Api-Token headers,
+// if the API rejects it (401/403) the request is retried with the token in the URL path, as older API versions expect.
+// A 404 is not retried: it does not tell a rejected token apart from a missing resource, and the token would end up in the logs of the server.
+// The header is added to the request.
+func (c *HTTPClient) apiRequest(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	resp, err := c.sendAPIRequest(ctx, method, path, body, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tokenInPath || c.tokenInHeader {
+		return resp, nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+
+		log.Printf("The API did not accept the token in the request header (status code: %d), retrying with the token in the URL path", resp.StatusCode)
+
+		// only stick to the URL path if it worked
+		resp, err := c.sendAPIRequestWithTokenInPath(ctx, method, path, body, header, true)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			c.tokenInPath = true
+		}
+		return resp, nil
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		c.tokenInHeader = true
+	}
+
+	return resp, nil
+}
+
+func (c *HTTPClient) sendAPIRequest(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	return c.sendAPIRequestWithTokenInPath(ctx, method, path, body, header, c.tokenInPath)
+}
+
+// apiToken returns the token, read from the token file if it is set, so the file can be rotated while the step runs.
+func (c *HTTPClient) apiToken() (string, error) {
+	if c.tokenFile == "" {
+		return c.token, nil
+	}
+
+	data, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read API token file (%s), error: %s", c.tokenFile, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("The API token file (%s) is empty", c.tokenFile)
+	}
+	redact.AddSecret(token)
+	return token, nil
+}
+
+func (c *HTTPClient) sendAPIRequestWithTokenInPath(ctx context.Context, method, path string, body []byte, header http.Header, tokenInPath bool) (*http.Response, error) {
+	token, err := c.apiToken()
+	if err != nil {
+		return nil, err
+	}
+
+	requestURL := c.baseURL + path
+	if tokenInPath {
+		// the token is the last segment of the path, before the query
+		query := ""
+		if i := strings.Index(requestURL, "?"); i != -1 {
+			requestURL, query = requestURL[:i], requestURL[i:]
+		}
+		requestURL += "/" + token + query
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Api-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.apiClient.Do(req)
+	if err != nil {
+		return nil, explainTLSError(err)
+	}
+	return resp, nil
+}
+
+// DownloadFile ...
+func (c *HTTPClient) DownloadFile(ctx context.Context, fileURL, pth string) error {
+	out, err := os.Create(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to open the local cache file for write: %s", err)
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Printf("Failed to close Archive download file (%s): %s", pth, err)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to create cache download request: %s", err)
+	}
+
+	resp, err := c.transferClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create cache download request: %s", explainTLSError(err))
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close Archive download response body: %s", err)
+		}
+	}()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Failed to download archive - non success response code: %d", resp.StatusCode)
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to save cache content into file: %s", err)
+	}
+
+	return nil
+}
+
+// ReadFile returns the content of the file from the given offset.
+func (c *HTTPClient) ReadFile(ctx context.Context, fileURL string, offset int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.transferClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get http response, error: %s", explainTLSError(err))
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// nothing new since offset
+		return []byte{}, nil
+	case http.StatusOK, http.StatusPartialContent:
+	default:
+		return nil, fmt.Errorf("Failed to get http response, status code: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	// the server ignored the Range header
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		if int64(len(body)) <= offset {
+			return []byte{}, nil
+		}
+		body = body[offset:]
+	}
+
+	return body, nil
+}
+
+// UploadFile ...
+func (c *HTTPClient) UploadFile(ctx context.Context, uploadURL, pth string) error {
+	archFile, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive file for upload (%s): %s", pth, err)
+	}
+	isFileCloseRequired := true
+	defer func() {
+		if !isFileCloseRequired {
+			return
+		}
+		if err := archFile.Close(); err != nil {
+			log.Printf(" (!) Failed to close archive file (%s): %s", pth, err)
+		}
+	}()
+
+	fileInfo, err := archFile.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to get File Stats of the Archive file (%s): %s", pth, err)
+	}
+	fileSize := fileInfo.Size()
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, archFile)
+	if err != nil {
+		return fmt.Errorf("Failed to create upload request: %s", err)
+	}
+
+	req.Header.Add("Content-Length", strconv.FormatInt(fileSize, 10))
+	req.ContentLength = fileSize
+
+	resp, err := c.transferClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to upload: %s", explainTLSError(err))
+	}
+	isFileCloseRequired = false
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf(" [!] Failed to close response body: %s", err)
+		}
+	}()
+
+	_, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response: %s", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Failed to upload file, response code was: %d", resp.StatusCode)
+	}
+
+	return nil
+}
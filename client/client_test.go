@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingServer is an httptest server recording the path (with the query) of the received requests.
+type recordingServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newRecordingServer(handler func(w http.ResponseWriter, r *http.Request)) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	return s
+}
+
+func (s *recordingServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("Failed to write response, error: %s", err)
+	}
+}
+
+func TestTokenInPathFallback(t *testing.T) {
+	for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(http.StatusText(statusCode), func(t *testing.T) {
+			server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+				// an older API version, accepting the token only in the path
+				if !strings.HasSuffix(r.URL.Path, "/secret") {
+					w.WriteHeader(statusCode)
+					return
+				}
+				writeJSON(t, w, map[string]string{"logcat": "https://example.com/logcat"})
+			})
+			defer server.Close()
+
+			c := New(server.URL, "app", "build", "secret", Options{})
+			for i := 0; i < 2; i++ {
+				assets, err := c.GetAssets(context.Background())
+				if err != nil {
+					t.Fatalf("GetAssets() unexpected error: %s", err)
+				}
+				if assets["logcat"] != "https://example.com/logcat" {
+					t.Errorf("GetAssets() = %v", assets)
+				}
+			}
+
+			// the token is only tried in the header once
+			want := []string{"/assets/app/build", "/assets/app/build/secret", "/assets/app/build/secret"}
+			if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("requests = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTokenInHeader(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Api-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/quota/app" {
+			writeJSON(t, w, Quota{})
+			return
+		}
+		// the endpoint is missing from the API
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	if _, err := c.GetQuota(context.Background()); err != nil {
+		t.Fatalf("GetQuota() unexpected error: %s", err)
+	}
+
+	// once the header is accepted, a 404 is not retried with the token in the path
+	_, err := c.GetCatalog(context.Background())
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("GetCatalog() error = %v, want status code 404", err)
+	}
+
+	want := []string{"/quota/app", "/catalog/app"}
+	if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestTokenInPathFallbackFails(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	for i := 0; i < 2; i++ {
+		_, err := c.GetCatalog(context.Background())
+		if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusForbidden {
+			t.Fatalf("GetCatalog() error = %v, want status code 403", err)
+		}
+	}
+
+	// the path is not stuck to, as the token is rejected either way
+	want := []string{"/catalog/app", "/catalog/app/secret", "/catalog/app", "/catalog/app/secret"}
+	if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestNotFoundIsNotRetriedWithTokenInPath(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	for i := 0; i < 2; i++ {
+		_, err := c.GetCatalog(context.Background())
+		if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("GetCatalog() error = %v, want status code 404", err)
+		}
+	}
+
+	want := []string{"/catalog/app", "/catalog/app"}
+	if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestETagCache(t *testing.T) {
+	var ifNoneMatch []string
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		writeJSON(t, w, ListStepsResponse{Steps: []*Step{{State: "inProgress"}}})
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	for i := 0; i < 2; i++ {
+		resp, err := c.ListSteps(context.Background())
+		if err != nil {
+			t.Fatalf("ListSteps() unexpected error: %s", err)
+		}
+		if len(resp.Steps) != 1 || resp.Steps[0].State != "inProgress" {
+			t.Errorf("ListSteps() = %+v, want the cached step", resp.Steps)
+		}
+	}
+
+	if want := []string{"", `"v1"`}; strings.Join(ifNoneMatch, ",") != strings.Join(want, ",") {
+		t.Errorf("If-None-Match = %q, want %q", ifNoneMatch, want)
+	}
+
+	// only the GET requests are cached
+	if err := c.CancelTest(context.Background()); err != nil {
+		t.Fatalf("CancelTest() unexpected error: %s", err)
+	}
+	if got := ifNoneMatch[len(ifNoneMatch)-1]; got != "" {
+		t.Errorf("If-None-Match of the DELETE request = %q, want none", got)
+	}
+}
+
+func TestListStepsPagination(t *testing.T) {
+	pages := map[string]ListStepsResponse{
+		"":   {Steps: []*Step{{State: "complete"}}, NextPageToken: "p2"},
+		"p2": {Steps: []*Step{{State: "inProgress"}}, NextPageToken: "p 3"},
+		"p 3": {
+			Steps:                []*Step{{State: "pending"}},
+			State:                "INVALID",
+			InvalidMatrixDetails: "NO_SIGNATURE",
+		},
+	}
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("pageToken")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeJSON(t, w, page)
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	resp, err := c.ListSteps(context.Background())
+	if err != nil {
+		t.Fatalf("ListSteps() unexpected error: %s", err)
+	}
+
+	var states []string
+	for _, step := range resp.Steps {
+		states = append(states, step.State)
+	}
+	if want := []string{"complete", "inProgress", "pending"}; strings.Join(states, ",") != strings.Join(want, ",") {
+		t.Errorf("step states = %v, want %v", states, want)
+	}
+	if resp.State != "INVALID" || resp.InvalidMatrixDetails != "NO_SIGNATURE" {
+		t.Errorf("matrix state = %s (%s), want the state of the last page", resp.State, resp.InvalidMatrixDetails)
+	}
+	if resp.NextPageToken != "" {
+		t.Errorf("NextPageToken = %s, want none", resp.NextPageToken)
+	}
+
+	want := []string{"/app/build", "/app/build?pageToken=p2", "/app/build?pageToken=p+3"}
+	if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestListStepsRepeatedPageToken(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, ListStepsResponse{Steps: []*Step{{State: "complete"}}, NextPageToken: "p2"})
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	_, err := c.ListSteps(context.Background())
+	if err == nil || err.Error() != "Failed to list steps, the API returned the same page token (p2) twice" {
+		t.Fatalf("ListSteps() error = %v, want the repeated page token error", err)
+	}
+	if got := len(server.Requests()); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestListStepsTokenInPathPagination(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/secret") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			writeJSON(t, w, ListStepsResponse{Steps: []*Step{{State: "complete"}}, NextPageToken: "p2"})
+			return
+		}
+		writeJSON(t, w, ListStepsResponse{Steps: []*Step{{State: "complete"}}})
+	})
+	defer server.Close()
+
+	c := New(server.URL, "app", "build", "secret", Options{})
+	resp, err := c.ListSteps(context.Background())
+	if err != nil {
+		t.Fatalf("ListSteps() unexpected error: %s", err)
+	}
+	if len(resp.Steps) != 2 {
+		t.Errorf("steps = %d, want 2", len(resp.Steps))
+	}
+
+	// the token goes before the query
+	want := []string{"/app/build", "/app/build/secret", "/app/build/secret?pageToken=p2"}
+	if got := server.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestDumpDir(t *testing.T) {
+	server := newRecordingServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/files/logcat.json" {
+			writeJSON(t, w, map[string]string{"file": "content"})
+			return
+		}
+		writeJSON(t, w, Quota{})
+	})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "client-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+	dumpDir := filepath.Join(dir, "responses")
+	if err := os.Mkdir(dumpDir, 0755); err != nil {
+		t.Fatalf("Failed to create dump dir, error: %s", err)
+	}
+
+	c := New(server.URL, "app", "build", "secret", Options{DumpDir: dumpDir})
+	if _, err := c.GetQuota(context.Background()); err != nil {
+		t.Fatalf("GetQuota() unexpected error: %s", err)
+	}
+	// the transfers are not API responses
+	if err := c.DownloadFile(context.Background(), server.URL+"/files/logcat.json", filepath.Join(dir, "logcat.json")); err != nil {
+		t.Fatalf("DownloadFile() unexpected error: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(dumpDir)
+	if err != nil {
+		t.Fatalf("Failed to read dump dir, error: %s", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if want := []string{"0001-GET-quota_app.json"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("dumped responses = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ListStepsResponse ...
+type ListStepsResponse struct {
+	Steps         []*Step `json:"steps,omitempty"`
+	NextPageToken string  `json:"nextPageToken,omitempty"`
+	// State and InvalidMatrixDetails are the state of the test matrix, if the backend exposes it.
+	State                string `json:"state,omitempty"`
+	InvalidMatrixDetails string `json:"invalidMatrixDetails,omitempty"`
+}
+
+// Outcome ...
+type Outcome struct {
+	FailureDetail      *FailureDetail      `json:"failureDetail,omitempty"`
+	InconclusiveDetail *InconclusiveDetail `json:"inconclusiveDetail,omitempty"`
+	SkippedDetail      *SkippedDetail      `json:"skippedDetail,omitempty"`
+	SuccessDetail      *SuccessDetail      `json:"successDetail,omitempty"`
+	Summary            string              `json:"summary,omitempty"`
+}
+
+// SuccessDetail ...
+type SuccessDetail struct {
+	OtherNativeCrash bool `json:"otherNativeCrash,omitempty"`
+}
+
+// SkippedDetail ...
+type SkippedDetail struct {
+	IncompatibleAppVersion   bool `json:"incompatibleAppVersion,omitempty"`
+	IncompatibleArchitecture bool `json:"incompatibleArchitecture,omitempty"`
+	IncompatibleDevice       bool `json:"incompatibleDevice,omitempty"`
+}
+
+// FailureDetail ...
+type FailureDetail struct {
+	Crashed          bool `json:"crashed,omitempty"`
+	NotInstalled     bool `json:"notInstalled,omitempty"`
+	OtherNativeCrash bool `json:"otherNativeCrash,omitempty"`
+	TimedOut         bool `json:"timedOut,omitempty"`
+	UnableToCrawl    bool `json:"unableToCrawl,omitempty"`
+}
+
+// InconclusiveDetail ...
+type InconclusiveDetail struct {
+	AbortedByUser         bool `json:"abortedByUser,omitempty"`
+	InfrastructureFailure bool `json:"infrastructureFailure,omitempty"`
+}
+
+// Step ...
+type Step struct {
+	Outcome        *Outcome                   `json:"outcome,omitempty"`
+	State          string                     `json:"state,omitempty"`
+	DimensionValue []*StepDimensionValueEntry `json:"dimensionValue,omitempty"`
+	CreationTime   *Timestamp                 `json:"creationTime,omitempty"`
+	CompletionTime *Timestamp                 `json:"completionTime,omitempty"`
+	RunDuration    *Duration                  `json:"runDuration,omitempty"`
+	TestExecution  *TestExecutionStep         `json:"testExecutionStep,omitempty"`
+	StepID         string                     `json:"stepId,omitempty"`
+	// ToolResultsStep is set if the backend exposes where the step is stored in Tool Results.
+	ToolResultsStep *ToolResultsStep `json:"toolResultsStep,omitempty"`
+}
+
+// ToolResultsStep ...
+type ToolResultsStep struct {
+	ProjectID   string `json:"projectId,omitempty"`
+	HistoryID   string `json:"historyId,omitempty"`
+	ExecutionID string `json:"executionId,omitempty"`
+	StepID      string `json:"stepId,omitempty"`
+}
+
+// TestExecutionStep ...
+type TestExecutionStep struct {
+	TestSuiteOverviews []*TestSuiteOverview `json:"testSuiteOverviews,omitempty"`
+}
+
+// TestSuiteOverview ...
+type TestSuiteOverview struct {
+	Name         string    `json:"name,omitempty"`
+	TotalCount   int       `json:"totalCount,omitempty"`
+	FailureCount int       `json:"failureCount,omitempty"`
+	ErrorCount   int       `json:"errorCount,omitempty"`
+	SkippedCount int       `json:"skippedCount,omitempty"`
+	FlakyCount   int       `json:"flakyCount,omitempty"`
+	ElapsedTime  *Duration `json:"elapsedTime,omitempty"`
+}
+
+// Timestamp ...
+type Timestamp struct {
+	Seconds int64 `json:"seconds,omitempty,string"`
+	Nanos   int64 `json:"nanos,omitempty"`
+}
+
+// Duration ...
+type Duration struct {
+	Seconds int64 `json:"seconds,omitempty,string"`
+	Nanos   int64 `json:"nanos,omitempty"`
+}
+
+// UnmarshalJSON accepts the seconds both as a string, the way the API encodes 64-bit integers, and as a number.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	seconds, nanos, err := unmarshalSecondsAndNanos(data)
+	if err != nil {
+		return err
+	}
+	t.Seconds, t.Nanos = seconds, nanos
+	return nil
+}
+
+// UnmarshalJSON accepts the seconds both as a string, the way the API encodes 64-bit integers, and as a number.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	seconds, nanos, err := unmarshalSecondsAndNanos(data)
+	if err != nil {
+		return err
+	}
+	d.Seconds, d.Nanos = seconds, nanos
+	return nil
+}
+
+func unmarshalSecondsAndNanos(data []byte) (int64, int64, error) {
+	var raw struct {
+		Seconds json.RawMessage `json:"seconds"`
+		Nanos   json.RawMessage `json:"nanos"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return 0, 0, err
+	}
+	seconds, err := parseInt64(raw.Seconds)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid seconds (%s), error: %s", raw.Seconds, err)
+	}
+	nanos, err := parseInt64(raw.Nanos)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid nanos (%s), error: %s", raw.Nanos, err)
+	}
+	return seconds, nanos, nil
+}
+
+// parseInt64 parses a JSON number or a string holding a number, a missing or null value is 0.
+func parseInt64(raw json.RawMessage) (int64, error) {
+	value := strings.Trim(string(raw), `"`)
+	if value == "" || value == "null" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// StepDimensionValueEntry ...
+type StepDimensionValueEntry struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Quota ...
+type Quota struct {
+	// RemainingMinutes is nil if the app has no device minute limit.
+	RemainingMinutes      *int `json:"remainingMinutes,omitempty"`
+	MaxConcurrentMatrices int  `json:"maxConcurrentMatrices,omitempty"`
+	RunningMatrices       int  `json:"runningMatrices,omitempty"`
+}
+
+// UploadURLRequest ...
+type UploadURLRequest struct {
+	AppURL     string `json:"appUrl"`
+	TestAppURL string `json:"testAppUrl"`
+	// MappingURL is set if the backend accepts a ProGuard/R8 mapping file to deobfuscate the results.
+	MappingURL string `json:"mappingUrl,omitempty"`
+	// NativeSymbolsURL is set if the backend accepts a zip of the native debug symbols to symbolize the native crashes.
+	NativeSymbolsURL string `json:"nativeSymbolsUrl,omitempty"`
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/redact"
+)
+
+// maxTracedBodyLength limits how much of a response body is printed in verbose mode.
+const maxTracedBodyLength = 4096
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTransport returns a transport trusting the system roots and the certificates of caCertPath (if set),
+// sending userAgent as the User-Agent of the requests. In verbose mode the requests and responses passing through the transport are logged.
+func NewTransport(caCertPath, tlsMinVersion, userAgent string, verbose bool) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if caCertPath != "" || tlsMinVersion != "" {
+		tlsConfig := &tls.Config{}
+
+		if caCertPath != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				log.Warnf("Failed to load the system certificate pool, error: %s", err)
+				pool = x509.NewCertPool()
+			}
+
+			caCerts, err := ioutil.ReadFile(caCertPath)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read CA certificate (%s), error: %s", caCertPath, err)
+			}
+			if !pool.AppendCertsFromPEM(caCerts) {
+				return nil, fmt.Errorf("No PEM encoded certificate found in (%s)", caCertPath)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if tlsMinVersion != "" {
+			version, ok := tlsVersions[tlsMinVersion]
+			if !ok {
+				return nil, fmt.Errorf("Unknown TLS version: %s", tlsMinVersion)
+			}
+			tlsConfig.MinVersion = version
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if userAgent != "" {
+		roundTripper = &userAgentTransport{next: roundTripper, userAgent: userAgent}
+	}
+	if verbose {
+		roundTripper = &tracingTransport{next: roundTripper}
+	}
+	return roundTripper, nil
+}
+
+// explainTLSError adds a hint to certificate verification errors, which are usually caused by TLS interception on the runner.
+func explainTLSError(err error) error {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidCertErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &invalidCertErr) || errors.As(err, &hostnameErr) {
+		return fmt.Errorf("%s (if the runner intercepts TLS traffic, set the ca_cert_path input to the certificate of the intercepting proxy)", err)
+	}
+	return err
+}
+
+// userAgentTransport sets the User-Agent of the requests which do not set it.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip ...
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// tracingTransport logs the requests and responses passing through it, with the secrets redacted.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip ...
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	log.Printf("[verbose] --> %s %s", req.Method, redact.String(req.URL.String()))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("[verbose] <-- %s %s failed, error: %s", req.Method, redact.String(req.URL.String()), redact.String(err.Error()))
+		return nil, err
+	}
+
+	log.Printf("[verbose] <-- %s %s: %s", req.Method, redact.String(req.URL.String()), resp.Status)
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "json") && !strings.HasPrefix(contentType, "text/") {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf("[verbose] Failed to close response body, error: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	traced := string(body)
+	if len(traced) > maxTracedBodyLength {
+		traced = traced[:maxTracedBodyLength] + "... (truncated)"
+	}
+	log.Printf("[verbose] %s", redact.String(traced))
+
+	return resp, nil
+}
+
+// NewDumpingTransport returns a transport saving the JSON responses of next into dir, or next if dir is empty.
+// It is only applied to the API requests, not to the notifications, the metrics and the file transfers.
+func NewDumpingTransport(next http.RoundTripper, dir string) http.RoundTripper {
+	if dir == "" {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &dumpingTransport{next: next, dir: dir}
+}
+
+// dumpingTransport saves the JSON responses passing through it into dir, with the secrets redacted.
+type dumpingTransport struct {
+	next http.RoundTripper
+	dir  string
+
+	mu    sync.Mutex
+	count int
+}
+
+// RoundTrip ...
+func (t *dumpingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf("Failed to close response body, error: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	t.count++
+	name := fmt.Sprintf("%04d-%s-%s.json", t.count, req.Method, dumpFileName(req.URL.Path))
+	t.mu.Unlock()
+
+	if err := ioutil.WriteFile(filepath.Join(t.dir, name), []byte(redact.String(string(body))), 0644); err != nil {
+		log.Warnf("Failed to save response, error: %s", err)
+	}
+
+	return resp, nil
+}
+
+// dumpFileName turns the URL path into a file name, like: `a_b_steps`.
+func dumpFileName(urlPath string) string {
+	name := strings.Trim(dumpFileNameReplacer.ReplaceAllString(redact.String(urlPath), "_"), "_")
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	return name
+}
+
+var dumpFileNameReplacer = regexp.MustCompile("[^A-Za-z0-9.-]+")
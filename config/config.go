@@ -0,0 +1,1008 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/glob"
+	"github.com/bitrise-tools/go-steputils/input"
+)
+
+// The maximum test timeouts of Test Lab.
+const (
+	MaxVirtualTestTimeout  = 60 * time.Minute
+	MaxPhysicalTestTimeout = 45 * time.Minute
+)
+
+// MaxSystraceDuration is the longest systrace Test Lab captures.
+const MaxSystraceDuration = 30 * time.Second
+
+// MaxDeflakeIterations limits the test matrices started in deflake mode, each of them is billed.
+const MaxDeflakeIterations = 100
+
+// DefaultTestDevice is a low resolution virtual device on the latest stable API level,
+// tested on if no test device is set and UseDefaultDevice is enabled.
+const DefaultTestDevice = "NexusLowRes,30,en,portrait"
+
+// FallbackOrientation replaces the `default` and `auto` orientations if the catalog does not tell the default orientation.
+const FallbackOrientation = "portrait"
+
+// ConfigsModel ...
+type ConfigsModel struct {
+	ConfigPath string
+
+	// api
+	APIBaseURL   string
+	BuildSlug    string
+	AppSlug      string
+	APIToken     string
+	APITokenFile string
+
+	// firebase
+	ServiceAccountJSON string
+	GCPProjectID       string
+	GCSBucket          string
+
+	// build
+	BuildNumber string
+	GitBranch   string
+	GitCommit   string
+	WorkflowID  string
+
+	// network
+	CACertPath      string
+	TLSMinVersion   string
+	APITimeout      string
+	TransferTimeout string
+	StepTimeout     string
+
+	// mode
+	Mode                string
+	TestMatrixBuildSlug string
+	TestMatrixID        string
+	DeflakeIterations   string
+
+	// shared
+	ApkPath               string
+	AllowMissingApk       string
+	TestApkPath           string
+	MappingFile           string
+	NativeSymbols         string
+	TestType              string
+	TestDevices           string
+	UseDefaultDevice      string
+	AppPackageID          string
+	TestTimeout           string
+	DownloadTestResults   string
+	ZipTestAssets         string
+	PrefixAssetNames      string
+	DirectoriesToPull     string
+	FilesToPull           string
+	EnvironmentVariables  string
+	NetworkProfile        string
+	SystraceDuration      string
+	FailOnSkipped         string
+	FailOnInconclusive    string
+	FailOnFlaky           string
+	FailIf                string
+	RerunFailedDevices    string
+	RerunFailedTests      string
+	MaxMatrixRetries      string
+	FailFast              string
+	WaitForResults        string
+	StatePath             string
+	TestHistoryPath       string
+	BaselinePath          string
+	QuarantinePath        string
+	WaitForQuota          string
+	VirtualOnly           string
+	FailOnIncompatibleABI string
+	FailOnIncompatibleSDK string
+	DryRun                string
+	Quiet                 string
+	DisableColors         string
+	ResultsSort           string
+	WideResults           string
+	StreamLogcat          string
+	HeartbeatInterval     string
+	Verbose               string
+	DumpResponses         string
+
+	// instrumentation
+	InstTestPackageID   string
+	InstTestRunnerClass string
+	InstTestTargets     string
+	InstRunnerArgs      string
+	InstTestAnnotation  string
+	InstTestSize        string
+	ShardsFile          string
+	EnableCoverage      string
+	MergeCoverage       string
+	CoverageClassDirs   string
+	CoverageSourceDirs  string
+	JacocoCLIPath       string
+
+	// robo
+	RoboInitialActivity string
+	RoboMaxDepth        string
+	RoboMaxSteps        string
+	RoboDirectives      string
+
+	// loop
+	LoopScenarios      string
+	LoopScenarioLabels string
+
+	// notification
+	SlackWebhookURL      string
+	SlackChannel         string
+	ResultWebhookURL     string
+	ResultWebhookHeaders string
+	ResultWebhookSecret  string
+	AnnotateBuild        string
+
+	// metrics
+	StatsDAddress  string
+	PushgatewayURL string
+	MetricsPrefix  string
+	OTLPEndpoint   string
+	OTLPHeaders    string
+}
+
+// CreateFromEnvs ...
+func CreateFromEnvs() ConfigsModel {
+	return ConfigsModel{
+		ConfigPath: os.Getenv("config_path"),
+
+		// api
+		APIBaseURL:   os.Getenv("api_base_url"),
+		BuildSlug:    os.Getenv("BITRISE_BUILD_SLUG"),
+		AppSlug:      os.Getenv("BITRISE_APP_SLUG"),
+		APIToken:     os.Getenv("api_token"),
+		APITokenFile: os.Getenv("api_token_file"),
+
+		// firebase
+		ServiceAccountJSON: os.Getenv("service_account_json"),
+		GCPProjectID:       os.Getenv("gcp_project_id"),
+		GCSBucket:          os.Getenv("gcs_bucket"),
+
+		// build
+		BuildNumber: os.Getenv("BITRISE_BUILD_NUMBER"),
+		GitBranch:   os.Getenv("BITRISE_GIT_BRANCH"),
+		GitCommit:   os.Getenv("BITRISE_GIT_COMMIT"),
+		WorkflowID:  os.Getenv("BITRISE_TRIGGERED_WORKFLOW_ID"),
+
+		// network
+		CACertPath:      os.Getenv("ca_cert_path"),
+		TLSMinVersion:   os.Getenv("tls_min_version"),
+		APITimeout:      os.Getenv("api_timeout"),
+		TransferTimeout: os.Getenv("transfer_timeout"),
+		StepTimeout:     os.Getenv("step_timeout"),
+
+		// mode
+		Mode:                os.Getenv("mode"),
+		TestMatrixBuildSlug: os.Getenv("test_matrix_build_slug"),
+		TestMatrixID:        os.Getenv("test_matrix_id"),
+		DeflakeIterations:   os.Getenv("deflake_iterations"),
+
+		// shared
+		ApkPath:               os.Getenv("apk_path"),
+		AllowMissingApk:       os.Getenv("allow_missing_apk"),
+		TestApkPath:           os.Getenv("test_apk_path"),
+		MappingFile:           os.Getenv("mapping_file"),
+		NativeSymbols:         os.Getenv("native_symbols"),
+		TestType:              os.Getenv("test_type"),
+		TestDevices:           os.Getenv("test_devices"),
+		UseDefaultDevice:      os.Getenv("use_default_device"),
+		AppPackageID:          os.Getenv("app_package_id"),
+		TestTimeout:           os.Getenv("test_timeout"),
+		DownloadTestResults:   os.Getenv("download_test_results"),
+		ZipTestAssets:         os.Getenv("zip_test_assets"),
+		PrefixAssetNames:      os.Getenv("prefix_asset_names"),
+		DirectoriesToPull:     os.Getenv("directories_to_pull"),
+		FilesToPull:           os.Getenv("files_to_pull"),
+		EnvironmentVariables:  os.Getenv("environment_variables"),
+		NetworkProfile:        os.Getenv("network_profile"),
+		SystraceDuration:      os.Getenv("systrace_duration"),
+		FailOnSkipped:         os.Getenv("fail_on_skipped"),
+		FailOnInconclusive:    os.Getenv("fail_on_inconclusive"),
+		FailOnFlaky:           os.Getenv("fail_on_flaky"),
+		FailIf:                os.Getenv("fail_if"),
+		RerunFailedDevices:    os.Getenv("rerun_failed_devices"),
+		RerunFailedTests:      os.Getenv("rerun_failed_tests"),
+		MaxMatrixRetries:      os.Getenv("max_matrix_retries"),
+		FailFast:              os.Getenv("fail_fast"),
+		WaitForResults:        os.Getenv("wait_for_results"),
+		StatePath:             os.Getenv("state_path"),
+		TestHistoryPath:       os.Getenv("test_history_path"),
+		BaselinePath:          os.Getenv("baseline_path"),
+		QuarantinePath:        os.Getenv("quarantine_path"),
+		WaitForQuota:          os.Getenv("wait_for_quota"),
+		VirtualOnly:           os.Getenv("virtual_only"),
+		FailOnIncompatibleABI: os.Getenv("fail_on_incompatible_abi"),
+		FailOnIncompatibleSDK: os.Getenv("fail_on_incompatible_sdk"),
+		DryRun:                os.Getenv("dry_run"),
+		Quiet:                 os.Getenv("quiet"),
+		DisableColors:         os.Getenv("disable_colors"),
+		ResultsSort:           os.Getenv("results_sort"),
+		WideResults:           os.Getenv("wide_results"),
+		StreamLogcat:          os.Getenv("stream_logcat"),
+		HeartbeatInterval:     os.Getenv("heartbeat_interval"),
+		Verbose:               os.Getenv("verbose"),
+		DumpResponses:         os.Getenv("dump_responses"),
+
+		// instrumentation
+		InstTestPackageID:   os.Getenv("inst_test_package_id"),
+		InstTestRunnerClass: os.Getenv("inst_test_runner_class"),
+		InstTestTargets:     os.Getenv("inst_test_targets"),
+		InstRunnerArgs:      os.Getenv("inst_runner_args"),
+		InstTestAnnotation:  os.Getenv("inst_test_annotation"),
+		InstTestSize:        os.Getenv("inst_test_size"),
+		ShardsFile:          os.Getenv("shards_file"),
+		EnableCoverage:      os.Getenv("enable_coverage"),
+		MergeCoverage:       os.Getenv("merge_coverage"),
+		CoverageClassDirs:   os.Getenv("coverage_class_dirs"),
+		CoverageSourceDirs:  os.Getenv("coverage_source_dirs"),
+		JacocoCLIPath:       os.Getenv("jacoco_cli_path"),
+
+		// robo
+		RoboInitialActivity: os.Getenv("robo_initial_activity"),
+		RoboMaxDepth:        os.Getenv("robo_max_depth"),
+		RoboMaxSteps:        os.Getenv("robo_max_steps"),
+		RoboDirectives:      os.Getenv("robo_directives"),
+
+		// loop
+		LoopScenarios:      os.Getenv("loop_scenarios"),
+		LoopScenarioLabels: os.Getenv("loop_scenario_labels"),
+
+		// notification
+		SlackWebhookURL:      os.Getenv("slack_webhook_url"),
+		SlackChannel:         os.Getenv("slack_channel"),
+		ResultWebhookURL:     os.Getenv("result_webhook_url"),
+		ResultWebhookHeaders: os.Getenv("result_webhook_headers"),
+		ResultWebhookSecret:  os.Getenv("result_webhook_secret"),
+		AnnotateBuild:        os.Getenv("annotate_build"),
+
+		// metrics
+		StatsDAddress:  os.Getenv("statsd_address"),
+		PushgatewayURL: os.Getenv("pushgateway_url"),
+		MetricsPrefix:  os.Getenv("metrics_prefix"),
+		OTLPEndpoint:   os.Getenv("otlp_endpoint"),
+		OTLPHeaders:    os.Getenv("otlp_headers"),
+	}
+}
+
+// Print ...
+func (configs ConfigsModel) Print() {
+	log.Infof("Configs:")
+	log.Printf("- ConfigPath: %s", configs.ConfigPath)
+	log.Printf("- Mode: %s", configs.Mode)
+	if configs.Mode == "wait" {
+		log.Printf("- TestMatrixBuildSlug: %s", configs.TestMatrixBuildSlug)
+		log.Printf("- TestMatrixID: %s", configs.TestMatrixID)
+	}
+	if configs.Mode == "deflake" {
+		log.Printf("- DeflakeIterations: %s", configs.DeflakeIterations)
+	}
+	log.Printf("- ApkPath: %s", configs.ApkPath)
+	log.Printf("- AllowMissingApk: %s", configs.AllowMissingApk)
+	log.Printf("- MappingFile: %s", configs.MappingFile)
+	log.Printf("- NativeSymbols: %s", configs.NativeSymbols)
+
+	log.Printf("- TestTimeout: %s", configs.TestTimeout)
+	log.Printf("- DirectoriesToPull: %s", configs.DirectoriesToPull)
+	log.Printf("- FilesToPull: %s", configs.FilesToPull)
+	log.Printf("- ZipTestAssets: %s", configs.ZipTestAssets)
+	log.Printf("- PrefixAssetNames: %s", configs.PrefixAssetNames)
+	log.Printf("- EnvironmentVariables: %s", configs.EnvironmentVariables)
+	log.Printf("- NetworkProfile: %s", configs.NetworkProfile)
+	log.Printf("- SystraceDuration: %s", configs.SystraceDuration)
+	log.Printf("- FailOnSkipped: %s", configs.FailOnSkipped)
+	log.Printf("- FailOnInconclusive: %s", configs.FailOnInconclusive)
+	log.Printf("- FailOnFlaky: %s", configs.FailOnFlaky)
+	log.Printf("- FailIf: %s", configs.FailIf)
+	log.Printf("- RerunFailedDevices: %s", configs.RerunFailedDevices)
+	log.Printf("- RerunFailedTests: %s", configs.RerunFailedTests)
+	log.Printf("- MaxMatrixRetries: %s", configs.MaxMatrixRetries)
+	log.Printf("- FailFast: %s", configs.FailFast)
+	log.Printf("- WaitForResults: %s", configs.WaitForResults)
+	log.Printf("- StatePath: %s", configs.StatePath)
+	log.Printf("- TestHistoryPath: %s", configs.TestHistoryPath)
+	log.Printf("- BaselinePath: %s", configs.BaselinePath)
+	log.Printf("- QuarantinePath: %s", configs.QuarantinePath)
+	log.Printf("- WaitForQuota: %s", configs.WaitForQuota)
+	log.Printf("- VirtualOnly: %s", configs.VirtualOnly)
+	log.Printf("- FailOnIncompatibleABI: %s", configs.FailOnIncompatibleABI)
+	log.Printf("- FailOnIncompatibleSDK: %s", configs.FailOnIncompatibleSDK)
+	log.Printf("- DryRun: %s", configs.DryRun)
+	log.Printf("- Quiet: %s", configs.Quiet)
+	log.Printf("- DisableColors: %s", configs.DisableColors)
+	log.Printf("- ResultsSort: %s", configs.ResultsSort)
+	log.Printf("- WideResults: %s", configs.WideResults)
+	log.Printf("- StreamLogcat: %s", configs.StreamLogcat)
+	log.Printf("- HeartbeatInterval: %s", configs.HeartbeatInterval)
+	if configs.ServiceAccountJSON != "" {
+		log.Printf("- GCPProjectID: %s", configs.GCPProjectID)
+		log.Printf("- GCSBucket: %s", configs.GCSBucket)
+	}
+	log.Printf("- Verbose: %s", configs.Verbose)
+	log.Printf("- DumpResponses: %s", configs.DumpResponses)
+	log.Printf("- UseDefaultDevice: %s", configs.UseDefaultDevice)
+	log.Printf("- TestDevices:\n---")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "Model\tAPI Level\tLocale\tOrientation\tTest Timeout\t")
+	for _, line := range ParseList(configs.TestDevices) {
+		devices, err := ParseTestDevices(line)
+		if err != nil {
+			continue
+		}
+
+		device := devices[0]
+		timeout := device.Timeout
+		if timeout == "" {
+			timeout = configs.TestTimeout
+		}
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t", device.Model, device.Version, device.Locale, device.Orientation, timeout))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Failed to flush writer, error: %s", err)
+	}
+	log.Printf("---")
+	log.Printf("- AppPackageID: %s", configs.AppPackageID)
+	log.Printf("- TestType: %s", configs.TestType)
+
+	// instruments
+	if configs.TestType == "instrumentation" {
+		log.Printf("- TestApkPath: %s", configs.TestApkPath)
+		log.Printf("- InstTestPackageID: %s", configs.InstTestPackageID)
+		log.Printf("- InstTestRunnerClass: %s", configs.InstTestRunnerClass)
+		log.Printf("- InstTestTargets: %s", configs.InstTestTargets)
+		log.Printf("- InstRunnerArgs: %s", configs.InstRunnerArgs)
+		log.Printf("- InstTestAnnotation: %s", configs.InstTestAnnotation)
+		log.Printf("- InstTestSize: %s", configs.InstTestSize)
+		log.Printf("- ShardsFile: %s", configs.ShardsFile)
+		log.Printf("- EnableCoverage: %s", configs.EnableCoverage)
+		log.Printf("- MergeCoverage: %s", configs.MergeCoverage)
+		log.Printf("- CoverageClassDirs: %s", configs.CoverageClassDirs)
+		log.Printf("- CoverageSourceDirs: %s", configs.CoverageSourceDirs)
+		log.Printf("- JacocoCLIPath: %s", configs.JacocoCLIPath)
+	}
+
+	//robo
+	if configs.TestType == "robo" {
+		log.Printf("- RoboInitialActivity: %s", configs.RoboInitialActivity)
+		log.Printf("- RoboMaxDepth: %s", configs.RoboMaxDepth)
+		log.Printf("- RoboMaxSteps: %s", configs.RoboMaxSteps)
+		log.Printf("- RoboDirectives: %s", configs.RoboDirectives)
+	}
+
+	if configs.TestType == "gameloop" {
+		// loop
+		log.Printf("- LoopScenarios: %s", configs.LoopScenarios)
+		log.Printf("- LoopScenarioLabels: %s", configs.LoopScenarioLabels)
+	}
+
+	log.Printf("- CACertPath: %s", configs.CACertPath)
+	log.Printf("- TLSMinVersion: %s", configs.TLSMinVersion)
+	log.Printf("- APITimeout: %s", configs.APITimeout)
+	log.Printf("- TransferTimeout: %s", configs.TransferTimeout)
+	log.Printf("- StepTimeout: %s", configs.StepTimeout)
+	log.Printf("- SlackWebhookURL: %s", input.SecureInput(configs.SlackWebhookURL))
+	log.Printf("- SlackChannel: %s", configs.SlackChannel)
+	log.Printf("- ResultWebhookURL: %s", input.SecureInput(configs.ResultWebhookURL))
+	log.Printf("- ResultWebhookHeaders: %s", input.SecureInput(configs.ResultWebhookHeaders))
+	log.Printf("- ResultWebhookSecret: %s", input.SecureInput(configs.ResultWebhookSecret))
+	log.Printf("- AnnotateBuild: %s", configs.AnnotateBuild)
+	log.Printf("- StatsDAddress: %s", configs.StatsDAddress)
+	log.Printf("- PushgatewayURL: %s", input.SecureInput(configs.PushgatewayURL))
+	log.Printf("- MetricsPrefix: %s", configs.MetricsPrefix)
+	log.Printf("- OTLPEndpoint: %s", configs.OTLPEndpoint)
+	log.Printf("- OTLPHeaders: %s", input.SecureInput(configs.OTLPHeaders))
+}
+
+// Validate ...
+func (configs ConfigsModel) Validate() error {
+
+	if configs.ServiceAccountJSON != "" {
+		if err := input.ValidateIfNotEmpty(configs.GCSBucket); err != nil {
+			return fmt.Errorf("Issue with GCSBucket: %s", err)
+		}
+	} else {
+		if err := input.ValidateIfNotEmpty(configs.APIBaseURL); err != nil {
+			return fmt.Errorf("Issue with APIBaseURL: %s", err)
+		}
+		if configs.APITokenFile != "" {
+			if err := input.ValidateIfPathExists(configs.APITokenFile); err != nil {
+				return fmt.Errorf("Issue with APITokenFile: %s", err)
+			}
+		} else if err := input.ValidateIfNotEmpty(configs.APIToken); err != nil {
+			return fmt.Errorf("Issue with APIToken: %s", err)
+		}
+	}
+	if err := input.ValidateIfNotEmpty(configs.BuildSlug); err != nil {
+		return fmt.Errorf("Issue with BuildSlug: %s", err)
+	}
+	if err := input.ValidateIfNotEmpty(configs.AppSlug); err != nil {
+		return fmt.Errorf("Issue with AppSlug: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.Mode, "run", "wait", "deflake", "list-network-profiles", "local-mock"); err != nil {
+		return fmt.Errorf("Issue with Mode: %s", err)
+	}
+	// the network profiles are listed without testing
+	if configs.Mode == "list-network-profiles" {
+		return nil
+	}
+	if configs.Mode == "deflake" {
+		if configs.TestType != "instrumentation" {
+			return fmt.Errorf("Issue with Mode: the pass rates of the test cases are only collected for instrumentation tests")
+		}
+		if count, err := strconv.Atoi(configs.DeflakeIterations); err != nil || count < 1 || count > MaxDeflakeIterations {
+			return fmt.Errorf("Issue with DeflakeIterations: should be an integer between 1 and %d, got: %s", MaxDeflakeIterations, configs.DeflakeIterations)
+		}
+	}
+	if configs.Mode == "wait" {
+		if configs.ServiceAccountJSON != "" {
+			if err := input.ValidateIfNotEmpty(configs.TestMatrixID); err != nil {
+				return fmt.Errorf("Issue with TestMatrixID: %s", err)
+			}
+		} else {
+			if err := input.ValidateIfNotEmpty(configs.TestMatrixBuildSlug); err != nil {
+				return fmt.Errorf("Issue with TestMatrixBuildSlug: %s", err)
+			}
+		}
+	}
+	if err := input.ValidateWithOptions(configs.UseDefaultDevice, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with UseDefaultDevice: %s", err)
+	}
+	if configs.Mode != "wait" && strings.TrimSpace(configs.TestDevices) == "" {
+		return fmt.Errorf("Issue with TestDevices: no test device is set, set the use_default_device input to true to test on %s", DefaultTestDevice)
+	}
+	if configs.Mode != "wait" {
+		devices, err := ParseTestDevices(configs.TestDevices)
+		if err != nil {
+			return fmt.Errorf("Issue with TestDevices: %s", err)
+		}
+		for _, device := range devices {
+			if timeout, err := ParseTimeout(device.Timeout); err == nil && timeout > MaxVirtualTestTimeout {
+				return fmt.Errorf("Issue with TestDevices: the test timeout of %s should be at most %s, got: %s", device, MaxVirtualTestTimeout, timeout)
+			}
+		}
+		if groups, err := configs.SplitByTestTimeout(); err == nil && len(groups) > 1 && configs.WaitForResults == "false" {
+			return fmt.Errorf("Issue with TestDevices: the devices with their own test timeout run in separate test matrices, whose results can only be waited for")
+		}
+	}
+	if err := input.ValidateIfNotEmpty(configs.TestType); err != nil {
+		return fmt.Errorf("Issue with TestType: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.TestType, "instrumentation", "robo", "gameloop"); err != nil {
+		return fmt.Errorf("Issue with TestType: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.AllowMissingApk, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with AllowMissingApk: %s", err)
+	}
+	// in wait mode the APKs are already uploaded
+	if configs.Mode != "wait" {
+		if err := input.ValidateIfNotEmpty(configs.ApkPath); err != nil {
+			return fmt.Errorf("Issue with ApkPath: %s", err)
+		}
+		if IsRemoteURL(configs.ApkPath) {
+			if _, err := url.Parse(configs.ApkPath); err != nil {
+				return fmt.Errorf("Issue with ApkPath: invalid URL, error: %s", err)
+			}
+		} else if err := input.ValidateIfPathExists(configs.ApkPath); err != nil {
+			return fmt.Errorf("Issue with ApkPath: %s", err)
+		}
+		if configs.TestType == "instrumentation" {
+			if err := input.ValidateIfNotEmpty(configs.TestApkPath); err != nil {
+				return fmt.Errorf("Issue with TestApkPath: %s", err)
+			}
+			for _, pth := range ParseTestApkPaths(configs.TestApkPath) {
+				if err := input.ValidateIfPathExists(pth); err != nil {
+					return fmt.Errorf("Issue with TestApkPath: %s", err)
+				}
+			}
+		}
+	}
+	if configs.MappingFile != "" {
+		if err := input.ValidateIfPathExists(configs.MappingFile); err != nil {
+			return fmt.Errorf("Issue with MappingFile: %s", err)
+		}
+	}
+	if configs.NativeSymbols != "" {
+		if err := input.ValidateIfPathExists(configs.NativeSymbols); err != nil {
+			return fmt.Errorf("Issue with NativeSymbols: %s", err)
+		}
+	}
+	if configs.TestType == "instrumentation" {
+		if configs.InstTestSize != "" {
+			if err := input.ValidateWithOptions(configs.InstTestSize, "small", "medium", "large"); err != nil {
+				return fmt.Errorf("Issue with InstTestSize: %s", err)
+			}
+		}
+		if configs.ShardsFile != "" {
+			// Test Lab does not accept test targets besides the ones of the shards
+			if configs.InstTestTargets != "" || configs.InstTestAnnotation != "" || configs.InstTestSize != "" {
+				return fmt.Errorf("Issue with ShardsFile: the test targets are set by the shards, inst_test_targets, inst_test_annotation and inst_test_size can not be set")
+			}
+			if _, err := ParseShardsFile(configs.ShardsFile); err != nil {
+				return fmt.Errorf("Issue with ShardsFile: %s", err)
+			}
+		}
+		if err := input.ValidateWithOptions(configs.EnableCoverage, "true", "false"); err != nil {
+			return fmt.Errorf("Issue with EnableCoverage: %s", err)
+		}
+		if err := input.ValidateWithOptions(configs.MergeCoverage, "true", "false"); err != nil {
+			return fmt.Errorf("Issue with MergeCoverage: %s", err)
+		}
+		if configs.JacocoCLIPath != "" {
+			if err := input.ValidateIfPathExists(configs.JacocoCLIPath); err != nil {
+				return fmt.Errorf("Issue with JacocoCLIPath: %s", err)
+			}
+		}
+	}
+	for _, dir := range ParseList(configs.DirectoriesToPull) {
+		if err := validateDirectoryToPull(dir); err != nil {
+			return fmt.Errorf("Issue with DirectoriesToPull: %s", err)
+		}
+	}
+	for _, pattern := range ParseList(configs.FilesToPull) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Issue with FilesToPull: invalid pattern (%s), error: %s", pattern, err)
+		}
+		if err := validateDirectoryToPull(glob.Root(pattern)); err != nil {
+			return fmt.Errorf("Issue with FilesToPull: %s", err)
+		}
+	}
+	if err := input.ValidateWithOptions(configs.ZipTestAssets, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with ZipTestAssets: %s", err)
+	}
+	if configs.ZipTestAssets == "true" && configs.DownloadTestResults != "true" {
+		return fmt.Errorf("Issue with ZipTestAssets: the test assets are zipped only if download_test_results is set to true")
+	}
+	if err := input.ValidateWithOptions(configs.PrefixAssetNames, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with PrefixAssetNames: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.AnnotateBuild, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with AnnotateBuild: %s", err)
+	}
+	if configs.StatsDAddress != "" || configs.PushgatewayURL != "" {
+		if err := input.ValidateIfNotEmpty(configs.MetricsPrefix); err != nil {
+			return fmt.Errorf("Issue with MetricsPrefix: %s", err)
+		}
+	}
+	if _, err := ParseWebhookHeaders(configs.OTLPHeaders); err != nil {
+		return fmt.Errorf("Issue with OTLPHeaders: %s", err)
+	}
+	if _, err := ParseEnvironmentVariables(configs.EnvironmentVariables); err != nil {
+		return fmt.Errorf("Issue with EnvironmentVariables: %s", err)
+	}
+	if systraceDuration, err := ParseTimeout(configs.SystraceDuration); err != nil {
+		return fmt.Errorf("Issue with SystraceDuration: %s", err)
+	} else if systraceDuration > MaxSystraceDuration {
+		return fmt.Errorf("Issue with SystraceDuration: should be at most %s, got: %s", MaxSystraceDuration, systraceDuration)
+	}
+	if configs.TestType == "robo" {
+		if _, err := ParseRoboDirectives(configs.RoboDirectives); err != nil {
+			return fmt.Errorf("Issue with RoboDirectives: %s", err)
+		}
+	}
+	if configs.TestType == "gameloop" {
+		if _, err := ParseScenarios(configs.LoopScenarios); err != nil {
+			return fmt.Errorf("Issue with LoopScenarios: %s", err)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.FailOnSkipped, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailOnSkipped: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.FailOnInconclusive, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailOnInconclusive: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.FailOnFlaky, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailOnFlaky: %s", err)
+	}
+	if testTimeout, err := ParseTimeout(configs.TestTimeout); err != nil {
+		return fmt.Errorf("Issue with TestTimeout: %s", err)
+	} else if testTimeout > MaxVirtualTestTimeout {
+		return fmt.Errorf("Issue with TestTimeout: should be at most %s, got: %s", MaxVirtualTestTimeout, testTimeout)
+	}
+	if count, err := strconv.Atoi(configs.RerunFailedDevices); err != nil || count < 0 {
+		return fmt.Errorf("Issue with RerunFailedDevices: should be a non-negative integer, got: %s", configs.RerunFailedDevices)
+	}
+	if count, err := strconv.Atoi(configs.RerunFailedTests); err != nil || count < 0 {
+		return fmt.Errorf("Issue with RerunFailedTests: should be a non-negative integer, got: %s", configs.RerunFailedTests)
+	}
+	if count, err := strconv.Atoi(configs.MaxMatrixRetries); err != nil || count < 0 {
+		return fmt.Errorf("Issue with MaxMatrixRetries: should be a non-negative integer, got: %s", configs.MaxMatrixRetries)
+	}
+	if err := input.ValidateWithOptions(configs.FailFast, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailFast: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.WaitForResults, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with WaitForResults: %s", err)
+	}
+	if configs.WaitForResults == "false" && configs.TestType == "instrumentation" && len(ParseTestApkPaths(configs.TestApkPath)) > 1 {
+		return fmt.Errorf("Issue with WaitForResults: the results of multiple test APKs can only be waited for")
+	}
+	if err := input.ValidateWithOptions(configs.WaitForQuota, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with WaitForQuota: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.VirtualOnly, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with VirtualOnly: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.FailOnIncompatibleABI, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailOnIncompatibleABI: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.FailOnIncompatibleSDK, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with FailOnIncompatibleSDK: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.DryRun, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with DryRun: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.Quiet, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with Quiet: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.DisableColors, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with DisableColors: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ResultsSort, "outcome", "model", "duration"); err != nil {
+		return fmt.Errorf("Issue with ResultsSort: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.WideResults, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with WideResults: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.StreamLogcat, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with StreamLogcat: %s", err)
+	}
+	if _, err := ParseTimeout(configs.HeartbeatInterval); err != nil {
+		return fmt.Errorf("Issue with HeartbeatInterval: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.Verbose, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with Verbose: %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.DumpResponses, "true", "false"); err != nil {
+		return fmt.Errorf("Issue with DumpResponses: %s", err)
+	}
+	if configs.BaselinePath != "" {
+		if err := input.ValidateIfPathExists(configs.BaselinePath); err != nil {
+			return fmt.Errorf("Issue with BaselinePath: %s", err)
+		}
+	}
+	if configs.QuarantinePath != "" {
+		if err := input.ValidateIfPathExists(configs.QuarantinePath); err != nil {
+			return fmt.Errorf("Issue with QuarantinePath: %s", err)
+		}
+	}
+	if configs.CACertPath != "" {
+		if err := input.ValidateIfPathExists(configs.CACertPath); err != nil {
+			return fmt.Errorf("Issue with CACertPath: %s", err)
+		}
+	}
+	if configs.TLSMinVersion != "" {
+		if err := input.ValidateWithOptions(configs.TLSMinVersion, "1.0", "1.1", "1.2", "1.3"); err != nil {
+			return fmt.Errorf("Issue with TLSMinVersion: %s", err)
+		}
+	}
+	if _, err := ParseTimeout(configs.APITimeout); err != nil {
+		return fmt.Errorf("Issue with APITimeout: %s", err)
+	}
+	if _, err := ParseTimeout(configs.TransferTimeout); err != nil {
+		return fmt.Errorf("Issue with TransferTimeout: %s", err)
+	}
+	if _, err := ParseTimeout(configs.StepTimeout); err != nil {
+		return fmt.Errorf("Issue with StepTimeout: %s", err)
+	}
+	if _, err := ParseWebhookHeaders(configs.ResultWebhookHeaders); err != nil {
+		return fmt.Errorf("Issue with ResultWebhookHeaders: %s", err)
+	}
+
+	return nil
+}
+
+// pullableRoots are the on-device directories the files can be pulled from.
+var pullableRoots = []string{"/sdcard", "/data/local/tmp"}
+
+func validateDirectoryToPull(dir string) error {
+	if !path.IsAbs(dir) {
+		return fmt.Errorf("%s is not an absolute path", dir)
+	}
+
+	cleaned := path.Clean(dir)
+	for _, root := range pullableRoots {
+		if cleaned == root || strings.HasPrefix(cleaned, root+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s can not be pulled, only the directories under %s are allowed", dir, strings.Join(pullableRoots, " or "))
+}
+
+// PulledDirectories returns the directories to pull: DirectoriesToPull and the directories of the FilesToPull patterns.
+// Test Lab pulls whole directories, the files not matching the patterns are not downloaded.
+func (configs ConfigsModel) PulledDirectories() []string {
+	directories := ParseList(configs.DirectoriesToPull)
+	for _, pattern := range ParseList(configs.FilesToPull) {
+		if root := glob.Root(pattern); !sliceutil.IsStringInSlice(root, directories) {
+			directories = append(directories, root)
+		}
+	}
+	return directories
+}
+
+// ParseList parses a newline separated list, skipping the empty lines.
+func ParseList(list string) []string {
+	items := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		if item := strings.TrimSpace(scanner.Text()); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// IsApkMissing tells if the APK path (or the BITRISE_APK_PATH env) does not resolve to an existing APK,
+// like if the build step was skipped.
+func (configs ConfigsModel) IsApkMissing() bool {
+	apkPath := configs.ApkPath
+	if apkPath == "" {
+		apkPath = os.Getenv("BITRISE_APK_PATH")
+	}
+	if IsRemoteURL(apkPath) {
+		return false
+	}
+
+	apkPaths := ParseTestApkPaths(apkPath)
+	if len(apkPaths) == 0 {
+		return true
+	}
+	for _, pth := range apkPaths {
+		if glob.HasMeta(pth) {
+			// an invalid pattern is reported by ResolveApkPaths
+			if matches, err := glob.Glob(pth); err == nil && len(matches) == 0 {
+				return true
+			}
+		} else if _, err := os.Stat(pth); os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveApkPaths expands the glob patterns of the APK paths. If the paths are empty,
+// the APKs exported by the previous steps (BITRISE_APK_PATH and BITRISE_TEST_APK_PATH) are used.
+func (configs *ConfigsModel) ResolveApkPaths() error {
+	apkPath := configs.ApkPath
+	if apkPath == "" {
+		apkPath = os.Getenv("BITRISE_APK_PATH")
+	}
+	if IsRemoteURL(apkPath) {
+		// the APK is downloaded before testing
+		configs.ApkPath = apkPath
+		return configs.resolveTestApkPaths()
+	}
+
+	apkPaths, err := expandApkPaths(apkPath)
+	if err != nil {
+		return fmt.Errorf("Issue with ApkPath: %s", err)
+	}
+	if len(apkPaths) > 1 {
+		// BITRISE_APK_PATH lists the test APKs too
+		var appPaths []string
+		for _, pth := range apkPaths {
+			if !strings.HasSuffix(pth, "-androidTest.apk") {
+				appPaths = append(appPaths, pth)
+			}
+		}
+		if len(appPaths) != 1 {
+			return fmt.Errorf("Issue with ApkPath: (%s) matches multiple APKs, select one of them:\n%s", apkPath, strings.Join(apkPaths, "\n"))
+		}
+		apkPaths = appPaths
+	}
+	configs.ApkPath = strings.Join(apkPaths, "|")
+
+	return configs.resolveTestApkPaths()
+}
+
+func (configs *ConfigsModel) resolveTestApkPaths() error {
+	if configs.TestType == "instrumentation" {
+		testApkPath := configs.TestApkPath
+		if testApkPath == "" {
+			testApkPath = os.Getenv("BITRISE_TEST_APK_PATH")
+		}
+		testApkPaths, err := expandApkPaths(testApkPath)
+		if err != nil {
+			return fmt.Errorf("Issue with TestApkPath: %s", err)
+		}
+		configs.TestApkPath = strings.Join(testApkPaths, "|")
+	}
+
+	return nil
+}
+
+// ResolveTestDevices falls back to the DefaultTestDevice if no test device is set and UseDefaultDevice is enabled.
+// It returns true if the default device is used.
+func (configs *ConfigsModel) ResolveTestDevices() bool {
+	if strings.TrimSpace(configs.TestDevices) != "" || configs.UseDefaultDevice != "true" {
+		return false
+	}
+	configs.TestDevices = DefaultTestDevice
+	return true
+}
+
+// UseLocalMock points the step to the fake API at apiBaseURL in local-mock mode, instead of the add-on or Firebase Test Lab.
+// The build and the app slugs get a placeholder if the step runs outside of a build,
+// and the notifications and the metrics are disabled, as they would report the results of a fake test.
+func (configs *ConfigsModel) UseLocalMock(apiBaseURL string) {
+	configs.APIBaseURL = apiBaseURL
+	configs.APIToken = "local-mock"
+	configs.APITokenFile = ""
+	configs.ServiceAccountJSON = ""
+	if configs.BuildSlug == "" {
+		configs.BuildSlug = "local-mock"
+	}
+	if configs.AppSlug == "" {
+		configs.AppSlug = "local-mock"
+	}
+
+	configs.SlackWebhookURL = ""
+	configs.ResultWebhookURL = ""
+	configs.AnnotateBuild = "false"
+	configs.StatsDAddress = ""
+	configs.PushgatewayURL = ""
+	configs.OTLPEndpoint = ""
+}
+
+// DeduplicateTestDevices removes the repeated devices of TestDevices and returns the removed lines.
+// The devices are compared after parsing, so the lines differing only in whitespace are the same device,
+// the lines which can not be parsed are left for the validation.
+func (configs *ConfigsModel) DeduplicateTestDevices() []string {
+	var lines, keys, duplicates []string
+	for _, line := range ParseList(configs.TestDevices) {
+		key := line
+		if devices, err := ParseTestDevices(line); err == nil && len(devices) == 1 {
+			key = devices[0].String() + "," + devices[0].Timeout
+		}
+		if sliceutil.IsStringInSlice(key, keys) {
+			duplicates = append(duplicates, line)
+			continue
+		}
+		lines = append(lines, line)
+		keys = append(keys, key)
+	}
+	configs.TestDevices = strings.Join(lines, "\n")
+	return duplicates
+}
+
+func expandApkPaths(paths string) ([]string, error) {
+	var expanded []string
+	for _, pth := range ParseTestApkPaths(paths) {
+		if !glob.HasMeta(pth) {
+			expanded = append(expanded, pth)
+			continue
+		}
+
+		matches, err := glob.Glob(pth)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no APK matches the pattern (%s)", pth)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// IsRemoteURL tells if the APK path is an https:// URL, which is downloaded before testing.
+func IsRemoteURL(pth string) bool {
+	return strings.HasPrefix(pth, "https://")
+}
+
+// ParseTestApkPaths parses the test APK paths, separated by newlines or `|`.
+func ParseTestApkPaths(paths string) []string {
+	return ParseList(strings.Replace(paths, "|", "\n", -1))
+}
+
+// ParseScenarios parses a comma separated list of game loop scenarios and scenario ranges, like: `1-5,8,10-12`.
+func ParseScenarios(scenarios string) ([]int64, error) {
+	parsed := []int64{}
+	seen := map[int64]bool{}
+	add := func(scenario int64) {
+		if !seen[scenario] {
+			seen[scenario] = true
+			parsed = append(parsed, scenario)
+		}
+	}
+
+	for _, item := range strings.Split(strings.TrimSpace(scenarios), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(item, "-", 2)
+		start, err := parseScenario(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scenario (%s): %s", item, err)
+		}
+		if len(bounds) == 1 {
+			add(start)
+			continue
+		}
+
+		end, err := parseScenario(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scenario range (%s): %s", item, err)
+		}
+		if start > end {
+			return nil, fmt.Errorf("Invalid scenario range (%s): the start is greater than the end", item)
+		}
+		for scenario := start; scenario <= end; scenario++ {
+			add(scenario)
+		}
+	}
+	return parsed, nil
+}
+
+func parseScenario(scenario string) (int64, error) {
+	parsed, err := strconv.ParseInt(strings.TrimSpace(scenario), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("not an integer: %q", strings.TrimSpace(scenario))
+	}
+	if parsed < 1 {
+		return 0, fmt.Errorf("scenarios are numbered from 1, got: %d", parsed)
+	}
+	return parsed, nil
+}
+
+// ParseTimeout parses a timeout given in seconds or as a duration (like: `15m`, `900s` or `1h`),
+// an empty value means the default should be used.
+func ParseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.Atoi(timeout); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("timeout should be a positive number of seconds, got: %d", seconds)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("timeout should be a number of seconds or a duration (like: 15m), got: %s", timeout)
+	}
+	if duration < time.Second {
+		return 0, fmt.Errorf("timeout should be at least 1s, got: %s", timeout)
+	}
+
+	return duration, nil
+}
+
+// ParseWebhookHeaders parses one `Key: Value` header per line.
+func ParseWebhookHeaders(headers string) (map[string]string, error) {
+	parsed := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(headers))
+	for scanner.Scan() {
+		header := strings.TrimSpace(scanner.Text())
+		if header == "" {
+			continue
+		}
+
+		headerSplit := strings.SplitN(header, ":", 2)
+		if len(headerSplit) != 2 || strings.TrimSpace(headerSplit[0]) == "" {
+			return nil, fmt.Errorf("Invalid header configuration: %s", header)
+		}
+		parsed[strings.TrimSpace(headerSplit[0])] = strings.TrimSpace(headerSplit[1])
+	}
+	return parsed, nil
+}
@@ -0,0 +1,730 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{timeout: "", want: 0},
+		{timeout: "900", want: 15 * time.Minute},
+		{timeout: "15m", want: 15 * time.Minute},
+		{timeout: "1h30m", want: 90 * time.Minute},
+		{timeout: "1s", want: time.Second},
+		{timeout: "0", wantErr: true},
+		{timeout: "-5", wantErr: true},
+		{timeout: "500ms", wantErr: true},
+		{timeout: "fifteen minutes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimeout(tt.timeout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimeout(%q) error = %v, wantErr %v", tt.timeout, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTimeout(%q) = %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestParseScenarios(t *testing.T) {
+	tests := []struct {
+		scenarios string
+		want      []int64
+		wantErr   bool
+	}{
+		{scenarios: "", want: []int64{}},
+		{scenarios: "3", want: []int64{3}},
+		{scenarios: "1-3, 5", want: []int64{1, 2, 3, 5}},
+		{scenarios: "2-4,3,1-2", want: []int64{2, 3, 4, 1}},
+		{scenarios: "1,,2,", want: []int64{1, 2}},
+		{scenarios: "4-4", want: []int64{4}},
+		{scenarios: "0", wantErr: true},
+		{scenarios: "a", wantErr: true},
+		{scenarios: "5-3", wantErr: true},
+		{scenarios: "1-b", wantErr: true},
+		{scenarios: "1-2-3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseScenarios(tt.scenarios)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseScenarios(%q) error = %v, wantErr %v", tt.scenarios, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseScenarios(%q) = %v, want %v", tt.scenarios, got, tt.want)
+		}
+	}
+}
+
+// createFiles creates empty files in a temporary directory and returns the directory.
+func createFiles(t *testing.T, names ...string) string {
+	dir, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	for _, name := range names {
+		pth := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatalf("Failed to create dir, error: %s", err)
+		}
+		if err := ioutil.WriteFile(pth, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file, error: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestResolveApkPaths(t *testing.T) {
+	dir := createFiles(t,
+		"app/build/outputs/apk/debug/app-debug.apk",
+		"app/build/outputs/apk/androidTest/debug/app-debug-androidTest.apk",
+		"lib/build/outputs/apk/debug/lib-debug.apk",
+	)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+	appApk := filepath.Join(dir, "app/build/outputs/apk/debug/app-debug.apk")
+	testApk := filepath.Join(dir, "app/build/outputs/apk/androidTest/debug/app-debug-androidTest.apk")
+
+	tests := []struct {
+		name            string
+		apkPath         string
+		testApkPath     string
+		testType        string
+		envApkPath      string
+		envTestApkPath  string
+		wantApkPath     string
+		wantTestApkPath string
+		wantErr         string
+	}{
+		{
+			name:        "plain paths are kept",
+			apkPath:     appApk,
+			testApkPath: testApk,
+			testType:    "instrumentation",
+			wantApkPath: appApk, wantTestApkPath: testApk,
+		},
+		{
+			name:        "glob patterns are expanded",
+			apkPath:     filepath.Join(dir, "app/**/debug/app-debug.apk"),
+			testApkPath: filepath.Join(dir, "**/*-androidTest.apk"),
+			testType:    "instrumentation",
+			wantApkPath: appApk, wantTestApkPath: testApk,
+		},
+		{
+			name:           "the exported APKs are used if the paths are empty",
+			testType:       "instrumentation",
+			envApkPath:     appApk + "|" + testApk,
+			envTestApkPath: testApk,
+			wantApkPath:    appApk, wantTestApkPath: testApk,
+		},
+		{
+			name:        "the test APK is not resolved for robo tests",
+			apkPath:     appApk,
+			testApkPath: filepath.Join(dir, "*.apk"),
+			testType:    "robo",
+			wantApkPath: appApk, wantTestApkPath: filepath.Join(dir, "*.apk"),
+		},
+		{
+			name:        "remote URLs are kept",
+			apkPath:     "https://example.com/app.apk",
+			testType:    "robo",
+			wantApkPath: "https://example.com/app.apk",
+		},
+		{
+			name:     "pattern matching multiple apps",
+			apkPath:  filepath.Join(dir, "**/*-debug.apk"),
+			testType: "robo",
+			wantErr:  "Issue with ApkPath: (" + filepath.Join(dir, "**/*-debug.apk") + ") matches multiple APKs",
+		},
+		{
+			name:     "pattern without match",
+			apkPath:  filepath.Join(dir, "**/*-release.apk"),
+			testType: "robo",
+			wantErr:  "Issue with ApkPath: no APK matches the pattern",
+		},
+		{
+			name:        "test APK pattern without match",
+			apkPath:     appApk,
+			testApkPath: filepath.Join(dir, "**/*-release-androidTest.apk"),
+			testType:    "instrumentation",
+			wantErr:     "Issue with TestApkPath: no APK matches the pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "BITRISE_APK_PATH", tt.envApkPath)
+			setenv(t, "BITRISE_TEST_APK_PATH", tt.envTestApkPath)
+
+			configs := ConfigsModel{ApkPath: tt.apkPath, TestApkPath: tt.testApkPath, TestType: tt.testType}
+			err := configs.ResolveApkPaths()
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveApkPaths() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveApkPaths() unexpected error: %s", err)
+			}
+			if configs.ApkPath != tt.wantApkPath {
+				t.Errorf("ApkPath = %s, want %s", configs.ApkPath, tt.wantApkPath)
+			}
+			if configs.TestApkPath != tt.wantTestApkPath {
+				t.Errorf("TestApkPath = %s, want %s", configs.TestApkPath, tt.wantTestApkPath)
+			}
+		})
+	}
+}
+
+// setenv sets the env var for the duration of the test, an empty value unsets it.
+func setenv(t *testing.T, key, value string) {
+	original, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, original)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// validConfigs returns the configs of an instrumentation test run, passing the validation.
+func validConfigs(dir string) ConfigsModel {
+	return ConfigsModel{
+		APIBaseURL:            "https://vdt.bitrise.io/test",
+		APIToken:              "token",
+		BuildSlug:             "build-slug",
+		AppSlug:               "app-slug",
+		Mode:                  "run",
+		ApkPath:               filepath.Join(dir, "app.apk"),
+		AllowMissingApk:       "false",
+		TestApkPath:           filepath.Join(dir, "app-androidTest.apk"),
+		TestType:              "instrumentation",
+		TestDevices:           "NexusLowRes,30,en,portrait",
+		UseDefaultDevice:      "false",
+		DownloadTestResults:   "false",
+		ZipTestAssets:         "false",
+		PrefixAssetNames:      "false",
+		EnableCoverage:        "false",
+		MergeCoverage:         "false",
+		AnnotateBuild:         "false",
+		FailOnSkipped:         "false",
+		FailOnInconclusive:    "false",
+		FailOnFlaky:           "false",
+		RerunFailedDevices:    "0",
+		RerunFailedTests:      "0",
+		MaxMatrixRetries:      "0",
+		FailFast:              "false",
+		WaitForResults:        "true",
+		WaitForQuota:          "false",
+		VirtualOnly:           "false",
+		FailOnIncompatibleABI: "false",
+		FailOnIncompatibleSDK: "false",
+		DryRun:                "false",
+		Quiet:                 "false",
+		DisableColors:         "false",
+		ResultsSort:           "outcome",
+		WideResults:           "false",
+		StreamLogcat:          "false",
+		Verbose:               "false",
+		DumpResponses:         "false",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := createFiles(t, "app.apk", "app-androidTest.apk")
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		modify  func(configs *ConfigsModel)
+		wantErr string
+	}{
+		{name: "valid", modify: func(configs *ConfigsModel) {}},
+		{
+			name:    "missing API token",
+			modify:  func(configs *ConfigsModel) { configs.APIToken = "" },
+			wantErr: "Issue with APIToken",
+		},
+		{
+			name: "the token file replaces the token",
+			modify: func(configs *ConfigsModel) {
+				configs.APIToken = ""
+				configs.APITokenFile = filepath.Join(dir, "app.apk")
+			},
+		},
+		{
+			name:    "Firebase needs a bucket",
+			modify:  func(configs *ConfigsModel) { configs.ServiceAccountJSON = "{}" },
+			wantErr: "Issue with GCSBucket",
+		},
+		{
+			name:    "unknown mode",
+			modify:  func(configs *ConfigsModel) { configs.Mode = "walk" },
+			wantErr: "Issue with Mode",
+		},
+		{
+			name: "listing the network profiles needs no test",
+			modify: func(configs *ConfigsModel) {
+				configs.Mode = "list-network-profiles"
+				configs.TestDevices = ""
+				configs.ApkPath = ""
+			},
+		},
+		{
+			name: "wait mode needs the build slug of the test matrix",
+			modify: func(configs *ConfigsModel) {
+				configs.Mode = "wait"
+				configs.ApkPath = ""
+			},
+			wantErr: "Issue with TestMatrixBuildSlug",
+		},
+		{
+			name: "deflake mode only for instrumentation tests",
+			modify: func(configs *ConfigsModel) {
+				configs.Mode = "deflake"
+				configs.TestType = "robo"
+			},
+			wantErr: "Issue with Mode",
+		},
+		{
+			name: "too many deflake iterations",
+			modify: func(configs *ConfigsModel) {
+				configs.Mode = "deflake"
+				configs.DeflakeIterations = "1000"
+			},
+			wantErr: "Issue with DeflakeIterations",
+		},
+		{
+			name:    "no test device",
+			modify:  func(configs *ConfigsModel) { configs.TestDevices = "" },
+			wantErr: "Issue with TestDevices: no test device is set",
+		},
+		{
+			name:    "invalid test device",
+			modify:  func(configs *ConfigsModel) { configs.TestDevices = "NexusLowRes,30" },
+			wantErr: "Issue with TestDevices",
+		},
+		{
+			name:    "missing APK",
+			modify:  func(configs *ConfigsModel) { configs.ApkPath = filepath.Join(dir, "missing.apk") },
+			wantErr: "Issue with ApkPath",
+		},
+		{
+			name:    "missing test APK",
+			modify:  func(configs *ConfigsModel) { configs.TestApkPath = "" },
+			wantErr: "Issue with TestApkPath",
+		},
+		{
+			name: "remote APK",
+			modify: func(configs *ConfigsModel) {
+				configs.ApkPath = "https://example.com/app.apk"
+			},
+		},
+		{
+			name:    "unknown test type",
+			modify:  func(configs *ConfigsModel) { configs.TestType = "xctest" },
+			wantErr: "Issue with TestType",
+		},
+		{
+			name:    "invalid test timeout",
+			modify:  func(configs *ConfigsModel) { configs.TestTimeout = "soon" },
+			wantErr: "Issue with TestTimeout",
+		},
+		{
+			name:    "too long test timeout",
+			modify:  func(configs *ConfigsModel) { configs.TestTimeout = "2h" },
+			wantErr: "Issue with TestTimeout: should be at most",
+		},
+		{
+			name: "invalid game loop scenarios",
+			modify: func(configs *ConfigsModel) {
+				configs.TestType = "gameloop"
+				configs.LoopScenarios = "3-1"
+			},
+			wantErr: "Issue with LoopScenarios",
+		},
+		{
+			name:    "negative rerun count",
+			modify:  func(configs *ConfigsModel) { configs.RerunFailedDevices = "-1" },
+			wantErr: "Issue with RerunFailedDevices",
+		},
+		{
+			name: "zipped assets need the download",
+			modify: func(configs *ConfigsModel) {
+				configs.ZipTestAssets = "true"
+			},
+			wantErr: "Issue with ZipTestAssets",
+		},
+		{
+			name:    "pulling from an unsupported directory",
+			modify:  func(configs *ConfigsModel) { configs.DirectoriesToPull = "/system" },
+			wantErr: "Issue with DirectoriesToPull",
+		},
+		{
+			name:    "metrics need a prefix",
+			modify:  func(configs *ConfigsModel) { configs.StatsDAddress = "localhost:8125" },
+			wantErr: "Issue with MetricsPrefix",
+		},
+		{
+			name:    "invalid boolean",
+			modify:  func(configs *ConfigsModel) { configs.FailFast = "yes" },
+			wantErr: "Issue with FailFast",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := validConfigs(dir)
+			tt.modify(&configs)
+
+			err := configs.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	pth := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file, error: %s", err)
+	}
+	return pth
+}
+
+func TestParseShardsFile(t *testing.T) {
+	dir := createFiles(t)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Shard
+		wantErr string
+	}{
+		{
+			name:    "JSON object",
+			content: `{"login": ["class com.example.LoginTest", " "], "checkout": ["package com.example.checkout"]}`,
+			want: []Shard{
+				{Name: "checkout", Targets: []string{"package com.example.checkout"}},
+				{Name: "login", Targets: []string{"class com.example.LoginTest"}},
+			},
+		},
+		{
+			name:    "JSON list",
+			content: `[["class com.example.LoginTest"], ["package com.example.checkout"]]`,
+			want: []Shard{
+				{Name: "shard-0", Targets: []string{"class com.example.LoginTest"}},
+				{Name: "shard-1", Targets: []string{"package com.example.checkout"}},
+			},
+		},
+		{
+			name:    "JSON under the shards key",
+			content: `{"shards": {"login": ["class com.example.LoginTest"]}}`,
+			want:    []Shard{{Name: "login", Targets: []string{"class com.example.LoginTest"}}},
+		},
+		{
+			name: "YAML mapping",
+			content: `# generated by the affected modules
+login:
+  - class com.example.LoginTest # the slow one
+  - "class com.example.LogoutTest"
+checkout:
+- 'package com.example.checkout'
+`,
+			want: []Shard{
+				{Name: "checkout", Targets: []string{"package com.example.checkout"}},
+				{Name: "login", Targets: []string{"class com.example.LoginTest", "class com.example.LogoutTest"}},
+			},
+		},
+		{
+			name: "YAML nested lists",
+			content: `shards:
+  -
+    - class com.example.LoginTest
+  - [class com.example.CheckoutTest, "class com.example.CartTest"]
+`,
+			want: []Shard{
+				{Name: "shard-0", Targets: []string{"class com.example.LoginTest"}},
+				{Name: "shard-1", Targets: []string{"class com.example.CheckoutTest", "class com.example.CartTest"}},
+			},
+		},
+		{
+			name: "YAML flank output",
+			content: `matrix-0:
+  shard-0:
+    - class com.example.LoginTest#login
+  shard-1:
+    - class com.example.LoginTest#logout
+`,
+			want: []Shard{
+				{Name: "matrix-0/shard-0", Targets: []string{"class com.example.LoginTest#login"}},
+				{Name: "matrix-0/shard-1", Targets: []string{"class com.example.LoginTest#logout"}},
+			},
+		},
+		{
+			name:    "quoted YAML scalars",
+			content: "login:\n  - \"class com.example.Login\\u0054est # not a comment\"\n  - 'it''s: quoted'\n",
+			want:    []Shard{{Name: "login", Targets: []string{"class com.example.LoginTest # not a comment", "it's: quoted"}}},
+		},
+		{
+			name:    "bad YAML indentation",
+			content: "login:\n  - class com.example.LoginTest\n    - class com.example.LogoutTest\n   checkout: []\n",
+			wantErr: "Failed to parse shards file",
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"login": ["class com.example.LoginTest"]`,
+			wantErr: "Failed to parse shards file",
+		},
+		{
+			name:    "numeric test target",
+			content: "login:\n  - 42\n",
+			wantErr: "Invalid shards file",
+		},
+		{
+			name:    "shard without test targets",
+			content: `{"login": []}`,
+			wantErr: "Invalid shards file",
+		},
+		{
+			name:    "scalar shard",
+			content: "login: class com.example.LoginTest\n",
+			wantErr: "Invalid shards file",
+		},
+		{
+			name:    "empty file",
+			content: "# no shards\n",
+			wantErr: "Invalid shards file",
+		},
+		{
+			name:    "too many shards",
+			content: "[" + strings.TrimSuffix(strings.Repeat(`["class com.example.LoginTest"],`, MaxShards+1), ",") + "]",
+			wantErr: "Invalid shards file",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := writeFile(t, dir, fmt.Sprintf("shards-%d", i), tt.content)
+
+			got, err := ParseShardsFile(pth)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseShardsFile() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseShardsFile() unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseShardsFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	dir := createFiles(t)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "JSON",
+			content: `{
+  "test_type": "robo",
+  "test_devices": ["NexusLowRes,24,en,portrait", "NexusLowRes,24,en,landscape"],
+  "robo_max_depth": 10,
+  "fail_fast": true,
+  "inst_test_targets": null
+}`,
+			want: map[string]string{
+				"test_type":         "robo",
+				"test_devices":      "NexusLowRes,24,en,portrait\nNexusLowRes,24,en,landscape",
+				"robo_max_depth":    "10",
+				"fail_fast":         "true",
+				"inst_test_targets": "",
+			},
+		},
+		{
+			name: "YAML",
+			content: `# the nightly matrix
+test_type: robo
+test_devices:
+  - NexusLowRes,24,en,portrait
+  - NexusLowRes,24,en,landscape
+robo_max_depth: 10
+test_timeout: 1.5
+fail_fast: false
+environment_variables: [clearPackageData=true, "coverage=true"]
+inst_test_targets:
+`,
+			want: map[string]string{
+				"test_type":             "robo",
+				"test_devices":          "NexusLowRes,24,en,portrait\nNexusLowRes,24,en,landscape",
+				"robo_max_depth":        "10",
+				"test_timeout":          "1.5",
+				"fail_fast":             "false",
+				"environment_variables": "clearPackageData=true\ncoverage=true",
+				"inst_test_targets":     "",
+			},
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"test_type": "robo",}`,
+			wantErr: "Failed to parse config file",
+		},
+		{
+			name:    "invalid YAML",
+			content: "test_type: robo\n  test_devices: NexusLowRes,24,en,portrait\n",
+			wantErr: "Failed to parse config file",
+		},
+		{
+			name:    "not an object",
+			content: `["test_type", "robo"]`,
+			wantErr: "Invalid config file",
+		},
+		{
+			name:    "empty YAML",
+			content: "# nothing to override\n",
+			wantErr: "Invalid config file",
+		},
+		{
+			name:    "nested array",
+			content: `{"test_devices": [["NexusLowRes,24,en,portrait"]]}`,
+			wantErr: "Invalid value of (test_devices)",
+		},
+		{
+			name:    "object value",
+			content: "robo_directives:\n  username: user\n",
+			wantErr: "Invalid value of (robo_directives)",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key := range tt.want {
+				setenv(t, key, "original")
+			}
+			pth := writeFile(t, dir, fmt.Sprintf("config-%d", i), tt.content)
+
+			err := ApplyConfigFile(pth)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyConfigFile() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyConfigFile() unexpected error: %s", err)
+			}
+			for key, want := range tt.want {
+				if got := os.Getenv(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeduplicateTestDevices(t *testing.T) {
+	tests := []struct {
+		name           string
+		testDevices    string
+		wantDevices    string
+		wantDuplicates []string
+	}{
+		{
+			name:           "repeated lines",
+			testDevices:    "NexusLowRes,30,en,portrait\nPixel2,28,en,portrait\nNexusLowRes,30,en,portrait\n",
+			wantDevices:    "NexusLowRes,30,en,portrait\nPixel2,28,en,portrait",
+			wantDuplicates: []string{"NexusLowRes,30,en,portrait"},
+		},
+		{
+			name:           "lines differing only in whitespace",
+			testDevices:    "NexusLowRes,30,en,portrait\n  NexusLowRes, 30 ,en,\tportrait\r\nNexusLowRes , 30, en, portrait",
+			wantDevices:    "NexusLowRes,30,en,portrait",
+			wantDuplicates: []string{"NexusLowRes, 30 ,en,\tportrait", "NexusLowRes , 30, en, portrait"},
+		},
+		{
+			name:        "different test timeouts",
+			testDevices: "NexusLowRes,30,en,portrait\nNexusLowRes,30,en,portrait,30m",
+			wantDevices: "NexusLowRes,30,en,portrait\nNexusLowRes,30,en,portrait,30m",
+		},
+		{
+			name:           "invalid lines are left for the validation",
+			testDevices:    "NexusLowRes,30\nNexusLowRes,30\nNexusLowRes, 30",
+			wantDevices:    "NexusLowRes,30\nNexusLowRes, 30",
+			wantDuplicates: []string{"NexusLowRes,30"},
+		},
+		{
+			name:        "no duplicates",
+			testDevices: "NexusLowRes,30,en,portrait\n\nNexusLowRes,30,en,landscape",
+			wantDevices: "NexusLowRes,30,en,portrait\nNexusLowRes,30,en,landscape",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := ConfigsModel{TestDevices: tt.testDevices}
+			duplicates := configs.DeduplicateTestDevices()
+			if !reflect.DeepEqual(duplicates, tt.wantDuplicates) {
+				t.Errorf("DeduplicateTestDevices() = %q, want %q", duplicates, tt.wantDuplicates)
+			}
+			if configs.TestDevices != tt.wantDevices {
+				t.Errorf("TestDevices = %q, want %q", configs.TestDevices, tt.wantDevices)
+			}
+		})
+	}
+}
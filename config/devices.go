@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+// TestDevice is a line of TestDevices: `model,version,locale,orientation`,
+// optionally followed by the test timeout of the device, overriding TestTimeout.
+type TestDevice struct {
+	Model       string
+	Version     string
+	Locale      string
+	Orientation string
+	Timeout     string
+}
+
+// ParseTestDevices parses the TestDevices lines, empty lines are skipped.
+func ParseTestDevices(devices string) ([]TestDevice, error) {
+	parsed := []TestDevice{}
+	for _, line := range ParseList(devices) {
+		params := strings.Split(line, ",")
+		if len(params) != 4 && len(params) != 5 {
+			return nil, fmt.Errorf("Invalid test device configuration: %s", line)
+		}
+		for i := range params {
+			params[i] = strings.TrimSpace(params[i])
+		}
+
+		device := TestDevice{Model: params[0], Version: params[1], Locale: params[2], Orientation: params[3]}
+		if len(params) == 5 {
+			if _, err := ParseTimeout(params[4]); err != nil {
+				return nil, fmt.Errorf("Invalid test timeout of test device (%s): %s", line, err)
+			}
+			device.Timeout = params[4]
+		}
+		parsed = append(parsed, device)
+	}
+	return parsed, nil
+}
+
+// autoOrientations are the orientations resolved to the default orientation of the catalog.
+var autoOrientations = []string{"default", "auto"}
+
+// HasAutoOrientation returns true if the orientation of any test device is `default` or `auto`.
+func (configs ConfigsModel) HasAutoOrientation() bool {
+	devices, err := ParseTestDevices(configs.TestDevices)
+	if err != nil {
+		return false
+	}
+	for _, device := range devices {
+		if sliceutil.IsStringInSlice(device.Orientation, autoOrientations) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAutoOrientation replaces the `default` and `auto` orientations of the test devices with orientation.
+func (configs *ConfigsModel) ResolveAutoOrientation(orientation string) error {
+	devices, err := ParseTestDevices(configs.TestDevices)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, device := range devices {
+		if sliceutil.IsStringInSlice(device.Orientation, autoOrientations) {
+			device.Orientation = orientation
+		}
+		line := device.String()
+		if device.Timeout != "" {
+			line += "," + device.Timeout
+		}
+		lines = append(lines, line)
+	}
+	configs.TestDevices = strings.Join(lines, "\n")
+	return nil
+}
+
+// String returns the device line, without the test timeout.
+func (device TestDevice) String() string {
+	return strings.Join([]string{device.Model, device.Version, device.Locale, device.Orientation}, ",")
+}
+
+// SplitByTestTimeout returns the configs of the test matrices needed to run the devices with their own test timeouts:
+// Test Lab applies a single test timeout to every device of a matrix. The devices without a test timeout come first, with TestTimeout.
+func (configs ConfigsModel) SplitByTestTimeout() ([]ConfigsModel, error) {
+	devices, err := ParseTestDevices(configs.TestDevices)
+	if err != nil {
+		return nil, err
+	}
+
+	// the devices without a test timeout come first
+	sorted := []TestDevice{}
+	for _, device := range devices {
+		if device.Timeout == "" {
+			sorted = append(sorted, device)
+		}
+	}
+	for _, device := range devices {
+		if device.Timeout != "" {
+			sorted = append(sorted, device)
+		}
+	}
+
+	// the timeouts are compared by their duration, `900` and `15m` are the same
+	groups := []ConfigsModel{}
+	groupByDuration := map[time.Duration]int{}
+	for _, device := range sorted {
+		timeout := device.Timeout
+		if timeout == "" {
+			timeout = configs.TestTimeout
+		}
+		duration, err := ParseTimeout(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid test timeout (%s): %s", timeout, err)
+		}
+
+		i, ok := groupByDuration[duration]
+		if !ok {
+			group := configs
+			group.TestTimeout = timeout
+			group.TestDevices = ""
+			groups = append(groups, group)
+			i = len(groups) - 1
+			groupByDuration[duration] = i
+		}
+		groups[i].TestDevices = strings.TrimPrefix(groups[i].TestDevices+"\n"+device.String(), "\n")
+	}
+	return groups, nil
+}
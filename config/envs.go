@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnvironmentVariable is a variable set for the instrumentation test run.
+type EnvironmentVariable struct {
+	Key   string
+	Value string
+}
+
+// ParseEnvironmentVariables parses the environment variables of the test run.
+// The variables are either a JSON object, like `{"coverage": "true"}`, or one `KEY=value` per line.
+// In the line format the value can be double quoted, where `\n`, `\t`, `\r`, `\"` and `\\` are escaped
+// and the value can span multiple lines, or single quoted, where the value is taken literally.
+// Empty lines and lines starting with `#` are skipped.
+func ParseEnvironmentVariables(envs string) ([]EnvironmentVariable, error) {
+	trimmed := strings.TrimSpace(envs)
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJSONEnvironmentVariables(trimmed)
+	}
+
+	parsed := []EnvironmentVariable{}
+	line := 0
+	for rest := envs; rest != ""; {
+		var current string
+		current, rest = cutLine(rest)
+		line++
+
+		if item := strings.TrimSpace(current); item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+
+		keyValue := strings.SplitN(current, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("Invalid environment variable in line %d, no `=` found: %s", line, current)
+		}
+		if key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("Invalid environment variable name in line %d: %q", line, key)
+		}
+
+		value := strings.TrimLeft(keyValue[1], " \t")
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			parsed = append(parsed, EnvironmentVariable{Key: key, Value: keyValue[1]})
+			continue
+		}
+
+		// the quoted value may continue in the next lines
+		startLine := line
+		quoted := value + "\n" + rest
+		value, remaining, err := unquoteValue(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of environment variable (%s) in line %d: %s", key, startLine, err)
+		}
+		line += strings.Count(quoted[:len(quoted)-len(remaining)], "\n")
+
+		trailing, next := cutLine(remaining)
+		if strings.TrimSpace(trailing) != "" {
+			return nil, fmt.Errorf("Invalid value of environment variable (%s) in line %d: unexpected characters after the closing quote: %s", key, startLine, trailing)
+		}
+		rest = next
+
+		parsed = append(parsed, EnvironmentVariable{Key: key, Value: value})
+	}
+	return parsed, nil
+}
+
+// cutLine returns the first line of s, without the line ending, and the rest after it.
+func cutLine(s string) (string, string) {
+	line, rest := s, ""
+	if i := strings.Index(s, "\n"); i >= 0 {
+		line, rest = s[:i], s[i+1:]
+	}
+	return strings.TrimSuffix(line, "\r"), rest
+}
+
+// unquoteValue unquotes the value at the start of s and returns the part of s after the closing quote.
+func unquoteValue(s string) (string, string, error) {
+	quote := s[0]
+	if quote == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("missing closing quote")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", fmt.Errorf("missing closing quote")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			case '"', '\\':
+				value.WriteByte(s[i])
+			default:
+				return "", "", fmt.Errorf("unknown escape sequence: \\%c", s[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("missing closing quote")
+}
+
+func parseJSONEnvironmentVariables(envs string) ([]EnvironmentVariable, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(envs)))
+	decoder.UseNumber()
+	var variables map[string]interface{}
+	if err := decoder.Decode(&variables); err != nil {
+		return nil, fmt.Errorf("Failed to parse environment variables JSON, error: %s", err)
+	}
+
+	keys := make([]string, 0, len(variables))
+	for key := range variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parsed := []EnvironmentVariable{}
+	for _, key := range keys {
+		_, isArray := variables[key].([]interface{})
+		value, err := configFileValue(variables[key])
+		if isArray || err != nil {
+			return nil, fmt.Errorf("Invalid value of environment variable (%s): should be a string, number or boolean", key)
+		}
+		parsed = append(parsed, EnvironmentVariable{Key: key, Value: value})
+	}
+	return parsed, nil
+}
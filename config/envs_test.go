@@ -0,0 +1,120 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvironmentVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		envs    string
+		want    []EnvironmentVariable
+		wantErr string
+	}{
+		{
+			name: "KEY=value lines",
+			envs: "coverage=true\n\n# a comment\r\nFILTER=a=b,c\r\nEMPTY=\n",
+			want: []EnvironmentVariable{{Key: "coverage", Value: "true"}, {Key: "FILTER", Value: "a=b,c"}, {Key: "EMPTY", Value: ""}},
+		},
+		{
+			name: "double quoted values",
+			envs: `ARGS="--filter=a,b" ` + "\n" + `ESCAPED="tab\there \"quoted\" back\\slash\nnext"`,
+			want: []EnvironmentVariable{{Key: "ARGS", Value: "--filter=a,b"}, {Key: "ESCAPED", Value: "tab\there \"quoted\" back\\slash\nnext"}},
+		},
+		{
+			name: "multiline double quoted value",
+			envs: "CERT=\"line 1\nline 2\"\nNEXT=value",
+			want: []EnvironmentVariable{{Key: "CERT", Value: "line 1\nline 2"}, {Key: "NEXT", Value: "value"}},
+		},
+		{
+			name: "single quoted value",
+			envs: `PATTERN='a\n"b",c=d'`,
+			want: []EnvironmentVariable{{Key: "PATTERN", Value: `a\n"b",c=d`}},
+		},
+		{
+			name: "JSON object",
+			envs: ` {"coverage": "true", "retries": 3, "debug": false, "filter": "a=b,c"}`,
+			want: []EnvironmentVariable{
+				{Key: "coverage", Value: "true"},
+				{Key: "debug", Value: "false"},
+				{Key: "filter", Value: "a=b,c"},
+				{Key: "retries", Value: "3"},
+			},
+		},
+		{
+			name: "empty",
+			envs: "\n",
+			want: []EnvironmentVariable{},
+		},
+		{
+			name:    "no equals sign",
+			envs:    "coverage=true\ndebug",
+			wantErr: "Invalid environment variable in line 2, no `=` found",
+		},
+		{
+			name:    "empty name",
+			envs:    "=true",
+			wantErr: "Invalid environment variable name in line 1",
+		},
+		{
+			name:    "name with a space",
+			envs:    "MY VAR=true",
+			wantErr: "Invalid environment variable name in line 1",
+		},
+		{
+			name:    "unterminated double quote",
+			envs:    "A=1\nARGS=\"--filter\nB=2",
+			wantErr: "Invalid value of environment variable (ARGS) in line 2: missing closing quote",
+		},
+		{
+			name:    "unterminated single quote",
+			envs:    "ARGS='--filter",
+			wantErr: "Invalid value of environment variable (ARGS) in line 1: missing closing quote",
+		},
+		{
+			name:    "unknown escape",
+			envs:    `ARGS="\x"`,
+			wantErr: "Invalid value of environment variable (ARGS) in line 1: unknown escape sequence",
+		},
+		{
+			name:    "characters after the closing quote",
+			envs:    "CERT=\"line 1\nline 2\" tail\nNEXT=value",
+			wantErr: "Invalid value of environment variable (CERT) in line 1: unexpected characters after the closing quote",
+		},
+		{
+			name:    "invalid JSON",
+			envs:    `{"coverage": true`,
+			wantErr: "Failed to parse environment variables JSON",
+		},
+		{
+			name:    "JSON array value",
+			envs:    `{"coverage": ["true"]}`,
+			wantErr: "Invalid value of environment variable (coverage)",
+		},
+		{
+			name:    "JSON object value",
+			envs:    `{"coverage": {"enabled": true}}`,
+			wantErr: "Invalid value of environment variable (coverage)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEnvironmentVariables(tt.envs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseEnvironmentVariables() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEnvironmentVariables() unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEnvironmentVariables() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
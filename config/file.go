@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ApplyConfigFile sets the inputs of the JSON or YAML config file at pth as environment variables, overriding the inputs set by the step.
+// The file is an object keyed by the input names, like `test_devices`. The values are strings, numbers, booleans,
+// or arrays of them, which are joined by newlines (like the lines of `test_devices`).
+func ApplyConfigFile(pth string) error {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file (%s), error: %s", pth, err)
+	}
+
+	value, err := decodeJSONOrYAML(data)
+	if err != nil {
+		return fmt.Errorf("Failed to parse config file (%s), error: %s", pth, err)
+	}
+	inputs, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid config file (%s): should be an object keyed by the input names", pth)
+	}
+
+	keys := make([]string, 0, len(inputs))
+	for key := range inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := configFileValue(inputs[key])
+		if err != nil {
+			return fmt.Errorf("Invalid value of (%s) in config file (%s): %s", key, pth, err)
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("Failed to set (%s) from config file (%s), error: %s", key, pth, err)
+		}
+	}
+	return nil
+}
+
+func configFileValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		if value {
+			return "true", nil
+		}
+		return "false", nil
+	case []interface{}:
+		lines := []string{}
+		for _, item := range value {
+			if _, ok := item.([]interface{}); ok {
+				return "", fmt.Errorf("nested arrays are not supported")
+			}
+			line, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n"), nil
+	default:
+		return "", fmt.Errorf("should be a string, number, boolean or an array of them")
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// roboActionTypes are the accepted action types of the Robo directives.
+var roboActionTypes = []string{"text", "click", "ignore"}
+
+// RoboDirective is a directive of the Robo test, describing the action on the UI element with the resource name.
+type RoboDirective struct {
+	ResourceName string `json:"resourceName"`
+	InputText    string `json:"inputText"`
+	ActionType   string `json:"actionType"`
+}
+
+// ParseRoboDirectives parses the Robo directives, one `ResourceName,InputText,ActionType` per line,
+// or a JSON list of objects, like `[{"resourceName": "username", "inputText": "john", "actionType": "text"}]`.
+// In the line format a field containing `,` can be double quoted, and `\,`, `\"`, `\\` and `\n` are escaped.
+// Every directive needs a resource name and a `text`, `click` or `ignore` action type.
+func ParseRoboDirectives(directives string) ([]RoboDirective, error) {
+	trimmed := strings.TrimSpace(directives)
+	if strings.HasPrefix(trimmed, "[") {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(trimmed)))
+		decoder.DisallowUnknownFields()
+		parsed := []RoboDirective{}
+		if err := decoder.Decode(&parsed); err != nil {
+			return nil, fmt.Errorf("Failed to parse Robo directives JSON, error: %s", err)
+		}
+		for i, directive := range parsed {
+			if err := directive.validate(); err != nil {
+				return nil, fmt.Errorf("Invalid directive configuration (item %d of the JSON list): %s", i, err)
+			}
+		}
+		return parsed, nil
+	}
+
+	parsed := []RoboDirective{}
+	for _, directive := range ParseList(directives) {
+		fields, err := splitDirective(directive)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid directive configuration (%s): %s", directive, err)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Invalid directive configuration (%s): should have 3 fields, found %d", directive, len(fields))
+		}
+		parsed = append(parsed, RoboDirective{ResourceName: fields[0], InputText: fields[1], ActionType: fields[2]})
+		if err := parsed[len(parsed)-1].validate(); err != nil {
+			return nil, fmt.Errorf("Invalid directive configuration (%s): %s", directive, err)
+		}
+	}
+	return parsed, nil
+}
+
+func (directive RoboDirective) validate() error {
+	if strings.TrimSpace(directive.ResourceName) == "" {
+		return fmt.Errorf("the resource name is empty")
+	}
+	for _, actionType := range roboActionTypes {
+		if directive.ActionType == actionType {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid action type (%s), should be one of: %s", directive.ActionType, strings.Join(roboActionTypes, ", "))
+}
+
+// splitDirective splits the directive on the commas, which are not escaped or quoted.
+func splitDirective(directive string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	quoted := false
+	for i := 0; i < len(directive); i++ {
+		switch c := directive[i]; {
+		case c == '\\':
+			if i+1 == len(directive) {
+				return nil, fmt.Errorf("unfinished escape sequence at the end")
+			}
+			i++
+			switch directive[i] {
+			case ',', '"', '\\':
+				field.WriteByte(directive[i])
+			case 'n':
+				field.WriteByte('\n')
+			default:
+				return nil, fmt.Errorf("unknown escape sequence: \\%c", directive[i])
+			}
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("missing closing quote")
+	}
+	return append(fields, field.String()), nil
+}
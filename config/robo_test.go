@@ -0,0 +1,117 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRoboDirectives(t *testing.T) {
+	tests := []struct {
+		name       string
+		directives string
+		want       []RoboDirective
+		wantErr    string
+	}{
+		{
+			name:       "lines",
+			directives: "username_resource,username,text\n\n  loginbtn_resource,,click  \nad_resource,,ignore",
+			want: []RoboDirective{
+				{ResourceName: "username_resource", InputText: "username", ActionType: "text"},
+				{ResourceName: "loginbtn_resource", InputText: "", ActionType: "click"},
+				{ResourceName: "ad_resource", InputText: "", ActionType: "ignore"},
+			},
+		},
+		{
+			name:       "quoted field",
+			directives: `address_resource,"1 Main St, Springfield",text`,
+			want:       []RoboDirective{{ResourceName: "address_resource", InputText: "1 Main St, Springfield", ActionType: "text"}},
+		},
+		{
+			name:       "escaped fields",
+			directives: `address_resource,1 Main St\, \"Springfield\"\nback\\slash,text`,
+			want:       []RoboDirective{{ResourceName: "address_resource", InputText: "1 Main St, \"Springfield\"\nback\\slash", ActionType: "text"}},
+		},
+		{
+			name:       "JSON list",
+			directives: ` [{"resourceName": "address_resource", "inputText": "1 Main St, Springfield", "actionType": "text"}, {"resourceName": "loginbtn_resource", "actionType": "click"}]`,
+			want: []RoboDirective{
+				{ResourceName: "address_resource", InputText: "1 Main St, Springfield", ActionType: "text"},
+				{ResourceName: "loginbtn_resource", ActionType: "click"},
+			},
+		},
+		{
+			name:       "empty",
+			directives: "\n",
+			want:       []RoboDirective{},
+		},
+		{
+			name:       "missing field",
+			directives: "username_resource,text",
+			wantErr:    "Invalid directive configuration (username_resource,text): should have 3 fields, found 2",
+		},
+		{
+			name:       "missing resource name",
+			directives: ",username,text",
+			wantErr:    "Invalid directive configuration (,username,text): the resource name is empty",
+		},
+		{
+			name:       "invalid action type",
+			directives: "username_resource,username,type",
+			wantErr:    "Invalid directive configuration (username_resource,username,type): invalid action type (type)",
+		},
+		{
+			name:       "unterminated quote",
+			directives: `address_resource,"1 Main St, Springfield,text`,
+			wantErr:    "Invalid directive configuration",
+		},
+		{
+			name:       "unknown escape",
+			directives: `address_resource,1 Main St\t,text`,
+			wantErr:    "Invalid directive configuration",
+		},
+		{
+			name:       "unfinished escape",
+			directives: `address_resource,username,text\`,
+			wantErr:    "Invalid directive configuration",
+		},
+		{
+			name:       "invalid JSON",
+			directives: `[{"resourceName": "username_resource"`,
+			wantErr:    "Failed to parse Robo directives JSON",
+		},
+		{
+			name:       "unknown JSON field",
+			directives: `[{"resourceName": "username_resource", "inputText": "username", "actionType": "text", "text": "username"}]`,
+			wantErr:    "Failed to parse Robo directives JSON",
+		},
+		{
+			name:       "JSON missing resource name",
+			directives: `[{"inputText": "username", "actionType": "text"}]`,
+			wantErr:    "Invalid directive configuration (item 0 of the JSON list): the resource name is empty",
+		},
+		{
+			name:       "JSON invalid action type",
+			directives: `[{"resourceName": "username_resource", "actionType": "TEXT"}]`,
+			wantErr:    "Invalid directive configuration (item 0 of the JSON list): invalid action type (TEXT)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoboDirectives(tt.directives)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRoboDirectives() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRoboDirectives() unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRoboDirectives() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
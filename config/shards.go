@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MaxShards is the maximum number of manual shards Test Lab accepts.
+const MaxShards = 50
+
+// Shard is a named group of test targets, run as a separate test execution on every device.
+type Shard struct {
+	Name    string
+	Targets []string
+}
+
+// ParseShardsFile reads the shards of the JSON or YAML file at pth. The file maps the shard names to their test targets,
+// like `{"login": ["class com.example.LoginTest"]}`, optionally under a `shards` key, or lists the test targets of the shards
+// without names (named `shard-0`, `shard-1`, ...). The output of `flank android run --dump-shards` (the shards of every matrix) is also accepted.
+func ParseShardsFile(pth string) ([]Shard, error) {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read shards file (%s), error: %s", pth, err)
+	}
+
+	value, err := decodeJSONOrYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse shards file (%s), error: %s", pth, err)
+	}
+
+	shards, err := shardsFromValue(value, "")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid shards file (%s): %s", pth, err)
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("Invalid shards file (%s): no shard found", pth)
+	}
+	if len(shards) > MaxShards {
+		return nil, fmt.Errorf("Invalid shards file (%s): %d shards found, Test Lab runs at most %d shards", pth, len(shards), MaxShards)
+	}
+	return shards, nil
+}
+
+func shardsFromValue(value interface{}, prefix string) ([]Shard, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if shards, ok := value["shards"]; ok {
+			return shardsFromValue(shards, prefix)
+		}
+
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		shards := []Shard{}
+		for _, name := range names {
+			switch item := value[name].(type) {
+			case []interface{}:
+				targets, err := shardTargets(prefix+name, item)
+				if err != nil {
+					return nil, err
+				}
+				shards = append(shards, Shard{Name: prefix + name, Targets: targets})
+			case map[string]interface{}:
+				// a matrix of the flank output
+				matrixShards, err := shardsFromValue(item, prefix+name+"/")
+				if err != nil {
+					return nil, err
+				}
+				shards = append(shards, matrixShards...)
+			default:
+				return nil, fmt.Errorf("the shard (%s) should be a list of test targets", prefix+name)
+			}
+		}
+		return shards, nil
+	case []interface{}:
+		shards := []Shard{}
+		for i, item := range value {
+			name := fmt.Sprintf("%sshard-%d", prefix, i)
+			targets, ok := item.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the shard (%s) should be a list of test targets", name)
+			}
+			parsed, err := shardTargets(name, targets)
+			if err != nil {
+				return nil, err
+			}
+			shards = append(shards, Shard{Name: name, Targets: parsed})
+		}
+		return shards, nil
+	default:
+		return nil, fmt.Errorf("should be an object of the shards, or a list of them")
+	}
+}
+
+func shardTargets(name string, items []interface{}) ([]string, error) {
+	targets := []string{}
+	for _, item := range items {
+		target, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("the test targets of the shard (%s) should be strings", name)
+		}
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("the shard (%s) has no test target", name)
+	}
+	return targets, nil
+}
+
+// decodeJSONOrYAML decodes data as JSON if it is a JSON object or array, as YAML otherwise.
+func decodeJSONOrYAML(data []byte) (interface{}, error) {
+	if trimmed := strings.TrimSpace(string(data)); !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return decodeYAML(data)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeYAML decodes data like encoding/json does with UseNumber: into maps keyed by strings, lists, strings, booleans and json.Numbers.
+func decodeYAML(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return jsonValue(value)
+}
+
+func jsonValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		mapping := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("the key (%v) should be a string", key)
+			}
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			mapping[name] = converted
+		}
+		return mapping, nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	case int, int64, uint64, float64:
+		return json.Number(fmt.Sprint(value)), nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,296 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// JaCoCo execution data file format, see org.jacoco.core.data.ExecutionDataWriter.
+const (
+	blockHeader        = 0x01
+	blockSessionInfo   = 0x10
+	blockExecutionData = 0x11
+
+	magicNumber   = 0xC0C0
+	formatVersion = 0x1007
+)
+
+// SessionInfo ...
+type SessionInfo struct {
+	ID    []byte
+	Start int64
+	Dump  int64
+}
+
+// ClassData is the execution data of a class: a probe is true if the corresponding code was executed.
+type ClassData struct {
+	ID     int64
+	Name   []byte
+	Probes []bool
+}
+
+// ExecutionData is the content of JaCoCo execution data files (`.exec`, `.ec`).
+type ExecutionData struct {
+	Sessions []SessionInfo
+	Classes  []*ClassData
+
+	classIndex map[int64]*ClassData
+}
+
+// NewExecutionData ...
+func NewExecutionData() *ExecutionData {
+	return &ExecutionData{classIndex: map[int64]*ClassData{}}
+}
+
+// ReadFile merges the execution data file at pth into data.
+func (data *ExecutionData) ReadFile(pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to open file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	if err := data.read(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("Failed to read execution data (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+func (data *ExecutionData) read(r *bufio.Reader) error {
+	for {
+		blockType, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch blockType {
+		case blockHeader:
+			var magic, version uint16
+			if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+				return err
+			}
+			if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+				return err
+			}
+			if magic != magicNumber {
+				return fmt.Errorf("invalid magic number: %#x", magic)
+			}
+			if version != formatVersion {
+				return fmt.Errorf("unsupported format version: %#x", version)
+			}
+		case blockSessionInfo:
+			session := SessionInfo{}
+			if session.ID, err = readUTF(r); err != nil {
+				return err
+			}
+			if err := binary.Read(r, binary.BigEndian, &session.Start); err != nil {
+				return err
+			}
+			if err := binary.Read(r, binary.BigEndian, &session.Dump); err != nil {
+				return err
+			}
+			data.Sessions = append(data.Sessions, session)
+		case blockExecutionData:
+			class := &ClassData{}
+			if err := binary.Read(r, binary.BigEndian, &class.ID); err != nil {
+				return err
+			}
+			if class.Name, err = readUTF(r); err != nil {
+				return err
+			}
+			if class.Probes, err = readBooleanArray(r); err != nil {
+				return err
+			}
+			if err := data.merge(class); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown block type: %#x", blockType)
+		}
+	}
+}
+
+// merge adds the class data, a probe of an already known class is executed if it was executed in any of the files.
+func (data *ExecutionData) merge(class *ClassData) error {
+	existing, ok := data.classIndex[class.ID]
+	if !ok {
+		data.classIndex[class.ID] = class
+		data.Classes = append(data.Classes, class)
+		return nil
+	}
+
+	if len(existing.Probes) != len(class.Probes) {
+		return fmt.Errorf("incompatible execution data for class %s", string(class.Name))
+	}
+	for i, probe := range class.Probes {
+		existing.Probes[i] = existing.Probes[i] || probe
+	}
+	return nil
+}
+
+// WriteFile writes the execution data into pth.
+func (data *ExecutionData) WriteFile(pth string) error {
+	f, err := os.Create(pth)
+	if err != nil {
+		return fmt.Errorf("Failed to create file (%s), error: %s", pth, err)
+	}
+
+	w := bufio.NewWriter(f)
+	if err := data.write(w); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("Failed to write execution data (%s), error: %s", pth, err)
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("Failed to write execution data (%s), error: %s", pth, err)
+	}
+	return f.Close()
+}
+
+func (data *ExecutionData) write(w *bufio.Writer) error {
+	if err := w.WriteByte(blockHeader); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, []uint16{magicNumber, formatVersion}); err != nil {
+		return err
+	}
+
+	for _, session := range data.Sessions {
+		if err := w.WriteByte(blockSessionInfo); err != nil {
+			return err
+		}
+		if err := writeUTF(w, session.ID); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, []int64{session.Start, session.Dump}); err != nil {
+			return err
+		}
+	}
+
+	for _, class := range data.Classes {
+		if err := w.WriteByte(blockExecutionData); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, class.ID); err != nil {
+			return err
+		}
+		if err := writeUTF(w, class.Name); err != nil {
+			return err
+		}
+		if err := writeBooleanArray(w, class.Probes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUTF reads a string written by Java's DataOutput.writeUTF, the (modified UTF-8) bytes are kept as they are.
+func readUTF(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeUTF(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint16(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readVarInt(r io.ByteReader) (int, error) {
+	value := 0
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+}
+
+func writeVarInt(w io.ByteWriter, value int) error {
+	for value&^0x7F != 0 {
+		if err := w.WriteByte(byte(value&0x7F | 0x80)); err != nil {
+			return err
+		}
+		value >>= 7
+	}
+	return w.WriteByte(byte(value))
+}
+
+// readBooleanArray reads a boolean array, packed 8 per byte, the least significant bit first.
+func readBooleanArray(r *bufio.Reader) ([]bool, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make([]bool, length)
+	var buffer byte
+	for i := range probes {
+		if i%8 == 0 {
+			if buffer, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		probes[i] = buffer&0x01 != 0
+		buffer >>= 1
+	}
+	return probes, nil
+}
+
+func writeBooleanArray(w *bufio.Writer, probes []bool) error {
+	if err := writeVarInt(w, len(probes)); err != nil {
+		return err
+	}
+
+	var buffer byte
+	for i, probe := range probes {
+		if probe {
+			buffer |= 0x01 << uint(i%8)
+		}
+		if i%8 == 7 {
+			if err := w.WriteByte(buffer); err != nil {
+				return err
+			}
+			buffer = 0
+		}
+	}
+	if len(probes)%8 != 0 {
+		return w.WriteByte(buffer)
+	}
+	return nil
+}
+
+// Merge merges the execution data files into out.
+func Merge(paths []string, out string) error {
+	data := NewExecutionData()
+	for _, pth := range paths {
+		if err := data.ReadFile(pth); err != nil {
+			return err
+		}
+	}
+	return data.WriteFile(out)
+}
@@ -0,0 +1,185 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestData returns execution data of a session with the classes.
+func newTestData(t *testing.T, session string, classes ...*ClassData) *ExecutionData {
+	data := NewExecutionData()
+	data.Sessions = append(data.Sessions, SessionInfo{ID: []byte(session), Start: 1000, Dump: 2000})
+	for _, class := range classes {
+		if err := data.merge(class); err != nil {
+			t.Fatalf("Failed to add class, error: %s", err)
+		}
+	}
+	return data
+}
+
+func createTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "coverage-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	return dir
+}
+
+func removeTempDir(t *testing.T, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Errorf("Failed to remove temp dir, error: %s", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeTempDir(t, dir)
+
+	probes := make([]bool, 200)
+	probes[0], probes[199] = true, true
+	data := newTestData(t, "s",
+		&ClassData{ID: 1, Name: []byte("a"), Probes: []bool{true, false, true, false, false, false, false, false, true}},
+		&ClassData{ID: -2, Name: []byte("b"), Probes: probes},
+		&ClassData{ID: 3, Name: []byte("c"), Probes: []bool{}},
+	)
+	pth := filepath.Join(dir, "coverage.ec")
+	if err := data.WriteFile(pth); err != nil {
+		t.Fatalf("WriteFile() unexpected error: %s", err)
+	}
+
+	got, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatalf("Failed to read file, error: %s", err)
+	}
+	want := []byte{
+		0x01, 0xC0, 0xC0, 0x10, 0x07,
+		0x10, 0x00, 0x01, 's', 0, 0, 0, 0, 0, 0, 0x03, 0xE8, 0, 0, 0, 0, 0, 0, 0x07, 0xD0,
+		// 9 probes take 2 bytes, the least significant bit first
+		0x11, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00, 0x01, 'a', 0x09, 0x05, 0x01,
+	}
+	// 200 probes: the length is a 2 bytes varint, the probes take 25 bytes
+	want = append(want, 0x11, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE, 0x00, 0x01, 'b', 0xC8, 0x01, 0x01)
+	want = append(want, make([]byte, 23)...)
+	want = append(want, 0x80)
+	want = append(want, 0x11, 0, 0, 0, 0, 0, 0, 0, 0x03, 0x00, 0x01, 'c', 0x00)
+	if !bytes.Equal(got, want) {
+		t.Errorf("WriteFile() wrote\n% x\nwant\n% x", got, want)
+	}
+
+	// the written file reads back as it was
+	read := NewExecutionData()
+	if err := read.ReadFile(pth); err != nil {
+		t.Fatalf("ReadFile() unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(read.Sessions, data.Sessions) || !reflect.DeepEqual(read.Classes, data.Classes) {
+		t.Errorf("ReadFile() = %+v %+v, want %+v %+v", read.Sessions, read.Classes, data.Sessions, data.Classes)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeTempDir(t, dir)
+
+	probes := make([]bool, 20)
+	probes[3] = true
+	first := newTestData(t, "device-1",
+		&ClassData{ID: 1, Name: []byte("com/example/Login"), Probes: []bool{true, false, false}},
+		&ClassData{ID: 2, Name: []byte("com/example/Checkout"), Probes: probes},
+	)
+	second := newTestData(t, "device-2",
+		&ClassData{ID: 1, Name: []byte("com/example/Login"), Probes: []bool{false, false, true}},
+		&ClassData{ID: 3, Name: []byte("com/example/Cart"), Probes: []bool{true}},
+	)
+	paths := []string{filepath.Join(dir, "first.ec"), filepath.Join(dir, "second.ec")}
+	for i, data := range []*ExecutionData{first, second} {
+		if err := data.WriteFile(paths[i]); err != nil {
+			t.Fatalf("WriteFile() unexpected error: %s", err)
+		}
+	}
+
+	out := filepath.Join(dir, "merged.ec")
+	if err := Merge(paths, out); err != nil {
+		t.Fatalf("Merge() unexpected error: %s", err)
+	}
+
+	merged := NewExecutionData()
+	if err := merged.ReadFile(out); err != nil {
+		t.Fatalf("ReadFile() of the merged file unexpected error: %s", err)
+	}
+	var sessions []string
+	for _, session := range merged.Sessions {
+		sessions = append(sessions, string(session.ID))
+	}
+	if want := []string{"device-1", "device-2"}; !reflect.DeepEqual(sessions, want) {
+		t.Errorf("sessions = %v, want %v", sessions, want)
+	}
+	want := []*ClassData{
+		{ID: 1, Name: []byte("com/example/Login"), Probes: []bool{true, false, true}},
+		{ID: 2, Name: []byte("com/example/Checkout"), Probes: probes},
+		{ID: 3, Name: []byte("com/example/Cart"), Probes: []bool{true}},
+	}
+	if !reflect.DeepEqual(merged.Classes, want) {
+		t.Errorf("classes = %+v, want %+v", merged.Classes, want)
+	}
+}
+
+func TestMergeIncompatible(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeTempDir(t, dir)
+
+	first := newTestData(t, "device-1", &ClassData{ID: 1, Name: []byte("com/example/Login"), Probes: []bool{true, false, false}})
+	// the class was changed between the runs
+	second := newTestData(t, "device-2", &ClassData{ID: 1, Name: []byte("com/example/Login"), Probes: []bool{true, false, false, true}})
+	paths := []string{filepath.Join(dir, "first.ec"), filepath.Join(dir, "second.ec")}
+	for i, data := range []*ExecutionData{first, second} {
+		if err := data.WriteFile(paths[i]); err != nil {
+			t.Fatalf("WriteFile() unexpected error: %s", err)
+		}
+	}
+
+	out := filepath.Join(dir, "merged.ec")
+	err := Merge(paths, out)
+	if err == nil || !strings.HasSuffix(err.Error(), "incompatible execution data for class com/example/Login") {
+		t.Fatalf("Merge() error = %v, want incompatible execution data", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("the merged file is written despite the error")
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeTempDir(t, dir)
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{name: "invalid magic number", content: []byte{0x01, 0xCA, 0xFE, 0x10, 0x07}, wantErr: "invalid magic number: 0xcafe"},
+		{name: "unsupported version", content: []byte{0x01, 0xC0, 0xC0, 0x10, 0x06}, wantErr: "unsupported format version: 0x1006"},
+		{name: "unknown block type", content: []byte{0x01, 0xC0, 0xC0, 0x10, 0x07, 0x42}, wantErr: "unknown block type: 0x42"},
+		{name: "truncated header", content: []byte{0x01, 0xC0}, wantErr: "unexpected EOF"},
+		{name: "truncated class name", content: []byte{0x11, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00, 0x05, 'a'}, wantErr: "unexpected EOF"},
+		{name: "truncated probes", content: []byte{0x11, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00, 0x01, 'a', 0x09, 0x05}, wantErr: "EOF"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, fmt.Sprintf("%d.ec", i))
+			if err := ioutil.WriteFile(pth, tt.content, 0644); err != nil {
+				t.Fatalf("Failed to write file, error: %s", err)
+			}
+			err := NewExecutionData().ReadFile(pth)
+			if err == nil || !strings.HasSuffix(err.Error(), "error: "+tt.wantErr) {
+				t.Fatalf("ReadFile() error = %v, want suffix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package coverage
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// GenerateXMLReport generates a JaCoCo XML report from the execution data with the JaCoCo command line interface.
+func GenerateXMLReport(jacocoCLIPath, execPath string, classDirs, sourceDirs []string, out string) error {
+	args := []string{"-jar", jacocoCLIPath, "report", execPath}
+	for _, dir := range classDirs {
+		args = append(args, "--classfiles", dir)
+	}
+	for _, dir := range sourceDirs {
+		args = append(args, "--sourcefiles", dir)
+	}
+	args = append(args, "--xml", out)
+
+	cmd := command.New("java", args...)
+	if output, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to generate coverage report ($ %s), error: %s, output: %s", cmd.PrintableCommandArgs(), err, output)
+	}
+	return nil
+}
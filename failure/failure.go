@@ -0,0 +1,80 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Phase is the phase of the step an error happened in.
+type Phase string
+
+// The phases of the step.
+const (
+	Setup    Phase = "setup"
+	Check    Phase = "check"
+	Upload   Phase = "upload"
+	Start    Phase = "start"
+	Wait     Phase = "wait"
+	Download Phase = "download"
+	Report   Phase = "report"
+)
+
+// Category tells what kind of failure an error is, each category is reported with its own exit code.
+type Category string
+
+// The failure categories.
+const (
+	// ConfigError is an invalid input or APK, retrying the step does not help
+	ConfigError Category = "config"
+	// UploadError is a failed upload of the APKs or the related files
+	UploadError Category = "upload"
+	// APIError is a failed call of the virtual device testing API
+	APIError Category = "api"
+	// TestFailure is a failed test
+	TestFailure Category = "test-failure"
+	// InfraError is an infrastructure failure of the test matrix, or an aborted step
+	InfraError Category = "infra"
+)
+
+// Error is an error failing the step, with the phase it happened in and its category.
+type Error struct {
+	Phase    Phase
+	Category Category
+	Err      error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap ...
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// New classifies err, it returns nil if err is nil.
+// An error which is already classified keeps its phase and category.
+func New(phase Phase, category Category, err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	return &Error{Phase: phase, Category: category, Err: err}
+}
+
+// Errorf returns a classified error with the formatted message.
+func Errorf(phase Phase, category Category, format string, v ...interface{}) error {
+	return &Error{Phase: phase, Category: category, Err: fmt.Errorf(format, v...)}
+}
+
+// Classify returns the phase and the category of err, an error which is not classified is an API error of the setup phase.
+func Classify(err error) (Phase, Category) {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Phase, classified.Category
+	}
+	return Setup, APIError
+}
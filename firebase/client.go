@@ -0,0 +1,395 @@
+package firebase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/catalog"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+// Endpoints are the base URLs of the Google APIs.
+type Endpoints struct {
+	Testing     string
+	ToolResults string
+	Storage     string
+}
+
+// DefaultEndpoints ...
+var DefaultEndpoints = Endpoints{
+	Testing:     "https://testing.googleapis.com/v1",
+	ToolResults: "https://toolresults.googleapis.com/toolresults/v1beta3",
+	Storage:     "https://storage.googleapis.com",
+}
+
+// APIError is returned if a Google API responds with a non successful status code.
+type APIError struct {
+	client.StatusError
+	Message string
+}
+
+func (err *APIError) Error() string {
+	if err.Message == "" {
+		return err.StatusError.Error()
+	}
+	return fmt.Sprintf("%s, message: %s", err.StatusError.Error(), err.Message)
+}
+
+// Unwrap makes the status code available through errors.As.
+func (err *APIError) Unwrap() error {
+	return &err.StatusError
+}
+
+// Client implements client.Client on top of the Firebase Test Lab (Testing and Tool Results) APIs,
+// the APKs and the test results are stored in the user's Cloud Storage bucket.
+type Client struct {
+	projectID string
+	bucket    string
+	// every object of the step run is stored under this prefix in the bucket
+	prefix    string
+	endpoints Endpoints
+
+	apiClient *http.Client
+	transfers *client.HTTPClient
+
+	uploads     int
+	appApk      string
+	testApk     string
+	matrices    int
+	matrixID    string
+	resultsPath string
+}
+
+// New creates a Client authenticated with the service account. The project ID defaults to the project of the service account.
+func New(account ServiceAccount, projectID, bucket, buildSlug string, endpoints Endpoints, options client.Options) (*Client, error) {
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	if projectID == "" {
+		return nil, fmt.Errorf("No GCP project ID set and the service account JSON does not contain one")
+	}
+
+	tokens, err := newTokenSource(account, &http.Client{Transport: options.Transport, Timeout: options.APITimeout})
+	if err != nil {
+		return nil, err
+	}
+	transport := authTransport{tokens: tokens, transport: options.Transport}
+
+	return &Client{
+		projectID: projectID,
+		bucket:    bucket,
+		prefix:    "bitrise-vdtesting/" + buildSlug,
+		endpoints: endpoints,
+		apiClient: &http.Client{Transport: client.NewDumpingTransport(transport, options.DumpDir), Timeout: options.APITimeout},
+		transfers: client.New("", "", "", "", client.Options{Transport: transport, APITimeout: options.APITimeout, TransferTimeout: options.TransferTimeout}),
+	}, nil
+}
+
+// objectURL returns the XML API URL of the object, which can be uploaded with PUT and downloaded with GET.
+func (c *Client) objectURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return c.endpoints.Storage + "/" + url.PathEscape(c.bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (c *Client) gcsPath(name string) string {
+	return "gs://" + c.bucket + "/" + name
+}
+
+func (c *Client) matrixPath() string {
+	return c.endpoints.Testing + "/projects/" + url.PathEscape(c.projectID) + "/testMatrices"
+}
+
+// GetUploadURLs returns the URLs of new objects in the bucket, a new pair for every call.
+func (c *Client) GetUploadURLs(ctx context.Context) (*client.UploadURLRequest, error) {
+	c.uploads++
+	dir := fmt.Sprintf("%s/apks-%d", c.prefix, c.uploads)
+	c.appApk = dir + "/app.apk"
+	c.testApk = dir + "/test.apk"
+
+	return &client.UploadURLRequest{
+		AppURL:     c.objectURL(c.appApk),
+		TestAppURL: c.objectURL(c.testApk),
+	}, nil
+}
+
+// UploadFile ...
+func (c *Client) UploadFile(ctx context.Context, uploadURL, pth string) error {
+	return c.transfers.UploadFile(ctx, uploadURL, pth)
+}
+
+// StartTest creates the test matrix with the last uploaded APKs, every matrix stores its results in a separate directory.
+func (c *Client) StartTest(ctx context.Context, testMatrix *matrix.TestMatrix) error {
+	c.matrices++
+	c.resultsPath = fmt.Sprintf("%s/results-%d/", c.prefix, c.matrices)
+
+	// the caller's matrix is reused for the reruns, so it is left untouched
+	request := *testMatrix
+	request.ProjectID = c.projectID
+	request.ResultStorage = &matrix.ResultStorage{GoogleCloudStorage: &matrix.GoogleCloudStorage{GcsPath: c.gcsPath(c.resultsPath)}}
+
+	spec := *testMatrix.TestSpecification
+	request.TestSpecification = &spec
+	appApk := &matrix.FileReference{GcsPath: c.gcsPath(c.appApk)}
+	switch {
+	case spec.AndroidInstrumentationTest != nil:
+		test := *spec.AndroidInstrumentationTest
+		test.AppApk = appApk
+		test.TestApk = &matrix.FileReference{GcsPath: c.gcsPath(c.testApk)}
+		spec.AndroidInstrumentationTest = &test
+	case spec.AndroidRoboTest != nil:
+		test := *spec.AndroidRoboTest
+		test.AppApk = appApk
+		spec.AndroidRoboTest = &test
+	case spec.AndroidTestLoop != nil:
+		test := *spec.AndroidTestLoop
+		test.AppApk = appApk
+		spec.AndroidTestLoop = &test
+	}
+
+	response := testMatrixResponse{}
+	if err := c.doJSON(ctx, "POST", c.matrixPath(), &request, &response); err != nil {
+		return err
+	}
+	c.matrixID = response.TestMatrixID
+	return nil
+}
+
+// ListSteps returns the Tool Results steps of the test matrix.
+// Until every device is reported to Tool Results, the steps are created from the test executions of the matrix.
+func (c *Client) ListSteps(ctx context.Context) (*client.ListStepsResponse, error) {
+	testMatrix := testMatrixResponse{}
+	if err := c.doJSON(ctx, "GET", c.matrixPath()+"/"+url.PathEscape(c.matrixID), nil, &testMatrix); err != nil {
+		return nil, err
+	}
+
+	if testMatrix.State == "INVALID" || testMatrix.State == "ERROR" {
+		return &client.ListStepsResponse{State: testMatrix.State, InvalidMatrixDetails: testMatrix.InvalidMatrixDetails}, nil
+	}
+
+	reported := len(testMatrix.TestExecutions) > 0
+	for _, execution := range testMatrix.TestExecutions {
+		if execution.ToolResultsStep == nil {
+			reported = false
+		}
+	}
+	if !reported {
+		response := &client.ListStepsResponse{}
+		for _, execution := range testMatrix.TestExecutions {
+			response.Steps = append(response.Steps, execution.step())
+		}
+		return response, nil
+	}
+
+	response := &client.ListStepsResponse{}
+	listed := map[string]bool{}
+	for _, execution := range testMatrix.TestExecutions {
+		step := execution.ToolResultsStep
+		executionPath := fmt.Sprintf("%s/projects/%s/histories/%s/executions/%s/steps", c.endpoints.ToolResults, url.PathEscape(step.ProjectID), url.PathEscape(step.HistoryID), url.PathEscape(step.ExecutionID))
+		if listed[executionPath] {
+			continue
+		}
+		listed[executionPath] = true
+
+		steps, err := c.listExecutionSteps(ctx, executionPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, listedStep := range steps {
+			listedStep.ToolResultsStep = &client.ToolResultsStep{
+				ProjectID:   step.ProjectID,
+				HistoryID:   step.HistoryID,
+				ExecutionID: step.ExecutionID,
+				StepID:      listedStep.StepID,
+			}
+		}
+		response.Steps = append(response.Steps, steps...)
+	}
+	return response, nil
+}
+
+// listExecutionSteps returns the steps of every page.
+func (c *Client) listExecutionSteps(ctx context.Context, executionPath string) ([]*client.Step, error) {
+	var steps []*client.Step
+	pageToken := ""
+	for {
+		path := executionPath
+		if pageToken != "" {
+			path += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+
+		response := client.ListStepsResponse{}
+		if err := c.doJSON(ctx, "GET", path, nil, &response); err != nil {
+			return nil, err
+		}
+		steps = append(steps, response.Steps...)
+
+		if response.NextPageToken == "" {
+			return steps, nil
+		}
+		if response.NextPageToken == pageToken {
+			return nil, fmt.Errorf("Failed to list steps, the API returned the same page token (%s) twice", pageToken)
+		}
+		pageToken = response.NextPageToken
+	}
+}
+
+// MatrixID returns the ID of the last started test matrix.
+func (c *Client) MatrixID() string {
+	return c.matrixID
+}
+
+// Resume continues with an already started test matrix, to wait for its results.
+func (c *Client) Resume(ctx context.Context, matrixID string) error {
+	testMatrix := testMatrixResponse{}
+	if err := c.doJSON(ctx, "GET", c.matrixPath()+"/"+url.PathEscape(matrixID), nil, &testMatrix); err != nil {
+		return err
+	}
+
+	if testMatrix.ResultStorage == nil || testMatrix.ResultStorage.GoogleCloudStorage == nil {
+		return fmt.Errorf("No result storage found in test matrix (%s)", matrixID)
+	}
+	bucketPrefix := c.gcsPath("")
+	gcsPath := testMatrix.ResultStorage.GoogleCloudStorage.GcsPath
+	if !strings.HasPrefix(gcsPath, bucketPrefix) {
+		return fmt.Errorf("The results of test matrix (%s) are stored in (%s), not in the bucket (%s)", matrixID, gcsPath, c.bucket)
+	}
+
+	c.matrixID = matrixID
+	c.resultsPath = strings.TrimPrefix(gcsPath, bucketPrefix)
+	if !strings.HasSuffix(c.resultsPath, "/") {
+		c.resultsPath += "/"
+	}
+	return nil
+}
+
+// CancelTest ...
+func (c *Client) CancelTest(ctx context.Context) error {
+	if c.matrixID == "" {
+		return nil
+	}
+	return c.doJSON(ctx, "POST", c.matrixPath()+"/"+url.PathEscape(c.matrixID)+":cancel", nil, nil)
+}
+
+// GetAssets returns the download URLs of the result files of the last test matrix, by their path in the results directory.
+func (c *Client) GetAssets(ctx context.Context) (map[string]string, error) {
+	assets := map[string]string{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", c.resultsPath)
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		objects := objectList{}
+		if err := c.doJSON(ctx, "GET", c.endpoints.Storage+"/storage/v1/b/"+url.PathEscape(c.bucket)+"/o?"+query.Encode(), nil, &objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects.Items {
+			if name := strings.TrimPrefix(object.Name, c.resultsPath); name != "" && !strings.HasSuffix(name, "/") {
+				assets[name] = c.objectURL(object.Name)
+			}
+		}
+
+		if objects.NextPageToken == "" {
+			return assets, nil
+		}
+		pageToken = objects.NextPageToken
+	}
+}
+
+// DownloadFile ...
+func (c *Client) DownloadFile(ctx context.Context, fileURL, pth string) error {
+	return c.transfers.DownloadFile(ctx, fileURL, pth)
+}
+
+// ReadFile ...
+func (c *Client) ReadFile(ctx context.Context, fileURL string, offset int64) ([]byte, error) {
+	return c.transfers.ReadFile(ctx, fileURL, offset)
+}
+
+// GetQuota is not available for the Test Lab projects, their quota is enforced by Test Lab itself.
+func (c *Client) GetQuota(ctx context.Context) (*client.Quota, error) {
+	return nil, &client.StatusError{StatusCode: http.StatusNotFound}
+}
+
+// GetCatalog returns the Android device catalog, along with the network configuration catalog,
+// which Test Lab serves separately.
+func (c *Client) GetCatalog(ctx context.Context) (*catalog.Catalog, error) {
+	responseModel := &catalog.Catalog{}
+	if err := c.doJSON(ctx, "GET", c.endpoints.Testing+"/testEnvironmentCatalog/ANDROID?projectId="+url.QueryEscape(c.projectID), nil, responseModel); err != nil {
+		return nil, err
+	}
+
+	networkModel := &catalog.Catalog{}
+	if err := c.doJSON(ctx, "GET", c.endpoints.Testing+"/testEnvironmentCatalog/NETWORK_CONFIGURATION?projectId="+url.QueryEscape(c.projectID), nil, networkModel); err != nil {
+		return nil, err
+	}
+	responseModel.NetworkConfigurationCatalog = networkModel.NetworkConfigurationCatalog
+	return responseModel, nil
+}
+
+func (c *Client) doJSON(ctx context.Context, method, url string, requestModel, responseModel interface{}) error {
+	var bodyReader io.Reader
+	if requestModel != nil {
+		jsonByte, err := json.Marshal(requestModel)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal request body, error: %s", err)
+		}
+		bodyReader = bytes.NewReader(jsonByte)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if err != nil {
+		return fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+	if requestModel != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.apiClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to get http response, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := string(responseBody)
+		errorResponse := errorResponse{}
+		if err := json.Unmarshal(responseBody, &errorResponse); err == nil && errorResponse.Error.Message != "" {
+			message = errorResponse.Error.Message
+		}
+		return &APIError{StatusError: client.StatusError{StatusCode: resp.StatusCode}, Message: message}
+	}
+
+	if responseModel == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(responseBody, responseModel); err != nil {
+		return fmt.Errorf("Failed to unmarshal response body, error: %s, body: %s", err, string(responseBody))
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package firebase
+
+import (
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+type testMatrixResponse struct {
+	TestMatrixID         string                `json:"testMatrixId,omitempty"`
+	State                string                `json:"state,omitempty"`
+	InvalidMatrixDetails string                `json:"invalidMatrixDetails,omitempty"`
+	ResultStorage        *matrix.ResultStorage `json:"resultStorage,omitempty"`
+	TestExecutions       []*testExecution      `json:"testExecutions,omitempty"`
+}
+
+type testExecution struct {
+	State           string                  `json:"state,omitempty"`
+	Environment     *environment            `json:"environment,omitempty"`
+	ToolResultsStep *client.ToolResultsStep `json:"toolResultsStep,omitempty"`
+}
+
+type environment struct {
+	AndroidDevice *matrix.AndroidDevice `json:"androidDevice,omitempty"`
+}
+
+// step describes the test execution as a Tool Results step, which is not created yet.
+func (execution testExecution) step() *client.Step {
+	step := &client.Step{State: "pending"}
+	switch execution.State {
+	case "RUNNING", "FINISHED":
+		step.State = "inProgress"
+	}
+
+	if execution.Environment != nil && execution.Environment.AndroidDevice != nil {
+		device := execution.Environment.AndroidDevice
+		step.DimensionValue = []*client.StepDimensionValueEntry{
+			{Key: "Model", Value: device.AndroidModelID},
+			{Key: "Version", Value: device.AndroidVersionID},
+			{Key: "Locale", Value: device.Locale},
+			{Key: "Orientation", Value: device.Orientation},
+		}
+	}
+	return step
+}
+
+type objectList struct {
+	Items         []*object `json:"items,omitempty"`
+	NextPageToken string    `json:"nextPageToken,omitempty"`
+}
+
+type object struct {
+	Name string `json:"name,omitempty"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message,omitempty"`
+	} `json:"error,omitempty"`
+}
@@ -0,0 +1,109 @@
+package glob
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HasMeta returns true if the path is a glob pattern.
+func HasMeta(pth string) bool {
+	return strings.ContainsAny(pth, "*?[")
+}
+
+// Glob returns the files matching the pattern, in lexical order.
+// Besides the filepath.Match syntax, a `**` path element matches any number of directories,
+// like: `app/build/outputs/apk/**/release/*.apk`.
+func Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid pattern (%s), error: %s", pattern, err)
+	}
+
+	// walk from the deepest directory which does not contain a pattern
+	root, elements := splitRoot(filepath.ToSlash(filepath.Clean(pattern)))
+	root = filepath.FromSlash(root)
+
+	var matches []string
+	err := filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if pth == root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, pth)
+		if err != nil {
+			return err
+		}
+		if match(elements, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, pth)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Root returns the deepest directory of the slash separated pattern which does not contain a pattern,
+// like `/sdcard/screenshots` for `/sdcard/screenshots/**/*.png`, or the parent directory of a path.
+func Root(pattern string) string {
+	root, _ := splitRoot(path.Clean(pattern))
+	return root
+}
+
+// Match returns true if the slash separated path matches the pattern, with the syntax of Glob.
+func Match(pattern, pth string) bool {
+	return match(strings.Split(path.Clean(pattern), "/"), strings.Split(path.Clean(pth), "/"))
+}
+
+// splitRoot splits the pattern to its root directory and the pattern elements below it.
+func splitRoot(pattern string) (string, []string) {
+	elements := strings.Split(pattern, "/")
+
+	root := ""
+	for len(elements) > 1 && !HasMeta(elements[0]) {
+		root = path.Join(root, elements[0])
+		elements = elements[1:]
+	}
+	if strings.HasPrefix(pattern, "/") {
+		root = "/" + root
+	}
+	if root == "" {
+		root = "."
+	}
+	return root, elements
+}
+
+func match(patterns, elements []string) bool {
+	if len(patterns) == 0 {
+		return len(elements) == 0
+	}
+
+	if patterns[0] == "**" {
+		for i := 0; i <= len(elements); i++ {
+			if match(patterns[1:], elements[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(elements) == 0 {
+		return false
+	}
+	if ok, err := filepath.Match(patterns[0], elements[0]); err != nil || !ok {
+		return false
+	}
+	return match(patterns[1:], elements[1:])
+}
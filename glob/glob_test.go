@@ -0,0 +1,148 @@
+package glob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHasMeta(t *testing.T) {
+	for pth, want := range map[string]bool{
+		"app/build/outputs/apk/debug/app-debug.apk": false,
+		"app/build/outputs/apk/*/app-debug.apk":     true,
+		"app-debug-?.apk":                           true,
+		"app-[a-z].apk":                             true,
+		"":                                          false,
+	} {
+		if got := HasMeta(pth); got != want {
+			t.Errorf("HasMeta(%q) = %t, want %t", pth, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		pth     string
+		want    bool
+	}{
+		{pattern: "/sdcard/screenshots/*.png", pth: "/sdcard/screenshots/login.png", want: true},
+		{pattern: "/sdcard/screenshots/*.png", pth: "/sdcard/screenshots/login/1.png", want: false},
+		{pattern: "/sdcard/screenshots/*.png", pth: "/sdcard/screenshots/login.jpg", want: false},
+		{pattern: "/sdcard/screenshots/**/*.png", pth: "/sdcard/screenshots/login.png", want: true},
+		{pattern: "/sdcard/screenshots/**/*.png", pth: "/sdcard/screenshots/login/phone/1.png", want: true},
+		{pattern: "/sdcard/screenshots/**", pth: "/sdcard/screenshots/login/1.png", want: true},
+		{pattern: "/sdcard/**/login/*.png", pth: "/sdcard/screenshots/login/1.png", want: true},
+		{pattern: "/sdcard/**/login/*.png", pth: "/sdcard/screenshots/checkout/1.png", want: false},
+		{pattern: "/sdcard/**/**/*.png", pth: "/sdcard/1.png", want: true},
+		{pattern: "/sdcard/screenshot-?.png", pth: "/sdcard/screenshot-1.png", want: true},
+		{pattern: "/sdcard/screenshot-[0-4].png", pth: "/sdcard/screenshot-5.png", want: false},
+		{pattern: "/sdcard/*", pth: "/sdcard/screenshots/login.png", want: false},
+		{pattern: "/sdcard/./screenshots//*.png", pth: "/sdcard/screenshots/login.png", want: true},
+		{pattern: "/sdcard/screenshots/login.png", pth: "/sdcard/screenshots/login.png", want: true},
+		{pattern: "/sdcard/[", pth: "/sdcard/[", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.pth); got != tt.want {
+			t.Errorf("Match(%q, %q) = %t, want %t", tt.pattern, tt.pth, got, tt.want)
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"/sdcard/screenshots/**/*.png":  "/sdcard/screenshots",
+		"/sdcard/screenshots/login.png": "/sdcard/screenshots",
+		"/sdcard/*/login.png":           "/sdcard",
+		"/sdcard/screenshots/":          "/sdcard",
+		"/*.png":                        "/",
+		"*.apk":                         ".",
+		"app/build/**/*.apk":            "app/build",
+		"./app/../lib/*.apk":            "lib",
+	} {
+		if got := Root(pattern); got != want {
+			t.Errorf("Root(%q) = %s, want %s", pattern, got, want)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+	for _, name := range []string{
+		"app/build/outputs/apk/debug/app-debug.apk",
+		"app/build/outputs/apk/release/app-release.apk",
+		"app/build/outputs/apk/free/release/app-free-release.apk",
+		"app/build/outputs/apk/free/release/output-metadata.json",
+		"app/build/outputs/apk/androidTest/debug/app-debug-androidTest.apk",
+	} {
+		pth := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatalf("Failed to create dir, error: %s", err)
+		}
+		if err := ioutil.WriteFile(pth, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file, error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{
+			pattern: "app/build/outputs/apk/**/release/*.apk",
+			want:    []string{"app/build/outputs/apk/free/release/app-free-release.apk", "app/build/outputs/apk/release/app-release.apk"},
+		},
+		{
+			pattern: "app/build/outputs/apk/*/*.apk",
+			want:    []string{"app/build/outputs/apk/debug/app-debug.apk", "app/build/outputs/apk/release/app-release.apk"},
+		},
+		{
+			pattern: "**/*-androidTest.apk",
+			want:    []string{"app/build/outputs/apk/androidTest/debug/app-debug-androidTest.apk"},
+		},
+		{
+			pattern: "app/build/outputs/apk/**/*.aab",
+			want:    nil,
+		},
+		{
+			pattern: "lib/build/outputs/apk/**/*.apk",
+			want:    nil,
+		},
+		{
+			pattern: "app/build/outputs/apk/[/*.apk",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		matches, err := Glob(filepath.Join(dir, tt.pattern))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Glob(%q) error = %v, wantErr %t", tt.pattern, err, tt.wantErr)
+			continue
+		}
+
+		var got []string
+		for _, match := range matches {
+			rel, err := filepath.Rel(dir, match)
+			if err != nil {
+				t.Fatalf("Failed to get relative path, error: %s", err)
+			}
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// MaxBuilds is the number of recent builds kept in the history.
+const MaxBuilds = 10
+
+// Build holds the test outcomes of a build, keyed by `ClassName#name`, the value is true if the test passed.
+type Build struct {
+	BuildSlug string          `json:"build_slug"`
+	Results   map[string]bool `json:"results"`
+}
+
+// History holds the test outcomes of the recent builds, the oldest first.
+type History struct {
+	Builds []Build `json:"builds"`
+}
+
+// Load reads the history from pth, a missing file means an empty history.
+func Load(pth string) (*History, error) {
+	data, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return &History{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read test history (%s), error: %s", pth, err)
+	}
+
+	var history History
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("Failed to parse test history (%s), error: %s", pth, err)
+	}
+	return &history, nil
+}
+
+// Save writes the history to pth.
+func (history *History) Save(pth string) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize test history, error: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write test history (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+// Add records the test outcomes of a build, dropping the oldest builds above MaxBuilds.
+// A build recorded again (for example on a rebuild) replaces its previous record.
+func (history *History) Add(buildSlug string, results map[string]bool) {
+	builds := []Build{}
+	for _, build := range history.Builds {
+		if build.BuildSlug != buildSlug {
+			builds = append(builds, build)
+		}
+	}
+	builds = append(builds, Build{BuildSlug: buildSlug, Results: results})
+
+	if len(builds) > MaxBuilds {
+		builds = builds[len(builds)-MaxBuilds:]
+	}
+	history.Builds = builds
+}
+
+// Flaky returns the tests which both passed and failed in the recorded builds.
+func (history *History) Flaky() []string {
+	passed := map[string]bool{}
+	failed := map[string]bool{}
+	for _, build := range history.Builds {
+		for test, ok := range build.Results {
+			if ok {
+				passed[test] = true
+			} else {
+				failed[test] = true
+			}
+		}
+	}
+
+	flaky := []string{}
+	for test := range failed {
+		if passed[test] {
+			flaky = append(flaky, test)
+		}
+	}
+	sort.Strings(flaky)
+	return flaky
+}
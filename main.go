@@ -1,841 +1,2428 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
-	"github.com/bitrise-io/go-utils/colorstring"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/ansi"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/apk"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/assets"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/catalog"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/config"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/coverage"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/firebase"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/history"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/metrics"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/mock"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/poll"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/redact"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/report"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/state"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/testlist"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/tracing"
 	"github.com/bitrise-tools/go-steputils/input"
 	"github.com/bitrise-tools/go-steputils/tools"
 )
 
-// ConfigsModel ...
-type ConfigsModel struct {
-	// api
-	APIBaseURL string
-	BuildSlug  string
-	AppSlug    string
-	APIToken   string
+// Exit codes, so CI logic can tell the failure classes apart.
+const (
+	exitCodeTestFailure           = 1
+	exitCodeInfrastructureFailure = 2
+	exitCodeInvalidConfiguration  = 3
+	exitCodeAPIError              = 4
+	exitCodeUploadFailure         = 5
+)
 
-	// shared
-	ApkPath              string
-	TestApkPath          string
-	TestType             string
-	TestDevices          string
-	AppPackageID         string
-	TestTimeout          string
-	DownloadTestResults  string
-	DirectoriesToPull    string
-	EnvironmentVariables string
+// exitCodes are the exit codes of the failure categories.
+var exitCodes = map[failure.Category]int{
+	failure.TestFailure: exitCodeTestFailure,
+	failure.InfraError:  exitCodeInfrastructureFailure,
+	failure.ConfigError: exitCodeInvalidConfiguration,
+	failure.APIError:    exitCodeAPIError,
+	failure.UploadError: exitCodeUploadFailure,
+}
 
-	// instrumentation
-	InstTestPackageID   string
-	InstTestRunnerClass string
-	InstTestTargets     string
+// Default timeouts, used when the corresponding input is empty.
+const (
+	defaultAPITimeout      = 60 * time.Second
+	defaultTransferTimeout = 15 * time.Minute
+)
 
-	// robo
-	RoboInitialActivity string
-	RoboMaxDepth        string
-	RoboMaxSteps        string
-	RoboDirectives      string
+// cancelTimeout bounds the cancel request sent after the step got aborted.
+const cancelTimeout = 30 * time.Second
 
-	// loop
-	LoopScenarios      string
-	LoopScenarioLabels string
-}
+// quotaPollInterval is the wait between the quota checks while waiting for a free test matrix slot.
+const quotaPollInterval = 30 * time.Second
 
-// ListStepsResponse ...
-type ListStepsResponse struct {
-	Steps []*Step `json:"steps,omitempty"`
-}
+// stepVersion is the version of the step, sent in the User-Agent of the requests and printed in the log,
+// so that the backend logs and the support tickets can be correlated with the step release.
+// Keep it in sync with the STEP_VERSION of the bitrise.yml.
+const stepVersion = "0.9.7"
 
-// Outcome ...
-type Outcome struct {
-	FailureDetail      *FailureDetail      `json:"failureDetail,omitempty"`
-	InconclusiveDetail *InconclusiveDetail `json:"inconclusiveDetail,omitempty"`
-	SkippedDetail      *SkippedDetail      `json:"skippedDetail,omitempty"`
-	SuccessDetail      *SuccessDetail      `json:"successDetail,omitempty"`
-	Summary            string              `json:"summary,omitempty"`
-}
+// stdout is the original standard output, os.Stdout is replaced in quiet mode to drop the progress output.
+var stdout = os.Stdout
 
-// SuccessDetail ...
-type SuccessDetail struct {
-	OtherNativeCrash bool `json:"otherNativeCrash,omitempty"`
-}
+// quiet is set if only the results and the failures are printed.
+var quiet bool
 
-// SkippedDetail ...
-type SkippedDetail struct {
-	IncompatibleAppVersion   bool `json:"incompatibleAppVersion,omitempty"`
-	IncompatibleArchitecture bool `json:"incompatibleArchitecture,omitempty"`
-	IncompatibleDevice       bool `json:"incompatibleDevice,omitempty"`
-}
+// noColors is set if the logs are printed without ANSI color escape sequences.
+var noColors bool
 
-// FailureDetail ...
-type FailureDetail struct {
-	Crashed          bool `json:"crashed,omitempty"`
-	NotInstalled     bool `json:"notInstalled,omitempty"`
-	OtherNativeCrash bool `json:"otherNativeCrash,omitempty"`
-	TimedOut         bool `json:"timedOut,omitempty"`
-	UnableToCrawl    bool `json:"unableToCrawl,omitempty"`
-}
+// tracer records the phases of the step, it is nil if no OTLP endpoint is set.
+var tracer *tracing.Tracer
 
-// InconclusiveDetail ...
-type InconclusiveDetail struct {
-	AbortedByUser         bool `json:"abortedByUser,omitempty"`
-	InfrastructureFailure bool `json:"infrastructureFailure,omitempty"`
-}
+// exportTraces sends the recorded phases to the OTLP endpoint, it is replaced once tracing is set up.
+var exportTraces = func(failed bool) {}
 
-// Step ...
-type Step struct {
-	Outcome        *Outcome                   `json:"outcome,omitempty"`
-	State          string                     `json:"state,omitempty"`
-	DimensionValue []*StepDimensionValueEntry `json:"dimensionValue,omitempty"`
+// setOutput routes the output of the step (the logs included) to out, with the secrets redacted.
+func setOutput(out *os.File) {
+	os.Stdout = out
+	if noColors {
+		log.SetOutWriter(redact.NewWriter(ansi.NewWriter(out)))
+	} else {
+		log.SetOutWriter(redact.NewWriter(out))
+	}
 }
 
-// StepDimensionValueEntry ...
-type StepDimensionValueEntry struct {
-	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
-}
+// printAlways prints even in quiet mode.
+func printAlways(print func()) {
+	if !quiet {
+		print()
+		return
+	}
 
-// AndroidDevice ...
-type AndroidDevice struct {
-	AndroidModelID   string `json:"androidModelId,omitempty"`
-	AndroidVersionID string `json:"androidVersionId,omitempty"`
-	Locale           string `json:"locale,omitempty"`
-	Orientation      string `json:"orientation,omitempty"`
+	discard := os.Stdout
+	setOutput(stdout)
+	print()
+	setOutput(discard)
 }
 
-// AndroidDeviceList ...
-type AndroidDeviceList struct {
-	AndroidDevices []*AndroidDevice `json:"androidDevices,omitempty"`
-}
-
-// EnvironmentMatrix ...
-type EnvironmentMatrix struct {
-	AndroidDeviceList *AndroidDeviceList `json:"androidDeviceList,omitempty"`
-}
+// fail prints the error with its phase and category, and exits with the exit code of the category.
+func fail(err error) {
+	phase, category := failure.Classify(err)
 
-// TestMatrix ...
-type TestMatrix struct {
-	EnvironmentMatrix *EnvironmentMatrix `json:"environmentMatrix,omitempty"`
-	TestSpecification *TestSpecification `json:"testSpecification,omitempty"`
+	setOutput(stdout)
+	log.Errorf("%s", err)
+	log.Printf("Phase: %s, failure category: %s", phase, category)
+	exportTraces(true)
+	os.Exit(exitCodes[category])
 }
 
-// TestSpecification ...
-type TestSpecification struct {
-	AndroidInstrumentationTest *AndroidInstrumentationTest `json:"androidInstrumentationTest,omitempty"`
-	AndroidRoboTest            *AndroidRoboTest            `json:"androidRoboTest,omitempty"`
-	AndroidTestLoop            *AndroidTestLoop            `json:"androidTestLoop,omitempty"`
-	AutoGoogleLogin            bool                        `json:"autoGoogleLogin,omitempty"`
-	TestSetup                  *TestSetup                  `json:"testSetup,omitempty"`
-	TestTimeout                string                      `json:"testTimeout,omitempty"`
-}
+// exitIfAborted exits if the step's context is done (aborted or timed out),
+// cancelling the test matrix first if it is still running.
+func exitIfAborted(ctx context.Context, apiClient client.Client, testRunning bool) {
+	if ctx.Err() == nil {
+		return
+	}
 
-// AndroidInstrumentationTest ...
-type AndroidInstrumentationTest struct {
-	AppPackageID    string   `json:"appPackageId,omitempty"`
-	TestPackageID   string   `json:"testPackageId,omitempty"`
-	TestRunnerClass string   `json:"testRunnerClass,omitempty"`
-	TestTargets     []string `json:"testTargets,omitempty"`
-}
+	setOutput(stdout)
+	fmt.Println()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warnf("The step timed out")
+	} else {
+		log.Warnf("The step was aborted")
+	}
 
-// AndroidRoboTest ...
-type AndroidRoboTest struct {
-	AppInitialActivity string           `json:"appInitialActivity,omitempty"`
-	AppPackageID       string           `json:"appPackageId,omitempty"`
-	MaxDepth           int64            `json:"maxDepth,omitempty"`
-	MaxSteps           int64            `json:"maxSteps,omitempty"`
-	RoboDirectives     []*RoboDirective `json:"roboDirectives,omitempty"`
-}
+	if testRunning {
+		log.Printf("Cancelling the test matrix")
 
-// RoboDirective ...
-type RoboDirective struct {
-	ActionType   string `json:"actionType,omitempty"`
-	InputText    string `json:"inputText,omitempty"`
-	ResourceName string `json:"resourceName,omitempty"`
-}
+		cancelCtx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		err := apiClient.CancelTest(cancelCtx)
+		cancel()
 
-// AndroidTestLoop ...
-type AndroidTestLoop struct {
-	AppPackageID   string   `json:"appPackageId,omitempty"`
-	ScenarioLabels []string `json:"scenarioLabels,omitempty"`
-	Scenarios      []int64  `json:"scenarios,omitempty"`
-}
+		if err != nil {
+			log.Errorf("Failed to cancel the test matrix, error: %s", err)
+		} else {
+			log.Donef("=> Test matrix cancelled")
+		}
+	}
 
-// TestSetup ...
-type TestSetup struct {
-	DirectoriesToPull    []string               `json:"directoriesToPull,omitempty"`
-	EnvironmentVariables []*EnvironmentVariable `json:"environmentVariables,omitempty"`
-	NetworkProfile       string                 `json:"networkProfile,omitempty"`
+	os.Exit(exitCodes[failure.InfraError])
 }
 
-// EnvironmentVariable ...
-type EnvironmentVariable struct {
-	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
+func timeoutOrDefault(timeout string, defaultTimeout time.Duration) (time.Duration, error) {
+	parsed, err := config.ParseTimeout(timeout)
+	if err != nil {
+		return 0, failure.Errorf(failure.Setup, failure.ConfigError, "Failed to parse timeout, error: %s", err)
+	}
+	if parsed == 0 {
+		return defaultTimeout, nil
+	}
+	return parsed, nil
 }
 
-// UploadURLRequest ...
-type UploadURLRequest struct {
-	AppURL     string `json:"appUrl"`
-	TestAppURL string `json:"testAppUrl"`
+func main() {
+	if err := runStep(); err != nil {
+		fail(err)
+	}
 }
 
-func createConfigsModelFromEnvs() ConfigsModel {
-	return ConfigsModel{
-		// api
-		APIBaseURL: os.Getenv("api_base_url"),
-		BuildSlug:  os.Getenv("BITRISE_BUILD_SLUG"),
-		AppSlug:    os.Getenv("BITRISE_APP_SLUG"),
-		APIToken:   os.Getenv("api_token"),
+// runStep runs the step, the returned error is classified by the failure package.
+func runStep() error {
+	configs, err := readConfigs()
+	if err != nil {
+		return err
+	}
 
-		// shared
-		ApkPath:              os.Getenv("apk_path"),
-		TestApkPath:          os.Getenv("test_apk_path"),
-		TestType:             os.Getenv("test_type"),
-		TestDevices:          os.Getenv("test_devices"),
-		AppPackageID:         os.Getenv("app_package_id"),
-		TestTimeout:          os.Getenv("test_timeout"),
-		DownloadTestResults:  os.Getenv("download_test_results"),
-		DirectoriesToPull:    os.Getenv("directories_to_pull"),
-		EnvironmentVariables: os.Getenv("environment_variables"),
-
-		// instrumentation
-		InstTestPackageID:   os.Getenv("inst_test_package_id"),
-		InstTestRunnerClass: os.Getenv("inst_test_runner_class"),
-		InstTestTargets:     os.Getenv("inst_test_targets"),
-
-		// robo
-		RoboInitialActivity: os.Getenv("robo_initial_activity"),
-		RoboMaxDepth:        os.Getenv("robo_max_depth"),
-		RoboMaxSteps:        os.Getenv("robo_max_steps"),
-		RoboDirectives:      os.Getenv("robo_directives"),
-
-		// loop
-		LoopScenarios:      os.Getenv("loop_scenarios"),
-		LoopScenarioLabels: os.Getenv("loop_scenario_labels"),
-	}
-}
-
-func (configs ConfigsModel) print() {
-	log.Infof("Configs:")
-	log.Printf("- ApkPath: %s", configs.ApkPath)
-
-	log.Printf("- TestTimeout: %s", configs.TestTimeout)
-	log.Printf("- DirectoriesToPull: %s", configs.DirectoriesToPull)
-	log.Printf("- EnvironmentVariables: %s", configs.EnvironmentVariables)
-	log.Printf("- TestDevices:\n---")
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "Model\tAPI Level\tLocale\tOrientation\t")
-	scanner := bufio.NewScanner(strings.NewReader(configs.TestDevices))
-	for scanner.Scan() {
-		device := scanner.Text()
-		device = strings.TrimSpace(device)
-		if device == "" {
-			continue
-		}
+	// local-mock runs the test against a fake API, so the configuration can be tried without using device minutes
+	if configs.Mode == "local-mock" {
+		mockServer := mock.NewServer(mock.Script{})
+		defer mockServer.Close()
+		configs.UseLocalMock(mockServer.URL())
+		log.Warnf("Running against a fake API (local-mock), the APKs are not tested on real devices and the notifications are not sent")
+	}
 
-		deviceParams := strings.Split(device, ",")
+	if skipped, err := resolveConfigs(&configs); err != nil || skipped {
+		return err
+	}
 
-		if len(deviceParams) != 4 {
-			continue
-		}
+	fmt.Println()
 
-		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%s\t", deviceParams[0], deviceParams[1], deviceParams[3], deviceParams[2]))
-	}
-	if err := w.Flush(); err != nil {
-		log.Errorf("Failed to flush writer, error: %s", err)
+	stepClients, err := newClients(configs)
+	if err != nil {
+		return err
 	}
-	log.Printf("---")
-	log.Printf("- AppPackageID: %s", configs.AppPackageID)
-	log.Printf("- TestType: %s", configs.TestType)
+	apiClient, notificationClient := stepClients.api, stepClients.notifications
 
-	// instruments
-	if configs.TestType == "instrumentation" {
-		log.Printf("- TestApkPath: %s", configs.TestApkPath)
-		log.Printf("- InstTestPackageID: %s", configs.InstTestPackageID)
-		log.Printf("- InstTestRunnerClass: %s", configs.InstTestRunnerClass)
-		log.Printf("- InstTestTargets: %s", configs.InstTestTargets)
+	if configs.OTLPEndpoint != "" {
+		if err := setupTracing(configs, notificationClient); err != nil {
+			return err
+		}
 	}
 
-	//robo
-	if configs.TestType == "robo" {
-		log.Printf("- RoboInitialActivity: %s", configs.RoboInitialActivity)
-		log.Printf("- RoboMaxDepth: %s", configs.RoboMaxDepth)
-		log.Printf("- RoboMaxSteps: %s", configs.RoboMaxSteps)
-		log.Printf("- RoboDirectives: %s", configs.RoboDirectives)
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if configs.TestType == "gameloop" {
-		// loop
-		log.Printf("- LoopScenarios: %s", configs.LoopScenarios)
-		log.Printf("- LoopScenarioLabels: %s", configs.LoopScenarioLabels)
+	if stepTimeout, err := config.ParseTimeout(configs.StepTimeout); err != nil {
+		return failure.Errorf(failure.Setup, failure.ConfigError, "Failed to parse step timeout, error: %s", err)
+	} else if stepTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, stepTimeout)
+		defer cancel()
 	}
-}
 
-func (configs ConfigsModel) validate() error {
+	// stop every in-flight request if the build gets aborted, the test matrix is cancelled by exitIfAborted on the main goroutine
+	abortSignals := make(chan os.Signal, 1)
+	signal.Notify(abortSignals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(abortSignals)
+	go func() {
+		select {
+		case sig := <-abortSignals:
+			// a second signal kills the step right away, without waiting for the test matrix to be cancelled
+			signal.Stop(abortSignals)
+			fmt.Println()
+			log.Warnf("Received %s signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	if err := input.ValidateIfNotEmpty(configs.APIBaseURL); err != nil {
-		return fmt.Errorf("Issue with APIBaseURL: %s", err)
-	}
-	if err := input.ValidateIfNotEmpty(configs.APIToken); err != nil {
-		return fmt.Errorf("Issue with APIToken: %s", err)
-	}
-	if err := input.ValidateIfNotEmpty(configs.BuildSlug); err != nil {
-		return fmt.Errorf("Issue with BuildSlug: %s", err)
-	}
-	if err := input.ValidateIfNotEmpty(configs.AppSlug); err != nil {
-		return fmt.Errorf("Issue with AppSlug: %s", err)
+	if configs.Mode == "list-network-profiles" {
+		return listNetworkProfiles(ctx, apiClient)
 	}
-	if err := input.ValidateIfNotEmpty(configs.TestType); err != nil {
-		return fmt.Errorf("Issue with TestType: %s", err)
-	}
-	if err := input.ValidateWithOptions(configs.TestType, "instrumentation", "robo", "gameloop"); err != nil {
-		return fmt.Errorf("Issue with TestType: %s", err)
-	}
-	if err := input.ValidateIfNotEmpty(configs.ApkPath); err != nil {
-		return fmt.Errorf("Issue with ApkPath: %s", err)
-	}
-	if err := input.ValidateIfPathExists(configs.ApkPath); err != nil {
-		return fmt.Errorf("Issue with ApkPath: %s", err)
+
+	if configs.Mode == "wait" {
+		return resumeTest(ctx, apiClient, configs, notificationClient)
 	}
-	if configs.TestType == "instrumentation" {
-		if err := input.ValidateIfNotEmpty(configs.TestApkPath); err != nil {
-			return fmt.Errorf("Issue with TestApkPath: %s", err)
-		}
-		if err := input.ValidateIfPathExists(configs.TestApkPath); err != nil {
-			return fmt.Errorf("Issue with TestApkPath: %s", err)
+
+	if config.IsRemoteURL(configs.ApkPath) {
+		log.Infof("Download APK")
+		pth, err := downloadApk(ctx, stepClients.transfers, configs.ApkPath)
+		if err != nil {
+			exitIfAborted(ctx, apiClient, false)
+			return failure.New(failure.Setup, failure.UploadError, err)
 		}
+		configs.ApkPath = pth
+		log.Donef("=> APK downloaded to (%s)", pth)
+		fmt.Println()
 	}
 
-	return nil
-}
+	log.Infof("Check APKs")
+	configs.AppPackageID = checkAppManifest(configs.ApkPath, configs.AppPackageID)
+	if err := checkAPKs(configs); err != nil {
+		return err
+	}
+	log.Donef("=> APKs checked")
+	fmt.Println()
 
-func failf(f string, v ...interface{}) {
-	log.Errorf(f, v)
-	os.Exit(1)
-}
+	if configs.HasAutoOrientation() {
+		if err := resolveAutoOrientation(ctx, apiClient, &configs); err != nil {
+			return err
+		}
+	}
 
-func main() {
-	configs := createConfigsModelFromEnvs()
+	testModel, err := matrix.Create(configs)
+	if err != nil {
+		return failure.New(failure.Check, failure.ConfigError, err)
+	}
 
+	log.Infof("Check devices")
+	if err := checkSDKVersions(configs, testModel); err != nil {
+		return err
+	}
+	if err := checkDevices(ctx, apiClient, configs, testModel); err != nil {
+		return err
+	}
 	fmt.Println()
-	configs.print()
 
-	if err := configs.validate(); err != nil {
-		failf("%s", err)
+	// Test Lab applies a single test timeout to the devices of a matrix
+	deviceGroups, err := configs.SplitByTestTimeout()
+	if err != nil {
+		return failure.New(failure.Check, failure.ConfigError, err)
 	}
 
-	fmt.Println()
+	if configs.DryRun == "true" {
+		return dryRun(deviceGroups)
+	}
 
-	successful := true
+	testApkPaths := []string{""}
+	if configs.TestType == "instrumentation" {
+		testApkPaths = config.ParseTestApkPaths(configs.TestApkPath)
+	}
+	runs := createTestRuns(testApkPaths, deviceGroups)
 
-	log.Infof("Upload APKs")
-	{
-		url := configs.APIBaseURL + "/assets/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
+	outputs, err := newTestOutputs(configs)
+	if err != nil {
+		return failure.New(failure.Setup, failure.InfraError, err)
+	}
 
-		req, err := http.NewRequest("POST", url, nil)
-		if err != nil {
-			failf("Failed to create http request, error: %s", err)
-		}
+	// a restarted step waits for the test matrix it started before, instead of starting a duplicate one
+	var savedState *state.State
+	if configs.StatePath != "" && configs.Mode == "run" && configs.WaitForResults == "true" {
+		savedState = loadState(configs, apiClient, runs)
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			failf("Failed to get http response, error: %s", err)
+	resultSteps := []*client.Step{}
+	for i, run := range runs {
+		var resumeState *state.State
+		if i == 0 {
+			resumeState = savedState
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			failf("Failed to get http response, status code: %d", resp.StatusCode)
+		if len(runs) > 1 {
+			log.Infof("Test run (%d/%d): %s", i+1, len(runs), run.description)
+			fmt.Println()
 		}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			failf("Failed to read response body, error: %s", err)
+		if configs.Mode == "deflake" {
+			if err := deflakeTest(ctx, apiClient, run.configs, run.testApkPath, run.label, outputs); err != nil {
+				return err
+			}
+			continue
 		}
 
-		responseModel := &UploadURLRequest{}
-
-		err = json.Unmarshal(body, responseModel)
+		runSteps, err := runTest(ctx, apiClient, run.configs, i, run.testApkPath, run.label, resumeState, outputs)
 		if err != nil {
-			failf("Failed to unmarshal response body, error: %s", err)
+			return err
 		}
+		resultSteps = append(resultSteps, runSteps...)
+		fmt.Println()
 
-		err = uploadFile(responseModel.AppURL, configs.ApkPath)
-		if err != nil {
-			failf("Failed to upload file(%s) to (%s), error: %s", configs.ApkPath, responseModel.AppURL, err)
+		if configs.FailFast == "true" && len(report.FailedSteps(resultSteps)) > 0 && i < len(runs)-1 {
+			log.Warnf("Skipping the remaining %d test run(s), as a device failed", len(runs)-i-1)
+			fmt.Println()
+			break
 		}
+	}
 
-		if configs.TestType == "instrumentation" {
-			err = uploadFile(responseModel.TestAppURL, configs.TestApkPath)
-			if err != nil {
-				failf("Failed to upload file(%s) to (%s), error: %s", configs.TestApkPath, responseModel.TestAppURL, err)
-			}
+	if configs.StatePath != "" {
+		if err := state.Remove(configs.StatePath); err != nil {
+			log.Warnf("%s", err)
 		}
-
-		log.Donef("=> APKs uploaded")
 	}
 
-	fmt.Println()
-	log.Infof("Start test")
-	{
-		url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
-
-		testModel := &TestMatrix{}
-		testModel.EnvironmentMatrix = &EnvironmentMatrix{AndroidDeviceList: &AndroidDeviceList{}}
-		testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = []*AndroidDevice{}
-
-		scanner := bufio.NewScanner(strings.NewReader(configs.TestDevices))
-		for scanner.Scan() {
-			device := scanner.Text()
-			device = strings.TrimSpace(device)
-			if device == "" {
-				continue
-			}
+	if configs.Mode == "deflake" {
+		return reportPassRates(outputs)
+	}
 
-			deviceParams := strings.Split(device, ",")
-			if len(deviceParams) != 4 {
-				failf("Invalid test device configuration: %s", device)
-			}
+	if configs.WaitForResults == "false" {
+		log.Donef("=> The test matrix is running, its results are not waited for")
+		exportTraces(false)
+		return nil
+	}
 
-			newDevice := AndroidDevice{
-				AndroidModelID:   deviceParams[0],
-				AndroidVersionID: deviceParams[1],
-				Locale:           deviceParams[2],
-				Orientation:      deviceParams[3],
+	if len(runs) > 1 {
+		printAlways(func() {
+			log.Infof("Test results of every test run:")
+			if err := report.PrintTable(os.Stdout, resultSteps); err != nil {
+				log.Errorf("Failed to flush writer, error: %s", err)
 			}
+			fmt.Println()
+		})
+	}
 
-			testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = append(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices, &newDevice)
-		}
+	return reportResults(configs, notificationClient, resultSteps, outputs)
+}
 
-		// parse directories to pull
-		scanner = bufio.NewScanner(strings.NewReader(configs.DirectoriesToPull))
-		directoriesToPull := []string{}
-		for scanner.Scan() {
-			path := scanner.Text()
-			path = strings.TrimSpace(path)
-			if path == "" {
-				continue
-			}
-			directoriesToPull = append(directoriesToPull, path)
+// readConfigs reads the configs from the config file and the inputs, and applies the output settings.
+func readConfigs() (config.ConfigsModel, error) {
+	if configPath := os.Getenv("config_path"); configPath != "" {
+		if err := config.ApplyConfigFile(configPath); err != nil {
+			return config.ConfigsModel{}, failure.New(failure.Setup, failure.ConfigError, err)
 		}
+	}
+	configs := config.CreateFromEnvs()
 
-		// parse environment variables
-		scanner = bufio.NewScanner(strings.NewReader(configs.DirectoriesToPull))
-		envs := []*EnvironmentVariable{}
-		for scanner.Scan() {
-			envStr := scanner.Text()
+	redact.AddSecret(configs.APIToken)
+	redact.AddSecret(configs.ServiceAccountJSON)
+	redact.AddSecret(configs.SlackWebhookURL)
+	redact.AddSecret(configs.ResultWebhookSecret)
 
-			if envStr == "" {
-				continue
-			}
+	// https://no-color.org
+	if configs.DisableColors == "true" || os.Getenv("NO_COLOR") != "" {
+		noColors = true
+		report.DisableColors()
+	}
+	report.SortResults(configs.ResultsSort)
+	if configs.WideResults == "true" {
+		report.SetTableWidth(0)
+	} else if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		report.SetTableWidth(columns)
+	}
+	setOutput(stdout)
 
-			if !strings.Contains(envStr, "=") {
-				continue
-			}
+	log.Printf("Step version: %s", stepVersion)
 
-			envStrSplit := strings.Split(envStr, "=")
-			envKey := envStrSplit[0]
-			envValue := strings.Join(envStrSplit[1:], "=")
+	return configs, nil
+}
 
-			envs = append(envs, &EnvironmentVariable{Key: envKey, Value: envValue})
+// resolveConfigs resolves the APKs and the devices, then validates the configs.
+// It returns true if the test is skipped, as there is no APK to test.
+func resolveConfigs(configs *config.ConfigsModel) (bool, error) {
+	// in wait mode neither the APKs nor the devices are used
+	if configs.Mode == "run" || configs.Mode == "deflake" || configs.Mode == "local-mock" {
+		if configs.AllowMissingApk == "true" && configs.IsApkMissing() {
+			skipMissingApk()
+			return true, nil
 		}
-
-		testModel.TestSpecification = &TestSpecification{
-			TestTimeout: fmt.Sprintf("%ss", configs.TestTimeout),
-			TestSetup: &TestSetup{
-				EnvironmentVariables: envs,
-				DirectoriesToPull:    directoriesToPull,
-			},
+		if err := configs.ResolveApkPaths(); err != nil {
+			return false, failure.New(failure.Setup, failure.ConfigError, err)
 		}
-
-		switch configs.TestType {
-		case "instrumentation":
-			testModel.TestSpecification.AndroidInstrumentationTest = &AndroidInstrumentationTest{}
-			if configs.AppPackageID != "" {
-				testModel.TestSpecification.AndroidInstrumentationTest.AppPackageID = configs.AppPackageID
-			}
-			if configs.InstTestPackageID != "" {
-				testModel.TestSpecification.AndroidInstrumentationTest.TestPackageID = configs.InstTestPackageID
-			}
-			if configs.InstTestRunnerClass != "" {
-				testModel.TestSpecification.AndroidInstrumentationTest.TestRunnerClass = configs.InstTestRunnerClass
-			}
-			if configs.InstTestTargets != "" {
-				targets := strings.Split(strings.TrimSpace(configs.InstTestTargets), ",")
-				testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = targets
-			}
-		case "robo":
-			testModel.TestSpecification.AndroidRoboTest = &AndroidRoboTest{}
-			if configs.AppPackageID != "" {
-				testModel.TestSpecification.AndroidRoboTest.AppPackageID = configs.AppPackageID
-			}
-			if configs.RoboInitialActivity != "" {
-				testModel.TestSpecification.AndroidRoboTest.AppInitialActivity = configs.RoboInitialActivity
-			}
-			if configs.RoboMaxDepth != "" {
-				maxDepth, err := strconv.Atoi(configs.RoboMaxDepth)
-				if err != nil {
-					failf("Failed to parse string(%s) to integer, error: %s", configs.RoboMaxDepth, err)
-				}
-				testModel.TestSpecification.AndroidRoboTest.MaxDepth = int64(maxDepth)
-			}
-			if configs.RoboMaxSteps != "" {
-				maxSteps, err := strconv.Atoi(configs.RoboMaxSteps)
-				if err != nil {
-					failf("Failed to parse string(%s) to integer, error: %s", configs.RoboMaxSteps, err)
-				}
-				testModel.TestSpecification.AndroidRoboTest.MaxSteps = int64(maxSteps)
-			}
-			if configs.RoboDirectives != "" {
-				roboDirectives := []*RoboDirective{}
-				scanner := bufio.NewScanner(strings.NewReader(configs.RoboDirectives))
-				for scanner.Scan() {
-					directive := scanner.Text()
-					directive = strings.TrimSpace(directive)
-					if directive == "" {
-						continue
-					}
-
-					directiveParams := strings.Split(directive, ",")
-					if len(directiveParams) != 3 {
-						failf("Invalid directive configuration: %s", directive)
-					}
-					roboDirectives = append(roboDirectives, &RoboDirective{ResourceName: directiveParams[0], InputText: directiveParams[1], ActionType: directiveParams[2]})
-				}
-				testModel.TestSpecification.AndroidRoboTest.RoboDirectives = roboDirectives
-			}
-		case "gameloop":
-			testModel.TestSpecification.AndroidTestLoop = &AndroidTestLoop{}
-			if configs.AppPackageID != "" {
-				testModel.TestSpecification.AndroidTestLoop.AppPackageID = configs.AppPackageID
-			}
-			if configs.LoopScenarios != "" {
-				loopScenarios := []int64{}
-				for _, scenarioStr := range strings.Split(strings.TrimSpace(configs.LoopScenarios), ",") {
-					scenario, err := strconv.Atoi(scenarioStr)
-					if err != nil {
-						failf("Failed to parse string(%s) to integer, error: %s", scenarioStr, err)
-					}
-					loopScenarios = append(loopScenarios, int64(scenario))
-				}
-				testModel.TestSpecification.AndroidTestLoop.Scenarios = loopScenarios
-			}
-			if configs.LoopScenarioLabels != "" {
-				scenarioLabels := strings.Split(strings.TrimSpace(configs.LoopScenarioLabels), ",")
-				testModel.TestSpecification.AndroidTestLoop.ScenarioLabels = scenarioLabels
+		if config.IsRemoteURL(configs.ApkPath) {
+			// the query of a signed URL is the access token
+			if apkURL, err := url.Parse(configs.ApkPath); err == nil {
+				redact.AddSecret(apkURL.RawQuery)
 			}
 		}
+		if configs.ResolveTestDevices() {
+			log.Warnf("No test device is set, testing on the default device (%s)", config.DefaultTestDevice)
+		}
+		for _, device := range configs.DeduplicateTestDevices() {
+			log.Warnf("The test device (%s) is set multiple times, it is tested only once", device)
+		}
+	}
+
+	if configs.Quiet != "true" {
+		fmt.Println()
+		configs.Print()
+	}
 
-		jsonByte, err := json.Marshal(testModel)
+	if err := configs.Validate(); err != nil {
+		return false, failure.New(failure.Setup, failure.ConfigError, err)
+	}
+	// the rule is parsed by the report package, which the config package can not depend on
+	if configs.FailIf != "" {
+		if _, err := report.ParseRule(configs.FailIf); err != nil {
+			return false, failure.Errorf(failure.Setup, failure.ConfigError, "Issue with FailIf: %s", err)
+		}
+	}
+	if configs.MappingFile != "" {
+		mapping, err := report.LoadMapping(configs.MappingFile)
 		if err != nil {
-			failf("Failed to marshal test model, error: %s", err)
+			return false, failure.Errorf(failure.Setup, failure.ConfigError, "Issue with MappingFile: %s", err)
 		}
+		report.SetMapping(mapping)
+	}
+	if configs.NativeSymbols != "" {
+		// the backtraces are printed without symbols, instead of failing the test
+		if symbols, err := report.LoadNativeSymbols(configs.NativeSymbols); err != nil {
+			log.Warnf("Failed to load native symbols, the native crashes are not symbolized, error: %s", err)
+		} else {
+			report.SetNativeSymbols(symbols)
+		}
+	}
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonByte))
+	if configs.Quiet == "true" {
+		discard, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
 		if err != nil {
-			failf("Failed to create http request, error: %s", err)
+			return false, failure.Errorf(failure.Setup, failure.ConfigError, "Failed to open %s, error: %s", os.DevNull, err)
 		}
+		quiet = true
+		setOutput(discard)
+	}
+
+	return false, nil
+}
+
+// clients are the clients of the APIs the step talks to.
+type clients struct {
+	api client.Client
+	// transfers downloads the APK from a remote URL
+	transfers     *client.HTTPClient
+	notifications *http.Client
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+// newClients returns the clients configured with the transport and the timeouts of the configs.
+func newClients(configs config.ConfigsModel) (clients, error) {
+	dumpDir := ""
+	if configs.DumpResponses == "true" {
+		dir, err := pathutil.NormalizedOSTempDirPath("vdtesting_responses")
 		if err != nil {
-			failf("Failed to get http response, error: %s", err)
+			return clients{}, failure.Errorf(failure.Setup, failure.InfraError, "Failed to create temp dir, error: %s", err)
 		}
+		dumpDir = dir
 
-		if resp.StatusCode != http.StatusOK {
-			failf("Failed to get http response, status code: %d", resp.StatusCode)
+		if err := tools.ExportEnvironmentWithEnvman("VDTESTING_RESPONSES_DIR", dumpDir); err != nil {
+			log.Warnf("Failed to export environment (VDTESTING_RESPONSES_DIR), error: %s", err)
+		} else {
+			log.Printf("The API responses directory (%s) is exported to the VDTESTING_RESPONSES_DIR environment variable.", dumpDir)
 		}
+		fmt.Println()
+	}
 
-		log.Donef("=> Test started")
+	userAgent := fmt.Sprintf("bitrise-vdtesting-step/%s (%s)", stepVersion, runtime.Version())
+	transport, err := client.NewTransport(configs.CACertPath, configs.TLSMinVersion, userAgent, configs.Verbose == "true")
+	if err != nil {
+		return clients{}, failure.Errorf(failure.Setup, failure.ConfigError, "Failed to configure TLS, error: %s", err)
 	}
 
-	fmt.Println()
-	log.Infof("Waiting for test results")
-	{
-		finished := false
-		printedLogs := []string{}
-		for !finished {
-			url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
+	apiTimeout, err := timeoutOrDefault(configs.APITimeout, defaultAPITimeout)
+	if err != nil {
+		return clients{}, err
+	}
+	transferTimeout, err := timeoutOrDefault(configs.TransferTimeout, defaultTransferTimeout)
+	if err != nil {
+		return clients{}, err
+	}
 
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				failf("Failed to create http request, error: %s", err)
-			}
+	clientOptions := client.Options{
+		Transport:       transport,
+		APITimeout:      apiTimeout,
+		TransferTimeout: transferTimeout,
+		TokenFile:       configs.APITokenFile,
+		DumpDir:         dumpDir,
+	}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				failf("Failed to get http response, error: %s", err)
-			}
+	// in wait mode the test matrix of an earlier build is collected
+	testBuildSlug := configs.BuildSlug
+	if configs.Mode == "wait" {
+		testBuildSlug = configs.TestMatrixBuildSlug
+	}
 
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
+	var apiClient client.Client = client.New(configs.APIBaseURL, configs.AppSlug, testBuildSlug, configs.APIToken, clientOptions)
+	if configs.ServiceAccountJSON != "" {
+		log.Printf("Using Firebase Test Lab directly, with the service account")
 
-			responseModel := &ListStepsResponse{}
+		account, err := firebase.ParseServiceAccount([]byte(configs.ServiceAccountJSON))
+		if err != nil {
+			return clients{}, failure.New(failure.Setup, failure.ConfigError, err)
+		}
+		if apiClient, err = firebase.New(account, configs.GCPProjectID, configs.GCSBucket, configs.BuildSlug, firebase.DefaultEndpoints, clientOptions); err != nil {
+			return clients{}, failure.New(failure.Setup, failure.ConfigError, err)
+		}
+	}
+	return clients{
+		api:           apiClient,
+		transfers:     client.New("", "", "", "", client.Options{Transport: transport, APITimeout: apiTimeout, TransferTimeout: transferTimeout}),
+		notifications: &http.Client{Transport: transport, Timeout: apiTimeout},
+	}, nil
+}
 
-			err = json.Unmarshal(body, responseModel)
-			if err != nil {
-				failf("Failed to unmarshal response body, error: %s, body: %s", err, string(body))
-			}
+// setupTracing starts tracing the step, the traces are exported to the OTLP endpoint by exportTraces.
+func setupTracing(configs config.ConfigsModel, httpClient *http.Client) error {
+	headers, err := config.ParseWebhookHeaders(configs.OTLPHeaders)
+	if err != nil {
+		return failure.Errorf(failure.Setup, failure.ConfigError, "Failed to parse OTLP headers, error: %s", err)
+	}
 
-			finished = true
-			testsRunning := 0
-			for _, step := range responseModel.Steps {
-				if step.State != "complete" {
-					finished = false
-					testsRunning++
-				}
-			}
+	tracer = tracing.NewTracer("virtual device testing", map[string]string{
+		"bitrise.app_slug":    configs.AppSlug,
+		"bitrise.build_slug":  configs.BuildSlug,
+		"vdtesting.test_type": configs.TestType,
+	})
+	exportTraces = func(failed bool) {
+		// the traces are exported only once, even if exporting them fails the step
+		exportTraces = func(bool) {}
+		if err := tracer.Export(httpClient, configs.OTLPEndpoint, headers, failed); err != nil {
+			log.Warnf("%s", err)
+		}
+	}
+	return nil
+}
 
-			msg := ""
-			if len(responseModel.Steps) == 0 {
-				finished = false
-				msg = fmt.Sprintf("- Validating")
-			} else {
-				msg = fmt.Sprintf("- (%d/%d) running", testsRunning, len(responseModel.Steps))
-			}
-
-			if !sliceutil.IsStringInSlice(msg, printedLogs) {
-				log.Printf(msg)
-				printedLogs = append(printedLogs, msg)
-			}
-
-			if finished {
-				log.Donef("=> Test finished")
-				fmt.Println()
-
-				log.Infof("Test results:")
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-				fmt.Fprintln(w, "Model\tAPI Level\tLocale\tOrientation\tOutcome\t")
-
-				for _, step := range responseModel.Steps {
-					dimensions := map[string]string{}
-					for _, dimension := range step.DimensionValue {
-						dimensions[dimension.Key] = dimension.Value
-					}
-
-					outcome := step.Outcome.Summary
-
-					switch outcome {
-					case "success":
-						outcome = colorstring.Green(outcome)
-					case "failure":
-						successful = false
-						if step.Outcome.FailureDetail != nil {
-							if step.Outcome.FailureDetail.Crashed {
-								outcome += "(Crashed)"
-							}
-							if step.Outcome.FailureDetail.NotInstalled {
-								outcome += "(NotInstalled)"
-							}
-							if step.Outcome.FailureDetail.OtherNativeCrash {
-								outcome += "(OtherNativeCrash)"
-							}
-							if step.Outcome.FailureDetail.TimedOut {
-								outcome += "(TimedOut)"
-							}
-							if step.Outcome.FailureDetail.UnableToCrawl {
-								outcome += "(UnableToCrawl)"
-							}
-						}
-						outcome = colorstring.Red(outcome)
-					case "inconclusive":
-						successful = false
-						if step.Outcome.InconclusiveDetail != nil {
-							if step.Outcome.InconclusiveDetail.AbortedByUser {
-								outcome += "(AbortedByUser)"
-							}
-							if step.Outcome.InconclusiveDetail.InfrastructureFailure {
-								outcome += "(InfrastructureFailure)"
-							}
-						}
-						outcome = colorstring.Yellow(outcome)
-					case "skipped":
-						successful = false
-						if step.Outcome.SkippedDetail != nil {
-							if step.Outcome.SkippedDetail.IncompatibleAppVersion {
-								outcome += "(IncompatibleAppVersion)"
-							}
-							if step.Outcome.SkippedDetail.IncompatibleArchitecture {
-								outcome += "(IncompatibleArchitecture)"
-							}
-							if step.Outcome.SkippedDetail.IncompatibleDevice {
-								outcome += "(IncompatibleDevice)"
-							}
-						}
-						outcome = colorstring.Blue(outcome)
-					}
-
-					fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t", dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"], outcome))
-				}
-				if err := w.Flush(); err != nil {
-					log.Errorf("Failed to flush writer, error: %s", err)
-				}
-			}
-			if !finished {
-				time.Sleep(5 * time.Second)
-			}
+// resumeTest collects and reports the results of the test matrix started by an earlier build.
+func resumeTest(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, notificationClient *http.Client) error {
+	outputs, err := newTestOutputs(configs)
+	if err != nil {
+		return failure.New(failure.Setup, failure.InfraError, err)
+	}
+
+	log.Infof("Resume test matrix")
+	if firebaseClient, ok := apiClient.(*firebase.Client); ok {
+		if err := firebaseClient.Resume(ctx, configs.TestMatrixID); err != nil {
+			exitIfAborted(ctx, apiClient, false)
+			return failure.New(failure.Wait, failure.APIError, err)
 		}
+		log.Printf("Test matrix ID: %s", configs.TestMatrixID)
+	} else {
+		log.Printf("Build slug of the test matrix: %s", configs.TestMatrixBuildSlug)
 	}
+	log.Donef("=> Test matrix resumed")
 
-	if configs.DownloadTestResults == "true" {
-		fmt.Println()
-		log.Infof("Downloading test assets")
-		{
-			url := configs.APIBaseURL + "/assets/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
+	resultSteps, err := collectResults(ctx, apiClient, configs, "", outputs)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
 
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				failf("Failed to create http request, error: %s", err)
-			}
+	return reportResults(configs, notificationClient, resultSteps, outputs)
+}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				failf("Failed to get http response, error: %s", err)
-			}
+// dryRun prints the test matrices of the device groups, without uploading or starting anything.
+func dryRun(deviceGroups []config.ConfigsModel) error {
+	log.Infof("Dry run")
 
-			if resp.StatusCode != http.StatusOK {
-				failf("Failed to get http response, status code: %d", resp.StatusCode)
-			}
+	for _, group := range deviceGroups {
+		groupModel, err := matrix.Create(group)
+		if err != nil {
+			return failure.New(failure.Check, failure.ConfigError, err)
+		}
 
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
+		// environment variables might hold secrets
+		maskedEnvs := []*matrix.EnvironmentVariable{}
+		for _, env := range groupModel.TestSpecification.TestSetup.EnvironmentVariables {
+			maskedEnvs = append(maskedEnvs, &matrix.EnvironmentVariable{Key: env.Key, Value: input.SecureInput(env.Value)})
+		}
+		groupModel.TestSpecification.TestSetup.EnvironmentVariables = maskedEnvs
 
-			responseModel := map[string]string{}
+		jsonByte, err := json.MarshalIndent(groupModel, "", "  ")
+		if err != nil {
+			return failure.Errorf(failure.Check, failure.ConfigError, "Failed to marshal test model, error: %s", err)
+		}
 
-			err = json.Unmarshal(body, &responseModel)
-			if err != nil {
-				failf("Failed to unmarshal response body, error: %s", err)
-			}
+		log.Printf("Test matrix:")
+		fmt.Println(string(jsonByte))
 
-			tempDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_test_assets")
-			if err != nil {
-				failf("Failed to create temp dir, error: %s", err)
-			}
+		printEstimatedMinutes(groupModel, group.TestTimeout)
+	}
 
-			for fileName, fileURL := range responseModel {
-				err := downloadFile(fileURL, filepath.Join(tempDir, fileName))
-				if err != nil {
-					failf("Failed to download file, error: %s", err)
-				}
-			}
+	log.Donef("=> Dry run finished, nothing was uploaded or started")
+	return nil
+}
 
-			log.Donef("=> Assets downloaded")
-			if err := tools.ExportEnvironmentWithEnvman("VDTESTING_DOWNLOADED_FILES_DIR", tempDir); err != nil {
-				log.Warnf("Failed to export environment (VDTESTING_DOWNLOADED_FILES_DIR), error: %s", err)
-			} else {
-				log.Printf("The downloaded test assets path (%s) is exported to the VDTESTING_DOWNLOADED_FILES_DIR environment variable.", tempDir)
+// reportResults exports the outputs and sends the notifications.
+// It returns a test failure or an infrastructure failure error if the results fail the policy.
+func reportResults(configs config.ConfigsModel, notificationClient *http.Client, resultSteps []*client.Step, outputs *testOutputs) error {
+	printAlways(func() {
+		log.Printf("Device outcomes: %s", report.SummarizeOutcomes(resultSteps))
+		if len(outputs.failedDownloads) > 0 {
+			log.Warnf("Failed to download %d test asset(s), they are missing from the outputs:", len(outputs.failedDownloads))
+			for _, name := range outputs.failedDownloads {
+				log.Warnf("- %s", name)
 			}
 		}
-	}
+		fmt.Println()
+	})
 
-	if !successful {
-		os.Exit(1)
-	}
-}
+	exportOutputs(configs, resultSteps, outputs)
 
-func downloadFile(url string, localPath string) error {
-	out, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("Failed to open the local cache file for write: %s", err)
+	policy := report.Policy{
+		FailOnSkipped:      configs.FailOnSkipped == "true",
+		FailOnInconclusive: configs.FailOnInconclusive == "true",
+		FailOnFlaky:        configs.FailOnFlaky == "true",
 	}
-	defer func() {
-		if err := out.Close(); err != nil {
-			log.Printf("Failed to close Archive download file (%s): %s", localPath, err)
+	if configs.FailIf != "" {
+		rule, err := report.ParseRule(configs.FailIf)
+		if err != nil {
+			return failure.Errorf(failure.Report, failure.ConfigError, "Issue with FailIf: %s", err)
 		}
-	}()
+		policy.FailIf = rule
+	}
+	printAlways(func() {
+		printPassedQuarantinedTests(outputs.quarantineResults)
+	})
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("Failed to create cache download request: %s", err)
+	result, policyErr := report.Judge(policy, resultSteps, outputs.knownFailures)
+
+	if configs.StatsDAddress != "" || configs.PushgatewayURL != "" {
+		pushMetrics(configs, notificationClient, resultSteps, outputs)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Failed to close Archive download response body: %s", err)
-		}
-	}()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Failed to download archive - non success response code: %d", resp.StatusCode)
+	if configs.AnnotateBuild == "true" {
+		annotateBuild(resultSteps, outputs.failedTests)
 	}
 
-	_, err = io.Copy(out, resp.Body)
+	headers, err := config.ParseWebhookHeaders(configs.ResultWebhookHeaders)
 	if err != nil {
-		return fmt.Errorf("Failed to save cache content into file: %s", err)
+		return failure.Errorf(failure.Report, failure.ConfigError, "Failed to parse webhook headers, error: %s", err)
+	}
+	notifications := report.Notifications{
+		SlackWebhookURL: configs.SlackWebhookURL,
+		SlackChannel:    configs.SlackChannel,
+		BuildURL:        os.Getenv("BITRISE_BUILD_URL"),
+		WebhookURL:      configs.ResultWebhookURL,
+		WebhookHeaders:  headers,
+		WebhookSecret:   configs.ResultWebhookSecret,
+		AppSlug:         configs.AppSlug,
+		BuildSlug:       configs.BuildSlug,
+		TestType:        configs.TestType,
 	}
+	notifications.Send(notificationClient, resultSteps, result.Successful)
 
-	return nil
+	exportTraces(!result.Successful)
+	return policyErr
 }
 
-func uploadFile(uploadURL string, archiveFilePath string) error {
-	archFile, err := os.Open(archiveFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to open archive file for upload (%s): %s", archiveFilePath, err)
-	}
-	isFileCloseRequired := true
-	defer func() {
-		if !isFileCloseRequired {
-			return
+// pushMetrics sends the durations and the device outcomes of the test to StatsD and the Prometheus Pushgateway.
+func pushMetrics(configs config.ConfigsModel, httpClient *http.Client, steps []*client.Step, outputs *testOutputs) {
+	samples := report.MetricSamples(steps, report.Timings{
+		Upload:        outputs.uploadDuration,
+		Queue:         outputs.queueDuration,
+		Run:           outputs.runDuration,
+		Download:      outputs.downloadDuration,
+		BilledMinutes: outputs.billedMinutes,
+	})
+
+	fmt.Println()
+	log.Infof("Pushing metrics")
+	if configs.StatsDAddress != "" {
+		if err := metrics.PushStatsD(configs.StatsDAddress, configs.MetricsPrefix, samples); err != nil {
+			log.Warnf("%s", err)
+		} else {
+			log.Donef("=> Metrics sent to StatsD")
 		}
-		if err := archFile.Close(); err != nil {
-			log.Printf(" (!) Failed to close archive file (%s): %s", archiveFilePath, err)
+	}
+	if configs.PushgatewayURL != "" {
+		grouping := map[string]string{"app_slug": configs.AppSlug}
+		if err := metrics.PushGateway(httpClient, configs.PushgatewayURL, metrics.PushgatewayJob, grouping, configs.MetricsPrefix, samples); err != nil {
+			log.Warnf("%s", err)
+		} else {
+			log.Donef("=> Metrics pushed to the Pushgateway")
 		}
-	}()
+	}
+}
 
-	fileInfo, err := archFile.Stat()
-	if err != nil {
-		return fmt.Errorf("Failed to get File Stats of the Archive file (%s): %s", archiveFilePath, err)
+// annotateBuild adds the failed, skipped and inconclusive devices to the build summary.
+func annotateBuild(steps []*client.Step, failedTests map[*client.Step][]string) {
+	annotations := report.CreateAnnotations(steps, failedTests)
+	if len(annotations) == 0 {
+		return
 	}
-	fileSize := fileInfo.Size()
 
-	req, err := http.NewRequest("PUT", uploadURL, archFile)
-	if err != nil {
-		return fmt.Errorf("Failed to create upload request: %s", err)
+	fmt.Println()
+	log.Infof("Annotating the build")
+	for _, annotation := range annotations {
+		if err := report.PostAnnotation(annotation); err != nil {
+			log.Warnf("%s", err)
+			return
+		}
 	}
+	log.Donef("=> %d annotation(s) added", len(annotations))
+}
 
-	req.Header.Add("Content-Length", strconv.FormatInt(fileSize, 10))
-	req.ContentLength = fileSize
+// testOutputs collects the outputs of the test runs, one run per test APK.
+type testOutputs struct {
+	assetsDir         string
+	screenshotsDir    string
+	screenshots       map[string][]string
+	coverageDir       string
+	coverageFiles     []string
+	systraceDir       string
+	systraceFiles     []string
+	testResults       map[string]bool
+	failedTests       map[*client.Step][]string
+	knownFailures     map[*client.Step]bool
+	quarantineResults map[string]bool
+	billedMinutes     int
+	passRates         report.PassRates
+	// the test assets which failed to download, the results are reported without them
+	failedDownloads []string
+	// the time spent on uploading the APKs, waiting for the first device, running the devices
+	// and downloading the results, for the metrics and the outputs
+	uploadDuration   time.Duration
+	testStarted      time.Time
+	queueDuration    time.Duration
+	runDuration      time.Duration
+	downloadDuration time.Duration
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("Failed to upload: %s", err)
+func newTestOutputs(configs config.ConfigsModel) (*testOutputs, error) {
+	outputs := &testOutputs{
+		screenshots:       map[string][]string{},
+		testResults:       map[string]bool{},
+		failedTests:       map[*client.Step][]string{},
+		knownFailures:     map[*client.Step]bool{},
+		quarantineResults: map[string]bool{},
+		passRates:         report.PassRates{},
 	}
-	isFileCloseRequired = false
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf(" [!] Failed to close response body: %s", err)
-		}
-	}()
 
-	_, err = ioutil.ReadAll(resp.Body)
+	if configs.DownloadTestResults == "true" {
+		assetsDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_test_assets")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		outputs.assetsDir = assetsDir
+
+		screenshotsDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_screenshots")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		outputs.screenshotsDir = screenshotsDir
+	}
+
+	if configs.TestType == "instrumentation" && configs.EnableCoverage == "true" {
+		coverageDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_coverage")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		outputs.coverageDir = coverageDir
+	}
+
+	if configs.SystraceDuration != "" {
+		systraceDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_systrace")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		outputs.systraceDir = systraceDir
+	}
+
+	return outputs, nil
+}
+
+// testRun is a test matrix started by the step: a test APK tested on the devices sharing the same test timeout.
+type testRun struct {
+	configs     config.ConfigsModel
+	testApkPath string
+	// the outputs of the runs are separated by a subdirectory, if there are more of them
+	label       string
+	description string
+}
+
+// createTestRuns returns a run for every test APK on every group of devices.
+func createTestRuns(testApkPaths []string, deviceGroups []config.ConfigsModel) []testRun {
+	runs := []testRun{}
+	for i, testApkPath := range testApkPaths {
+		for _, group := range deviceGroups {
+			run := testRun{configs: group, testApkPath: testApkPath}
+
+			var labels, descriptions []string
+			if len(testApkPaths) > 1 {
+				labels = append(labels, fmt.Sprintf("%d-%s", i+1, strings.TrimSuffix(filepath.Base(testApkPath), filepath.Ext(testApkPath))))
+				descriptions = append(descriptions, "test APK "+testApkPath)
+			}
+			if len(deviceGroups) > 1 {
+				labels = append(labels, "timeout-"+group.TestTimeout)
+				descriptions = append(descriptions, fmt.Sprintf("%d device(s) with test timeout %s", len(config.ParseList(group.TestDevices)), group.TestTimeout))
+			}
+			run.label = strings.Join(labels, "-")
+			run.description = strings.Join(descriptions, ", ")
+
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// runTest uploads the APKs, runs the test matrix (and the reruns of the failed devices),
+// then collects the outputs of the matrix, which are only available until the next matrix starts.
+// If resumeState is set, the test matrix recorded in it is waited for, instead of starting a new one.
+func runTest(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, index int, testApkPath, label string, resumeState *state.State, outputs *testOutputs) ([]*client.Step, error) {
+	if configs.TestType == "instrumentation" {
+		configs.InstTestPackageID, configs.InstTestRunnerClass = checkTestManifest(testApkPath, configs.AppPackageID, configs.InstTestPackageID, configs.InstTestRunnerClass)
+		fmt.Println()
+	}
+
+	testModel, err := matrix.Create(configs)
+	if err != nil {
+		return nil, failure.New(failure.Check, failure.ConfigError, err)
+	}
+
+	if resumeState != nil {
+		log.Infof("Resume test matrix")
+		if firebaseClient, ok := apiClient.(*firebase.Client); ok {
+			if err := firebaseClient.Resume(ctx, resumeState.TestMatrixID); err != nil {
+				exitIfAborted(ctx, apiClient, false)
+				return nil, failure.New(failure.Wait, failure.APIError, err)
+			}
+			log.Printf("Test matrix ID: %s", resumeState.TestMatrixID)
+		}
+		log.Printf("Started at: %s", resumeState.Started.Format(time.RFC3339))
+		log.Donef("=> Test matrix resumed from the state file, the APKs are not uploaded again")
+		outputs.testStarted = resumeState.Started
+
+		return collectResults(ctx, apiClient, configs, label, outputs)
+	}
+
+	if err := uploadAPKs(ctx, apiClient, configs, testApkPath, label, outputs); err != nil {
+		return nil, err
+	}
+
+	fmt.Println()
+	log.Infof("Start test")
+	startSpan := tracer.Start("start").SetAttribute("vdtesting.test_apk", label)
+	{
+		estimatedMinutes := printEstimatedMinutes(testModel, configs.TestTimeout)
+		checkQuota(ctx, apiClient, estimatedMinutes, configs.WaitForQuota == "true")
+
+		if err := apiClient.StartTest(ctx, testModel); err != nil {
+			// the matrix might have been created before the request got aborted
+			exitIfAborted(ctx, apiClient, true)
+			return nil, failure.New(failure.Start, failure.APIError, err)
+		}
+
+		log.Donef("=> Test started")
+	}
+	outputs.testStarted = time.Now()
+	startSpan.End()
+
+	if configs.WaitForResults == "false" {
+		exportTestMatrix(configs, apiClient)
+		return nil, nil
+	}
+
+	if configs.StatePath != "" {
+		saveState(configs, apiClient, index, testApkPath, outputs.testStarted)
+	}
+
+	return collectResults(ctx, apiClient, configs, label, outputs)
+}
+
+// deflakeTest uploads the APKs and runs the test matrix deflake_iterations times, counting the passes of every test case.
+func deflakeTest(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, testApkPath, label string, outputs *testOutputs) error {
+	configs.InstTestPackageID, configs.InstTestRunnerClass = checkTestManifest(testApkPath, configs.AppPackageID, configs.InstTestPackageID, configs.InstTestRunnerClass)
+	fmt.Println()
+
+	testModel, err := matrix.Create(configs)
+	if err != nil {
+		return failure.New(failure.Check, failure.ConfigError, err)
+	}
+
+	iterations, err := strconv.Atoi(configs.DeflakeIterations)
+	if err != nil {
+		return failure.Errorf(failure.Setup, failure.ConfigError, "Failed to parse deflake iterations, error: %s", err)
+	}
+
+	if err := uploadAPKs(ctx, apiClient, configs, testApkPath, label, outputs); err != nil {
+		return err
+	}
+
+	collector := newCollector(apiClient, configs)
+	for iteration := 1; iteration <= iterations; iteration++ {
+		fmt.Println()
+		log.Infof("Deflake iteration (%d/%d)", iteration, iterations)
+
+		estimatedMinutes := printEstimatedMinutes(testModel, configs.TestTimeout)
+		checkQuota(ctx, apiClient, estimatedMinutes, configs.WaitForQuota == "true")
+
+		if err := apiClient.StartTest(ctx, testModel); err != nil {
+			exitIfAborted(ctx, apiClient, true)
+			return failure.New(failure.Start, failure.APIError, err)
+		}
+
+		// the queue of the iterations is not measured
+		resultSteps, infrastructureFailure, err := collector.Wait(ctx, time.Time{})
+		if err != nil {
+			exitIfAborted(ctx, apiClient, true)
+			return err
+		}
+		if infrastructureFailure {
+			log.Warnf("The test matrix stopped due to an infrastructure failure, the iteration is not counted")
+			continue
+		}
+
+		// the test results are only available until the next matrix starts
+		files, err := apiClient.GetAssets(ctx)
+		if err != nil {
+			log.Warnf("Failed to get test assets, the iteration is not counted, error: %s", err)
+			continue
+		}
+		for _, step := range resultSteps {
+			testCases := readTestCases(ctx, apiClient, files, step)
+			if len(testCases) == 0 {
+				log.Warnf("No test result found for %s (%s)", report.DeviceName(step), report.OutcomeWithDetails(step))
+			}
+			outputs.passRates.Add(testCases)
+		}
+
+		log.Donef("=> Iteration finished")
+	}
+	outputs.billedMinutes += collector.Stats.BilledMinutes
+	outputs.runDuration += collector.Stats.RunDuration
+	return nil
+}
+
+// reportPassRates prints and exports the pass rates of the deflake iterations.
+// It returns a test failure error if a test case failed in any of them.
+func reportPassRates(outputs *testOutputs) error {
+	printAlways(func() {
+		log.Infof("Pass rates:")
+		if err := report.PrintPassRates(os.Stdout, outputs.passRates); err != nil {
+			log.Errorf("Failed to flush writer, error: %s", err)
+		}
+		fmt.Println()
+	})
+
+	log.Printf("Total billed device time: %d minute(s)", outputs.billedMinutes)
+
+	if outputDir, err := resultsDir(); err != nil {
+		log.Warnf("%s", err)
+	} else if pth, err := report.ExportPassRatesCSV(outputs.passRates, outputDir); err != nil {
+		log.Warnf("%s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("VDTESTING_PASS_RATES_PATH", pth); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_PASS_RATES_PATH), error: %s", err)
+	} else {
+		log.Printf("The pass rates CSV (%s) is exported to the VDTESTING_PASS_RATES_PATH environment variable.", pth)
+	}
+
+	if len(outputs.passRates) == 0 {
+		return failure.Errorf(failure.Report, failure.InfraError, "No test result found in any of the deflake iterations")
+	}
+	if unstable := outputs.passRates.Unstable(); unstable > 0 {
+		return failure.Errorf(failure.Report, failure.TestFailure, "%d of %d test(s) failed in some of the deflake iterations", unstable, len(outputs.passRates))
+	}
+	exportTraces(false)
+	log.Donef("=> Every test passed in every deflake iteration")
+	return nil
+}
+
+// uploadAPKs uploads the app and the test APK, the next started test matrix tests them.
+func uploadAPKs(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, testApkPath, label string, outputs *testOutputs) error {
+	log.Infof("Upload APKs")
+	uploadStarted := time.Now()
+	uploadSpan := tracer.Start("upload").SetAttribute("vdtesting.test_apk", label)
+	{
+		uploadURLs, err := apiClient.GetUploadURLs(ctx)
+		if err != nil {
+			exitIfAborted(ctx, apiClient, false)
+			return failure.New(failure.Upload, failure.APIError, err)
+		}
+
+		if err := apiClient.UploadFile(ctx, uploadURLs.AppURL, configs.ApkPath); err != nil {
+			exitIfAborted(ctx, apiClient, false)
+			return failure.Errorf(failure.Upload, failure.UploadError, "Failed to upload file(%s) to (%s), error: %s", configs.ApkPath, uploadURLs.AppURL, err)
+		}
+
+		if configs.TestType == "instrumentation" {
+			if err := apiClient.UploadFile(ctx, uploadURLs.TestAppURL, testApkPath); err != nil {
+				exitIfAborted(ctx, apiClient, false)
+				return failure.Errorf(failure.Upload, failure.UploadError, "Failed to upload file(%s) to (%s), error: %s", testApkPath, uploadURLs.TestAppURL, err)
+			}
+		}
+
+		if configs.MappingFile != "" {
+			if uploadURLs.MappingURL == "" {
+				log.Printf("The backend does not accept mapping files, the mapping file is only used to deobfuscate the stack traces printed by the step")
+			} else if err := apiClient.UploadFile(ctx, uploadURLs.MappingURL, configs.MappingFile); err != nil {
+				exitIfAborted(ctx, apiClient, false)
+				return failure.Errorf(failure.Upload, failure.UploadError, "Failed to upload file(%s) to (%s), error: %s", configs.MappingFile, uploadURLs.MappingURL, err)
+			}
+		}
+
+		if configs.NativeSymbols != "" {
+			if uploadURLs.NativeSymbolsURL == "" {
+				log.Printf("The backend does not accept native symbols, they are only used to symbolize the native crashes printed by the step")
+			} else if err := uploadNativeSymbols(ctx, apiClient, configs.NativeSymbols, uploadURLs.NativeSymbolsURL); err != nil {
+				return err
+			}
+		}
+
+		log.Donef("=> APKs uploaded")
+	}
+	outputs.uploadDuration += time.Since(uploadStarted)
+	uploadSpan.End()
+	return nil
+}
+
+// uploadNativeSymbols uploads the native symbols, a directory is zipped first.
+func uploadNativeSymbols(ctx context.Context, apiClient client.Client, pth, uploadURL string) error {
+	if info, err := os.Stat(pth); err == nil && info.IsDir() {
+		tmpDir, err := pathutil.NormalizedOSTempDirPath("native_symbols")
+		if err != nil {
+			return failure.Errorf(failure.Upload, failure.InfraError, "Failed to create temp dir, error: %s", err)
+		}
+		zipPth := filepath.Join(tmpDir, "native-debug-symbols.zip")
+		if err := assets.Zip(pth, zipPth); err != nil {
+			return failure.New(failure.Upload, failure.InfraError, err)
+		}
+		pth = zipPth
+	}
+
+	if err := apiClient.UploadFile(ctx, uploadURL, pth); err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		return failure.Errorf(failure.Upload, failure.UploadError, "Failed to upload file(%s) to (%s), error: %s", pth, uploadURL, err)
+	}
+	return nil
+}
+
+// loadState reads the state file saved by an earlier run of the step, which was interrupted while waiting for the test matrix.
+// The state is ignored if it was saved by another build or with other test APKs,
+// or during a test run after the first one, whose earlier runs would not be reported.
+func loadState(configs config.ConfigsModel, apiClient client.Client, runs []testRun) *state.State {
+	savedState, err := state.Load(configs.StatePath)
+	if err != nil {
+		log.Warnf("%s", err)
+		return nil
+	}
+	if savedState == nil {
+		return nil
+	}
+
+	_, isFirebase := apiClient.(*firebase.Client)
+	if savedState.BuildSlug != configs.BuildSlug ||
+		savedState.RunIndex < 0 || savedState.RunIndex >= len(runs) ||
+		savedState.TestApkPath != runs[savedState.RunIndex].testApkPath ||
+		(isFirebase && savedState.TestMatrixID == "") {
+		log.Warnf("Ignoring the state file (%s), it was saved by another build or with other test APKs", configs.StatePath)
+		return nil
+	}
+
+	// the results of the finished test runs are not recorded, resuming a later run would leave them out of the outcome
+	if savedState.RunIndex > 0 {
+		log.Warnf("Ignoring the state file (%s), the step was restarted during test run %d/%d, starting the test runs over to report the earlier ones", configs.StatePath, savedState.RunIndex+1, len(runs))
+		fmt.Println()
+		return nil
+	}
+
+	log.Warnf("Found the state file (%s) of a test matrix started at %s, resuming it", configs.StatePath, savedState.Started.Format(time.RFC3339))
+	fmt.Println()
+	return savedState
+}
+
+// saveState records the started test matrix in the state file, so a restarted step can resume waiting for it.
+func saveState(configs config.ConfigsModel, apiClient client.Client, index int, testApkPath string, started time.Time) {
+	runState := state.State{
+		BuildSlug:   configs.BuildSlug,
+		RunIndex:    index,
+		TestApkPath: testApkPath,
+		Started:     started,
+	}
+	if firebaseClient, ok := apiClient.(*firebase.Client); ok {
+		runState.TestMatrixID = firebaseClient.MatrixID()
+	}
+
+	if err := runState.Save(configs.StatePath); err != nil {
+		log.Warnf("%s", err)
+	}
+}
+
+// collectResults waits for the started test matrix, reruns the failed devices and downloads the outputs of the test.
+func collectResults(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, label string, outputs *testOutputs) ([]*client.Step, error) {
+	collector := newCollector(apiClient, configs)
+	resultSteps, err := collectSteps(ctx, collector, configs, outputs)
+	outputs.billedMinutes += collector.Stats.BilledMinutes
+	outputs.runDuration += collector.Stats.RunDuration
+	outputs.queueDuration += collector.Stats.QueueDuration
+	if err != nil {
+		exitIfAborted(ctx, apiClient, true)
+		return nil, err
+	}
+
+	report.PrintConsoleURLs(os.Stdout, resultSteps)
+
+	var knownFailuresErr error
+	printAlways(func() {
+		if quiet {
+			// the tables are printed only once the reruns are done
+			fmt.Println()
+			log.Infof("Test results:")
+			if err := report.PrintTable(os.Stdout, resultSteps); err != nil {
+				log.Errorf("Failed to flush writer, error: %s", err)
+			}
+		}
+
+		printCrashes(ctx, apiClient, resultSteps)
+		printANRs(ctx, apiClient, resultSteps)
+		printFailedTests(ctx, apiClient, resultSteps, outputs)
+
+		if configs.BaselinePath != "" || configs.QuarantinePath != "" {
+			knownFailuresErr = checkKnownFailures(ctx, apiClient, configs, resultSteps, outputs)
+		}
+	})
+	if knownFailuresErr != nil {
+		return nil, knownFailuresErr
+	}
+
+	downloadSpan := tracer.Start("download").SetAttribute("vdtesting.test_apk", label)
+	downloadStarted := time.Now()
+	if configs.TestType == "gameloop" {
+		exportGameLoopResults(ctx, apiClient, resultSteps)
+	}
+
+	if outputs.coverageDir != "" {
+		outputs.coverageFiles = append(outputs.coverageFiles, downloadCoverage(ctx, apiClient, filepath.Join(outputs.coverageDir, label), label, outputs)...)
+	}
+
+	if outputs.systraceDir != "" {
+		outputs.systraceFiles = append(outputs.systraceFiles, downloadSystrace(ctx, apiClient, filepath.Join(outputs.systraceDir, label), label, outputs)...)
+	}
+
+	if configs.TestHistoryPath != "" {
+		collectTestResults(ctx, apiClient, resultSteps, outputs.testResults)
+	}
+
+	if outputs.assetsDir != "" {
+		fmt.Println()
+		log.Infof("Downloading test assets")
+
+		prefixDevices := map[string]bool{}
+		if configs.PrefixAssetNames == "true" {
+			for _, step := range resultSteps {
+				prefixDevices[assets.DeviceID(report.StepDimensions(step))] = true
+			}
+		}
+
+		pulled := assets.PulledFiles{
+			Directories: config.ParseList(configs.DirectoriesToPull),
+			Patterns:    config.ParseList(configs.FilesToPull),
+		}
+		if configs.TestType == "instrumentation" && configs.EnableCoverage == "true" {
+			pulled.Directories = append(pulled.Directories, matrix.CoverageDir)
+		}
+
+		assetsDir := filepath.Join(outputs.assetsDir, label)
+		failed, err := assets.Download(ctx, apiClient, assetsDir, prefixDevices, pulled)
+		if err != nil {
+			exitIfAborted(ctx, apiClient, false)
+			return nil, failure.New(failure.Download, failure.APIError, err)
+		}
+		recordFailedDownloads(outputs, label, failed)
+		log.Donef("=> Assets downloaded")
+
+		screenshots, err := assets.CollectScreenshots(assetsDir, filepath.Join(outputs.screenshotsDir, label))
+		if err != nil {
+			log.Warnf("%s", err)
+		}
+		for device, names := range screenshots {
+			outputs.screenshots[path.Join(label, device)] = names
+		}
+	}
+	outputs.downloadDuration += time.Since(downloadStarted)
+	downloadSpan.End()
+
+	return resultSteps, nil
+}
+
+// collectSteps waits for the started test matrix, retries it on infrastructure failures,
+// and reruns the failed devices and the failed tests.
+func collectSteps(ctx context.Context, collector *poll.Collector, configs config.ConfigsModel, outputs *testOutputs) ([]*client.Step, error) {
+	fmt.Println()
+	log.Infof("Waiting for test results")
+	resultSteps, infrastructureFailure, err := collector.Wait(ctx, outputs.testStarted)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetries, err := strconv.Atoi(configs.MaxMatrixRetries)
+	if err != nil {
+		return nil, failure.Errorf(failure.Wait, failure.ConfigError, "Failed to parse max matrix retries, error: %s", err)
+	}
+	if configs.Mode == "wait" {
+		// the APKs and the devices of the test matrix are not known
+		maxRetries = 0
+	}
+	resultSteps, err = collector.Retry(ctx, resultSteps, infrastructureFailure, maxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Donef("=> Test finished")
+	fmt.Println()
+
+	log.Infof("Test results:")
+	if err := report.PrintTable(os.Stdout, resultSteps); err != nil {
+		log.Errorf("Failed to flush writer, error: %s", err)
+	}
+
+	rerunCount, err := strconv.Atoi(configs.RerunFailedDevices)
+	if err != nil {
+		return nil, failure.Errorf(failure.Wait, failure.ConfigError, "Failed to parse rerun failed devices count, error: %s", err)
+	}
+	if configs.FailFast == "true" && len(report.FailedSteps(resultSteps)) > 0 {
+		// the failure is reported as soon as possible, instead of confirming it
+		rerunCount = 0
+	}
+	if configs.Mode == "wait" {
+		// the APKs and the devices of the test matrix are not known
+		rerunCount = 0
+	}
+	resultSteps, err = collector.RerunDevices(ctx, resultSteps, rerunCount)
+	if err != nil {
+		return nil, err
+	}
+
+	testRerunCount, err := strconv.Atoi(configs.RerunFailedTests)
+	if err != nil {
+		return nil, failure.Errorf(failure.Wait, failure.ConfigError, "Failed to parse rerun failed tests count, error: %s", err)
+	}
+	if configs.TestType != "instrumentation" || configs.Mode == "wait" || configs.FailFast == "true" && len(report.FailedSteps(resultSteps)) > 0 {
+		testRerunCount = 0
+	}
+	for attempt := 1; attempt <= testRerunCount; attempt++ {
+		rerunResultSteps, err := rerunFailedTests(ctx, collector, resultSteps, attempt, testRerunCount, outputs)
+		if err != nil {
+			return nil, err
+		}
+		if rerunResultSteps == nil {
+			break
+		}
+		resultSteps = report.MergeTestReruns(resultSteps, rerunResultSteps)
+
+		log.Infof("Test results after rerunning the failed tests:")
+		if err := report.PrintTable(os.Stdout, resultSteps); err != nil {
+			log.Errorf("Failed to flush writer, error: %s", err)
+		}
+	}
+	return resultSteps, nil
+}
+
+// rerunFailedTests starts a test matrix running only the failed test cases of the failed devices, and waits for its results.
+// It returns nil steps if there is nothing to rerun.
+func rerunFailedTests(ctx context.Context, collector *poll.Collector, steps []*client.Step, attempt, count int, outputs *testOutputs) ([]*client.Step, error) {
+	apiClient := collector.Client
+	failedSteps := report.FailedSteps(steps)
+	if len(failedSteps) == 0 {
+		return nil, nil
+	}
+
+	// the test results are only available until the next matrix starts
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, the failed tests are not rerun, error: %s", err)
+		return nil, nil
+	}
+
+	devices := []*matrix.AndroidDevice{}
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, step := range failedSteps {
+		stepTargets := report.RerunTestTargets(readTestCases(ctx, apiClient, files, step))
+		if len(stepTargets) == 0 {
+			continue
+		}
+
+		devices = append(devices, report.StepDevice(step))
+		for _, target := range stepTargets {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(devices) == 0 {
+		log.Warnf("No failed test case found in the test results, the failed tests are not rerun")
+		return nil, nil
+	}
+
+	fmt.Println()
+	log.Infof("Rerunning %d failed test(s) on %d device(s) (attempt %d/%d)", len(targets), len(devices), attempt, count)
+
+	rerunModel, err := collector.NewMatrix()
+	if err != nil {
+		return nil, failure.New(failure.Check, failure.ConfigError, err)
+	}
+	rerunModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = devices
+	// the failed tests of every device run on each of them, Test Lab does not take test targets per device
+	rerunModel.TestSpecification.AndroidInstrumentationTest.TestTargets = targets
+	rerunModel.TestSpecification.AndroidInstrumentationTest.ShardingOption = nil
+
+	rerunResultSteps, err := collector.Run(ctx, rerunModel)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Donef("=> Rerun finished")
+	fmt.Println()
+	return rerunResultSteps, nil
+}
+
+// skipMissingApk exports the outputs of a skipped test, if there is no APK to test with allow_missing_apk.
+func skipMissingApk() {
+	log.Warnf("No APK found, skipping the test (allow_missing_apk)")
+	fmt.Println()
+
+	for _, output := range []struct {
+		key   string
+		name  string
+		value string
+	}{
+		{"VDTESTING_STATUS", "status", "skipped: no APK"},
+		{"VDTESTING_BILLED_MINUTES", "number of billed device minutes", "0"},
+		{"VDTESTING_UPLOAD_DURATION", "upload duration in seconds", "0"},
+		{"VDTESTING_QUEUE_DURATION", "queue duration in seconds", "0"},
+		{"VDTESTING_RUN_DURATION", "run duration in seconds", "0"},
+		{"VDTESTING_DOWNLOAD_DURATION", "download duration in seconds", "0"},
+		{"VDTESTING_TESTS_TOTAL", "number of total tests", "0"},
+		{"VDTESTING_TESTS_PASSED", "number of passed tests", "0"},
+		{"VDTESTING_TESTS_FAILED", "number of failed tests", "0"},
+		{"VDTESTING_TESTS_SKIPPED", "number of skipped tests", "0"},
+		{"VDTESTING_TESTS_FLAKY", "number of flaky tests", "0"},
+		{"VDTESTING_RESULTS_JSON", "list of device results", "{}"},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(output.key, output.value); err != nil {
+			log.Warnf("Failed to export environment (%s), error: %s", output.key, err)
+		} else {
+			log.Printf("The %s (%s) is exported to the %s environment variable.", output.name, output.value, output.key)
+		}
+	}
+
+	fmt.Println()
+	log.Donef("=> Test skipped: no APK")
+}
+
+// exportOutputs exports the outputs collected from the test runs.
+func exportOutputs(configs config.ConfigsModel, resultSteps []*client.Step, outputs *testOutputs) {
+	log.Printf("Total billed device time: %d minute(s)", outputs.billedMinutes)
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_BILLED_MINUTES", strconv.Itoa(outputs.billedMinutes)); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_BILLED_MINUTES), error: %s", err)
+	} else {
+		log.Printf("The billed device minutes (%d) are exported to the VDTESTING_BILLED_MINUTES environment variable.", outputs.billedMinutes)
+	}
+
+	log.Printf("Time breakdown: upload %s, queue %s, run %s, download %s",
+		outputs.uploadDuration.Round(time.Second), outputs.queueDuration.Round(time.Second), outputs.runDuration.Round(time.Second), outputs.downloadDuration.Round(time.Second))
+	for _, output := range []struct {
+		key      string
+		name     string
+		duration time.Duration
+	}{
+		{"VDTESTING_UPLOAD_DURATION", "upload", outputs.uploadDuration},
+		{"VDTESTING_QUEUE_DURATION", "queue", outputs.queueDuration},
+		{"VDTESTING_RUN_DURATION", "run", outputs.runDuration},
+		{"VDTESTING_DOWNLOAD_DURATION", "download", outputs.downloadDuration},
+	} {
+		seconds := strconv.Itoa(int(output.duration.Round(time.Second).Seconds()))
+		if err := tools.ExportEnvironmentWithEnvman(output.key, seconds); err != nil {
+			log.Warnf("Failed to export environment (%s), error: %s", output.key, err)
+		} else {
+			log.Printf("The %s duration in seconds (%s) is exported to the %s environment variable.", output.name, seconds, output.key)
+		}
+	}
+
+	for _, step := range resultSteps {
+		key, outcome := report.DeviceResultEnvKey(step), report.OutcomeWithDetails(step)
+		if err := tools.ExportEnvironmentWithEnvman(key, outcome); err != nil {
+			log.Warnf("Failed to export environment (%s), error: %s", key, err)
+		} else {
+			log.Printf("The outcome of %s (%s) is exported to the %s environment variable.", report.DeviceName(step), outcome, key)
+		}
+	}
+
+	testCounts := report.TotalTestCounts(resultSteps)
+	for _, output := range []struct {
+		key   string
+		name  string
+		count int
+	}{
+		{"VDTESTING_TESTS_TOTAL", "total", testCounts.Total},
+		{"VDTESTING_TESTS_PASSED", "passed", testCounts.Passed()},
+		{"VDTESTING_TESTS_FAILED", "failed", testCounts.Failed + testCounts.Errors},
+		{"VDTESTING_TESTS_SKIPPED", "skipped", testCounts.Skipped},
+		{"VDTESTING_TESTS_FLAKY", "flaky", testCounts.Flaky},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(output.key, strconv.Itoa(output.count)); err != nil {
+			log.Warnf("Failed to export environment (%s), error: %s", output.key, err)
+		} else {
+			log.Printf("The number of %s tests (%d) is exported to the %s environment variable.", output.name, output.count, output.key)
+		}
+	}
+
+	if resultsJSON, err := report.DeviceResultsJSON(resultSteps); err != nil {
+		log.Warnf("Failed to marshal the device results, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("VDTESTING_RESULTS_JSON", resultsJSON); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_RESULTS_JSON), error: %s", err)
+	} else {
+		log.Printf("The device results (%s) are exported to the VDTESTING_RESULTS_JSON environment variable.", resultsJSON)
+	}
+
+	if outputs.coverageDir != "" && len(outputs.coverageFiles) > 0 {
+		exportCoverage(configs, outputs.coverageDir, outputs.coverageFiles)
+	}
+
+	if outputs.systraceDir != "" && len(outputs.systraceFiles) > 0 {
+		if err := tools.ExportEnvironmentWithEnvman("VDTESTING_SYSTRACE_DIR", outputs.systraceDir); err != nil {
+			log.Warnf("Failed to export environment (VDTESTING_SYSTRACE_DIR), error: %s", err)
+		} else {
+			log.Printf("The systrace directory (%s) is exported to the VDTESTING_SYSTRACE_DIR environment variable.", outputs.systraceDir)
+		}
+	}
+
+	if configs.TestHistoryPath != "" {
+		updateTestHistory(outputs.testResults, configs.BuildSlug, configs.TestHistoryPath)
+	}
+
+	if csvPath, err := exportResultsCSV(resultSteps); err != nil {
+		log.Warnf("Failed to export results CSV, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("VDTESTING_RESULTS_CSV_PATH", csvPath); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_RESULTS_CSV_PATH), error: %s", err)
+	} else {
+		log.Printf("The results CSV path (%s) is exported to the VDTESTING_RESULTS_CSV_PATH environment variable.", csvPath)
+	}
+
+	if outputs.assetsDir != "" {
+		if err := tools.ExportEnvironmentWithEnvman("VDTESTING_DOWNLOADED_FILES_DIR", outputs.assetsDir); err != nil {
+			log.Warnf("Failed to export environment (VDTESTING_DOWNLOADED_FILES_DIR), error: %s", err)
+		} else {
+			log.Printf("The downloaded test assets path (%s) is exported to the VDTESTING_DOWNLOADED_FILES_DIR environment variable.", outputs.assetsDir)
+		}
+
+		if len(outputs.screenshots) > 0 {
+			exportScreenshots(outputs.screenshotsDir, outputs.screenshots)
+		}
+
+		if configs.ZipTestAssets == "true" {
+			exportAssetsZip(outputs.assetsDir)
+		}
+	}
+}
+
+// exportAssetsZip compresses the downloaded test assets into a single zip file in the deploy dir.
+func exportAssetsZip(assetsDir string) {
+	outputDir, err := resultsDir()
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+
+	zipPath := filepath.Join(outputDir, "vdtesting-assets.zip")
+	if err := assets.Zip(assetsDir, zipPath); err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_ASSETS_ZIP_PATH", zipPath); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_ASSETS_ZIP_PATH), error: %s", err)
+	} else {
+		log.Printf("The zipped test assets path (%s) is exported to the VDTESTING_ASSETS_ZIP_PATH environment variable.", zipPath)
+	}
+}
+
+// downloadApk downloads the APK from apkURL into a temp dir, keeping the file name of the URL.
+func downloadApk(ctx context.Context, downloader *client.HTTPClient, apkURL string) (string, error) {
+	parsed, err := url.Parse(apkURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid APK URL, error: %s", err)
+	}
+	name := path.Base(parsed.Path)
+	if !strings.HasSuffix(name, ".apk") {
+		name = "app.apk"
+	}
+
+	dir, err := pathutil.NormalizedOSTempDirPath("vdtesting_apk")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create temp dir, error: %s", err)
+	}
+	pth := filepath.Join(dir, name)
+	if err := downloader.DownloadFile(ctx, apkURL, pth); err != nil {
+		return "", fmt.Errorf("Failed to download APK (%s), error: %s", apkURL, err)
+	}
+	return pth, nil
+}
+
+// checkAPKs fails the step if the APKs can not be tested, before spending time on uploading them.
+func checkAPKs(configs config.ConfigsModel) error {
+	var testApkPaths []string
+	if configs.TestType == "instrumentation" {
+		testApkPaths = config.ParseTestApkPaths(configs.TestApkPath)
+	}
+
+	for _, pth := range append([]string{configs.ApkPath}, testApkPaths...) {
+		if err := apk.Check(pth); err != nil {
+			return failure.New(failure.Check, failure.ConfigError, err)
+		}
+	}
+
+	if len(testApkPaths) == 0 {
+		return nil
+	}
+
+	// the manifest read errors are reported by checkAppManifest and checkTestManifest
+	appManifest, err := apk.ReadManifest(configs.ApkPath)
+	if err != nil {
+		return nil
+	}
+	for _, pth := range testApkPaths {
+		testManifest, err := apk.ReadManifest(pth)
+		if err != nil {
+			continue
+		}
+		if appManifest.Debuggable != testManifest.Debuggable {
+			return failure.Errorf(failure.Check, failure.ConfigError, "The app APK is a %s build, but the test APK (%s) is a %s build, they are signed with different keys most likely", buildType(appManifest), pth, buildType(testManifest))
+		}
+	}
+	return nil
+}
+
+func buildType(manifest apk.Manifest) string {
+	if manifest.Debuggable {
+		return "debug"
+	}
+	return "release"
+}
+
+// checkAppManifest reads the package ID from the manifest of the app APK.
+// It returns the package ID to use: the configured one if set, otherwise the one in the manifest.
+func checkAppManifest(apkPath, appPackageID string) string {
+	manifest, err := apk.ReadManifest(apkPath)
+	if err != nil {
+		log.Warnf("Failed to read the manifest of the app APK, error: %s", err)
+		return appPackageID
+	}
+	log.Printf("- App package ID: %s", manifest.Package)
+
+	if appPackageID == "" {
+		return manifest.Package
+	}
+	if appPackageID != manifest.Package {
+		log.Warnf("The app_package_id input (%s) does not match the package ID in the app APK manifest (%s)", appPackageID, manifest.Package)
+	}
+	return appPackageID
+}
+
+// checkTestManifest reads the package ID and the instrumentation runner from the manifest of the test APK.
+// It returns the test package ID and runner class to use: the configured ones if set, otherwise the ones in the manifest.
+func checkTestManifest(testApkPath, appPackageID, testPackageID, runnerClass string) (string, string) {
+	manifest, err := apk.ReadManifest(testApkPath)
+	if err != nil {
+		log.Warnf("Failed to read the manifest of the test APK, error: %s", err)
+		return testPackageID, runnerClass
+	}
+	log.Printf("- Test package ID: %s", manifest.Package)
+	log.Printf("- Test runner class: %s", manifest.RunnerClass())
+
+	if testPackageID == "" {
+		testPackageID = manifest.Package
+	} else if testPackageID != manifest.Package {
+		log.Warnf("The inst_test_package_id input (%s) does not match the package ID in the test APK manifest (%s)", testPackageID, manifest.Package)
+	}
+
+	if runnerClass == "" {
+		runnerClass = manifest.RunnerClass()
+	} else {
+		found := false
+		for _, instrumentation := range manifest.Instrumentations {
+			if instrumentation.Name == runnerClass {
+				found = true
+			}
+		}
+		if !found {
+			log.Warnf("The inst_test_runner_class input (%s) is not an instrumentation in the test APK manifest", runnerClass)
+		}
+	}
+
+	for _, instrumentation := range manifest.Instrumentations {
+		if instrumentation.Name == runnerClass && appPackageID != "" && instrumentation.TargetPackage != appPackageID {
+			log.Warnf("The instrumentation (%s) targets (%s), but the app package ID is (%s)", runnerClass, instrumentation.TargetPackage, appPackageID)
+		}
+	}
+
+	return testPackageID, runnerClass
+}
+
+// checkDevices prints the form and capacity of the requested devices from the device catalog,
+// and warns about the deprecated, low capacity and ABI incompatible ones.
+// It fails if a physical device is requested with virtual_only,
+// or if the APK's native libraries do not run on a device with fail_on_incompatible_abi.
+func checkDevices(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, testModel *matrix.TestMatrix) error {
+	deviceCatalog, err := apiClient.GetCatalog(ctx)
+	if err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		log.Warnf("Failed to get the device catalog, skipping the device checks, error: %s", err)
+		return nil
+	}
+
+	if configs.NetworkProfile != "" {
+		// the catalog of the backend might not contain the network profiles
+		if profiles := deviceCatalog.NetworkProfileIDs(); profiles != nil && !sliceutil.IsStringInSlice(configs.NetworkProfile, profiles) {
+			return failure.Errorf(failure.Check, failure.ConfigError, "Unknown network profile (%s)%s The available profiles are listed by the list-network-profiles mode.", configs.NetworkProfile, didYouMean(configs.NetworkProfile, profiles))
+		}
+	}
+
+	abis, err := apk.NativeABIs(configs.ApkPath)
+	if err != nil {
+		log.Warnf("Failed to inspect the APK, skipping the ABI check, error: %s", err)
+	} else if len(abis) > 0 {
+		log.Printf("- APK native ABIs: %s", strings.Join(abis, ", "))
+	}
+
+	deviceTimeouts := map[string]string{}
+	if devices, err := config.ParseTestDevices(configs.TestDevices); err == nil {
+		for _, device := range devices {
+			deviceTimeouts[device.String()] = device.Timeout
+		}
+	}
+
+	locales := deviceCatalog.LocaleIDs()
+	for _, device := range testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices {
+		// Test Lab would fail the whole matrix with an invalid locale, a catalog without locales does not tell the valid ones
+		if len(locales) > 0 && !sliceutil.IsStringInSlice(device.Locale, locales) {
+			return failure.Errorf(failure.Check, failure.ConfigError, "Unknown locale (%s) of %s API %s%s", device.Locale, device.AndroidModelID, device.AndroidVersionID, didYouMean(device.Locale, locales))
+		}
+
+		model := deviceCatalog.Model(device.AndroidModelID)
+		if model == nil {
+			continue
+		}
+
+		form := "virtual"
+		if model.IsPhysical() {
+			form = "physical"
+		}
+		capacity := model.Capacity(device.AndroidVersionID)
+		if capacity == "" {
+			capacity = "unknown"
+		}
+		log.Printf("- %s API %s: %s device, %s capacity", device.AndroidModelID, device.AndroidVersionID, form, capacity)
+
+		if model.IsPhysical() {
+			if configs.VirtualOnly == "true" {
+				return failure.Errorf(failure.Check, failure.ConfigError, "%s is a physical device, but only virtual devices are allowed (virtual_only)", device.AndroidModelID)
+			}
+			timeout := configs.TestTimeout
+			if deviceTimeout := deviceTimeouts[strings.Join([]string{device.AndroidModelID, device.AndroidVersionID, device.Locale, device.Orientation}, ",")]; deviceTimeout != "" {
+				timeout = deviceTimeout
+			}
+			if testTimeout, err := config.ParseTimeout(timeout); err == nil && testTimeout > config.MaxPhysicalTestTimeout {
+				return failure.Errorf(failure.Check, failure.ConfigError, "%s is a physical device, the test timeout of physical devices should be at most %s, got: %s", device.AndroidModelID, config.MaxPhysicalTestTimeout, testTimeout)
+			}
+			if capacity == "low" || capacity == "none" {
+				log.Warnf("%s API %s is a physical device with %s capacity, the test might be queued for a long time", device.AndroidModelID, device.AndroidVersionID, capacity)
+			}
+		}
+
+		if !model.SupportsAnyABI(abis) {
+			msg := fmt.Sprintf("%s supports only %s, but the APK ships native libraries for %s, the device would skip the test as IncompatibleArchitecture", device.AndroidModelID, strings.Join(model.SupportedAbis, ", "), strings.Join(abis, ", "))
+			if configs.FailOnIncompatibleABI == "true" {
+				return failure.Errorf(failure.Check, failure.ConfigError, "%s", msg)
+			}
+			log.Warnf("%s", msg)
+		}
+
+		if model.IsDeprecated(device.AndroidVersionID) {
+			log.Warnf("%s API %s is deprecated and will be removed from the device catalog, migrate to a newer device or API level", device.AndroidModelID, device.AndroidVersionID)
+		}
+	}
+
+	log.Donef("=> Devices checked")
+	return nil
+}
+
+// checkSDKVersions warns about the devices with an API level below the minSdkVersion of the APKs,
+// as the APKs can not be installed on them. It fails with fail_on_incompatible_sdk.
+func checkSDKVersions(configs config.ConfigsModel, testModel *matrix.TestMatrix) error {
+	apkPaths := []string{configs.ApkPath}
+	if configs.TestType == "instrumentation" {
+		apkPaths = append(apkPaths, config.ParseTestApkPaths(configs.TestApkPath)...)
+	}
+
+	// the manifest read errors are reported by checkAppManifest and checkTestManifest
+	minSdkVersion, minSdkPath := 0, ""
+	for _, pth := range apkPaths {
+		manifest, err := apk.ReadManifest(pth)
+		if err != nil {
+			continue
+		}
+		log.Printf("- %s: minSdkVersion %d, targetSdkVersion %d", filepath.Base(pth), manifest.MinSdkVersion, manifest.TargetSdkVersion)
+		if manifest.MinSdkVersion > minSdkVersion {
+			minSdkVersion, minSdkPath = manifest.MinSdkVersion, pth
+		}
+	}
+	if minSdkVersion == 0 {
+		return nil
+	}
+
+	for _, device := range testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices {
+		apiLevel, err := strconv.Atoi(device.AndroidVersionID)
+		if err != nil || apiLevel >= minSdkVersion {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s API %s is below the minSdkVersion (%d) of %s, the device would skip the test as IncompatibleAppVersion", device.AndroidModelID, device.AndroidVersionID, minSdkVersion, filepath.Base(minSdkPath))
+		if configs.FailOnIncompatibleSDK == "true" {
+			return failure.Errorf(failure.Check, failure.ConfigError, "%s", msg)
+		}
+		log.Warnf("%s", msg)
+	}
+	return nil
+}
+
+// resolveAutoOrientation replaces the `default` and `auto` orientations of the test devices with the default orientation of the catalog.
+func resolveAutoOrientation(ctx context.Context, apiClient client.Client, configs *config.ConfigsModel) error {
+	orientation := ""
+	if deviceCatalog, err := apiClient.GetCatalog(ctx); err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		log.Warnf("Failed to get the device catalog, error: %s", err)
+	} else {
+		orientation = deviceCatalog.DefaultOrientation()
+	}
+	if orientation == "" {
+		orientation = config.FallbackOrientation
+		log.Warnf("The default orientation is unknown, testing the devices with auto orientation in %s", orientation)
+	} else {
+		log.Printf("Testing the devices with auto orientation in the default orientation: %s", orientation)
+	}
+
+	if err := configs.ResolveAutoOrientation(orientation); err != nil {
+		return failure.Errorf(failure.Check, failure.ConfigError, "Issue with TestDevices: %s", err)
+	}
+	for _, device := range configs.DeduplicateTestDevices() {
+		log.Warnf("The test device (%s) is set multiple times, it is tested only once", device)
+	}
+	fmt.Println()
+	return nil
+}
+
+// didYouMean returns the closest candidates to the mistyped value formatted as a suggestion, or a period if there is none.
+func didYouMean(value string, candidates []string) string {
+	suggestions := catalog.Suggest(value, candidates)
+	if len(suggestions) == 0 {
+		return "."
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, " or "))
+}
+
+// listNetworkProfiles prints the network profiles of the catalog, which can be set as network_profile.
+func listNetworkProfiles(ctx context.Context, apiClient client.Client) error {
+	log.Infof("Network profiles")
+
+	networkCatalog, err := apiClient.GetCatalog(ctx)
+	if err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		return failure.Errorf(failure.Setup, failure.APIError, "Failed to get the network profile catalog, error: %s", err)
+	}
+	if networkCatalog.NetworkConfigurationCatalog == nil {
+		return failure.Errorf(failure.Setup, failure.APIError, "The catalog does not contain network profiles")
+	}
+
+	formatRule := func(rule *catalog.TrafficRule) string {
+		if rule == nil {
+			return "-"
+		}
+		delay := rule.Delay
+		if delay == "" {
+			delay = "0s"
+		}
+		return fmt.Sprintf("%g kbit/s, %s delay, %g%% loss", rule.Bandwidth, delay, rule.PacketLossRatio*100)
+	}
+
+	// the list is the output of the mode, printed even if quiet
+	printAlways(func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "ID\tDownload\tUpload\t")
+		for _, configuration := range networkCatalog.NetworkConfigurationCatalog.Configurations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", configuration.ID, formatRule(configuration.DownRule), formatRule(configuration.UpRule))
+		}
+		if err := w.Flush(); err != nil {
+			log.Errorf("Failed to flush writer, error: %s", err)
+		}
+	})
+	return nil
+}
+
+func printEstimatedMinutes(testModel *matrix.TestMatrix, testTimeout string) int {
+	devices := len(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices)
+
+	// the backend applies its default if test_timeout is empty
+	timeout, err := config.ParseTimeout(testTimeout)
+	if err != nil || timeout == 0 {
+		timeout = report.DefaultTestTimeout
+	}
+
+	if shards := testModel.Shards(); shards > 1 {
+		estimated := report.EstimatedMinutes(devices*shards, timeout)
+		log.Printf("Estimated device time: %d device(s) x %d shard(s) x %s = at most %d device minute(s)", devices, shards, timeout, estimated)
+		return estimated
+	}
+
+	estimated := report.EstimatedMinutes(devices, timeout)
+	log.Printf("Estimated device time: %d device(s) x %s = at most %d device minute(s)", devices, timeout, estimated)
+	return estimated
+}
+
+// checkQuota warns if the matrix might exceed the remaining device minutes or the concurrent matrix limit,
+// optionally waiting until a concurrent matrix slot frees up.
+func checkQuota(ctx context.Context, apiClient client.Client, estimatedMinutes int, wait bool) {
+	warnedMinutes := false
+	for {
+		quota, err := apiClient.GetQuota(ctx)
+		if err != nil {
+			exitIfAborted(ctx, apiClient, false)
+
+			var statusErr *client.StatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+				log.Printf("The API does not report quota, skipping the quota check")
+			} else {
+				log.Warnf("Failed to check quota, error: %s", err)
+			}
+			return
+		}
+
+		if quota.RemainingMinutes != nil && *quota.RemainingMinutes < estimatedMinutes && !warnedMinutes {
+			warnedMinutes = true
+			log.Warnf("The test might use up to %d device minute(s), but only %d remain in the quota, devices might not run to completion", estimatedMinutes, *quota.RemainingMinutes)
+		}
+
+		if quota.MaxConcurrentMatrices == 0 || quota.RunningMatrices < quota.MaxConcurrentMatrices {
+			return
+		}
+
+		if !wait {
+			log.Warnf("%d/%d concurrent test matrices are already running, the test might be queued or rejected", quota.RunningMatrices, quota.MaxConcurrentMatrices)
+			return
+		}
+
+		log.Printf("- Waiting for a free test matrix slot (%d/%d running)", quota.RunningMatrices, quota.MaxConcurrentMatrices)
+		select {
+		case <-ctx.Done():
+			exitIfAborted(ctx, apiClient, false)
+		case <-time.After(quotaPollInterval):
+		}
+	}
+}
+
+// pollOptions returns the options of waiting for the test matrix.
+func pollOptions(configs config.ConfigsModel) poll.Options {
+	options := poll.Options{
+		StreamLogcat: configs.StreamLogcat == "true",
+		FailFast:     configs.FailFast == "true",
+	}
+	if testTimeout, err := config.ParseTimeout(configs.TestTimeout); err == nil {
+		options.TestTimeout = testTimeout
+	}
+	if interval, err := config.ParseTimeout(configs.HeartbeatInterval); err == nil {
+		options.HeartbeatInterval = interval
+	}
+	return options
+}
+
+// newCollector returns the collector of the results of the test matrices started with the configs.
+func newCollector(apiClient client.Client, configs config.ConfigsModel) *poll.Collector {
+	return &poll.Collector{
+		Client:  apiClient,
+		Options: pollOptions(configs),
+		NewMatrix: func() (*matrix.TestMatrix, error) {
+			return matrix.Create(configs)
+		},
+		Tracer: tracer,
+	}
+}
+
+func printFailedTests(ctx context.Context, apiClient client.Client, steps []*client.Step, outputs *testOutputs) {
+	failedSteps := report.FailedSteps(steps)
+	if len(failedSteps) == 0 {
+		return
+	}
+
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Failed tests:")
+	for _, step := range failedSteps {
+		testCases := readTestCases(ctx, apiClient, files, step)
+		report.PrintFailedTests(os.Stdout, report.DeviceName(step), testCases)
+
+		for _, testCase := range testCases {
+			if testCase.Failed() {
+				outputs.failedTests[step] = append(outputs.failedTests[step], testCase.ID())
+			}
+		}
+	}
+}
+
+// printCrashes prints the fatal exception or native crash extracted from the logcat of the crashed devices.
+func printCrashes(ctx context.Context, apiClient client.Client, steps []*client.Step) {
+	var crashedSteps []*client.Step
+	for _, step := range steps {
+		if report.Crashed(step) {
+			crashedSteps = append(crashedSteps, step)
+		}
+	}
+	if len(crashedSteps) == 0 {
+		return
+	}
+
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Crashes:")
+	for _, step := range crashedSteps {
+		logcats, err := assets.ReadDeviceFiles(ctx, apiClient, files, assets.DeviceID(report.StepDimensions(step)), "logcat")
+		if err != nil {
+			log.Warnf("Failed to read logcat, error: %s", err)
+			continue
+		}
+
+		var crash []string
+		for _, logcat := range logcats {
+			if crash = report.ExtractCrash(string(logcat)); crash != nil {
+				break
+			}
+		}
+		if crash == nil {
+			log.Printf("%s: no crash found in the logcat", report.DeviceName(step))
+			continue
+		}
+		report.PrintCrash(os.Stdout, report.DeviceName(step), crash)
+	}
+}
+
+// printANRs prints the "Application Not Responding" entries found in the logcat of the unsuccessful devices,
+// with the stack of the main thread, if the ANR traces were pulled: ANRs otherwise show up as timeouts.
+func printANRs(ctx context.Context, apiClient client.Client, steps []*client.Step) {
+	var unsuccessfulSteps []*client.Step
+	for _, step := range steps {
+		if report.OutcomeSummary(step) != "success" {
+			unsuccessfulSteps = append(unsuccessfulSteps, step)
+		}
+	}
+	if len(unsuccessfulSteps) == 0 {
+		return
+	}
+
+	files, err := apiClient.GetAssets(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to read response: %s", err)
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return
+	}
+
+	printedHeader := false
+	for _, step := range unsuccessfulSteps {
+		deviceID := assets.DeviceID(report.StepDimensions(step))
+		logcats, err := assets.ReadDeviceFiles(ctx, apiClient, files, deviceID, "logcat")
+		if err != nil {
+			log.Warnf("Failed to read logcat, error: %s", err)
+			continue
+		}
+
+		var anr report.ANR
+		for _, logcat := range logcats {
+			if anr.Entry = report.ExtractANR(string(logcat)); anr.Entry != nil {
+				break
+			}
+		}
+		if anr.Entry == nil {
+			continue
+		}
+
+		for _, pattern := range []string{"traces*.txt", "anr_*"} {
+			traces, err := assets.ReadDeviceFiles(ctx, apiClient, files, deviceID, pattern)
+			if err != nil {
+				log.Warnf("Failed to read ANR traces, error: %s", err)
+				break
+			}
+			for _, content := range traces {
+				if anr.MainThread = report.ExtractMainThread(string(content)); anr.MainThread != nil {
+					break
+				}
+			}
+			if anr.MainThread != nil {
+				break
+			}
+		}
+
+		if !printedHeader {
+			fmt.Println()
+			log.Infof("ANRs:")
+			printedHeader = true
+		}
+		report.PrintANR(os.Stdout, report.DeviceName(step), anr)
+	}
+}
+
+// checkKnownFailures marks the failed steps as known failures, if the device is listed in the baseline,
+// or every failed test case of the step is listed in the baseline or in the quarantine list.
+// The results of the quarantined tests are collected, to report the ones which passed.
+func checkKnownFailures(ctx context.Context, apiClient client.Client, configs config.ConfigsModel, steps []*client.Step, outputs *testOutputs) error {
+	baseline, quarantine := testlist.List{}, testlist.List{}
+	if configs.BaselinePath != "" {
+		list, err := testlist.Load(configs.BaselinePath)
+		if err != nil {
+			return failure.New(failure.Report, failure.ConfigError, err)
+		}
+		baseline = list
+	}
+	if configs.QuarantinePath != "" {
+		list, err := testlist.Load(configs.QuarantinePath)
+		if err != nil {
+			return failure.New(failure.Report, failure.ConfigError, err)
+		}
+		quarantine = list
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Failed to upload file, response code was: %d", resp.StatusCode)
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return nil
 	}
 
+	fmt.Println()
+	for _, step := range steps {
+		failed := report.OutcomeSummary(step) == "failure"
+		if !failed && len(quarantine) == 0 {
+			continue
+		}
+
+		if failed && baseline[assets.DeviceID(report.StepDimensions(step))] {
+			outputs.knownFailures[step] = true
+			log.Warnf("%s is a known failure, listed in the baseline", report.DeviceName(step))
+			continue
+		}
+
+		var knownTests, quarantinedTests, newFailedTests []string
+		for _, testCase := range readTestCases(ctx, apiClient, files, step) {
+			id := testCase.ID()
+			if quarantine[id] && testCase.Skipped == nil {
+				passed, ok := outputs.quarantineResults[id]
+				outputs.quarantineResults[id] = (passed || !ok) && !testCase.Failed()
+			}
+
+			if !testCase.Failed() {
+				continue
+			}
+			switch {
+			case quarantine[id]:
+				quarantinedTests = append(quarantinedTests, id)
+			case baseline[id]:
+				knownTests = append(knownTests, id)
+			default:
+				newFailedTests = append(newFailedTests, id)
+			}
+		}
+		if !failed {
+			continue
+		}
+
+		if len(knownTests) > 0 {
+			log.Warnf("The failed tests of %s are known failures, listed in the baseline: %s", report.DeviceName(step), strings.Join(knownTests, ", "))
+		}
+		if len(quarantinedTests) > 0 {
+			log.Warnf("The failed tests of %s are quarantined: %s", report.DeviceName(step), strings.Join(quarantinedTests, ", "))
+		}
+		if len(newFailedTests) == 0 && len(knownTests)+len(quarantinedTests) > 0 {
+			outputs.knownFailures[step] = true
+		} else {
+			log.Errorf("%s has failures not listed in the baseline or the quarantine list", report.DeviceName(step))
+		}
+	}
 	return nil
 }
+
+// printPassedQuarantinedTests lists the quarantined tests which passed on every device, so they can be removed from the quarantine list.
+func printPassedQuarantinedTests(results map[string]bool) {
+	var passed []string
+	for test, testPassed := range results {
+		if testPassed {
+			passed = append(passed, test)
+		}
+	}
+	if len(passed) == 0 {
+		return
+	}
+	sort.Strings(passed)
+
+	fmt.Println()
+	log.Infof("Quarantined tests which passed on every device, consider removing them from the quarantine list:")
+	for _, test := range passed {
+		log.Printf("- %s", test)
+	}
+}
+
+// readTestCases returns the test cases of the step's JUnit reports, read errors are only logged.
+func readTestCases(ctx context.Context, apiClient client.Client, files map[string]string, step *client.Step) []report.TestCase {
+	results, err := assets.ReadTestResults(ctx, apiClient, files, assets.DeviceID(report.StepDimensions(step)))
+	if err != nil {
+		log.Warnf("Failed to read test results, error: %s", err)
+		return nil
+	}
+
+	var testCases []report.TestCase
+	for _, result := range results {
+		resultTestCases, err := report.ParseJUnit(result)
+		if err != nil {
+			log.Warnf("%s", err)
+			continue
+		}
+		testCases = append(testCases, resultTestCases...)
+	}
+	return testCases
+}
+
+// downloadCoverage downloads the coverage files of the devices into dir.
+func downloadCoverage(ctx context.Context, apiClient client.Client, dir, label string, outputs *testOutputs) []string {
+	fmt.Println()
+	log.Infof("Downloading coverage files")
+
+	files, failed, err := assets.DownloadDeviceFiles(ctx, apiClient, dir, "*.ec", "*.exec")
+	if err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		log.Warnf("Failed to download coverage files, error: %s", err)
+		return nil
+	}
+	recordFailedDownloads(outputs, label, failed)
+	if len(files) == 0 {
+		log.Warnf("No coverage files found, make sure the app is built with coverage enabled")
+		return nil
+	}
+	log.Donef("=> %d coverage file(s) downloaded", len(files))
+
+	return files
+}
+
+// downloadSystrace downloads the systrace files of the devices into dir.
+func downloadSystrace(ctx context.Context, apiClient client.Client, dir, label string, outputs *testOutputs) []string {
+	fmt.Println()
+	log.Infof("Downloading systrace files")
+
+	files, failed, err := assets.DownloadDeviceFiles(ctx, apiClient, dir, "*systrace*")
+	if err != nil {
+		exitIfAborted(ctx, apiClient, false)
+		log.Warnf("Failed to download systrace files, error: %s", err)
+		return nil
+	}
+	recordFailedDownloads(outputs, label, failed)
+	if len(files) == 0 {
+		log.Warnf("No systrace files found, systrace might not be supported on the tested devices")
+		return nil
+	}
+	log.Donef("=> %d systrace file(s) downloaded", len(files))
+
+	return files
+}
+
+// recordFailedDownloads warns about the test assets which failed to download, and records them for the summary of the results.
+func recordFailedDownloads(outputs *testOutputs, label string, failed map[string]error) {
+	var names []string
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		log.Warnf("Failed to download %s, skipping it, error: %s", name, failed[name])
+		outputs.failedDownloads = append(outputs.failedDownloads, path.Join(label, name))
+	}
+}
+
+// exportCoverage exports the coverage directory, and if merge_coverage is set the merged coverage file and report.
+func exportCoverage(configs config.ConfigsModel, coverageDir string, files []string) {
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_COVERAGE_DIR", coverageDir); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_COVERAGE_DIR), error: %s", err)
+	} else {
+		log.Printf("The coverage directory (%s) is exported to the VDTESTING_COVERAGE_DIR environment variable.", coverageDir)
+	}
+
+	if configs.MergeCoverage != "true" {
+		return
+	}
+
+	mergedPath := filepath.Join(coverageDir, "merged.exec")
+	if err := coverage.Merge(files, mergedPath); err != nil {
+		log.Warnf("Failed to merge coverage files, error: %s", err)
+		return
+	}
+	log.Donef("=> Coverage files merged")
+
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_COVERAGE_MERGED_PATH", mergedPath); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_COVERAGE_MERGED_PATH), error: %s", err)
+	} else {
+		log.Printf("The merged coverage file path (%s) is exported to the VDTESTING_COVERAGE_MERGED_PATH environment variable.", mergedPath)
+	}
+
+	classDirs := config.ParseList(configs.CoverageClassDirs)
+	if configs.JacocoCLIPath == "" || len(classDirs) == 0 {
+		return
+	}
+
+	xmlPath := filepath.Join(coverageDir, "coverage.xml")
+	if err := coverage.GenerateXMLReport(configs.JacocoCLIPath, mergedPath, classDirs, config.ParseList(configs.CoverageSourceDirs), xmlPath); err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	log.Donef("=> Coverage report generated")
+
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_COVERAGE_XML_PATH", xmlPath); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_COVERAGE_XML_PATH), error: %s", err)
+	} else {
+		log.Printf("The coverage report path (%s) is exported to the VDTESTING_COVERAGE_XML_PATH environment variable.", xmlPath)
+	}
+}
+
+// collectTestResults adds the test outcomes of the steps to results.
+// A test counts as failed if it failed on any of the devices.
+func collectTestResults(ctx context.Context, apiClient client.Client, steps []*client.Step, results map[string]bool) {
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return
+	}
+
+	for _, step := range steps {
+		for _, testCase := range readTestCases(ctx, apiClient, files, step) {
+			if testCase.Skipped != nil {
+				continue
+			}
+			passed, ok := results[testCase.ID()]
+			results[testCase.ID()] = (passed || !ok) && !testCase.Failed()
+		}
+	}
+}
+
+func updateTestHistory(results map[string]bool, buildSlug, pth string) {
+	testHistory, err := history.Load(pth)
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	testHistory.Add(buildSlug, results)
+
+	if flaky := testHistory.Flaky(); len(flaky) > 0 {
+		fmt.Println()
+		log.Warnf("Flaky tests (both passed and failed in the last %d builds):", len(testHistory.Builds))
+		for _, test := range flaky {
+			log.Printf("- %s", test)
+		}
+	}
+
+	if err := testHistory.Save(pth); err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_TEST_HISTORY_PATH", pth); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_TEST_HISTORY_PATH), error: %s", err)
+	} else {
+		log.Printf("The test history path (%s) is exported to the VDTESTING_TEST_HISTORY_PATH environment variable.", pth)
+	}
+}
+
+func exportScreenshots(screenshotsDir string, screenshots map[string][]string) {
+	if _, err := report.WriteScreenshotsHTML(screenshotsDir, screenshots); err != nil {
+		log.Warnf("%s", err)
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_SCREENSHOTS_DIR", screenshotsDir); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_SCREENSHOTS_DIR), error: %s", err)
+	} else {
+		log.Printf("The screenshots path (%s) is exported to the VDTESTING_SCREENSHOTS_DIR environment variable.", screenshotsDir)
+	}
+}
+
+func exportGameLoopResults(ctx context.Context, apiClient client.Client, steps []*client.Step) {
+	files, err := apiClient.GetAssets(ctx)
+	if err != nil {
+		log.Warnf("Failed to get test assets, error: %s", err)
+		return
+	}
+
+	var results []report.ScenarioResult
+	for _, step := range steps {
+		contents, err := assets.ReadDeviceFiles(ctx, apiClient, files, assets.DeviceID(report.StepDimensions(step)), "results_scenario_*.json")
+		if err != nil {
+			log.Warnf("Failed to read game loop results, error: %s", err)
+			continue
+		}
+
+		for fileName, content := range contents {
+			result, err := report.ParseScenarioResult(report.DeviceName(step), fileName, content)
+			if err != nil {
+				log.Warnf("%s", err)
+				continue
+			}
+			results = append(results, result)
+		}
+	}
+	if len(results) == 0 {
+		return
+	}
+	report.SortScenarioResults(results)
+
+	fmt.Println()
+	log.Infof("Game loop results:")
+	if err := report.PrintScenarioResults(os.Stdout, results); err != nil {
+		log.Errorf("Failed to flush writer, error: %s", err)
+	}
+
+	outputDir, err := resultsDir()
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	pth, err := report.ExportScenarioResults(results, outputDir)
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_GAMELOOP_RESULTS_PATH", pth); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_GAMELOOP_RESULTS_PATH), error: %s", err)
+	} else {
+		log.Printf("The game loop results path (%s) is exported to the VDTESTING_GAMELOOP_RESULTS_PATH environment variable.", pth)
+	}
+}
+
+// resultsDir returns the directory of the result files: the deploy dir on Bitrise, a temp dir otherwise.
+func resultsDir() (string, error) {
+	outputDir := os.Getenv("BITRISE_DEPLOY_DIR")
+	if outputDir == "" {
+		tempDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_results")
+		if err != nil {
+			return "", fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		outputDir = tempDir
+	}
+	return outputDir, nil
+}
+
+// exportTestMatrix exports the identifiers of the started test matrix, to collect its results in a later step.
+func exportTestMatrix(configs config.ConfigsModel, apiClient client.Client) {
+	fmt.Println()
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_BUILD_SLUG", configs.BuildSlug); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_BUILD_SLUG), error: %s", err)
+	} else {
+		log.Printf("The build slug of the test matrix (%s) is exported to the VDTESTING_BUILD_SLUG environment variable.", configs.BuildSlug)
+	}
+
+	firebaseClient, ok := apiClient.(*firebase.Client)
+	if !ok {
+		return
+	}
+	matrixID := firebaseClient.MatrixID()
+	if err := tools.ExportEnvironmentWithEnvman("VDTESTING_TEST_MATRIX_ID", matrixID); err != nil {
+		log.Warnf("Failed to export environment (VDTESTING_TEST_MATRIX_ID), error: %s", err)
+	} else {
+		log.Printf("The test matrix ID (%s) is exported to the VDTESTING_TEST_MATRIX_ID environment variable.", matrixID)
+	}
+}
+
+// exportResultsCSV writes the results CSV into the deploy dir (or a temp dir if it is not set).
+func exportResultsCSV(steps []*client.Step) (string, error) {
+	outputDir, err := resultsDir()
+	if err != nil {
+		return "", err
+	}
+	return report.ExportCSV(steps, outputDir)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/mock"
+)
+
+// stepBinary is the step built by TestMain, run against the fake API by the tests.
+var stepBinary string
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "step-test")
+	if err != nil {
+		fmt.Printf("Failed to create temp dir, error: %s\n", err)
+		return 1
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Failed to remove temp dir, error: %s\n", err)
+		}
+	}()
+
+	stepBinary = filepath.Join(dir, "step")
+	if out, err := exec.Command("go", "build", "-o", stepBinary, ".").CombinedOutput(); err != nil {
+		fmt.Printf("Failed to build the step, error: %s, output: %s\n", err, out)
+		return 1
+	}
+
+	// envman stores the exported outputs, a file per key
+	envman := "#!/bin/sh\ncat > \"$STEP_TEST_EXPORTS_DIR/$3\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "envman"), []byte(envman), 0755); err != nil {
+		fmt.Printf("Failed to write envman, error: %s\n", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// stepRun is the result of running the step.
+type stepRun struct {
+	exitCode int
+	output   string
+	exports  map[string]string
+}
+
+// writeAPK writes a signed looking APK, with a manifest the step can not parse, so it falls back to the inputs.
+func writeAPK(t *testing.T, pth string) {
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("Failed to create APK, error: %s", err)
+	}
+	writer := zip.NewWriter(file)
+	for _, name := range []string{"AndroidManifest.xml", "META-INF/CERT.RSA"} {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to the APK, error: %s", name, err)
+		}
+		if _, err := w.Write([]byte("mock")); err != nil {
+			t.Fatalf("Failed to write %s to the APK, error: %s", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write APK, error: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close APK, error: %s", err)
+	}
+}
+
+// runMockedStep runs the step against server with the default inputs of an instrumentation test, overridden by inputs.
+func runMockedStep(t *testing.T, server *mock.Server, inputs map[string]string) stepRun {
+	dir, err := ioutil.TempDir("", "step-run")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("Failed to remove temp dir, error: %s", err)
+		}
+	}()
+
+	exportsDir, deployDir := filepath.Join(dir, "exports"), filepath.Join(dir, "deploy")
+	for _, d := range []string{exportsDir, deployDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Failed to create dir, error: %s", err)
+		}
+	}
+	apkPath, testApkPath := filepath.Join(dir, "app.apk"), filepath.Join(dir, "app-androidTest.apk")
+	writeAPK(t, apkPath)
+	writeAPK(t, testApkPath)
+
+	envs := map[string]string{
+		"PATH":                  filepath.Dir(stepBinary) + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"HOME":                  os.Getenv("HOME"),
+		"TMPDIR":                dir,
+		"STEP_TEST_EXPORTS_DIR": exportsDir,
+		"BITRISE_DEPLOY_DIR":    deployDir,
+		"BITRISE_BUILD_SLUG":    "build-slug",
+		"BITRISE_APP_SLUG":      "app-slug",
+
+		"api_base_url":             server.URL(),
+		"api_token":                "token",
+		"mode":                     "run",
+		"apk_path":                 apkPath,
+		"test_apk_path":            testApkPath,
+		"allow_missing_apk":        "false",
+		"test_type":                "instrumentation",
+		"test_devices":             "NexusLowRes,30,en,portrait",
+		"use_default_device":       "false",
+		"app_package_id":           "com.example",
+		"inst_test_package_id":     "com.example.test",
+		"inst_test_runner_class":   "androidx.test.runner.AndroidJUnitRunner",
+		"download_test_results":    "false",
+		"zip_test_assets":          "false",
+		"prefix_asset_names":       "false",
+		"enable_coverage":          "false",
+		"merge_coverage":           "false",
+		"fail_on_skipped":          "false",
+		"fail_on_inconclusive":     "false",
+		"fail_on_flaky":            "false",
+		"rerun_failed_devices":     "0",
+		"rerun_failed_tests":       "0",
+		"max_matrix_retries":       "0",
+		"fail_fast":                "false",
+		"wait_for_results":         "true",
+		"wait_for_quota":           "false",
+		"virtual_only":             "false",
+		"fail_on_incompatible_abi": "false",
+		"fail_on_incompatible_sdk": "false",
+		"dry_run":                  "false",
+		"quiet":                    "false",
+		"disable_colors":           "true",
+		"results_sort":             "outcome",
+		"wide_results":             "false",
+		"stream_logcat":            "false",
+		"verbose":                  "false",
+		"dump_responses":           "false",
+		"annotate_build":           "false",
+		"metrics_prefix":           "vdtesting",
+	}
+	for key, value := range inputs {
+		envs[key] = value
+	}
+
+	cmd := exec.Command(stepBinary)
+	for key, value := range envs {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	out, err := cmd.CombinedOutput()
+
+	run := stepRun{output: string(out), exports: map[string]string{}}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		run.exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Failed to run the step, error: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(exportsDir)
+	if err != nil {
+		t.Fatalf("Failed to read the exports, error: %s", err)
+	}
+	for _, file := range files {
+		value, err := ioutil.ReadFile(filepath.Join(exportsDir, file.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read the export, error: %s", err)
+		}
+		run.exports[file.Name()] = string(value)
+	}
+
+	// the downloaded assets are listed before the temp dir (the TMPDIR of the step) is removed
+	if assetsDir := run.exports["VDTESTING_DOWNLOADED_FILES_DIR"]; assetsDir != "" {
+		run.exports["VDTESTING_DOWNLOADED_FILES_DIR"] = strings.Join(listFiles(t, assetsDir), ",")
+	}
+
+	if t.Failed() || testing.Verbose() {
+		t.Logf("step output:\n%s", run.output)
+	}
+	return run
+}
+
+// listFiles returns the files under dir, relative to dir.
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, pth)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to list the downloaded assets, error: %s", err)
+	}
+	return files
+}
+
+func TestStepWithMockServer(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   mock.Script
+		inputs   map[string]string
+		exitCode int
+		exports  map[string]string
+		output   []string
+	}{
+		{
+			name:     "success",
+			script:   mock.Script{},
+			exitCode: 0,
+			exports: map[string]string{
+				"VDTESTING_RESULT_NexusLowRes_30_en_portrait": "success",
+				"VDTESTING_TESTS_TOTAL":                       "2",
+				"VDTESTING_TESTS_PASSED":                      "2",
+				"VDTESTING_TESTS_FAILED":                      "0",
+				"VDTESTING_BILLED_MINUTES":                    "1",
+				"VDTESTING_RUN_DURATION":                      "60",
+			},
+		},
+		{
+			name:     "failure",
+			script:   mock.Script{Outcomes: map[string]string{"Pixel2-28-en-portrait": "failure"}},
+			inputs:   map[string]string{"test_devices": "NexusLowRes,30,en,portrait\nPixel2,28,en,portrait"},
+			exitCode: exitCodeTestFailure,
+			exports: map[string]string{
+				"VDTESTING_RESULT_NexusLowRes_30_en_portrait": "success",
+				"VDTESTING_RESULT_Pixel2_28_en_portrait":      "failure",
+				"VDTESTING_TESTS_TOTAL":                       "4",
+				"VDTESTING_TESTS_FAILED":                      "1",
+			},
+			output: []string{"failure category: test-failure"},
+		},
+		{
+			name:     "invalid matrix",
+			script:   mock.Script{InvalidMatrixDetails: "NO_SIGNATURE"},
+			exitCode: exitCodeInvalidConfiguration,
+			output:   []string{"NO_SIGNATURE", "failure category: config"},
+		},
+		{
+			name:     "unknown state",
+			script:   mock.Script{States: []string{"pending", "inProgress", "finalizing"}},
+			exitCode: 0,
+			exports: map[string]string{
+				"VDTESTING_RESULT_NexusLowRes_30_en_portrait": "success",
+			},
+			output: []string{`Unknown step state in the API response: "finalizing"`},
+		},
+		{
+			name:     "asset listing",
+			script:   mock.Script{},
+			inputs:   map[string]string{"download_test_results": "true"},
+			exitCode: 0,
+			exports: map[string]string{
+				"VDTESTING_DOWNLOADED_FILES_DIR": "NexusLowRes-30-en-portrait/logcat,NexusLowRes-30-en-portrait/test_result_1.xml",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mock.NewServer(tt.script)
+			defer server.Close()
+
+			run := runMockedStep(t, server, tt.inputs)
+			if run.exitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d\n%s", run.exitCode, tt.exitCode, run.output)
+			}
+			for key, want := range tt.exports {
+				if got, ok := run.exports[key]; !ok {
+					t.Errorf("%s is not exported", key)
+				} else if got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			for _, want := range tt.output {
+				if !strings.Contains(run.output, want) {
+					t.Errorf("the output does not contain %q", want)
+				}
+			}
+			if matrices := len(server.Matrices()); matrices != 1 {
+				t.Errorf("started test matrices = %d, want 1", matrices)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package matrix
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/config"
+)
+
+// AndroidDevice ...
+type AndroidDevice struct {
+	AndroidModelID   string `json:"androidModelId,omitempty"`
+	AndroidVersionID string `json:"androidVersionId,omitempty"`
+	Locale           string `json:"locale,omitempty"`
+	Orientation      string `json:"orientation,omitempty"`
+}
+
+// AndroidDeviceList ...
+type AndroidDeviceList struct {
+	AndroidDevices []*AndroidDevice `json:"androidDevices,omitempty"`
+}
+
+// EnvironmentMatrix ...
+type EnvironmentMatrix struct {
+	AndroidDeviceList *AndroidDeviceList `json:"androidDeviceList,omitempty"`
+}
+
+// TestMatrix ...
+type TestMatrix struct {
+	ProjectID         string             `json:"projectId,omitempty"`
+	ClientInfo        *ClientInfo        `json:"clientInfo,omitempty"`
+	EnvironmentMatrix *EnvironmentMatrix `json:"environmentMatrix,omitempty"`
+	TestSpecification *TestSpecification `json:"testSpecification,omitempty"`
+	ResultStorage     *ResultStorage     `json:"resultStorage,omitempty"`
+}
+
+// ResultStorage ...
+type ResultStorage struct {
+	GoogleCloudStorage *GoogleCloudStorage `json:"googleCloudStorage,omitempty"`
+}
+
+// GoogleCloudStorage ...
+type GoogleCloudStorage struct {
+	GcsPath string `json:"gcsPath,omitempty"`
+}
+
+// FileReference ...
+type FileReference struct {
+	GcsPath string `json:"gcsPath,omitempty"`
+}
+
+// ClientInfo ...
+type ClientInfo struct {
+	Name              string              `json:"name,omitempty"`
+	ClientInfoDetails []*ClientInfoDetail `json:"clientInfoDetails,omitempty"`
+}
+
+// ClientInfoDetail ...
+type ClientInfoDetail struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// TestSpecification ...
+type TestSpecification struct {
+	AndroidInstrumentationTest *AndroidInstrumentationTest `json:"androidInstrumentationTest,omitempty"`
+	AndroidRoboTest            *AndroidRoboTest            `json:"androidRoboTest,omitempty"`
+	AndroidTestLoop            *AndroidTestLoop            `json:"androidTestLoop,omitempty"`
+	AutoGoogleLogin            bool                        `json:"autoGoogleLogin,omitempty"`
+	TestSetup                  *TestSetup                  `json:"testSetup,omitempty"`
+	TestTimeout                string                      `json:"testTimeout,omitempty"`
+}
+
+// AndroidInstrumentationTest ...
+type AndroidInstrumentationTest struct {
+	AppApk          *FileReference  `json:"appApk,omitempty"`
+	TestApk         *FileReference  `json:"testApk,omitempty"`
+	AppPackageID    string          `json:"appPackageId,omitempty"`
+	TestPackageID   string          `json:"testPackageId,omitempty"`
+	TestRunnerClass string          `json:"testRunnerClass,omitempty"`
+	TestTargets     []string        `json:"testTargets,omitempty"`
+	ShardingOption  *ShardingOption `json:"shardingOption,omitempty"`
+}
+
+// ShardingOption ...
+type ShardingOption struct {
+	ManualSharding *ManualSharding `json:"manualSharding,omitempty"`
+}
+
+// ManualSharding ...
+type ManualSharding struct {
+	TestTargetsForShard []*TestTargetsForShard `json:"testTargetsForShard,omitempty"`
+}
+
+// TestTargetsForShard ...
+type TestTargetsForShard struct {
+	TestTargets []string `json:"testTargets,omitempty"`
+}
+
+// AndroidRoboTest ...
+type AndroidRoboTest struct {
+	AppApk             *FileReference   `json:"appApk,omitempty"`
+	AppInitialActivity string           `json:"appInitialActivity,omitempty"`
+	AppPackageID       string           `json:"appPackageId,omitempty"`
+	MaxDepth           int64            `json:"maxDepth,omitempty"`
+	MaxSteps           int64            `json:"maxSteps,omitempty"`
+	RoboDirectives     []*RoboDirective `json:"roboDirectives,omitempty"`
+}
+
+// RoboDirective ...
+type RoboDirective struct {
+	ActionType   string `json:"actionType,omitempty"`
+	InputText    string `json:"inputText,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// AndroidTestLoop ...
+type AndroidTestLoop struct {
+	AppApk         *FileReference `json:"appApk,omitempty"`
+	AppPackageID   string         `json:"appPackageId,omitempty"`
+	ScenarioLabels []string       `json:"scenarioLabels,omitempty"`
+	Scenarios      []int64        `json:"scenarios,omitempty"`
+}
+
+// TestSetup ...
+type TestSetup struct {
+	DirectoriesToPull    []string               `json:"directoriesToPull,omitempty"`
+	EnvironmentVariables []*EnvironmentVariable `json:"environmentVariables,omitempty"`
+	NetworkProfile       string                 `json:"networkProfile,omitempty"`
+	Systrace             *SystraceSetup         `json:"systrace,omitempty"`
+}
+
+// SystraceSetup ...
+type SystraceSetup struct {
+	DurationSeconds int64 `json:"durationSeconds,omitempty"`
+}
+
+// EnvironmentVariable ...
+type EnvironmentVariable struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// MaxTestExecutions is the maximum number of test executions (one per device) Test Lab accepts in a test matrix.
+const MaxTestExecutions = 200
+
+// Create renders the test matrix described by the configs.
+func Create(configs config.ConfigsModel) (*TestMatrix, error) {
+	testModel := &TestMatrix{}
+	testModel.ClientInfo = createClientInfo(configs)
+	testModel.EnvironmentMatrix = &EnvironmentMatrix{AndroidDeviceList: &AndroidDeviceList{}}
+	testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = []*AndroidDevice{}
+
+	// the test timeouts of the devices are applied by running them in separate matrices (see config.SplitByTestTimeout)
+	testDevices, err := config.ParseTestDevices(configs.TestDevices)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range testDevices {
+		newDevice := AndroidDevice{
+			AndroidModelID:   device.Model,
+			AndroidVersionID: device.Version,
+			Locale:           device.Locale,
+			Orientation:      device.Orientation,
+		}
+
+		testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = append(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices, &newDevice)
+	}
+
+	if devices := len(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices); devices > MaxTestExecutions {
+		return nil, fmt.Errorf("Too many test devices (%d), Test Lab runs at most %d test executions in a test matrix", devices, MaxTestExecutions)
+	}
+
+	// parse environment variables
+	environmentVariables, err := config.ParseEnvironmentVariables(configs.EnvironmentVariables)
+	if err != nil {
+		return nil, err
+	}
+	envs := []*EnvironmentVariable{}
+	for _, env := range environmentVariables {
+		envs = append(envs, &EnvironmentVariable{Key: env.Key, Value: env.Value})
+	}
+
+	testTimeout, err := config.ParseTimeout(configs.TestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid test timeout: %s", err)
+	}
+
+	testModel.TestSpecification = &TestSpecification{
+		TestSetup: &TestSetup{
+			EnvironmentVariables: envs,
+			NetworkProfile:       configs.NetworkProfile,
+			DirectoriesToPull:    configs.PulledDirectories(),
+		},
+	}
+	systraceDuration, err := config.ParseTimeout(configs.SystraceDuration)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid systrace duration: %s", err)
+	}
+	if systraceDuration > 0 {
+		testModel.TestSpecification.TestSetup.Systrace = &SystraceSetup{DurationSeconds: int64(systraceDuration / time.Second)}
+	}
+	if testTimeout > 0 {
+		testModel.TestSpecification.TestTimeout = fmt.Sprintf("%ds", int64(testTimeout/time.Second))
+	}
+
+	switch configs.TestType {
+	case "instrumentation":
+		testModel.TestSpecification.AndroidInstrumentationTest = &AndroidInstrumentationTest{}
+		if configs.AppPackageID != "" {
+			testModel.TestSpecification.AndroidInstrumentationTest.AppPackageID = configs.AppPackageID
+		}
+		if configs.InstTestPackageID != "" {
+			testModel.TestSpecification.AndroidInstrumentationTest.TestPackageID = configs.InstTestPackageID
+		}
+		if configs.InstTestRunnerClass != "" {
+			testModel.TestSpecification.AndroidInstrumentationTest.TestRunnerClass = configs.InstTestRunnerClass
+		}
+		if configs.InstTestTargets != "" {
+			targets, err := parseTestTargets(configs.InstTestTargets)
+			if err != nil {
+				return nil, err
+			}
+			testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = targets
+		}
+		for _, annotation := range strings.Split(configs.InstTestAnnotation, ",") {
+			if annotation = strings.TrimSpace(annotation); annotation != "" {
+				testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = append(testModel.TestSpecification.AndroidInstrumentationTest.TestTargets, "annotation "+annotation)
+			}
+		}
+		if configs.InstTestSize != "" {
+			testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = append(testModel.TestSpecification.AndroidInstrumentationTest.TestTargets, "size "+configs.InstTestSize)
+		}
+		if configs.ShardsFile != "" {
+			shards, err := config.ParseShardsFile(configs.ShardsFile)
+			if err != nil {
+				return nil, err
+			}
+			manualSharding := &ManualSharding{}
+			for _, shard := range shards {
+				manualSharding.TestTargetsForShard = append(manualSharding.TestTargetsForShard, &TestTargetsForShard{TestTargets: shard.Targets})
+			}
+			testModel.TestSpecification.AndroidInstrumentationTest.ShardingOption = &ShardingOption{ManualSharding: manualSharding}
+
+			// every shard runs on every device
+			if executions := len(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices) * len(shards); executions > MaxTestExecutions {
+				return nil, fmt.Errorf("Too many test executions (%d devices x %d shards), Test Lab runs at most %d test executions in a test matrix", len(testModel.EnvironmentMatrix.AndroidDeviceList.AndroidDevices), len(shards), MaxTestExecutions)
+			}
+		}
+		if configs.InstRunnerArgs != "" {
+			// the API passes the environment variables to the instrumentation runner as arguments
+			runnerArgs, err := parseRunnerArgs(configs.InstRunnerArgs)
+			if err != nil {
+				return nil, err
+			}
+			for _, arg := range runnerArgs {
+				for _, env := range envs {
+					if env.Key == arg.Key {
+						return nil, fmt.Errorf("Instrumentation runner argument (%s) is also set as an environment variable", arg.Key)
+					}
+				}
+			}
+			testModel.TestSpecification.TestSetup.EnvironmentVariables = append(envs, runnerArgs...)
+		}
+		if configs.EnableCoverage == "true" {
+			testSetup := testModel.TestSpecification.TestSetup
+			for _, env := range testSetup.EnvironmentVariables {
+				if env.Key == "coverage" || env.Key == "coverageFile" {
+					return nil, fmt.Errorf("Instrumentation runner argument (%s) is set by enable_coverage, remove it from the arguments", env.Key)
+				}
+			}
+			testSetup.EnvironmentVariables = append(testSetup.EnvironmentVariables,
+				&EnvironmentVariable{Key: "coverage", Value: "true"},
+				&EnvironmentVariable{Key: "coverageFile", Value: CoverageDir + "/coverage.ec"},
+			)
+			testSetup.DirectoriesToPull = append(testSetup.DirectoriesToPull, CoverageDir)
+		}
+	case "robo":
+		testModel.TestSpecification.AndroidRoboTest = &AndroidRoboTest{}
+		if configs.AppPackageID != "" {
+			testModel.TestSpecification.AndroidRoboTest.AppPackageID = configs.AppPackageID
+		}
+		if configs.RoboInitialActivity != "" {
+			testModel.TestSpecification.AndroidRoboTest.AppInitialActivity = configs.RoboInitialActivity
+		}
+		if configs.RoboMaxDepth != "" {
+			maxDepth, err := strconv.Atoi(configs.RoboMaxDepth)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse string(%s) to integer, error: %s", configs.RoboMaxDepth, err)
+			}
+			testModel.TestSpecification.AndroidRoboTest.MaxDepth = int64(maxDepth)
+		}
+		if configs.RoboMaxSteps != "" {
+			maxSteps, err := strconv.Atoi(configs.RoboMaxSteps)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse string(%s) to integer, error: %s", configs.RoboMaxSteps, err)
+			}
+			testModel.TestSpecification.AndroidRoboTest.MaxSteps = int64(maxSteps)
+		}
+		if configs.RoboDirectives != "" {
+			directives, err := config.ParseRoboDirectives(configs.RoboDirectives)
+			if err != nil {
+				return nil, err
+			}
+			roboDirectives := []*RoboDirective{}
+			for _, directive := range directives {
+				roboDirectives = append(roboDirectives, &RoboDirective{ResourceName: directive.ResourceName, InputText: directive.InputText, ActionType: directive.ActionType})
+			}
+			testModel.TestSpecification.AndroidRoboTest.RoboDirectives = roboDirectives
+		}
+	case "gameloop":
+		testModel.TestSpecification.AndroidTestLoop = &AndroidTestLoop{}
+		if configs.AppPackageID != "" {
+			testModel.TestSpecification.AndroidTestLoop.AppPackageID = configs.AppPackageID
+		}
+		if configs.LoopScenarios != "" {
+			loopScenarios, err := config.ParseScenarios(configs.LoopScenarios)
+			if err != nil {
+				return nil, err
+			}
+			testModel.TestSpecification.AndroidTestLoop.Scenarios = loopScenarios
+		}
+		if configs.LoopScenarioLabels != "" {
+			scenarioLabels := strings.Split(strings.TrimSpace(configs.LoopScenarioLabels), ",")
+			testModel.TestSpecification.AndroidTestLoop.ScenarioLabels = scenarioLabels
+		}
+	}
+
+	return testModel, nil
+}
+
+// Shards returns the number of shards of the test matrix, every shard runs on every device.
+func (testModel *TestMatrix) Shards() int {
+	if testModel.TestSpecification == nil || testModel.TestSpecification.AndroidInstrumentationTest == nil {
+		return 1
+	}
+	shardingOption := testModel.TestSpecification.AndroidInstrumentationTest.ShardingOption
+	if shardingOption == nil || shardingOption.ManualSharding == nil || len(shardingOption.ManualSharding.TestTargetsForShard) == 0 {
+		return 1
+	}
+	return len(shardingOption.ManualSharding.TestTargetsForShard)
+}
+
+// CoverageDir is the on-device directory of the coverage file, if enable_coverage is set.
+const CoverageDir = "/sdcard/coverage"
+
+// negatedTargetKinds maps the test target kinds to their exclusion counterpart.
+var negatedTargetKinds = map[string]string{
+	"package":    "notPackage",
+	"class":      "notClass",
+	"annotation": "notAnnotation",
+}
+
+// createClientInfo describes the originating build, so that the matrix can be correlated back to it
+// in the Firebase console and in the results fetched later.
+func createClientInfo(configs config.ConfigsModel) *ClientInfo {
+	clientInfo := &ClientInfo{Name: "Bitrise"}
+	for _, detail := range []ClientInfoDetail{
+		{Key: "app_slug", Value: configs.AppSlug},
+		{Key: "build_slug", Value: configs.BuildSlug},
+		{Key: "build_number", Value: configs.BuildNumber},
+		{Key: "branch", Value: configs.GitBranch},
+		{Key: "commit_hash", Value: configs.GitCommit},
+		{Key: "workflow", Value: configs.WorkflowID},
+	} {
+		if detail.Value != "" {
+			clientInfo.ClientInfoDetails = append(clientInfo.ClientInfoDetails, &ClientInfoDetail{Key: detail.Key, Value: detail.Value})
+		}
+	}
+	return clientInfo
+}
+
+// parseTestTargets parses the comma separated test targets.
+// A target prefixed with `!` excludes the tests instead, for example `!class com.example.SlowTest` is translated to `notClass com.example.SlowTest`.
+func parseTestTargets(targets string) ([]string, error) {
+	parsed := []string{}
+	for _, target := range strings.Split(strings.TrimSpace(targets), ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+
+		if strings.HasPrefix(target, "!") {
+			targetSplit := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(target, "!")), " ", 2)
+			negatedKind, ok := negatedTargetKinds[targetSplit[0]]
+			if !ok || len(targetSplit) != 2 {
+				return nil, fmt.Errorf("Invalid test target exclusion: %s, only package, class and annotation targets can be excluded", target)
+			}
+			target = negatedKind + " " + strings.TrimSpace(targetSplit[1])
+		}
+
+		parsed = append(parsed, target)
+	}
+	return parsed, nil
+}
+
+// parseRunnerArgs parses the instrumentation runner arguments, one `key=value` per line.
+func parseRunnerArgs(args string) ([]*EnvironmentVariable, error) {
+	runnerArgs := []*EnvironmentVariable{}
+	scanner := bufio.NewScanner(strings.NewReader(args))
+	for scanner.Scan() {
+		arg := strings.TrimSpace(scanner.Text())
+		if arg == "" {
+			continue
+		}
+
+		argSplit := strings.SplitN(arg, "=", 2)
+		if len(argSplit) != 2 || strings.TrimSpace(argSplit[0]) == "" {
+			return nil, fmt.Errorf("Invalid instrumentation runner argument configuration: %s", arg)
+		}
+		runnerArgs = append(runnerArgs, &EnvironmentVariable{Key: strings.TrimSpace(argSplit[0]), Value: strings.TrimSpace(argSplit[1])})
+	}
+	return runnerArgs, nil
+}
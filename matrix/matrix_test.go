@@ -0,0 +1,216 @@
+package matrix
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/config"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs config.ConfigsModel
+		check   func(t *testing.T, testMatrix *TestMatrix)
+		wantErr string
+	}{
+		{
+			name: "instrumentation test",
+			configs: config.ConfigsModel{
+				AppSlug:              "app-slug",
+				BuildSlug:            "build-slug",
+				TestType:             "instrumentation",
+				TestDevices:          "NexusLowRes,30,en,portrait\nPixel2,28,de,landscape",
+				TestTimeout:          "15m",
+				EnvironmentVariables: "FOO=bar",
+				NetworkProfile:       "LTE",
+				AppPackageID:         "com.example",
+				InstTestTargets:      "class com.example.FooTest, !package com.example.slow",
+				InstTestAnnotation:   "com.example.Smoke",
+				InstTestSize:         "small",
+				InstRunnerArgs:       "clearPackageData=true",
+			},
+			check: func(t *testing.T, testMatrix *TestMatrix) {
+				devices := testMatrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices
+				wantDevices := []*AndroidDevice{
+					{AndroidModelID: "NexusLowRes", AndroidVersionID: "30", Locale: "en", Orientation: "portrait"},
+					{AndroidModelID: "Pixel2", AndroidVersionID: "28", Locale: "de", Orientation: "landscape"},
+				}
+				if !reflect.DeepEqual(devices, wantDevices) {
+					t.Errorf("devices = %+v, want %+v", devices, wantDevices)
+				}
+
+				spec := testMatrix.TestSpecification
+				if spec.TestTimeout != "900s" {
+					t.Errorf("TestTimeout = %s, want 900s", spec.TestTimeout)
+				}
+				if spec.TestSetup.NetworkProfile != "LTE" {
+					t.Errorf("NetworkProfile = %s, want LTE", spec.TestSetup.NetworkProfile)
+				}
+				wantEnvs := []*EnvironmentVariable{{Key: "FOO", Value: "bar"}, {Key: "clearPackageData", Value: "true"}}
+				if !reflect.DeepEqual(spec.TestSetup.EnvironmentVariables, wantEnvs) {
+					t.Errorf("EnvironmentVariables = %+v, want %+v", spec.TestSetup.EnvironmentVariables, wantEnvs)
+				}
+
+				inst := spec.AndroidInstrumentationTest
+				if inst == nil || spec.AndroidRoboTest != nil || spec.AndroidTestLoop != nil {
+					t.Fatalf("only the instrumentation test should be set: %+v", spec)
+				}
+				if inst.AppPackageID != "com.example" {
+					t.Errorf("AppPackageID = %s, want com.example", inst.AppPackageID)
+				}
+				wantTargets := []string{"class com.example.FooTest", "notPackage com.example.slow", "annotation com.example.Smoke", "size small"}
+				if !reflect.DeepEqual(inst.TestTargets, wantTargets) {
+					t.Errorf("TestTargets = %v, want %v", inst.TestTargets, wantTargets)
+				}
+
+				wantClientInfo := &ClientInfo{Name: "Bitrise", ClientInfoDetails: []*ClientInfoDetail{{Key: "app_slug", Value: "app-slug"}, {Key: "build_slug", Value: "build-slug"}}}
+				if !reflect.DeepEqual(testMatrix.ClientInfo, wantClientInfo) {
+					t.Errorf("ClientInfo = %+v, want %+v", testMatrix.ClientInfo, wantClientInfo)
+				}
+			},
+		},
+		{
+			name: "coverage",
+			configs: config.ConfigsModel{
+				TestType:       "instrumentation",
+				TestDevices:    "NexusLowRes,30,en,portrait",
+				EnableCoverage: "true",
+			},
+			check: func(t *testing.T, testMatrix *TestMatrix) {
+				setup := testMatrix.TestSpecification.TestSetup
+				wantEnvs := []*EnvironmentVariable{{Key: "coverage", Value: "true"}, {Key: "coverageFile", Value: CoverageDir + "/coverage.ec"}}
+				if !reflect.DeepEqual(setup.EnvironmentVariables, wantEnvs) {
+					t.Errorf("EnvironmentVariables = %+v, want %+v", setup.EnvironmentVariables, wantEnvs)
+				}
+				if !reflect.DeepEqual(setup.DirectoriesToPull, []string{CoverageDir}) {
+					t.Errorf("DirectoriesToPull = %v, want %v", setup.DirectoriesToPull, []string{CoverageDir})
+				}
+			},
+		},
+		{
+			name: "robo test",
+			configs: config.ConfigsModel{
+				TestType:            "robo",
+				TestDevices:         "NexusLowRes,30,en,portrait",
+				RoboInitialActivity: "com.example.MainActivity",
+				RoboMaxDepth:        "10",
+				RoboMaxSteps:        "100",
+				SystraceDuration:    "30",
+			},
+			check: func(t *testing.T, testMatrix *TestMatrix) {
+				robo := testMatrix.TestSpecification.AndroidRoboTest
+				want := &AndroidRoboTest{AppInitialActivity: "com.example.MainActivity", MaxDepth: 10, MaxSteps: 100}
+				if !reflect.DeepEqual(robo, want) {
+					t.Errorf("AndroidRoboTest = %+v, want %+v", robo, want)
+				}
+				if systrace := testMatrix.TestSpecification.TestSetup.Systrace; systrace == nil || systrace.DurationSeconds != 30 {
+					t.Errorf("Systrace = %+v, want 30 seconds", systrace)
+				}
+				if testMatrix.TestSpecification.TestTimeout != "" {
+					t.Errorf("TestTimeout = %s, want the default", testMatrix.TestSpecification.TestTimeout)
+				}
+			},
+		},
+		{
+			name: "game loop test",
+			configs: config.ConfigsModel{
+				TestType:           "gameloop",
+				TestDevices:        "NexusLowRes,30,en,portrait",
+				LoopScenarios:      "1-3,5",
+				LoopScenarioLabels: "smoke,nightly",
+			},
+			check: func(t *testing.T, testMatrix *TestMatrix) {
+				loop := testMatrix.TestSpecification.AndroidTestLoop
+				want := &AndroidTestLoop{Scenarios: []int64{1, 2, 3, 5}, ScenarioLabels: []string{"smoke", "nightly"}}
+				if !reflect.DeepEqual(loop, want) {
+					t.Errorf("AndroidTestLoop = %+v, want %+v", loop, want)
+				}
+			},
+		},
+		{
+			name:    "invalid test device",
+			configs: config.ConfigsModel{TestType: "robo", TestDevices: "NexusLowRes,30"},
+			wantErr: "Invalid test device configuration",
+		},
+		{
+			name:    "too many test devices",
+			configs: config.ConfigsModel{TestType: "robo", TestDevices: strings.Repeat("NexusLowRes,30,en,portrait\n", MaxTestExecutions+1)},
+			wantErr: "Too many test devices",
+		},
+		{
+			name:    "invalid test target exclusion",
+			configs: config.ConfigsModel{TestType: "instrumentation", TestDevices: "NexusLowRes,30,en,portrait", InstTestTargets: "!size small"},
+			wantErr: "Invalid test target exclusion",
+		},
+		{
+			name: "runner argument set as an environment variable",
+			configs: config.ConfigsModel{
+				TestType:             "instrumentation",
+				TestDevices:          "NexusLowRes,30,en,portrait",
+				EnvironmentVariables: "debug=false",
+				InstRunnerArgs:       "debug=true",
+			},
+			wantErr: "Instrumentation runner argument (debug) is also set as an environment variable",
+		},
+		{
+			name:    "invalid robo max depth",
+			configs: config.ConfigsModel{TestType: "robo", TestDevices: "NexusLowRes,30,en,portrait", RoboMaxDepth: "deep"},
+			wantErr: "Failed to parse string(deep) to integer",
+		},
+		{
+			name:    "invalid test timeout",
+			configs: config.ConfigsModel{TestType: "robo", TestDevices: "NexusLowRes,30,en,portrait", TestTimeout: "soon"},
+			wantErr: "Invalid test timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testMatrix, err := Create(tt.configs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("Create() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Create() unexpected error: %s", err)
+			}
+			tt.check(t, testMatrix)
+		})
+	}
+}
+
+func TestCreateWithShards(t *testing.T) {
+	shardsFile, err := ioutil.TempFile("", "shards")
+	if err != nil {
+		t.Fatalf("Failed to create shards file, error: %s", err)
+	}
+	defer func() {
+		if err := os.Remove(shardsFile.Name()); err != nil {
+			t.Errorf("Failed to remove shards file, error: %s", err)
+		}
+	}()
+	if _, err := shardsFile.WriteString(`{"login": ["class com.example.LoginTest"], "checkout": ["class com.example.CheckoutTest"]}`); err != nil {
+		t.Fatalf("Failed to write shards file, error: %s", err)
+	}
+	if err := shardsFile.Close(); err != nil {
+		t.Fatalf("Failed to close shards file, error: %s", err)
+	}
+
+	testMatrix, err := Create(config.ConfigsModel{
+		TestType:    "instrumentation",
+		TestDevices: "NexusLowRes,30,en,portrait",
+		ShardsFile:  shardsFile.Name(),
+	})
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %s", err)
+	}
+	if shards := testMatrix.Shards(); shards != 2 {
+		t.Errorf("Shards() = %d, want 2", shards)
+	}
+}
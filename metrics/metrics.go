@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Sample is a gauge value of a metric, the labels tell apart the values of the same metric (like the device).
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitize replaces the characters which are not allowed in metric names.
+func sanitize(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// StatsDLines formats the samples as StatsD gauges, like: `vdtesting.device_duration_seconds.NexusLowRes_30_en_portrait:42|g`.
+// StatsD has no labels, so the label values are appended to the metric name.
+func StatsDLines(prefix string, samples []Sample) []string {
+	lines := []string{}
+	for _, sample := range samples {
+		name := sanitize(sample.Name)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		for _, key := range sortedKeys(sample.Labels) {
+			name += "." + sanitize(sample.Labels[key])
+		}
+		lines = append(lines, fmt.Sprintf("%s:%s|g", name, formatValue(sample.Value)))
+	}
+	return lines
+}
+
+// PushStatsD sends the samples to the StatsD server at address (`host:port`) over UDP.
+func PushStatsD(address, prefix string, samples []Sample) error {
+	conn, err := net.DialTimeout("udp", address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to StatsD (%s), error: %s", address, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("Failed to close StatsD connection, error: %s", err)
+		}
+	}()
+
+	// every line is sent in a separate packet, to stay below the packet size limit
+	for _, line := range StatsDLines(prefix, samples) {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return fmt.Errorf("Failed to send metrics to StatsD (%s), error: %s", address, err)
+		}
+	}
+	return nil
+}
+
+// PrometheusText formats the samples in the Prometheus text exposition format.
+func PrometheusText(prefix string, samples []Sample) string {
+	var buf bytes.Buffer
+	typed := map[string]bool{}
+	for _, sample := range samples {
+		name := sanitize(sample.Name)
+		if prefix != "" {
+			name = sanitize(prefix) + "_" + name
+		}
+		if !typed[name] {
+			fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+			typed[name] = true
+		}
+
+		labels := []string{}
+		for _, key := range sortedKeys(sample.Labels) {
+			labels = append(labels, fmt.Sprintf("%s=%q", sanitize(key), sample.Labels[key]))
+		}
+		if len(labels) > 0 {
+			name += "{" + strings.Join(labels, ",") + "}"
+		}
+		fmt.Fprintf(&buf, "%s %s\n", name, formatValue(sample.Value))
+	}
+	return buf.String()
+}
+
+// PushgatewayJob is the job of the metrics pushed by the step.
+const PushgatewayJob = "virtual_device_testing"
+
+// PushGateway replaces the metrics of the job and grouping labels on the Prometheus Pushgateway at gatewayURL with the samples.
+func PushGateway(httpClient *http.Client, gatewayURL, job string, grouping map[string]string, prefix string, samples []Sample) error {
+	if job == "" {
+		return fmt.Errorf("Failed to push metrics to the Pushgateway, no job set")
+	}
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	for _, key := range sortedKeys(grouping) {
+		pushURL += "/" + url.PathEscape(sanitize(key)) + "/" + url.PathEscape(grouping[key])
+	}
+	req, err := http.NewRequest("PUT", pushURL, strings.NewReader(PrometheusText(prefix, samples)))
+	if err != nil {
+		return fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to push metrics to the Pushgateway, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Failed to push metrics to the Pushgateway, status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSamples = []Sample{
+	{Name: "queue_seconds", Value: 12.5},
+	{Name: "device_duration_seconds", Labels: map[string]string{"device": "NexusLowRes-30-en-portrait"}, Value: 60},
+	{Name: "device_duration_seconds", Labels: map[string]string{"device": "Pixel2-28-en-portrait"}, Value: 75},
+	{Name: "devices", Labels: map[string]string{"outcome": "failure", "quoted": `say "hi"`}, Value: 1},
+}
+
+func TestPrometheusText(t *testing.T) {
+	want := `# TYPE vdtesting_queue_seconds gauge
+vdtesting_queue_seconds 12.5
+# TYPE vdtesting_device_duration_seconds gauge
+vdtesting_device_duration_seconds{device="NexusLowRes-30-en-portrait"} 60
+vdtesting_device_duration_seconds{device="Pixel2-28-en-portrait"} 75
+# TYPE vdtesting_devices gauge
+vdtesting_devices{outcome="failure",quoted="say \"hi\""} 1
+`
+	if got := PrometheusText("vdtesting", testSamples); got != want {
+		t.Errorf("PrometheusText() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := PrometheusText("", testSamples[:1]); got != "# TYPE queue_seconds gauge\nqueue_seconds 12.5\n" {
+		t.Errorf("PrometheusText() without prefix =\n%s", got)
+	}
+}
+
+func TestStatsDLines(t *testing.T) {
+	want := []string{
+		"vdtesting.queue_seconds:12.5|g",
+		"vdtesting.device_duration_seconds.NexusLowRes_30_en_portrait:60|g",
+		"vdtesting.device_duration_seconds.Pixel2_28_en_portrait:75|g",
+		"vdtesting.devices.failure.say__hi_:1|g",
+	}
+	if got := StatsDLines("vdtesting", testSamples); !reflect.DeepEqual(got, want) {
+		t.Errorf("StatsDLines() = %v, want %v", got, want)
+	}
+}
+
+func TestPushGateway(t *testing.T) {
+	var method, path, contentType, body string
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read request body, error: %s", err)
+		}
+		method, path, contentType, body = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(b)
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	grouping := map[string]string{"app_slug": "app/slug", "branch": "main"}
+	if err := PushGateway(server.Client(), server.URL+"/", PushgatewayJob, grouping, "vdtesting", testSamples); err != nil {
+		t.Fatalf("PushGateway() unexpected error: %s", err)
+	}
+	if method != "PUT" {
+		t.Errorf("method = %s, want PUT", method)
+	}
+	if want := "/metrics/job/virtual_device_testing/app_slug/app%2Fslug/branch/main"; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if contentType != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %s, want the text exposition format", contentType)
+	}
+	if want := PrometheusText("vdtesting", testSamples); body != want {
+		t.Errorf("body =\n%s\nwant\n%s", body, want)
+	}
+
+	statusCode = http.StatusBadRequest
+	err := PushGateway(server.Client(), server.URL, PushgatewayJob, grouping, "vdtesting", testSamples)
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to push metrics to the Pushgateway, status code: 400") {
+		t.Errorf("PushGateway() error = %v, want status code 400", err)
+	}
+
+	// the Pushgateway rejects an empty job
+	path = ""
+	if err := PushGateway(server.Client(), server.URL, "", grouping, "vdtesting", testSamples); err == nil {
+		t.Errorf("PushGateway() without a job succeeded, want an error")
+	}
+	if path != "" {
+		t.Errorf("PushGateway() without a job sent a request to %s", path)
+	}
+}
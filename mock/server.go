@@ -0,0 +1,291 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/catalog"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+// DefaultStates are the states of the steps on the consecutive polls, if the script does not set them.
+var DefaultStates = []string{"pending", "inProgress", "complete"}
+
+// Script scripts the test matrices started on the server.
+type Script struct {
+	// States are the states of the steps on the consecutive polls of a test matrix, the last one is repeated.
+	// The devices get their outcome in a state other than pending and inProgress, like complete or a state unknown to the step.
+	States []string
+	// Outcome is the outcome summary of the completed devices, success if empty
+	Outcome string
+	// Outcomes override Outcome for the devices, keyed by the device ID (`model-version-locale-orientation`)
+	Outcomes map[string]string
+	// InvalidMatrixDetails makes the started test matrices invalid with the given reason
+	InvalidMatrixDetails string
+	// Catalog is served as the device catalog, DefaultCatalog if nil
+	Catalog *catalog.Catalog
+}
+
+// DefaultCatalog returns the catalog served if the script does not set one.
+// It does not list the models and the locales, so every device is accepted.
+func DefaultCatalog() *catalog.Catalog {
+	return &catalog.Catalog{
+		AndroidDeviceCatalog: &catalog.AndroidDeviceCatalog{
+			RuntimeConfiguration: &catalog.AndroidRuntimeConfiguration{
+				Orientations: []*catalog.Orientation{
+					{ID: "portrait", Name: "Portrait", Tags: []string{"default"}},
+					{ID: "landscape", Name: "Landscape"},
+				},
+			},
+		},
+		NetworkConfigurationCatalog: &catalog.NetworkConfigurationCatalog{
+			Configurations: []*catalog.NetworkConfiguration{
+				{ID: "LTE", DownRule: &catalog.TrafficRule{Bandwidth: 50000, Delay: "0.044s"}, UpRule: &catalog.TrafficRule{Bandwidth: 10000, Delay: "0.044s"}},
+				{ID: "GPRS", DownRule: &catalog.TrafficRule{Bandwidth: 80, Delay: "0.5s"}, UpRule: &catalog.TrafficRule{Bandwidth: 40, Delay: "0.5s"}},
+			},
+		},
+	}
+}
+
+// Server is a fake virtual device testing API, serving the endpoints used by the client package from memory.
+// The started test matrices go through the scripted states, and every device gets a logcat,
+// and a JUnit test result if it ran an instrumentation test.
+type Server struct {
+	script Script
+	server *httptest.Server
+
+	mu       sync.Mutex
+	matrices []*matrix.TestMatrix
+	polls    int
+	canceled bool
+	uploads  map[string]int64
+	files    map[string]string
+}
+
+// NewServer starts a fake API on a random local port, it is stopped by Close.
+func NewServer(script Script) *Server {
+	if len(script.States) == 0 {
+		script.States = DefaultStates
+	}
+	if script.Outcome == "" {
+		script.Outcome = "success"
+	}
+	if script.Catalog == nil {
+		script.Catalog = DefaultCatalog()
+	}
+
+	s := &Server{
+		script:  script,
+		uploads: map[string]int64{},
+		files:   map[string]string{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL is the base URL of the API.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Matrices returns the test matrices started on the server, the oldest first.
+func (s *Server) Matrices() []*matrix.TestMatrix {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*matrix.TestMatrix{}, s.matrices...)
+}
+
+// Uploads returns the size of the uploaded files, keyed by the upload name (app, test, mapping, native_symbols).
+func (s *Server) Uploads() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := map[string]int64{}
+	for name, size := range s.uploads {
+		uploads[name] = size
+	}
+	return uploads
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/upload/") && r.Method == "PUT":
+		size, err := io.Copy(ioutil.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.uploads[strings.TrimPrefix(r.URL.Path, "/upload/")] = size
+	case strings.HasPrefix(r.URL.Path, "/files/") && r.Method == "GET":
+		content, ok := s.files[strings.TrimPrefix(r.URL.Path, "/files/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	case strings.HasPrefix(r.URL.Path, "/assets/") && r.Method == "POST":
+		writeJSON(w, client.UploadURLRequest{
+			AppURL:           s.URL() + "/upload/app",
+			TestAppURL:       s.URL() + "/upload/test",
+			MappingURL:       s.URL() + "/upload/mapping",
+			NativeSymbolsURL: s.URL() + "/upload/native_symbols",
+		})
+	case strings.HasPrefix(r.URL.Path, "/assets/") && r.Method == "GET":
+		writeJSON(w, s.assets())
+	case strings.HasPrefix(r.URL.Path, "/quota/") && r.Method == "GET":
+		writeJSON(w, client.Quota{})
+	case strings.HasPrefix(r.URL.Path, "/catalog/") && r.Method == "GET":
+		writeJSON(w, s.script.Catalog)
+	case r.Method == "POST":
+		testMatrix := &matrix.TestMatrix{}
+		if err := json.NewDecoder(r.Body).Decode(testMatrix); err != nil {
+			http.Error(w, fmt.Sprintf("invalid test matrix: %s", err), http.StatusBadRequest)
+			return
+		}
+		s.matrices = append(s.matrices, testMatrix)
+		s.polls = 0
+		s.canceled = false
+		s.files = map[string]string{}
+		writeJSON(w, struct{}{})
+	case r.Method == "GET":
+		if len(s.matrices) == 0 {
+			http.Error(w, "no test matrix started", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s.listSteps())
+	case r.Method == "DELETE":
+		s.canceled = true
+		writeJSON(w, struct{}{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listSteps returns the steps of the last test matrix in the scripted state of the poll.
+func (s *Server) listSteps() client.ListStepsResponse {
+	if s.script.InvalidMatrixDetails != "" {
+		return client.ListStepsResponse{State: "INVALID", InvalidMatrixDetails: s.script.InvalidMatrixDetails}
+	}
+
+	state := s.script.States[len(s.script.States)-1]
+	if s.polls < len(s.script.States) {
+		state = s.script.States[s.polls]
+	}
+	s.polls++
+
+	testMatrix := s.matrices[len(s.matrices)-1]
+	response := client.ListStepsResponse{}
+	for _, device := range devices(testMatrix) {
+		step := &client.Step{
+			State: state,
+			DimensionValue: []*client.StepDimensionValueEntry{
+				{Key: "Model", Value: device.AndroidModelID},
+				{Key: "Version", Value: device.AndroidVersionID},
+				{Key: "Locale", Value: device.Locale},
+				{Key: "Orientation", Value: device.Orientation},
+			},
+		}
+		if s.canceled && !isFinal(state) {
+			step.State = "complete"
+			step.Outcome = &client.Outcome{Summary: "inconclusive", InconclusiveDetail: &client.InconclusiveDetail{AbortedByUser: true}}
+		} else if isFinal(state) {
+			step.Outcome = &client.Outcome{Summary: s.outcome(deviceID(device))}
+			step.RunDuration = &client.Duration{Seconds: 60}
+			if isInstrumentation(testMatrix) {
+				failures := 0
+				if step.Outcome.Summary == "failure" {
+					failures = 1
+				}
+				step.TestExecution = &client.TestExecutionStep{
+					TestSuiteOverviews: []*client.TestSuiteOverview{{Name: "instrumentation", TotalCount: 2, FailureCount: failures}},
+				}
+			}
+		}
+		if step.State != "pending" {
+			s.writeFiles(testMatrix, device, step)
+		}
+		response.Steps = append(response.Steps, step)
+	}
+	return response
+}
+
+func (s *Server) outcome(id string) string {
+	if outcome, ok := s.script.Outcomes[id]; ok {
+		return outcome
+	}
+	return s.script.Outcome
+}
+
+// writeFiles creates the test assets of the device, the logcat of a running device, and the test result of a completed one.
+func (s *Server) writeFiles(testMatrix *matrix.TestMatrix, device *matrix.AndroidDevice, step *client.Step) {
+	id := deviceID(device)
+	s.files[id+"/logcat"] = fmt.Sprintf("01-01 00:00:00.000  1000  1000 I TestRunner: run started on %s\n", id)
+	if step.Outcome == nil {
+		return
+	}
+	s.files[id+"/logcat"] += fmt.Sprintf("01-01 00:01:00.000  1000  1000 I TestRunner: run finished: %s\n", step.Outcome.Summary)
+
+	if isInstrumentation(testMatrix) {
+		failure := ""
+		if step.Outcome.Summary == "failure" {
+			failure = "<failure>java.lang.AssertionError: scripted failure\n\tat com.example.MockTest.scriptedOutcome(MockTest.java:12)</failure>"
+		}
+		s.files[id+"/test_result_1.xml"] = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites><testsuite name="instrumentation" tests="2">
+<testcase classname="com.example.MockTest" name="passes"/>
+<testcase classname="com.example.MockTest" name="scriptedOutcome">` + failure + `</testcase>
+</testsuite></testsuites>
+`
+	}
+}
+
+// assets returns the download URLs of the test assets, keyed by the asset name.
+func (s *Server) assets() map[string]string {
+	assets := map[string]string{}
+	for name := range s.files {
+		assets[name] = s.URL() + "/files/" + name
+	}
+	return assets
+}
+
+// isFinal returns true if the devices are done in the state, that is the state is not pending or inProgress.
+func isFinal(state string) bool {
+	return state != "pending" && state != "inProgress"
+}
+
+func devices(testMatrix *matrix.TestMatrix) []*matrix.AndroidDevice {
+	if testMatrix.EnvironmentMatrix == nil || testMatrix.EnvironmentMatrix.AndroidDeviceList == nil {
+		return nil
+	}
+	return testMatrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices
+}
+
+func deviceID(device *matrix.AndroidDevice) string {
+	return strings.Join([]string{device.AndroidModelID, device.AndroidVersionID, device.Locale, device.Orientation}, "-")
+}
+
+func isInstrumentation(testMatrix *matrix.TestMatrix) bool {
+	return testMatrix.TestSpecification != nil && testMatrix.TestSpecification.AndroidInstrumentationTest != nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,147 @@
+package poll
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/report"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/tracing"
+)
+
+// Stats of the test matrices waited for.
+type Stats struct {
+	BilledMinutes int
+	RunDuration   time.Duration
+	QueueDuration time.Duration
+}
+
+// add adds the stats of the steps of a test matrix, the queue duration is unknown if started is zero.
+func (stats *Stats) add(steps []*client.Step, started time.Time) {
+	stats.BilledMinutes += report.BilledMinutes(steps)
+	stats.RunDuration += report.RunDuration(steps)
+	if !started.IsZero() {
+		stats.QueueDuration += report.QueueDuration(steps, started)
+	}
+}
+
+// Collector waits for the test matrices of a test, and restarts them to retry the infrastructure failures
+// and to rerun the failed devices.
+// The context error is returned once the context is done, cancelling the running test matrix is up to the caller.
+type Collector struct {
+	Client  client.Client
+	Options Options
+	// NewMatrix creates the test matrix to restart.
+	NewMatrix func() (*matrix.TestMatrix, error)
+	// Tracer records the waits, optional.
+	Tracer *tracing.Tracer
+
+	// Stats of the test matrices waited for.
+	Stats Stats
+}
+
+// Wait waits for the started test matrix, and records its stats.
+// The queue duration is not recorded if started is zero, like for a resumed test matrix.
+func (c *Collector) Wait(ctx context.Context, started time.Time) ([]*client.Step, bool, error) {
+	span := c.Tracer.Start("wait")
+	defer span.End()
+
+	steps, infraFailure, err := Wait(ctx, c.Client, c.Options)
+	if err != nil {
+		return nil, false, err
+	}
+	c.Stats.add(steps, started)
+	return steps, infraFailure, nil
+}
+
+// Run starts the test matrix and waits for it, an infrastructure failure is returned as an error.
+func (c *Collector) Run(ctx context.Context, testMatrix *matrix.TestMatrix) ([]*client.Step, error) {
+	started := time.Now()
+	if err := c.Client.StartTest(ctx, testMatrix); err != nil {
+		return nil, failure.New(failure.Start, failure.APIError, err)
+	}
+
+	steps, infraFailure, err := c.Wait(ctx, started)
+	if err != nil {
+		return nil, err
+	}
+	if infraFailure {
+		return nil, errInfraFailure()
+	}
+	return steps, nil
+}
+
+// Retry restarts the test matrix, at most maxRetries times, while it ends with an infrastructure failure
+// or every device is inconclusive. An infrastructure failure left after the retries is returned as an error.
+func (c *Collector) Retry(ctx context.Context, steps []*client.Step, infraFailure bool, maxRetries int) ([]*client.Step, error) {
+	for attempt := 1; attempt <= maxRetries && (infraFailure || report.AllInconclusive(steps)); attempt++ {
+		fmt.Println()
+		log.Warnf("The test matrix ended with an infrastructure failure, retrying it (attempt %d/%d)", attempt, maxRetries)
+
+		testMatrix, err := c.NewMatrix()
+		if err != nil {
+			return nil, failure.New(failure.Check, failure.ConfigError, err)
+		}
+		started := time.Now()
+		if err := c.Client.StartTest(ctx, testMatrix); err != nil {
+			return nil, failure.New(failure.Start, failure.APIError, err)
+		}
+
+		steps, infraFailure, err = c.Wait(ctx, started)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if infraFailure {
+		return nil, errInfraFailure()
+	}
+	return steps, nil
+}
+
+// RerunDevices reruns the failed and inconclusive devices on a test matrix of their own, at most count times,
+// and replaces their steps with the rerun ones.
+func (c *Collector) RerunDevices(ctx context.Context, steps []*client.Step, count int) ([]*client.Step, error) {
+	for attempt := 1; attempt <= count; attempt++ {
+		rerunSteps := report.RerunnableSteps(steps)
+		if len(rerunSteps) == 0 {
+			break
+		}
+
+		fmt.Println()
+		log.Infof("Rerunning %d failed device(s) (attempt %d/%d)", len(rerunSteps), attempt, count)
+
+		testMatrix, err := c.NewMatrix()
+		if err != nil {
+			return nil, failure.New(failure.Check, failure.ConfigError, err)
+		}
+		devices := []*matrix.AndroidDevice{}
+		for _, step := range rerunSteps {
+			devices = append(devices, report.StepDevice(step))
+		}
+		testMatrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices = devices
+
+		rerunResultSteps, err := c.Run(ctx, testMatrix)
+		if err != nil {
+			return nil, err
+		}
+		steps = report.MergeSteps(steps, rerunResultSteps)
+
+		log.Donef("=> Rerun finished")
+		fmt.Println()
+
+		log.Infof("Test results after rerun:")
+		if err := report.PrintTable(os.Stdout, steps); err != nil {
+			log.Errorf("Failed to flush writer, error: %s", err)
+		}
+	}
+	return steps, nil
+}
+
+func errInfraFailure() error {
+	return failure.Errorf(failure.Wait, failure.InfraError, "The test matrix stopped due to an infrastructure failure")
+}
@@ -0,0 +1,273 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+func newTestMatrix() (*matrix.TestMatrix, error) {
+	return &matrix.TestMatrix{
+		EnvironmentMatrix: &matrix.EnvironmentMatrix{
+			AndroidDeviceList: &matrix.AndroidDeviceList{AndroidDevices: []*matrix.AndroidDevice{{AndroidModelID: "a"}, {AndroidModelID: "b"}}},
+		},
+	}, nil
+}
+
+// completedStep returns a complete step which ran for the given seconds.
+func completedStep(model, outcome string, seconds int64) *client.Step {
+	step := newStep(model, "complete", outcome)
+	step.RunDuration = &client.Duration{Seconds: seconds}
+	return step
+}
+
+// startedModels returns the models of the devices of the started test matrices.
+func startedModels(apiClient *fakeClient) [][]string {
+	var models [][]string
+	for _, testMatrix := range apiClient.started {
+		var matrixModels []string
+		for _, device := range testMatrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices {
+			matrixModels = append(matrixModels, device.AndroidModelID)
+		}
+		models = append(models, matrixModels)
+	}
+	return models
+}
+
+func TestCollectorWait(t *testing.T) {
+	recordIntervals(t)
+
+	started := time.Unix(1000, 0)
+	step := completedStep("a", "success", 90)
+	step.CreationTime = &client.Timestamp{Seconds: 1030}
+	apiClient := &fakeClient{responses: []*client.ListStepsResponse{newResponse("FINISHED", step, completedStep("b", "success", 30))}}
+	collector := Collector{Client: apiClient}
+
+	if _, _, err := collector.Wait(context.Background(), started); err != nil {
+		t.Fatalf("Wait() unexpected error: %s", err)
+	}
+	want := Stats{BilledMinutes: 3, RunDuration: 90 * time.Second, QueueDuration: 30 * time.Second}
+	if collector.Stats != want {
+		t.Errorf("Stats = %+v, want %+v", collector.Stats, want)
+	}
+
+	// the queue is not known without the start time
+	if _, _, err := collector.Wait(context.Background(), time.Time{}); err != nil {
+		t.Fatalf("Wait() unexpected error: %s", err)
+	}
+	want = Stats{BilledMinutes: 6, RunDuration: 180 * time.Second, QueueDuration: 30 * time.Second}
+	if collector.Stats != want {
+		t.Errorf("Stats = %+v, want %+v", collector.Stats, want)
+	}
+}
+
+func TestCollectorRetry(t *testing.T) {
+	inconclusive := []*client.Step{completedStep("a", "inconclusive", 60), completedStep("b", "inconclusive", 60)}
+	mixed := []*client.Step{completedStep("a", "inconclusive", 60), completedStep("b", "failure", 60)}
+	passed := newResponse("FINISHED", completedStep("a", "success", 60), completedStep("b", "success", 60))
+	stopped := newResponse("ERROR", completedStep("a", "inconclusive", 60), newStep("b", "pending", ""))
+
+	tests := []struct {
+		name          string
+		steps         []*client.Step
+		infraFailure  bool
+		maxRetries    int
+		responses     []*client.ListStepsResponse
+		wantOutcomes  []string
+		wantStarted   int
+		wantMinutes   int
+		wantErrPrefix string
+	}{
+		{
+			name:         "infrastructure failure",
+			steps:        inconclusive,
+			infraFailure: true,
+			maxRetries:   2,
+			responses:    []*client.ListStepsResponse{passed},
+			wantOutcomes: []string{"success", "success"},
+			wantStarted:  1,
+			wantMinutes:  2,
+		},
+		{
+			name:         "every device inconclusive",
+			steps:        inconclusive,
+			maxRetries:   2,
+			responses:    []*client.ListStepsResponse{newResponse("FINISHED", inconclusive...), passed},
+			wantOutcomes: []string{"success", "success"},
+			wantStarted:  2,
+			wantMinutes:  4,
+		},
+		{
+			name:         "a conclusive device is not retried",
+			steps:        mixed,
+			maxRetries:   2,
+			wantOutcomes: []string{"inconclusive", "failure"},
+		},
+		{
+			name:          "retries run out",
+			steps:         inconclusive,
+			infraFailure:  true,
+			maxRetries:    2,
+			responses:     []*client.ListStepsResponse{stopped},
+			wantStarted:   2,
+			wantMinutes:   2,
+			wantErrPrefix: "The test matrix stopped due to an infrastructure failure",
+		},
+		{
+			name:          "no retries",
+			steps:         inconclusive,
+			infraFailure:  true,
+			wantErrPrefix: "The test matrix stopped due to an infrastructure failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordIntervals(t)
+			apiClient := &fakeClient{responses: tt.responses}
+			collector := Collector{Client: apiClient, NewMatrix: newTestMatrix}
+
+			steps, err := collector.Retry(context.Background(), tt.steps, tt.infraFailure, tt.maxRetries)
+			if tt.wantErrPrefix != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErrPrefix) {
+					t.Fatalf("Retry() error = %v, want prefix %q", err, tt.wantErrPrefix)
+				}
+				if _, category := failure.Classify(err); category != failure.InfraError {
+					t.Errorf("Retry() error category = %s, want %s", category, failure.InfraError)
+				}
+			} else if err != nil {
+				t.Fatalf("Retry() unexpected error: %s", err)
+			} else if got := outcomes(steps); !reflect.DeepEqual(got, tt.wantOutcomes) {
+				t.Errorf("Retry() outcomes = %v, want %v", got, tt.wantOutcomes)
+			}
+
+			if len(apiClient.started) != tt.wantStarted {
+				t.Errorf("started %d test matrices, want %d", len(apiClient.started), tt.wantStarted)
+			}
+			if collector.Stats.BilledMinutes != tt.wantMinutes {
+				t.Errorf("billed minutes = %d, want %d", collector.Stats.BilledMinutes, tt.wantMinutes)
+			}
+		})
+	}
+}
+
+func TestCollectorRerunDevices(t *testing.T) {
+	steps := []*client.Step{
+		completedStep("a", "failure", 60),
+		completedStep("b", "success", 60),
+		completedStep("c", "inconclusive", 60),
+		completedStep("d", "skipped", 0),
+	}
+
+	tests := []struct {
+		name         string
+		count        int
+		responses    []*client.ListStepsResponse
+		wantOutcomes []string
+		wantStarted  [][]string
+	}{
+		{
+			name:  "the failed devices pass",
+			count: 2,
+			responses: []*client.ListStepsResponse{
+				newResponse("FINISHED", completedStep("a", "success", 60), completedStep("c", "success", 60)),
+			},
+			wantOutcomes: []string{"success", "success", "success", "skipped"},
+			wantStarted:  [][]string{{"a", "c"}},
+		},
+		{
+			name:  "a device keeps failing",
+			count: 2,
+			responses: []*client.ListStepsResponse{
+				newResponse("FINISHED", completedStep("a", "success", 60), completedStep("c", "failure", 60)),
+				newResponse("FINISHED", completedStep("c", "failure", 60)),
+			},
+			wantOutcomes: []string{"success", "success", "failure", "skipped"},
+			wantStarted:  [][]string{{"a", "c"}, {"c"}},
+		},
+		{
+			name:         "no reruns",
+			count:        0,
+			wantOutcomes: []string{"failure", "success", "inconclusive", "skipped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordIntervals(t)
+			apiClient := &fakeClient{responses: tt.responses}
+			collector := Collector{Client: apiClient, NewMatrix: newTestMatrix}
+
+			rerunSteps, err := collector.RerunDevices(context.Background(), steps, tt.count)
+			if err != nil {
+				t.Fatalf("RerunDevices() unexpected error: %s", err)
+			}
+			if got := outcomes(rerunSteps); !reflect.DeepEqual(got, tt.wantOutcomes) {
+				t.Errorf("RerunDevices() outcomes = %v, want %v", got, tt.wantOutcomes)
+			}
+			if started := startedModels(apiClient); !reflect.DeepEqual(started, tt.wantStarted) {
+				t.Errorf("started devices = %v, want %v", started, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func TestCollectorErrors(t *testing.T) {
+	failed := []*client.Step{completedStep("a", "failure", 60)}
+
+	tests := []struct {
+		name         string
+		apiClient    *fakeClient
+		newMatrix    func() (*matrix.TestMatrix, error)
+		wantPhase    failure.Phase
+		wantCategory failure.Category
+		wantErr      string
+	}{
+		{
+			name:         "the test matrix can not be created",
+			apiClient:    &fakeClient{},
+			newMatrix:    func() (*matrix.TestMatrix, error) { return nil, errors.New("Issue with test type: invalid") },
+			wantPhase:    failure.Check,
+			wantCategory: failure.ConfigError,
+			wantErr:      "Issue with test type: invalid",
+		},
+		{
+			name:         "the test matrix can not be started",
+			apiClient:    &fakeClient{startErr: errors.New("Failed to get http response, status code: 429")},
+			newMatrix:    newTestMatrix,
+			wantPhase:    failure.Start,
+			wantCategory: failure.APIError,
+			wantErr:      "Failed to get http response, status code: 429",
+		},
+		{
+			name:         "the rerun stops",
+			apiClient:    &fakeClient{responses: []*client.ListStepsResponse{newResponse("ERROR", completedStep("a", "inconclusive", 60))}},
+			newMatrix:    newTestMatrix,
+			wantPhase:    failure.Wait,
+			wantCategory: failure.InfraError,
+			wantErr:      "The test matrix stopped due to an infrastructure failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordIntervals(t)
+			collector := Collector{Client: tt.apiClient, NewMatrix: tt.newMatrix}
+
+			_, err := collector.RerunDevices(context.Background(), failed, 1)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("RerunDevices() error = %v, want prefix %q", err, tt.wantErr)
+			}
+			if phase, category := failure.Classify(err); phase != tt.wantPhase || category != tt.wantCategory {
+				t.Errorf("RerunDevices() error is classified as %s/%s, want %s/%s", phase, category, tt.wantPhase, tt.wantCategory)
+			}
+		})
+	}
+}
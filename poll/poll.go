@@ -0,0 +1,163 @@
+package poll
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/assets"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/report"
+)
+
+// Poll intervals of the test matrix: fast while the matrix is validated and the devices are pending,
+// backing off once the tests run, as the tests take minutes anyway.
+const (
+	minInterval        = 2 * time.Second
+	defaultMaxInterval = 30 * time.Second
+	// logcatMaxInterval is the maximum poll interval while streaming the logcat
+	logcatMaxInterval = 5 * time.Second
+	// jitterFraction is the maximum random deviation of the poll interval, as a fraction of it,
+	// so that the concurrent builds do not poll in lockstep
+	jitterFraction = 0.2
+)
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// after waits for the poll interval, replaced by the tests.
+var after = time.After
+
+// Options of waiting for the test matrix.
+type Options struct {
+	// TestTimeout of a device, used for the ETA, 0 if unknown.
+	TestTimeout time.Duration
+	// HeartbeatInterval between the heartbeats, 0 disables them.
+	HeartbeatInterval time.Duration
+	// StreamLogcat streams the logcat of the running devices.
+	StreamLogcat bool
+	// FailFast cancels the rest of the test matrix once a device failed.
+	FailFast bool
+}
+
+// Wait polls the steps of the running test matrix until every step completes,
+// or the test matrix stops due to an infrastructure failure, which is reported by the returned flag.
+// The context error is returned once the context is done, the test matrix is not cancelled then.
+func Wait(ctx context.Context, apiClient client.Client, options Options) ([]*client.Step, bool, error) {
+	validatingLogged := false
+	progress := report.Progress{}
+	logcatStreamer := assets.NewLogcatStreamer(apiClient)
+	eta := report.ETA{TestTimeout: options.TestTimeout}
+	heartbeat := report.Heartbeat{Interval: options.HeartbeatInterval}
+	unknownValues := report.UnknownValues{}
+	interval := time.Duration(0)
+	maxInterval := defaultMaxInterval
+	if options.StreamLogcat {
+		maxInterval = logcatMaxInterval
+	}
+
+	for {
+		responseModel, err := apiClient.ListSteps(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			return nil, false, failure.New(failure.Wait, failure.APIError, err)
+		}
+
+		unknownValues.Check(responseModel)
+		switch responseModel.State {
+		case "INVALID":
+			return nil, false, failure.Errorf(failure.Wait, failure.ConfigError, "%s", report.InvalidMatrixMessage(responseModel.InvalidMatrixDetails))
+		case "ERROR":
+			return responseModel.Steps, true, nil
+		}
+
+		finished := len(responseModel.Steps) > 0
+		for _, step := range responseModel.Steps {
+			if !report.IsComplete(step) {
+				finished = false
+			}
+		}
+
+		if len(responseModel.Steps) == 0 {
+			if !validatingLogged {
+				log.Printf("- Validating")
+				validatingLogged = true
+			}
+		} else {
+			if err := progress.Update(os.Stdout, responseModel.Steps); err != nil {
+				log.Errorf("Failed to flush writer, error: %s", err)
+			}
+			if !finished {
+				eta.Update(os.Stdout, responseModel.Steps)
+				heartbeat.Update(os.Stdout, responseModel.Steps, time.Now())
+			}
+		}
+
+		if finished {
+			return responseModel.Steps, false, nil
+		}
+
+		if options.FailFast && len(report.FailedSteps(responseModel.Steps)) > 0 {
+			fmt.Println()
+			log.Warnf("A device failed, cancelling the rest of the test matrix")
+			if err := apiClient.CancelTest(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil, false, ctx.Err()
+				}
+				log.Warnf("Failed to cancel the test matrix, error: %s", err)
+			}
+			return responseModel.Steps, false, nil
+		}
+
+		if options.StreamLogcat {
+			runningDevices := []string{}
+			for _, step := range responseModel.Steps {
+				if step.State == "inProgress" {
+					runningDevices = append(runningDevices, assets.DeviceID(report.StepDimensions(step)))
+				}
+			}
+
+			if len(runningDevices) > 0 {
+				if err := logcatStreamer.Stream(ctx, os.Stdout, runningDevices); err != nil {
+					log.Warnf("Failed to stream logcat, error: %s", err)
+				}
+			}
+		}
+
+		interval = nextInterval(interval, maxInterval, responseModel.Steps)
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-after(jitter(interval)):
+		}
+	}
+}
+
+// nextInterval returns minInterval until a device starts running,
+// then grows the interval by half on every poll, up to maxInterval.
+func nextInterval(interval, maxInterval time.Duration, steps []*client.Step) time.Duration {
+	started := false
+	for _, step := range steps {
+		if step.State == "inProgress" || report.IsComplete(step) {
+			started = true
+		}
+	}
+	if !started || interval < minInterval {
+		return minInterval
+	}
+
+	if interval += interval / 2; interval > maxInterval {
+		return maxInterval
+	}
+	return interval
+}
+
+// jitter returns the interval deviated randomly by at most jitterFraction.
+func jitter(interval time.Duration) time.Duration {
+	return time.Duration(float64(interval) * (1 + jitterFraction*(2*random.Float64()-1)))
+}
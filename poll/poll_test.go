@@ -0,0 +1,293 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+// fakeClient returns the responses of ListSteps in order, repeating the last one, and records the started test matrices.
+type fakeClient struct {
+	client.Client
+
+	responses []*client.ListStepsResponse
+	listErr   error
+	cancelErr error
+	startErr  error
+
+	polls     int
+	cancelled int
+	started   []*matrix.TestMatrix
+}
+
+func (c *fakeClient) ListSteps(ctx context.Context) (*client.ListStepsResponse, error) {
+	if c.listErr != nil {
+		return nil, c.listErr
+	}
+	response := c.responses[len(c.responses)-1]
+	if c.polls < len(c.responses) {
+		response = c.responses[c.polls]
+	}
+	c.polls++
+	return response, nil
+}
+
+func (c *fakeClient) StartTest(ctx context.Context, testMatrix *matrix.TestMatrix) error {
+	if c.startErr != nil {
+		return c.startErr
+	}
+	c.started = append(c.started, testMatrix)
+	return nil
+}
+
+func (c *fakeClient) CancelTest(ctx context.Context) error {
+	c.cancelled++
+	return c.cancelErr
+}
+
+func newStep(model, state, outcome string) *client.Step {
+	step := &client.Step{
+		State:          state,
+		DimensionValue: []*client.StepDimensionValueEntry{{Key: "Model", Value: model}, {Key: "Version", Value: "30"}},
+	}
+	if outcome != "" {
+		step.Outcome = &client.Outcome{Summary: outcome}
+	}
+	return step
+}
+
+// outcomes returns the outcome summaries of the steps.
+func outcomes(steps []*client.Step) []string {
+	var summaries []string
+	for _, step := range steps {
+		summary := ""
+		if step.Outcome != nil {
+			summary = step.Outcome.Summary
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+func newResponse(state string, steps ...*client.Step) *client.ListStepsResponse {
+	return &client.ListStepsResponse{State: state, Steps: steps}
+}
+
+// recordIntervals makes the polls return immediately, and records the intervals waited for.
+func recordIntervals(t *testing.T) *[]time.Duration {
+	var intervals []time.Duration
+	after = func(d time.Duration) <-chan time.Time {
+		intervals = append(intervals, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	t.Cleanup(func() { after = time.After })
+	return &intervals
+}
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name          string
+		responses     []*client.ListStepsResponse
+		failFast      bool
+		wantOutcomes  []string
+		wantInfra     bool
+		wantPolls     int
+		wantCancelled int
+	}{
+		{
+			name: "completes",
+			responses: []*client.ListStepsResponse{
+				newResponse("VALIDATING"),
+				newResponse("PENDING", newStep("a", "pending", ""), newStep("b", "pending", "")),
+				newResponse("RUNNING", newStep("a", "inProgress", ""), newStep("b", "pending", "")),
+				newResponse("FINISHED", newStep("a", "complete", "success"), newStep("b", "complete", "failure")),
+			},
+			wantOutcomes: []string{"success", "failure"},
+			wantPolls:    4,
+		},
+		{
+			name: "infrastructure failure",
+			responses: []*client.ListStepsResponse{
+				newResponse("RUNNING", newStep("a", "inProgress", "")),
+				newResponse("ERROR", newStep("a", "complete", "inconclusive")),
+			},
+			wantOutcomes: []string{"inconclusive"},
+			wantInfra:    true,
+			wantPolls:    2,
+		},
+		{
+			name: "fail fast",
+			responses: []*client.ListStepsResponse{
+				newResponse("RUNNING", newStep("a", "complete", "failure"), newStep("b", "inProgress", "")),
+			},
+			failFast:      true,
+			wantOutcomes:  []string{"failure", ""},
+			wantPolls:     1,
+			wantCancelled: 1,
+		},
+		{
+			name: "a failure does not cancel without fail fast",
+			responses: []*client.ListStepsResponse{
+				newResponse("RUNNING", newStep("a", "complete", "failure"), newStep("b", "inProgress", "")),
+				newResponse("FINISHED", newStep("a", "complete", "failure"), newStep("b", "complete", "success")),
+			},
+			wantOutcomes: []string{"failure", "success"},
+			wantPolls:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordIntervals(t)
+			apiClient := &fakeClient{responses: tt.responses}
+
+			steps, infraFailure, err := Wait(context.Background(), apiClient, Options{FailFast: tt.failFast})
+			if err != nil {
+				t.Fatalf("Wait() unexpected error: %s", err)
+			}
+
+			if got := outcomes(steps); !reflect.DeepEqual(got, tt.wantOutcomes) {
+				t.Errorf("Wait() outcomes = %v, want %v", got, tt.wantOutcomes)
+			}
+			if infraFailure != tt.wantInfra {
+				t.Errorf("Wait() infraFailure = %t, want %t", infraFailure, tt.wantInfra)
+			}
+			if apiClient.polls != tt.wantPolls {
+				t.Errorf("polls = %d, want %d", apiClient.polls, tt.wantPolls)
+			}
+			if apiClient.cancelled != tt.wantCancelled {
+				t.Errorf("cancelled = %d, want %d", apiClient.cancelled, tt.wantCancelled)
+			}
+		})
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		apiClient    *fakeClient
+		wantCategory failure.Category
+		wantErr      string
+	}{
+		{
+			name:         "invalid test matrix",
+			apiClient:    &fakeClient{responses: []*client.ListStepsResponse{{State: "INVALID", InvalidMatrixDetails: "NO_SIGNATURE"}}},
+			wantCategory: failure.ConfigError,
+			wantErr:      "The test matrix is invalid (NO_SIGNATURE)",
+		},
+		{
+			name:         "API error",
+			apiClient:    &fakeClient{listErr: errors.New("Failed to get http response, status code: 500")},
+			wantCategory: failure.APIError,
+			wantErr:      "Failed to get http response, status code: 500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordIntervals(t)
+
+			_, _, err := Wait(context.Background(), tt.apiClient, Options{})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("Wait() error = %v, want prefix %q", err, tt.wantErr)
+			}
+			if phase, category := failure.Classify(err); phase != failure.Wait || category != tt.wantCategory {
+				t.Errorf("Wait() error is classified as %s/%s, want %s/%s", phase, category, failure.Wait, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	after = func(d time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+	defer func() { after = time.After }()
+
+	apiClient := &fakeClient{responses: []*client.ListStepsResponse{newResponse("RUNNING", newStep("a", "inProgress", ""))}}
+	if _, _, err := Wait(ctx, apiClient, Options{}); err != context.Canceled {
+		t.Fatalf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+	// cancelling the test matrix is up to the caller
+	if apiClient.cancelled != 0 {
+		t.Errorf("cancelled = %d, want 0", apiClient.cancelled)
+	}
+}
+
+func TestWaitIntervals(t *testing.T) {
+	intervals := recordIntervals(t)
+
+	responses := []*client.ListStepsResponse{newResponse("VALIDATING"), newResponse("PENDING", newStep("a", "pending", ""))}
+	for i := 0; i < 10; i++ {
+		responses = append(responses, newResponse("RUNNING", newStep("a", "inProgress", "")))
+	}
+	responses = append(responses, newResponse("FINISHED", newStep("a", "complete", "success")))
+	if _, _, err := Wait(context.Background(), &fakeClient{responses: responses}, Options{}); err != nil {
+		t.Fatalf("Wait() unexpected error: %s", err)
+	}
+
+	if len(*intervals) != len(responses)-1 {
+		t.Fatalf("waited %d times, want %d", len(*intervals), len(responses)-1)
+	}
+	for i, interval := range *intervals {
+		// the jitter keeps the intervals within 20% of the backoff
+		if shortest, longest := time.Duration(float64(minInterval)*0.8), time.Duration(float64(defaultMaxInterval)*1.2); interval < shortest || interval > longest {
+			t.Errorf("interval %d = %s, want between %s and %s", i, interval, shortest, longest)
+		}
+		if i < 2 && interval > time.Duration(float64(minInterval)*1.2) {
+			t.Errorf("interval %d = %s before the tests started, want about %s", i, interval, minInterval)
+		}
+	}
+	if last := (*intervals)[len(*intervals)-1]; last < time.Duration(float64(defaultMaxInterval)*0.8) {
+		t.Errorf("last interval = %s, want about %s after backing off", last, defaultMaxInterval)
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	pending := []*client.Step{newStep("a", "pending", "")}
+	running := []*client.Step{newStep("a", "inProgress", ""), newStep("b", "pending", "")}
+	complete := []*client.Step{newStep("a", "complete", "success"), newStep("b", "pending", "")}
+
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		maxInterval time.Duration
+		steps       []*client.Step
+		want        time.Duration
+	}{
+		{name: "first poll", interval: 0, maxInterval: defaultMaxInterval, steps: running, want: minInterval},
+		{name: "validating", interval: 8 * time.Second, maxInterval: defaultMaxInterval, steps: nil, want: minInterval},
+		{name: "pending", interval: 8 * time.Second, maxInterval: defaultMaxInterval, steps: pending, want: minInterval},
+		{name: "running", interval: 2 * time.Second, maxInterval: defaultMaxInterval, steps: running, want: 3 * time.Second},
+		{name: "a device completed", interval: 4 * time.Second, maxInterval: defaultMaxInterval, steps: complete, want: 6 * time.Second},
+		{name: "limited", interval: 24 * time.Second, maxInterval: defaultMaxInterval, steps: running, want: defaultMaxInterval},
+		{name: "limited while streaming the logcat", interval: 4 * time.Second, maxInterval: logcatMaxInterval, steps: running, want: logcatMaxInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextInterval(tt.interval, tt.maxInterval, tt.steps); got != tt.want {
+				t.Errorf("nextInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if got := jitter(10 * time.Second); got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("jitter(10s) = %s, want between 8s and 12s", got)
+		}
+	}
+}
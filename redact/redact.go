@@ -0,0 +1,57 @@
+package redact
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces the secrets in every printed message.
+const Placeholder = "[REDACTED]"
+
+var secretValues []string
+
+// AddSecret registers a value (and its URL encoded forms) to be redacted from the step's output.
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	for _, value := range []string{secret, url.PathEscape(secret), url.QueryEscape(secret)} {
+		isRegistered := false
+		for _, registered := range secretValues {
+			if registered == value {
+				isRegistered = true
+				break
+			}
+		}
+		if !isRegistered {
+			secretValues = append(secretValues, value)
+		}
+	}
+}
+
+// String replaces the registered secrets in s.
+func String(s string) string {
+	for _, secret := range secretValues {
+		s = strings.Replace(s, secret, Placeholder, -1)
+	}
+	return s
+}
+
+// Writer redacts the registered secrets from everything written through it.
+type Writer struct {
+	writer io.Writer
+}
+
+// NewWriter ...
+func NewWriter(writer io.Writer) Writer {
+	return Writer{writer: writer}
+}
+
+// Write ...
+func (w Writer) Write(p []byte) (int, error) {
+	if _, err := w.writer.Write([]byte(String(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
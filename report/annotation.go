@@ -0,0 +1,79 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// the contexts of the annotations, a later annotation in the same context replaces the previous one
+const (
+	failuresAnnotationContext = "vdtesting-failures"
+	warningsAnnotationContext = "vdtesting-warnings"
+)
+
+// maxAnnotatedTests is the number of failed tests listed per device, the rest is only counted.
+const maxAnnotatedTests = 10
+
+// Annotation is a Markdown message shown in the summary of the build.
+type Annotation struct {
+	Style   string
+	Context string
+	Body    string
+}
+
+// CreateAnnotations creates an error annotation for the failed devices, listing their failed tests,
+// and a warning annotation for the skipped and inconclusive devices.
+func CreateAnnotations(steps []*client.Step, failedTests map[*client.Step][]string) []Annotation {
+	var failures, warnings []string
+	for _, step := range steps {
+		line := fmt.Sprintf("- **%s**: %s", DeviceName(step), OutcomeWithDetails(step))
+
+		switch OutcomeSummary(step) {
+		case "failure":
+			tests := failedTests[step]
+			for i, test := range tests {
+				if i == maxAnnotatedTests {
+					line += fmt.Sprintf("\n  - ... and %d more", len(tests)-maxAnnotatedTests)
+					break
+				}
+				line += fmt.Sprintf("\n  - `%s`", test)
+			}
+			failures = append(failures, line)
+		case "skipped", "inconclusive":
+			warnings = append(warnings, line)
+		default:
+			if summary := OutcomeSummary(step); summary != "" && !IsKnownOutcome(summary) {
+				warnings = append(warnings, line)
+			}
+		}
+	}
+
+	var annotations []Annotation
+	if len(failures) > 0 {
+		annotations = append(annotations, Annotation{
+			Style:   "error",
+			Context: failuresAnnotationContext,
+			Body:    fmt.Sprintf("### Virtual device tests failed on %d device(s)\n\n%s", len(failures), strings.Join(failures, "\n")),
+		})
+	}
+	if len(warnings) > 0 {
+		annotations = append(annotations, Annotation{
+			Style:   "warning",
+			Context: warningsAnnotationContext,
+			Body:    fmt.Sprintf("### Virtual device tests were skipped or inconclusive on %d device(s)\n\n%s", len(warnings), strings.Join(warnings, "\n")),
+		})
+	}
+	return annotations
+}
+
+// PostAnnotation adds the annotation to the build with the Bitrise CLI.
+func PostAnnotation(annotation Annotation) error {
+	cmd := command.New("bitrise", ":annotate", "--style", annotation.Style, "--context", annotation.Context, annotation.Body)
+	if output, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to annotate the build, error: %s, output: %s", err, output)
+	}
+	return nil
+}
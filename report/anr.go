@@ -0,0 +1,51 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ANR is an "Application Not Responding" entry of the logcat,
+// with the stack of the main thread from the ANR traces, if the traces were pulled.
+type ANR struct {
+	Entry      []string
+	MainThread []string
+}
+
+// ExtractANR returns the lines of the first "ANR in" entry of the logcat, nil if the app was responding.
+func ExtractANR(logcat string) []string {
+	return extractLogcatEntry(logcat, "ANR in ")
+}
+
+// ExtractMainThread returns the stack of the main thread from an ANR traces dump,
+// from the `"main"` thread header until the first empty line, nil if the dump has no main thread.
+func ExtractMainThread(traces string) []string {
+	lines := strings.Split(traces, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, `"main"`) {
+			continue
+		}
+
+		stack := []string{}
+		for _, stackLine := range lines[i:] {
+			stackLine = strings.TrimRight(stackLine, "\r")
+			if strings.TrimSpace(stackLine) == "" {
+				break
+			}
+			stack = append(stack, stackLine)
+		}
+		return stack
+	}
+	return nil
+}
+
+// PrintANR prints the ANR of a device, followed by the stack of its main thread.
+func PrintANR(out io.Writer, deviceName string, anr ANR) {
+	fmt.Fprintf(out, "%s: the app was not responding\n", deviceName)
+	printIndented(out, anr.Entry)
+	if len(anr.MainThread) > 0 {
+		fmt.Fprintf(out, "  Main thread:\n")
+		printIndented(out, anr.MainThread)
+	}
+}
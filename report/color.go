@@ -0,0 +1,17 @@
+package report
+
+import "github.com/bitrise-io/go-utils/colorstring"
+
+var colorsDisabled bool
+
+// DisableColors prints the outcomes without ANSI color escape sequences.
+func DisableColors() {
+	colorsDisabled = true
+}
+
+func colorize(color colorstring.ColorFunc, s string) string {
+	if colorsDisabled {
+		return s
+	}
+	return color(s)
+}
@@ -0,0 +1,55 @@
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// ConsoleURL returns the Firebase console page of the step, with the videos, logs and test cases of the device.
+// It returns an empty string if the backend does not expose the Tool Results IDs of the step.
+func ConsoleURL(step *client.Step) string {
+	ids := step.ToolResultsStep
+	if ids == nil || ids.ProjectID == "" || ids.HistoryID == "" || ids.ExecutionID == "" || ids.StepID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://console.firebase.google.com/project/%s/testlab/histories/%s/matrices/%s/executions/%s", ids.ProjectID, ids.HistoryID, ids.ExecutionID, ids.StepID)
+}
+
+// MatrixConsoleURL returns the Firebase console page of the test matrix of the steps, with the results of every device.
+// It returns an empty string if the backend does not expose the Tool Results IDs of the steps,
+// or if the steps belong to multiple test matrices, like the reruns of the failed devices.
+func MatrixConsoleURL(steps []*client.Step) string {
+	url := ""
+	for _, step := range steps {
+		ids := step.ToolResultsStep
+		if ids == nil || ids.ProjectID == "" || ids.HistoryID == "" || ids.ExecutionID == "" {
+			return ""
+		}
+		matrixURL := fmt.Sprintf("https://console.firebase.google.com/project/%s/testlab/histories/%s/matrices/%s", ids.ProjectID, ids.HistoryID, ids.ExecutionID)
+		if url != "" && matrixURL != url {
+			return ""
+		}
+		url = matrixURL
+	}
+	return url
+}
+
+// PrintConsoleURLs prints the Firebase console page of every device, if any is known.
+func PrintConsoleURLs(out io.Writer, steps []*client.Step) {
+	printed := false
+	for _, step := range steps {
+		url := ConsoleURL(step)
+		if url == "" {
+			continue
+		}
+
+		if !printed {
+			fmt.Fprintln(out)
+			fmt.Fprintln(out, "Firebase console:")
+			printed = true
+		}
+		fmt.Fprintf(out, "- %s: %s\n", DeviceName(step), url)
+	}
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"math"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// DefaultTestTimeout is the test timeout applied by the backend if none is set.
+const DefaultTestTimeout = 15 * time.Minute
+
+// EstimatedMinutes returns the maximum device minutes a matrix can use: every device runs until the test timeout.
+func EstimatedMinutes(devices int, testTimeout time.Duration) int {
+	if testTimeout == 0 {
+		testTimeout = DefaultTestTimeout
+	}
+	return devices * billedMinutes(testTimeout)
+}
+
+// BilledMinutes returns the device minutes used by the steps, every device is billed by the started minute.
+func BilledMinutes(steps []*client.Step) int {
+	minutes := 0
+	for _, step := range steps {
+		minutes += billedMinutes(StepDuration(step))
+	}
+	return minutes
+}
+
+func billedMinutes(duration time.Duration) int {
+	return int(math.Ceil(duration.Minutes()))
+}
+
+// RunDuration returns the time the devices of a test matrix ran: the wall-clock time of the steps,
+// or the duration of the longest running device if the steps have no timestamps.
+func RunDuration(steps []*client.Step) time.Duration {
+	if wallClock := WallClockDuration(steps); wallClock > 0 {
+		return wallClock
+	}
+	var longest time.Duration
+	for _, step := range steps {
+		if duration := StepDuration(step); duration > longest {
+			longest = duration
+		}
+	}
+	return longest
+}
+
+// WallClockDuration returns the time from the creation of the first step to the completion of the last one,
+// 0 if the steps have no timestamps.
+func WallClockDuration(steps []*client.Step) time.Duration {
+	var start, end time.Time
+	for _, step := range steps {
+		if step.CreationTime != nil {
+			created := time.Unix(step.CreationTime.Seconds, step.CreationTime.Nanos)
+			if start.IsZero() || created.Before(start) {
+				start = created
+			}
+		}
+		if step.CompletionTime != nil {
+			completed := time.Unix(step.CompletionTime.Seconds, step.CompletionTime.Nanos)
+			if completed.After(end) {
+				end = completed
+			}
+		}
+	}
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
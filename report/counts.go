@@ -0,0 +1,78 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// Counts holds the number of test cases of a step by result.
+type Counts struct {
+	Total   int
+	Failed  int
+	Errors  int
+	Skipped int
+	Flaky   int
+}
+
+// TestCounts sums up the test suite overviews of the step.
+func TestCounts(step *client.Step) Counts {
+	counts := Counts{}
+	if step.TestExecution == nil {
+		return counts
+	}
+
+	for _, overview := range step.TestExecution.TestSuiteOverviews {
+		counts.Total += overview.TotalCount
+		counts.Failed += overview.FailureCount
+		counts.Errors += overview.ErrorCount
+		counts.Skipped += overview.SkippedCount
+		counts.Flaky += overview.FlakyCount
+	}
+	return counts
+}
+
+// TotalTestCounts sums up the test counts of every step.
+func TotalTestCounts(steps []*client.Step) Counts {
+	total := Counts{}
+	for _, step := range steps {
+		counts := TestCounts(step)
+		total.Total += counts.Total
+		total.Failed += counts.Failed
+		total.Errors += counts.Errors
+		total.Skipped += counts.Skipped
+		total.Flaky += counts.Flaky
+	}
+	return total
+}
+
+// Passed returns the number of test cases which neither failed, errored nor were skipped.
+func (counts Counts) Passed() int {
+	if passed := counts.Total - counts.Failed - counts.Errors - counts.Skipped; passed > 0 {
+		return passed
+	}
+	return 0
+}
+
+// String returns the counts in a short, human readable form, like: `42 tests, 2 failed, 1 skipped`.
+func (counts Counts) String() string {
+	if counts.Total == 0 {
+		return "-"
+	}
+
+	parts := []string{fmt.Sprintf("%d tests", counts.Total)}
+	if counts.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", counts.Failed))
+	}
+	if counts.Errors > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", counts.Errors))
+	}
+	if counts.Skipped > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", counts.Skipped))
+	}
+	if counts.Flaky > 0 {
+		parts = append(parts, fmt.Sprintf("%d flaky", counts.Flaky))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,87 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// crashLines is the maximum number of printed lines of a crash.
+const crashLines = 40
+
+// logcatTagPattern matches the priority and the tag of a logcat line in the threadtime or brief format,
+// e.g. `01-01 12:00:00.000  1234  1234 E AndroidRuntime: ...` or `E/AndroidRuntime( 1234): ...`.
+var logcatTagPattern = regexp.MustCompile(`(?:^|\s)([VDIWEF])[ /]([^:(]*?)\s*(?:\(\s*\d+\))?:`)
+
+// Crashed returns true if the step failed because the app crashed.
+func Crashed(step *client.Step) bool {
+	if step.Outcome == nil || step.Outcome.FailureDetail == nil {
+		return false
+	}
+	return step.Outcome.FailureDetail.Crashed || step.Outcome.FailureDetail.OtherNativeCrash
+}
+
+// ExtractCrash returns the lines of the first fatal exception or native crash tombstone of the logcat,
+// nil if the logcat has none.
+func ExtractCrash(logcat string) []string {
+	return extractLogcatEntry(logcat, "FATAL EXCEPTION", "*** *** *** *** ***")
+}
+
+// extractLogcatEntry returns the lines of the first entry of the logcat containing any of the markers,
+// nil if the logcat has none. The entry is followed until the tag of the lines changes.
+func extractLogcatEntry(logcat string, markers ...string) []string {
+	lines := strings.Split(logcat, "\n")
+	for i, line := range lines {
+		if !containsAny(line, markers) {
+			continue
+		}
+
+		tag := logcatTag(line)
+		entry := []string{}
+		for _, entryLine := range lines[i:] {
+			entryLine = strings.TrimRight(entryLine, "\r")
+			if logcatTag(entryLine) != tag {
+				break
+			}
+			entry = append(entry, entryLine)
+		}
+		return entry
+	}
+	return nil
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func logcatTag(line string) string {
+	match := logcatTagPattern.FindStringSubmatch(line)
+	if match == nil {
+		return ""
+	}
+	return match[2]
+}
+
+// PrintCrash prints the crash of a device, truncated to its first lines.
+func PrintCrash(out io.Writer, deviceName string, crash []string) {
+	fmt.Fprintf(out, "%s:\n", deviceName)
+	printIndented(out, crash)
+}
+
+func printIndented(out io.Writer, lines []string) {
+	lines = deobfuscate(lines)
+	if len(lines) > crashLines {
+		lines = append(lines[:crashLines:crashLines], "...")
+	}
+	for _, line := range lines {
+		fmt.Fprintf(out, "  %s\n", line)
+	}
+}
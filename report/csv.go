@@ -0,0 +1,55 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// ExportCSV writes the per-device results into dir/results.csv and returns its path.
+func ExportCSV(steps []*client.Step, dir string) (string, error) {
+	pth := filepath.Join(dir, "results.csv")
+
+	f, err := os.Create(pth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"model", "api", "locale", "orientation", "outcome", "failure_flags", "duration_seconds"}); err != nil {
+		return "", fmt.Errorf("Failed to write CSV header, error: %s", err)
+	}
+	for _, step := range steps {
+		dimensions := StepDimensions(step)
+
+		record := []string{
+			dimensions["Model"],
+			dimensions["Version"],
+			dimensions["Locale"],
+			dimensions["Orientation"],
+			OutcomeSummary(step),
+			strings.Join(OutcomeDetails(step.Outcome), "|"),
+			strconv.FormatInt(int64(StepDuration(step).Seconds()), 10),
+		}
+		if err := w.Write(record); err != nil {
+			return "", fmt.Errorf("Failed to write CSV record, error: %s", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("Failed to flush CSV writer, error: %s", err)
+	}
+
+	return pth, nil
+}
@@ -0,0 +1,135 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// PassRate counts the runs and the passes of a test case across the iterations of a deflake run, on every device.
+type PassRate struct {
+	Test   string
+	Runs   int
+	Passed int
+}
+
+// Rate returns the ratio of the passed runs, between 0 and 1.
+func (rate PassRate) Rate() float64 {
+	if rate.Runs == 0 {
+		return 0
+	}
+	return float64(rate.Passed) / float64(rate.Runs)
+}
+
+// Stable returns true if the test case passed in every run.
+func (rate PassRate) Stable() bool {
+	return rate.Runs > 0 && rate.Passed == rate.Runs
+}
+
+// PassRates collects the pass rates of the test cases, keyed by `ClassName#name`.
+type PassRates map[string]*PassRate
+
+// Add counts a run of the test cases, skipped test cases are not counted.
+func (rates PassRates) Add(testCases []TestCase) {
+	for _, testCase := range testCases {
+		if testCase.Skipped != nil {
+			continue
+		}
+		rate, ok := rates[testCase.ID()]
+		if !ok {
+			rate = &PassRate{Test: testCase.ID()}
+			rates[testCase.ID()] = rate
+		}
+		rate.Runs++
+		if !testCase.Failed() {
+			rate.Passed++
+		}
+	}
+}
+
+// Sorted returns the pass rates, the least stable test cases first.
+func (rates PassRates) Sorted() []PassRate {
+	sorted := []PassRate{}
+	for _, rate := range rates {
+		sorted = append(sorted, *rate)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Rate() != sorted[j].Rate() {
+			return sorted[i].Rate() < sorted[j].Rate()
+		}
+		return sorted[i].Test < sorted[j].Test
+	})
+	return sorted
+}
+
+// Unstable returns the number of test cases which failed in any run.
+func (rates PassRates) Unstable() int {
+	unstable := 0
+	for _, rate := range rates {
+		if !rate.Stable() {
+			unstable++
+		}
+	}
+	return unstable
+}
+
+// PrintPassRates prints the pass rates in a table, the least stable test cases first.
+func PrintPassRates(out io.Writer, rates PassRates) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "Test\tPassed\tPass rate\t")
+	for _, rate := range rates.Sorted() {
+		passRate := fmt.Sprintf("%.0f%%", rate.Rate()*100)
+		if rate.Stable() {
+			passRate = colorize(colorstring.Green, passRate)
+		} else {
+			passRate = colorize(colorstring.Red, passRate)
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%s\t\n", rate.Test, rate.Passed, rate.Runs, passRate)
+	}
+	return w.Flush()
+}
+
+// ExportPassRatesCSV writes the pass rates into dir/pass_rates.csv and returns its path.
+func ExportPassRatesCSV(rates PassRates, dir string) (string, error) {
+	pth := filepath.Join(dir, "pass_rates.csv")
+
+	f, err := os.Create(pth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"test", "runs", "passed", "pass_rate"}); err != nil {
+		return "", fmt.Errorf("Failed to write CSV header, error: %s", err)
+	}
+	for _, rate := range rates.Sorted() {
+		record := []string{
+			rate.Test,
+			strconv.Itoa(rate.Runs),
+			strconv.Itoa(rate.Passed),
+			strconv.FormatFloat(rate.Rate(), 'f', 4, 64),
+		}
+		if err := w.Write(record); err != nil {
+			return "", fmt.Errorf("Failed to write CSV record, error: %s", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("Failed to flush CSV writer, error: %s", err)
+	}
+
+	return pth, nil
+}
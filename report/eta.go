@@ -0,0 +1,121 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+const etaPrintInterval = time.Minute
+
+// ETA estimates the remaining time of the test matrix from the durations of the already completed devices.
+type ETA struct {
+	// TestTimeout is used as an upper bound while no device completed yet.
+	TestTimeout time.Duration
+
+	startTimes  map[string]time.Time
+	lastPrinted time.Time
+}
+
+// Estimate returns the estimated remaining time and the device expected to finish last.
+// The returned bool is false if the estimate is only an upper bound based on the test timeout.
+func (e *ETA) Estimate(steps []*client.Step, now time.Time) (time.Duration, string, bool) {
+	if e.startTimes == nil {
+		e.startTimes = map[string]time.Time{}
+	}
+
+	var allDurations []time.Duration
+	modelDurations := map[string][]time.Duration{}
+	for _, step := range steps {
+		if !IsComplete(step) {
+			continue
+		}
+		if duration := StepDuration(step); duration > 0 {
+			model := StepDimensions(step)["Model"]
+			allDurations = append(allDurations, duration)
+			modelDurations[model] = append(modelDurations[model], duration)
+		}
+	}
+
+	var remaining time.Duration
+	longest := ""
+	for _, step := range steps {
+		if IsComplete(step) {
+			continue
+		}
+
+		name := DeviceName(step)
+
+		var elapsed time.Duration
+		if step.State == "inProgress" {
+			start, ok := e.startTimes[name]
+			if !ok {
+				start = now
+				if step.CreationTime != nil {
+					start = time.Unix(step.CreationTime.Seconds, step.CreationTime.Nanos)
+				}
+				e.startTimes[name] = start
+			}
+			elapsed = now.Sub(start)
+		}
+
+		expected := e.TestTimeout
+		if durations := modelDurations[StepDimensions(step)["Model"]]; len(durations) > 0 {
+			expected = average(durations)
+		} else if len(allDurations) > 0 {
+			expected = average(allDurations)
+		}
+
+		stepRemaining := expected - elapsed
+		if stepRemaining < 0 {
+			stepRemaining = 0
+		}
+		if longest == "" || stepRemaining > remaining {
+			remaining = stepRemaining
+			longest = name
+		}
+	}
+
+	return remaining, longest, len(allDurations) > 0
+}
+
+// Update prints the estimated remaining time, at most once a minute.
+func (e *ETA) Update(out io.Writer, steps []*client.Step) {
+	now := time.Now()
+	if now.Sub(e.lastPrinted) < etaPrintInterval {
+		return
+	}
+
+	remaining, longest, ok := e.Estimate(steps, now)
+	if longest == "" {
+		return
+	}
+	if !ok && e.TestTimeout == 0 {
+		return
+	}
+	e.lastPrinted = now
+
+	if ok {
+		fmt.Fprintf(out, "- ~%s remaining, longest device: %s\n", formatMinutes(remaining), longest)
+	} else {
+		fmt.Fprintf(out, "- at most ~%s remaining, longest device: %s\n", formatMinutes(remaining), longest)
+	}
+}
+
+func average(durations []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+	return sum / time.Duration(len(durations))
+}
+
+func formatMinutes(d time.Duration) string {
+	if d < time.Minute {
+		return "<1m"
+	}
+	return fmt.Sprintf("%dm", int(math.Ceil(d.Minutes())))
+}
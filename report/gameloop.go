@@ -0,0 +1,104 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+)
+
+var scenarioFilePattern = regexp.MustCompile(`^results_scenario_(\d+)\.json$`)
+
+// ScenarioResult is the result of a game loop scenario, as written by the game into `results_scenario_<scenario>.json`.
+// The game is expected to write a JSON object, the `passed` (bool) and `score` (number) fields are recognized.
+type ScenarioResult struct {
+	Device   string   `json:"device"`
+	Scenario int      `json:"scenario"`
+	Passed   *bool    `json:"passed,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+}
+
+// ParseScenarioResult parses a game loop results file of the given device.
+func ParseScenarioResult(device, fileName string, data []byte) (ScenarioResult, error) {
+	match := scenarioFilePattern.FindStringSubmatch(path.Base(fileName))
+	if match == nil {
+		return ScenarioResult{}, fmt.Errorf("Unexpected game loop results file name: %s", fileName)
+	}
+	scenario, err := strconv.Atoi(match[1])
+	if err != nil {
+		return ScenarioResult{}, fmt.Errorf("Failed to parse scenario of %s, error: %s", fileName, err)
+	}
+
+	var content struct {
+		Passed *bool    `json:"passed"`
+		Score  *float64 `json:"score"`
+	}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return ScenarioResult{}, fmt.Errorf("Failed to parse %s, error: %s", fileName, err)
+	}
+
+	return ScenarioResult{
+		Device:   device,
+		Scenario: scenario,
+		Passed:   content.Passed,
+		Score:    content.Score,
+	}, nil
+}
+
+// SortScenarioResults orders the results by device, then by scenario.
+func SortScenarioResults(results []ScenarioResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Device != results[j].Device {
+			return results[i].Device < results[j].Device
+		}
+		return results[i].Scenario < results[j].Scenario
+	})
+}
+
+// PrintScenarioResults prints the per-scenario game loop results.
+func PrintScenarioResults(out io.Writer, results []ScenarioResult) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "Device\tScenario\tScore\tResult\t")
+
+	for _, result := range results {
+		score := "-"
+		if result.Score != nil {
+			score = strconv.FormatFloat(*result.Score, 'f', -1, 64)
+		}
+
+		outcome := "-"
+		if result.Passed != nil {
+			if *result.Passed {
+				outcome = colorize(colorstring.Green, "passed")
+			} else {
+				outcome = colorize(colorstring.Red, "failed")
+			}
+		}
+
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%d\t%s\t%s\t", result.Device, result.Scenario, score, outcome))
+	}
+
+	return w.Flush()
+}
+
+// ExportScenarioResults writes the game loop results into dir/gameloop_results.json and returns its path.
+func ExportScenarioResults(results []ScenarioResult, dir string) (string, error) {
+	pth := filepath.Join(dir, "gameloop_results.json")
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialize game loop results, error: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, data, 0644); err != nil {
+		return "", fmt.Errorf("Failed to write file (%s), error: %s", pth, err)
+	}
+	return pth, nil
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// Heartbeat prints the state of the test matrix periodically, so the log does not go silent while waiting.
+type Heartbeat struct {
+	// Interval between the heartbeats, 0 disables them.
+	Interval time.Duration
+
+	started     time.Time
+	lastPrinted time.Time
+	startTimes  map[string]time.Time
+}
+
+// Update prints the number of completed devices, the elapsed time and the longest running device, at most once an Interval.
+func (h *Heartbeat) Update(out io.Writer, steps []*client.Step, now time.Time) {
+	if h.startTimes == nil {
+		h.started = now
+		h.lastPrinted = now
+		h.startTimes = map[string]time.Time{}
+	}
+
+	completed := 0
+	longest, longestElapsed := "", time.Duration(0)
+	for _, step := range steps {
+		switch {
+		case IsComplete(step):
+			completed++
+		case step.State == "inProgress":
+			name := DeviceName(step)
+			start, ok := h.startTimes[name]
+			if !ok {
+				start = now
+				if step.CreationTime != nil {
+					start = time.Unix(step.CreationTime.Seconds, step.CreationTime.Nanos)
+				}
+				h.startTimes[name] = start
+			}
+			if elapsed := now.Sub(start); longest == "" || elapsed > longestElapsed {
+				longest, longestElapsed = name, elapsed
+			}
+		}
+	}
+
+	if h.Interval == 0 || now.Sub(h.lastPrinted) < h.Interval {
+		return
+	}
+	h.lastPrinted = now
+
+	message := fmt.Sprintf("- still running: %d/%d devices completed, elapsed %s", completed, len(steps), now.Sub(h.started).Round(time.Second))
+	if longest != "" {
+		message += fmt.Sprintf(", longest running device: %s at %s", longest, longestElapsed.Round(time.Second))
+	}
+	fmt.Fprintln(out, message)
+}
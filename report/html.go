@@ -0,0 +1,59 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+var screenshotsTemplate = template.Must(template.New("screenshots").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Virtual Device Testing screenshots</title>
+<style>
+body { font-family: sans-serif; }
+img { max-height: 480px; margin: 4px; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.Device}}</h2>
+<div>{{$device := .Device}}{{range .Screenshots}}<a href="{{$device}}/{{.}}"><img src="{{$device}}/{{.}}" alt="{{.}}"></a>{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+type deviceScreenshots struct {
+	Device      string
+	Screenshots []string
+}
+
+// WriteScreenshotsHTML writes an index.html into dir, which shows the screenshots of the per-device subdirectories.
+func WriteScreenshotsHTML(dir string, screenshots map[string][]string) (string, error) {
+	pth := filepath.Join(dir, "index.html")
+
+	var devices []deviceScreenshots
+	for device, names := range screenshots {
+		devices = append(devices, deviceScreenshots{Device: device, Screenshots: names})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+
+	f, err := os.Create(pth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	if err := screenshotsTemplate.Execute(f, devices); err != nil {
+		return "", fmt.Errorf("Failed to write HTML report, error: %s", err)
+	}
+	return pth, nil
+}
@@ -0,0 +1,47 @@
+package report
+
+import "fmt"
+
+// invalidMatrixReasons explains the invalidMatrixDetails values of Test Lab, with a hint on how to fix them.
+var invalidMatrixReasons = map[string]string{
+	"MALFORMED_APK":        "The app APK could not be parsed, make sure apk_path points to a valid APK.",
+	"MALFORMED_TEST_APK":   "The test APK could not be parsed, make sure test_apk_path points to a valid APK.",
+	"NO_MANIFEST":          "The AndroidManifest.xml could not be found in the APK.",
+	"NO_PACKAGE_NAME":      "The APK manifest does not declare a package name.",
+	"INVALID_PACKAGE_NAME": "The package name of the APK is invalid (it must not start with a dot, and it must contain at least one dot).",
+	"TEST_SAME_AS_APP":     "The test package and the app package are the same, make sure test_apk_path points to the test APK (for example: app-debug-androidTest.apk).",
+	"NO_INSTRUMENTATION":   "The test APK does not declare an instrumentation, make sure test_apk_path points to the androidTest APK.",
+	"NO_SIGNATURE":         "The APK is not signed, sign it (the debug builds are signed with the debug key by default).",
+	"INSTRUMENTATION_ORCHESTRATOR_INCOMPATIBLE": "The test runner class does not support the Android Test Orchestrator, use AndroidJUnitRunner 1.0 or later.",
+	"NO_TEST_RUNNER_CLASS":                      "The test APK does not contain the test runner class, check inst_test_runner_class and the testInstrumentationRunner of the Gradle build.",
+	"NO_LAUNCHER_ACTIVITY":                      "The app does not have a launcher activity (an activity with a MAIN/LAUNCHER intent filter), which is required by the Robo test.",
+	"FORBIDDEN_PERMISSIONS":                     "The app declares permissions which are not allowed in Test Lab.",
+	"INVALID_ROBO_DIRECTIVES":                   "The Robo directives are invalid, check robo_directives for conflicting or duplicated resource names.",
+	"INVALID_RESOURCE_NAME":                     "A resource name in robo_directives is invalid, use the resource ID names (like: username_input) of the app.",
+	"INVALID_DIRECTIVE_ACTION":                  "A Robo directive has an unsupported action type, use: SINGLE_CLICK, ENTER_TEXT or IGNORE.",
+	"TEST_LOOP_INTENT_FILTER_NOT_FOUND":         "The app does not declare the com.google.intent.action.TEST_LOOP intent filter, which is required by the game loop test.",
+	"SCENARIO_LABEL_NOT_DECLARED":               "A label in loop_scenario_labels is not declared in the app manifest.",
+	"SCENARIO_LABEL_MALFORMED":                  "A scenario label in the app manifest is malformed.",
+	"SCENARIO_NOT_DECLARED":                     "A scenario in loop_scenarios is not declared in the app manifest.",
+	"DEVICE_ADMIN_RECEIVER":                     "Device administrator apps are not allowed in Test Lab.",
+	"TEST_ONLY_APK":                             "The APK is marked as testOnly, build it without the testOnly flag (Android Studio's Run sets it, Gradle's assemble tasks do not).",
+	"NO_CODE_APK":                               "The APK does not contain code, make sure hasCode is not set to false in the manifest.",
+	"INVALID_INPUT_APK":                         "The APK is not a valid input, make sure apk_path points to an installable APK.",
+	"INVALID_APK_PREVIEW_SDK":                   "The APK is built for a preview SDK, which is not supported by Test Lab.",
+	"MATRIX_TOO_LARGE":                          "The test matrix is too large, reduce the number of devices or shards.",
+	"TEST_QUOTA_EXCEEDED":                       "The test quota is exceeded, wait for it to refill or upgrade the plan.",
+	"SERVICE_NOT_ACTIVATED":                     "A required cloud service API is not activated in the project, enable the Cloud Testing API and the Cloud Tool Results API.",
+	"UNKNOWN_PERMISSION_ERROR":                  "The test could not be started due to a permission error, check the roles of the service account.",
+	"DETAILS_UNAVAILABLE":                       "Test Lab did not report further details.",
+}
+
+// InvalidMatrixMessage returns an actionable error message of the invalidMatrixDetails reported by Test Lab.
+func InvalidMatrixMessage(details string) string {
+	if details == "" {
+		details = "DETAILS_UNAVAILABLE"
+	}
+	if reason, ok := invalidMatrixReasons[details]; ok {
+		return fmt.Sprintf("The test matrix is invalid (%s): %s", details, reason)
+	}
+	return fmt.Sprintf("The test matrix is invalid (%s)", details)
+}
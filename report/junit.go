@@ -0,0 +1,106 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const stackTraceLines = 5
+
+// TestCase is a test case result parsed from a JUnit XML report.
+type TestCase struct {
+	ClassName string `xml:"classname,attr"`
+	Name      string `xml:"name,attr"`
+	Failure   *struct {
+		Message string `xml:"message,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"failure"`
+	Error *struct {
+		Message string `xml:"message,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"error"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+type junitTestSuite struct {
+	TestCases []TestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+// Failed returns true if the test case failed or errored.
+func (testCase TestCase) Failed() bool {
+	return testCase.Failure != nil || testCase.Error != nil
+}
+
+// ID returns the identifier of the test case, like: `com.example.MainTest#testLogin`.
+func (testCase TestCase) ID() string {
+	return testCase.ClassName + "#" + testCase.Name
+}
+
+// StackTrace returns the failure message or stack trace of a failed test case.
+func (testCase TestCase) StackTrace() string {
+	switch {
+	case testCase.Failure != nil:
+		if content := strings.TrimSpace(testCase.Failure.Content); content != "" {
+			return content
+		}
+		return testCase.Failure.Message
+	case testCase.Error != nil:
+		if content := strings.TrimSpace(testCase.Error.Content); content != "" {
+			return content
+		}
+		return testCase.Error.Message
+	}
+	return ""
+}
+
+// ParseJUnit parses the test cases of a JUnit XML report, the root element can be either `testsuites` or `testsuite`.
+func ParseJUnit(data []byte) ([]TestCase, error) {
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		return nil, fmt.Errorf("Failed to parse JUnit XML, error: %s", err)
+	}
+	if suites.TestSuites != nil {
+		var testCases []TestCase
+		for _, suite := range suites.TestSuites {
+			testCases = append(testCases, suite.TestCases...)
+		}
+		return testCases, nil
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("Failed to parse JUnit XML, error: %s", err)
+	}
+	return suite.TestCases, nil
+}
+
+// PrintFailedTests prints the failed test cases of a device with the first lines of their stack traces.
+func PrintFailedTests(out io.Writer, deviceName string, testCases []TestCase) {
+	printedHeader := false
+	for _, testCase := range testCases {
+		if !testCase.Failed() {
+			continue
+		}
+
+		if !printedHeader {
+			fmt.Fprintf(out, "%s:\n", deviceName)
+			printedHeader = true
+		}
+
+		fmt.Fprintf(out, "  %s\n", testCase.ID())
+
+		lines := deobfuscate(strings.Split(testCase.StackTrace(), "\n"))
+		if len(lines) > stackTraceLines {
+			lines = append(lines[:stackTraceLines], "...")
+		}
+		for _, line := range lines {
+			fmt.Fprintf(out, "    %s\n", strings.TrimRight(line, "\r"))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package report
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+)
+
+// DefaultTableWidth is the width of the tables if the width of the terminal is unknown,
+// it fits into the Bitrise web log viewer without horizontal scrolling.
+const DefaultTableWidth = 120
+
+// columnPadding is the number of spaces between the columns.
+const columnPadding = 3
+
+// minColumnWidth is the width a column is not narrowed below, unless its header is wider,
+// so the usual model names, locales and outcomes stay readable.
+const minColumnWidth = 12
+
+var tableWidth = DefaultTableWidth
+
+// SetTableWidth sets the maximum width of the tables, the long cells are truncated,
+// the last column is wrapped into multiple lines. 0 disables the limit.
+func SetTableWidth(width int) {
+	tableWidth = width
+}
+
+// table lays out rows of plain text cells, the colors of the last column are applied after the layout,
+// so the color escape sequences do not count into the widths.
+type table struct {
+	indent string
+	header []string
+	rows   [][]string
+	colors []colorstring.ColorFunc
+	// separators are the indexes of the rows preceded by a separator line
+	separators map[int]bool
+}
+
+func (t *table) addRow(color colorstring.ColorFunc, cells ...string) {
+	t.rows = append(t.rows, cells)
+	t.colors = append(t.colors, color)
+}
+
+func (t *table) addSeparator() {
+	if t.separators == nil {
+		t.separators = map[int]bool{}
+	}
+	t.separators[len(t.rows)] = true
+}
+
+func (t table) lines() []string {
+	widths := t.columnWidths()
+
+	lines := t.formatRow(t.header, widths, nil)
+	for i, row := range t.rows {
+		if t.separators[i] && i > 0 {
+			width := -columnPadding
+			for _, w := range widths {
+				width += w + columnPadding
+			}
+			lines = append(lines, t.indent+strings.Repeat("-", width))
+		}
+		lines = append(lines, t.formatRow(row, widths, t.colors[i])...)
+	}
+	return lines
+}
+
+// columnWidths returns the widths of the widest cells of the columns, shrunk to fit into the table width:
+// the widest column is narrowed first, but not below the width of its header or minColumnWidth.
+func (t table) columnWidths() []int {
+	widths := make([]int, len(t.header))
+	minWidths := make([]int, len(t.header))
+	for i, cell := range t.header {
+		widths[i] = utf8.RuneCountInString(cell)
+		minWidths[i] = widths[i]
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if width := utf8.RuneCountInString(cell); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	for i, width := range widths {
+		if minWidths[i] < minColumnWidth {
+			minWidths[i] = minColumnWidth
+		}
+		if minWidths[i] > width {
+			minWidths[i] = width
+		}
+	}
+
+	if tableWidth <= 0 {
+		return widths
+	}
+
+	total := utf8.RuneCountInString(t.indent) + columnPadding*(len(widths)-1)
+	for _, width := range widths {
+		total += width
+	}
+	for total > tableWidth {
+		widest := -1
+		for i, width := range widths {
+			if width > minWidths[i] && (widest == -1 || width > widths[widest]) {
+				widest = i
+			}
+		}
+		if widest == -1 {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// formatRow returns the lines of a row: the cells are truncated to the width of their column,
+// except the last one, which is wrapped into the following lines.
+func (t table) formatRow(cells []string, widths []int, lastCellColor colorstring.ColorFunc) []string {
+	var line strings.Builder
+	line.WriteString(t.indent)
+	offset := utf8.RuneCountInString(t.indent)
+
+	last := len(cells) - 1
+	for i, cell := range cells[:last] {
+		cell = truncate(cell, widths[i])
+		line.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+columnPadding))
+		offset += widths[i] + columnPadding
+	}
+
+	lines := []string{}
+	for i, part := range wrap(cells[last], widths[last]) {
+		if lastCellColor != nil {
+			part = colorize(lastCellColor, part)
+		}
+		if i == 0 {
+			lines = append(lines, line.String()+part)
+		} else {
+			lines = append(lines, strings.Repeat(" ", offset)+part)
+		}
+	}
+	return lines
+}
+
+// truncate shortens the cell to width, marking the truncation with an ellipsis.
+func truncate(cell string, width int) string {
+	runes := []rune(cell)
+	if len(runes) <= width {
+		return cell
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// wrap splits the cell into lines of at most width runes, at the spaces or before the parentheses if possible.
+func wrap(cell string, width int) []string {
+	runes := []rune(cell)
+	if width < 1 || len(runes) <= width {
+		return []string{cell}
+	}
+
+	lines := []string{}
+	for len(runes) > width {
+		cut := width
+		for i := width; i > 0; i-- {
+			if runes[i] == ' ' || runes[i] == '(' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+	if len(runes) > 0 {
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
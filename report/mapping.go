@@ -0,0 +1,229 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Mapping is a ProGuard/R8 mapping file, used to deobfuscate the printed stack traces.
+type Mapping struct {
+	// classes are the original classes, keyed by their obfuscated name
+	classes map[string]*mappedClass
+}
+
+type mappedClass struct {
+	name       string
+	sourceFile string
+	methods    []mappedMethod
+}
+
+// mappedMethod is a method line of the mapping: `startLine:endLine:type name(args):originalStart:originalEnd -> obfuscated`.
+// The line ranges are 0 if the line is not mapped.
+type mappedMethod struct {
+	obfuscated    string
+	name          string
+	startLine     int
+	endLine       int
+	originalStart int
+	originalEnd   int
+}
+
+var (
+	mappingClassPattern      = regexp.MustCompile(`^(\S+) -> (\S+):$`)
+	mappingMethodPattern     = regexp.MustCompile(`^\s+(?:(\d+):(\d+):)?\S+ ([^\s(]+)\([^)]*\)(?::(\d+)(?::(\d+))?)? -> (\S+)$`)
+	mappingSourceFilePattern = regexp.MustCompile(`^\s*# \{.*"id":"sourceFile".*"fileName":"([^"]+)"`)
+	stackFramePattern        = regexp.MustCompile(`at ([\w$.]+)\.([\w$<>]+)\(([^)]*)\)`)
+	qualifiedNamePattern     = regexp.MustCompile(`[\w$]+(?:\.[\w$]+)+`)
+)
+
+var mapping *Mapping
+
+// SetMapping sets the mapping used to deobfuscate the printed stack traces, nil disables deobfuscation.
+func SetMapping(m *Mapping) {
+	mapping = m
+}
+
+// LoadMapping parses the ProGuard/R8 mapping file at pth.
+func LoadMapping(pth string) (*Mapping, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open mapping file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	m := &Mapping{classes: map[string]*mappedClass{}}
+	var class *mappedClass
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if match := mappingClassPattern.FindStringSubmatch(line); match != nil {
+			class = &mappedClass{name: match[1]}
+			m.classes[match[2]] = class
+			continue
+		}
+		if class == nil {
+			continue
+		}
+		if match := mappingSourceFilePattern.FindStringSubmatch(line); match != nil {
+			class.sourceFile = match[1]
+			continue
+		}
+		if match := mappingMethodPattern.FindStringSubmatch(line); match != nil {
+			method := mappedMethod{name: match[3], obfuscated: match[6]}
+			method.startLine, _ = strconv.Atoi(match[1])
+			method.endLine, _ = strconv.Atoi(match[2])
+			method.originalStart, _ = strconv.Atoi(match[4])
+			method.originalEnd, _ = strconv.Atoi(match[5])
+			class.methods = append(class.methods, method)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read mapping file (%s), error: %s", pth, err)
+	}
+	if len(m.classes) == 0 {
+		return nil, fmt.Errorf("Failed to parse mapping file (%s): no class mapping found", pth)
+	}
+	return m, nil
+}
+
+// Deobfuscate returns the line with the obfuscated stack frames and class names replaced by the original ones.
+// A frame of inlined methods is expanded into a frame per method.
+func (m *Mapping) Deobfuscate(line string) string {
+	if m == nil {
+		return line
+	}
+
+	if loc := stackFramePattern.FindStringSubmatchIndex(line); loc != nil {
+		className, methodName, location := line[loc[2]:loc[3]], line[loc[4]:loc[5]], line[loc[6]:loc[7]]
+		if frames := m.frames(className, methodName, location); frames != nil {
+			prefix, suffix := line[:loc[0]], line[loc[1]:]
+			for i := range frames {
+				frames[i] = prefix + "at " + frames[i] + suffix
+			}
+			return strings.Join(frames, "\n")
+		}
+	}
+
+	return qualifiedNamePattern.ReplaceAllStringFunc(line, func(name string) string {
+		if class, ok := m.classes[name]; ok {
+			return class.name
+		}
+		return name
+	})
+}
+
+// frames returns the original frames of an obfuscated frame, nil if the class is not in the mapping.
+func (m *Mapping) frames(className, methodName, location string) []string {
+	class, ok := m.classes[className]
+	if !ok {
+		return nil
+	}
+
+	line := 0
+	if i := strings.LastIndex(location, ":"); i != -1 {
+		line, _ = strconv.Atoi(location[i+1:])
+	}
+
+	var frames []string
+	for _, method := range class.methods {
+		if method.obfuscated != methodName {
+			continue
+		}
+		if line > 0 && method.startLine > 0 && (line < method.startLine || line > method.endLine) {
+			continue
+		}
+
+		originalClass, originalMethod := class.name, method.name
+		sourceFile := class.sourceFile
+		// the methods inlined from other classes are qualified with their class
+		if i := strings.LastIndex(method.name, "."); i != -1 {
+			originalClass, originalMethod = method.name[:i], method.name[i+1:]
+			sourceFile = ""
+			if inlinedClass := m.classByName(originalClass); inlinedClass != nil {
+				sourceFile = inlinedClass.sourceFile
+			}
+		}
+		if sourceFile == "" {
+			sourceFile = defaultSourceFile(originalClass)
+		}
+
+		originalLocation := sourceFile
+		if originalLine := method.originalLine(line); originalLine > 0 {
+			originalLocation += ":" + strconv.Itoa(originalLine)
+		}
+		frames = append(frames, fmt.Sprintf("%s.%s(%s)", originalClass, originalMethod, originalLocation))
+
+		// without line numbers the overloads can not be told apart
+		if line == 0 || method.startLine == 0 {
+			break
+		}
+	}
+	if frames == nil {
+		return []string{fmt.Sprintf("%s.%s(%s)", class.name, methodName, location)}
+	}
+	return frames
+}
+
+func (m *Mapping) classByName(name string) *mappedClass {
+	for _, class := range m.classes {
+		if class.name == name {
+			return class
+		}
+	}
+	return nil
+}
+
+// originalLine maps the obfuscated line of the method to the original one, 0 if unknown.
+func (method mappedMethod) originalLine(line int) int {
+	switch {
+	case line == 0:
+		return 0
+	case method.startLine == 0:
+		return line
+	case method.originalStart == 0:
+		return line
+	case method.originalEnd == 0 || method.originalEnd-method.originalStart != method.endLine-method.startLine:
+		// the range is mapped to a single line, like the call site of an inlined method
+		return method.originalStart
+	}
+	return method.originalStart + line - method.startLine
+}
+
+// defaultSourceFile returns the source file name of the top level class, like `Main.java` for `com.example.Main$Inner`.
+func defaultSourceFile(className string) string {
+	name := className
+	if i := strings.LastIndex(className, "."); i != -1 {
+		name = className[i+1:]
+	}
+	if i := strings.Index(name, "$"); i != -1 {
+		name = name[:i]
+	}
+	return name + ".java"
+}
+
+// deobfuscate deobfuscates the lines with the mapping set by SetMapping,
+// and symbolizes the native backtrace frames with the symbols set by SetNativeSymbols.
+func deobfuscate(lines []string) []string {
+	if mapping == nil && nativeSymbols == nil {
+		return lines
+	}
+
+	deobfuscated := []string{}
+	for _, line := range lines {
+		deobfuscated = append(deobfuscated, strings.Split(nativeSymbols.Symbolize(mapping.Deobfuscate(line)), "\n")...)
+	}
+	return deobfuscated
+}
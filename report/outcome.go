@@ -0,0 +1,240 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/matrix"
+)
+
+// Policy decides which outcomes fail the step.
+// If FailIf is set, it replaces the FailOn flags.
+type Policy struct {
+	FailOnSkipped      bool
+	FailOnInconclusive bool
+	FailOnFlaky        bool
+	FailIf             *Rule
+}
+
+// Result ...
+type Result struct {
+	Successful  bool
+	TestsFailed bool
+}
+
+// Evaluate ...
+func (policy Policy) Evaluate(steps []*client.Step) Result {
+	if policy.FailIf != nil {
+		return policy.evaluateRule(steps)
+	}
+
+	result := Result{Successful: true}
+	for _, step := range steps {
+		if step.Outcome == nil {
+			continue
+		}
+
+		switch step.Outcome.Summary {
+		case "failure":
+			result.Successful = false
+			result.TestsFailed = true
+		case "inconclusive":
+			if policy.FailOnInconclusive {
+				result.Successful = false
+			}
+		case "skipped":
+			if policy.FailOnSkipped {
+				result.Successful = false
+				result.TestsFailed = true
+			}
+		case "flaky":
+			if policy.FailOnFlaky {
+				result.Successful = false
+				result.TestsFailed = true
+			}
+		default:
+			// an outcome added to the API later can not be trusted as a pass
+			if !IsKnownOutcome(step.Outcome.Summary) && step.Outcome.Summary != "" && policy.FailOnInconclusive {
+				result.Successful = false
+			}
+		}
+	}
+	return result
+}
+
+// evaluateRule fails the step if the condition of FailIf holds, the failure counts as a test failure
+// unless only inconclusive devices are unsuccessful.
+func (policy Policy) evaluateRule(steps []*client.Step) Result {
+	if !policy.FailIf.Fails(steps) {
+		return Result{Successful: true}
+	}
+
+	result := Result{Successful: false}
+	for _, step := range steps {
+		switch OutcomeSummary(step) {
+		case "failure", "skipped", "flaky":
+			result.TestsFailed = true
+		}
+	}
+	return result
+}
+
+// OutcomeDetails returns the flags explaining a non successful outcome.
+func OutcomeDetails(outcome *client.Outcome) []string {
+	details := []string{}
+	if outcome == nil {
+		return details
+	}
+
+	switch outcome.Summary {
+	case "failure":
+		if outcome.FailureDetail != nil {
+			if outcome.FailureDetail.Crashed {
+				details = append(details, "Crashed")
+			}
+			if outcome.FailureDetail.NotInstalled {
+				details = append(details, "NotInstalled")
+			}
+			if outcome.FailureDetail.OtherNativeCrash {
+				details = append(details, "OtherNativeCrash")
+			}
+			if outcome.FailureDetail.TimedOut {
+				details = append(details, "TimedOut")
+			}
+			if outcome.FailureDetail.UnableToCrawl {
+				details = append(details, "UnableToCrawl")
+			}
+		}
+	case "inconclusive":
+		if outcome.InconclusiveDetail != nil {
+			if outcome.InconclusiveDetail.AbortedByUser {
+				details = append(details, "AbortedByUser")
+			}
+			if outcome.InconclusiveDetail.InfrastructureFailure {
+				details = append(details, "InfrastructureFailure")
+			}
+		}
+	case "skipped":
+		if outcome.SkippedDetail != nil {
+			if outcome.SkippedDetail.IncompatibleAppVersion {
+				details = append(details, "IncompatibleAppVersion")
+			}
+			if outcome.SkippedDetail.IncompatibleArchitecture {
+				details = append(details, "IncompatibleArchitecture")
+			}
+			if outcome.SkippedDetail.IncompatibleDevice {
+				details = append(details, "IncompatibleDevice")
+			}
+		}
+	}
+
+	return details
+}
+
+// summaryOrder is the order of the outcomes in SummarizeOutcomes.
+var summaryOrder = []string{"success", "flaky", "failure", "inconclusive", "skipped"}
+
+// SummarizeOutcomes counts the devices by outcome, like: `2 success, 1 flaky, 1 failure`.
+func SummarizeOutcomes(steps []*client.Step) string {
+	counts := map[string]int{}
+	for _, step := range steps {
+		counts[OutcomeSummary(step)]++
+	}
+
+	outcomes := append([]string{}, summaryOrder...)
+	// unknown outcomes and the devices without outcome come last
+	var others []string
+	for outcome := range counts {
+		if !sliceutil.IsStringInSlice(outcome, summaryOrder) {
+			others = append(others, outcome)
+		}
+	}
+	sort.Strings(others)
+	outcomes = append(outcomes, others...)
+
+	parts := []string{}
+	for _, outcome := range outcomes {
+		if counts[outcome] == 0 {
+			continue
+		}
+		name := outcome
+		if name == "" {
+			name = "no outcome"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", counts[outcome], name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// OutcomeSummary ...
+func OutcomeSummary(step *client.Step) string {
+	if step.Outcome == nil {
+		return ""
+	}
+	return step.Outcome.Summary
+}
+
+// OutcomeWithDetails returns the outcome summary followed by the outcome details, like: `failure(Crashed)`.
+func OutcomeWithDetails(step *client.Step) string {
+	outcome := OutcomeSummary(step)
+	for _, detail := range OutcomeDetails(step.Outcome) {
+		outcome += "(" + detail + ")"
+	}
+	return outcome
+}
+
+// StepDimensions returns the device dimensions (Model, Version, Locale, Orientation) of the step.
+func StepDimensions(step *client.Step) map[string]string {
+	dimensions := map[string]string{}
+	for _, dimension := range step.DimensionValue {
+		dimensions[dimension.Key] = dimension.Value
+	}
+	return dimensions
+}
+
+// StepDevice returns the device of the step, to run it again.
+func StepDevice(step *client.Step) *matrix.AndroidDevice {
+	dimensions := StepDimensions(step)
+	return &matrix.AndroidDevice{
+		AndroidModelID:   dimensions["Model"],
+		AndroidVersionID: dimensions["Version"],
+		Locale:           dimensions["Locale"],
+		Orientation:      dimensions["Orientation"],
+	}
+}
+
+// QueueDuration returns the time from the start of the test matrix until the first device was created,
+// or 0 if no device was created yet.
+func QueueDuration(steps []*client.Step, started time.Time) time.Duration {
+	var first time.Time
+	for _, step := range steps {
+		if step.CreationTime == nil {
+			continue
+		}
+		created := time.Unix(step.CreationTime.Seconds, step.CreationTime.Nanos)
+		if first.IsZero() || created.Before(first) {
+			first = created
+		}
+	}
+	if first.IsZero() || first.Before(started) {
+		return 0
+	}
+	return first.Sub(started)
+}
+
+// StepDuration ...
+func StepDuration(step *client.Step) time.Duration {
+	if step.RunDuration != nil {
+		return time.Duration(step.RunDuration.Seconds)*time.Second + time.Duration(step.RunDuration.Nanos)
+	}
+	if step.CreationTime != nil && step.CompletionTime != nil {
+		start := time.Unix(step.CreationTime.Seconds, step.CreationTime.Nanos)
+		end := time.Unix(step.CompletionTime.Seconds, step.CompletionTime.Nanos)
+		return end.Sub(start)
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package report
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/assets"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+var nonEnvKeyCharacters = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// DeviceResultEnvKey returns the name of the output env var of the device's outcome,
+// like: `VDTESTING_RESULT_NexusLowRes_30_en_portrait`.
+func DeviceResultEnvKey(step *client.Step) string {
+	return "VDTESTING_RESULT_" + nonEnvKeyCharacters.ReplaceAllString(assets.DeviceID(StepDimensions(step)), "_")
+}
+
+// DeviceResultsJSON returns the outcome of every device in a JSON object, keyed by the device ID (`<Model>-<Version>-<Locale>-<Orientation>`).
+func DeviceResultsJSON(steps []*client.Step) (string, error) {
+	results := map[string]string{}
+	for _, step := range steps {
+		results[assets.DeviceID(StepDimensions(step))] = OutcomeWithDetails(step)
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
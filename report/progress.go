@@ -0,0 +1,99 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// Progress tracks the state of the devices between polls.
+type Progress struct {
+	lastStatuses string
+	// the last status of each device and the time it was first seen
+	deviceStatuses map[string]string
+	deviceSince    map[string]time.Time
+}
+
+// DeviceName returns a short, human readable name of the step's device.
+func DeviceName(step *client.Step) string {
+	dimensions := StepDimensions(step)
+	return fmt.Sprintf("%s API %s (%s, %s)", dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"])
+}
+
+// StepStatus returns the step's state in a human readable form, including the outcome of completed steps.
+func StepStatus(step *client.Step) string {
+	switch step.State {
+	case "pending":
+		return "pending"
+	case "inProgress":
+		return "in progress"
+	case "complete":
+		if outcome := OutcomeSummary(step); outcome != "" {
+			return "complete (" + outcome + ")"
+		}
+		return "complete"
+	}
+	if outcome := OutcomeSummary(step); outcome != "" {
+		return step.State + " (" + outcome + ")"
+	}
+	return step.State
+}
+
+// Update prints the per-device status table if any device changed its status since the last update.
+func (p *Progress) Update(out io.Writer, steps []*client.Step) error {
+	statuses := []string{}
+	for _, step := range steps {
+		statuses = append(statuses, DeviceName(step)+": "+StepStatus(step))
+	}
+
+	current := strings.Join(statuses, "\n")
+	if current == p.lastStatuses {
+		return nil
+	}
+	p.lastStatuses = current
+
+	completed := 0
+	for _, step := range steps {
+		if IsComplete(step) {
+			completed++
+		}
+	}
+
+	fmt.Fprintf(out, "- (%d/%d) completed\n", completed, len(steps))
+	p.printTransitions(out, steps, time.Now())
+
+	t := table{indent: "  ", header: []string{"Model", "API Level", "Locale", "Orientation", "Status"}}
+	for _, step := range steps {
+		dimensions := StepDimensions(step)
+		t.addRow(nil, dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"], StepStatus(step))
+	}
+	_, err := fmt.Fprintln(out, strings.Join(t.lines(), "\n"))
+	return err
+}
+
+// printTransitions prints the status changes of the devices since the last update, with the time spent in the previous status.
+func (p *Progress) printTransitions(out io.Writer, steps []*client.Step, now time.Time) {
+	if p.deviceStatuses == nil {
+		p.deviceStatuses = map[string]string{}
+		p.deviceSince = map[string]time.Time{}
+	}
+
+	for _, step := range steps {
+		name, status := DeviceName(step), StepStatus(step)
+		previous, ok := p.deviceStatuses[name]
+		if ok && previous == status {
+			continue
+		}
+
+		if ok {
+			fmt.Fprintf(out, "  %s %s: %s -> %s, after %s\n", now.Format("15:04:05"), name, previous, status, now.Sub(p.deviceSince[name]).Round(time.Second))
+		} else {
+			fmt.Fprintf(out, "  %s %s: %s\n", now.Format("15:04:05"), name, status)
+		}
+		p.deviceStatuses[name] = status
+		p.deviceSince[name] = now
+	}
+}
@@ -0,0 +1,102 @@
+package report
+
+import (
+	"strings"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// RerunnableSteps returns the steps worth rerunning: the failed and inconclusive ones.
+// Skipped steps are left out, as they are skipped due to an incompatibility, which a rerun does not fix.
+func RerunnableSteps(steps []*client.Step) []*client.Step {
+	var rerunnable []*client.Step
+	for _, step := range steps {
+		switch OutcomeSummary(step) {
+		case "failure", "inconclusive":
+			rerunnable = append(rerunnable, step)
+		}
+	}
+	return rerunnable
+}
+
+// AllInconclusive returns true if every step has inconclusive outcome, like when the whole test matrix hit an infrastructure failure.
+func AllInconclusive(steps []*client.Step) bool {
+	for _, step := range steps {
+		if OutcomeSummary(step) != "inconclusive" {
+			return false
+		}
+	}
+	return len(steps) > 0
+}
+
+// FailedSteps returns the steps with failure outcome.
+func FailedSteps(steps []*client.Step) []*client.Step {
+	var failed []*client.Step
+	for _, step := range steps {
+		if OutcomeSummary(step) == "failure" {
+			failed = append(failed, step)
+		}
+	}
+	return failed
+}
+
+// MergeSteps replaces the steps with the rerun steps of the same device.
+func MergeSteps(steps, rerunSteps []*client.Step) []*client.Step {
+	rerunByDevice := map[string]*client.Step{}
+	for _, step := range rerunSteps {
+		rerunByDevice[DeviceName(step)] = step
+	}
+
+	merged := []*client.Step{}
+	for _, step := range steps {
+		if rerunStep, ok := rerunByDevice[DeviceName(step)]; ok {
+			step = rerunStep
+		}
+		merged = append(merged, step)
+	}
+	return merged
+}
+
+// RerunTestTargets returns the test targets running exactly the failed test cases, like `class com.example.MainTest#testLogin`.
+// The parameters of parameterized tests (like `testLogin[1]`) are dropped, as only test methods can be targeted.
+func RerunTestTargets(testCases []TestCase) []string {
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, testCase := range testCases {
+		if !testCase.Failed() || testCase.ClassName == "" || testCase.Name == "" {
+			continue
+		}
+		name := testCase.Name
+		if i := strings.Index(name, "["); i > 0 {
+			name = name[:i]
+		}
+		target := "class " + testCase.ClassName + "#" + name
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// MergeTestReruns marks the steps flaky, whose failed tests passed when rerun on the same device.
+// The steps are kept otherwise, so the test counts still cover every test, not only the rerun ones.
+func MergeTestReruns(steps, rerunSteps []*client.Step) []*client.Step {
+	passedDevices := map[string]bool{}
+	for _, step := range rerunSteps {
+		if OutcomeSummary(step) == "success" {
+			passedDevices[DeviceName(step)] = true
+		}
+	}
+
+	merged := []*client.Step{}
+	for _, step := range steps {
+		if OutcomeSummary(step) == "failure" && passedDevices[DeviceName(step)] {
+			flakyStep := *step
+			flakyStep.Outcome = &client.Outcome{Summary: "flaky"}
+			step = &flakyStep
+		}
+		merged = append(merged, step)
+	}
+	return merged
+}
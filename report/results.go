@@ -0,0 +1,124 @@
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/assets"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/metrics"
+)
+
+// Judge evaluates the policy on the steps, except the known failures of the baseline and the quarantined tests,
+// which do not fail the build.
+// It returns a test failure error, or an infrastructure failure error if no test failed, if the policy fails.
+func Judge(policy Policy, steps []*client.Step, knownFailures map[*client.Step]bool) (Result, error) {
+	policySteps := []*client.Step{}
+	for _, step := range steps {
+		if !knownFailures[step] {
+			policySteps = append(policySteps, step)
+		}
+	}
+
+	result := policy.Evaluate(policySteps)
+	switch {
+	case result.Successful:
+		return result, nil
+	case result.TestsFailed:
+		return result, failure.Errorf(failure.Report, failure.TestFailure, "The test failed, device outcomes: %s", SummarizeOutcomes(steps))
+	default:
+		return result, failure.Errorf(failure.Report, failure.InfraError, "The test did not pass, device outcomes: %s", SummarizeOutcomes(steps))
+	}
+}
+
+// Notifications are the targets the results are sent to, the ones without URL are skipped.
+type Notifications struct {
+	SlackWebhookURL string
+	SlackChannel    string
+	// BuildURL is linked from the Slack message, if the test matrix has no Firebase console page.
+	BuildURL string
+
+	WebhookURL     string
+	WebhookHeaders map[string]string
+	WebhookSecret  string
+	AppSlug        string
+	BuildSlug      string
+	TestType       string
+}
+
+// Send sends the results to the Slack and the webhook, the failures are logged as warnings.
+func (notifications Notifications) Send(httpClient *http.Client, steps []*client.Step, successful bool) {
+	if notifications.SlackWebhookURL != "" {
+		fmt.Println()
+		log.Infof("Sending Slack notification")
+
+		message := CreateSlackMessage(notifications.SlackChannel, steps, successful, notifications.BuildURL)
+		if err := PostSlackMessage(httpClient, notifications.SlackWebhookURL, message); err != nil {
+			log.Warnf("Failed to send Slack notification, error: %s", err)
+		} else {
+			log.Donef("=> Slack notification sent")
+		}
+	}
+
+	if notifications.WebhookURL != "" {
+		fmt.Println()
+		log.Infof("Sending results to webhook")
+
+		payload := WebhookPayload{
+			AppSlug:    notifications.AppSlug,
+			BuildSlug:  notifications.BuildSlug,
+			TestType:   notifications.TestType,
+			Successful: successful,
+			Devices:    CreateDeviceResults(steps),
+		}
+		if err := PostWebhook(httpClient, notifications.WebhookURL, notifications.WebhookHeaders, notifications.WebhookSecret, payload); err != nil {
+			log.Warnf("Failed to send results to webhook, error: %s", err)
+		} else {
+			log.Donef("=> Results sent")
+		}
+	}
+}
+
+// Timings are the time spent on the phases of the test, and the device minutes billed for it.
+type Timings struct {
+	Upload        time.Duration
+	Queue         time.Duration
+	Run           time.Duration
+	Download      time.Duration
+	BilledMinutes int
+}
+
+// metricOutcomes are the outcomes counted by the metrics, none stands for the steps without outcome.
+var metricOutcomes = []string{"success", "flaky", "failure", "inconclusive", "skipped", "none"}
+
+// MetricSamples returns the timings of the test, the duration of every device and the number of devices by outcome.
+// Every outcome is counted, even if no device has it, so that the gauges drop back to 0.
+func MetricSamples(steps []*client.Step, timings Timings) []metrics.Sample {
+	samples := []metrics.Sample{
+		{Name: "upload_seconds", Value: timings.Upload.Seconds()},
+		{Name: "queue_seconds", Value: timings.Queue.Seconds()},
+		{Name: "run_seconds", Value: timings.Run.Seconds()},
+		{Name: "download_seconds", Value: timings.Download.Seconds()},
+		{Name: "billed_minutes", Value: float64(timings.BilledMinutes)},
+	}
+	outcomes := map[string]int{}
+	for _, step := range steps {
+		outcome := OutcomeSummary(step)
+		if outcome == "" {
+			outcome = "none"
+		}
+		outcomes[outcome]++
+		samples = append(samples, metrics.Sample{
+			Name:   "device_duration_seconds",
+			Labels: map[string]string{"device": assets.DeviceID(StepDimensions(step))},
+			Value:  StepDuration(step).Seconds(),
+		})
+	}
+	for _, outcome := range metricOutcomes {
+		samples = append(samples, metrics.Sample{Name: "devices", Labels: map[string]string{"outcome": outcome}, Value: float64(outcomes[outcome])})
+	}
+	return samples
+}
@@ -0,0 +1,187 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/failure"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/metrics"
+)
+
+func resultStep(model, outcome string, seconds int64) *client.Step {
+	return &client.Step{
+		State:   "complete",
+		Outcome: &client.Outcome{Summary: outcome},
+		DimensionValue: []*client.StepDimensionValueEntry{
+			{Key: "Model", Value: model}, {Key: "Version", Value: "30"}, {Key: "Locale", Value: "en"}, {Key: "Orientation", Value: "portrait"},
+		},
+		RunDuration: &client.Duration{Seconds: seconds},
+	}
+}
+
+func TestJudge(t *testing.T) {
+	passed := resultStep("a", "success", 60)
+	failed := resultStep("b", "failure", 60)
+	inconclusive := resultStep("c", "inconclusive", 60)
+	flaky := resultStep("d", "flaky", 60)
+
+	tests := []struct {
+		name          string
+		policy        Policy
+		steps         []*client.Step
+		knownFailures map[*client.Step]bool
+		want          Result
+		wantCategory  failure.Category
+	}{
+		{
+			name:  "passed",
+			steps: []*client.Step{passed, inconclusive, flaky},
+			want:  Result{Successful: true},
+		},
+		{
+			name:         "failed",
+			steps:        []*client.Step{passed, failed},
+			want:         Result{TestsFailed: true},
+			wantCategory: failure.TestFailure,
+		},
+		{
+			name:          "known failure",
+			steps:         []*client.Step{passed, failed},
+			knownFailures: map[*client.Step]bool{failed: true},
+			want:          Result{Successful: true},
+		},
+		{
+			name:         "inconclusive",
+			policy:       Policy{FailOnInconclusive: true},
+			steps:        []*client.Step{passed, inconclusive},
+			want:         Result{},
+			wantCategory: failure.InfraError,
+		},
+		{
+			name:         "flaky",
+			policy:       Policy{FailOnFlaky: true},
+			steps:        []*client.Step{passed, flaky},
+			want:         Result{TestsFailed: true},
+			wantCategory: failure.TestFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Judge(tt.policy, tt.steps, tt.knownFailures)
+			if result != tt.want {
+				t.Errorf("Judge() = %+v, want %+v", result, tt.want)
+			}
+			if tt.wantCategory == "" {
+				if err != nil {
+					t.Errorf("Judge() unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Judge() succeeded, want a %s error", tt.wantCategory)
+			}
+			if phase, category := failure.Classify(err); phase != failure.Report || category != tt.wantCategory {
+				t.Errorf("Judge() error is classified as %s/%s, want %s/%s", phase, category, failure.Report, tt.wantCategory)
+			}
+			// the outcomes of the known failures are reported too
+			if want := SummarizeOutcomes(tt.steps); !strings.HasSuffix(err.Error(), "device outcomes: "+want) {
+				t.Errorf("Judge() error = %s, want the device outcomes: %s", err, want)
+			}
+		})
+	}
+}
+
+func TestNotificationsSend(t *testing.T) {
+	requests := map[string][]byte{}
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read request body, error: %s", err)
+		}
+		requests[r.URL.Path] = body
+		if r.URL.Path == "/webhook" {
+			signature = r.Header.Get("X-Vdtesting-Signature")
+			if got := r.Header.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("Authorization header = %q, want the configured header", got)
+			}
+		}
+	}))
+	defer server.Close()
+
+	steps := []*client.Step{resultStep("a", "success", 60), resultStep("b", "failure", 60)}
+
+	Notifications{}.Send(server.Client(), steps, false)
+	if len(requests) != 0 {
+		t.Fatalf("Send() without URLs sent %d request(s)", len(requests))
+	}
+
+	notifications := Notifications{
+		SlackWebhookURL: server.URL + "/slack",
+		SlackChannel:    "#builds",
+		BuildURL:        "https://app.bitrise.io/build/build-slug",
+		WebhookURL:      server.URL + "/webhook",
+		WebhookHeaders:  map[string]string{"Authorization": "Bearer token"},
+		WebhookSecret:   "secret",
+		AppSlug:         "app-slug",
+		BuildSlug:       "build-slug",
+		TestType:        "instrumentation",
+	}
+	notifications.Send(server.Client(), steps, false)
+
+	var message SlackMessage
+	if err := json.Unmarshal(requests["/slack"], &message); err != nil {
+		t.Fatalf("Failed to parse the Slack message (%s), error: %s", requests["/slack"], err)
+	}
+	if message.Channel != "#builds" {
+		t.Errorf("Slack channel = %s, want #builds", message.Channel)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(requests["/webhook"], &payload); err != nil {
+		t.Fatalf("Failed to parse the webhook payload (%s), error: %s", requests["/webhook"], err)
+	}
+	if payload.AppSlug != "app-slug" || payload.BuildSlug != "build-slug" || payload.TestType != "instrumentation" || payload.Successful || len(payload.Devices) != 2 {
+		t.Errorf("webhook payload = %+v", payload)
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("webhook signature = %q, want a sha256 signature", signature)
+	}
+}
+
+func TestMetricSamples(t *testing.T) {
+	steps := []*client.Step{
+		resultStep("Pixel2", "success", 75),
+		resultStep("NexusLowRes", "failure", 60),
+		{State: "pending"},
+	}
+	timings := Timings{Upload: 10 * time.Second, Queue: 20 * time.Second, Run: 75 * time.Second, Download: 5 * time.Second, BilledMinutes: 3}
+
+	want := []metrics.Sample{
+		{Name: "upload_seconds", Value: 10},
+		{Name: "queue_seconds", Value: 20},
+		{Name: "run_seconds", Value: 75},
+		{Name: "download_seconds", Value: 5},
+		{Name: "billed_minutes", Value: 3},
+		{Name: "device_duration_seconds", Labels: map[string]string{"device": "Pixel2-30-en-portrait"}, Value: 75},
+		{Name: "device_duration_seconds", Labels: map[string]string{"device": "NexusLowRes-30-en-portrait"}, Value: 60},
+		{Name: "device_duration_seconds", Labels: map[string]string{"device": "---"}, Value: 0},
+		{Name: "devices", Labels: map[string]string{"outcome": "success"}, Value: 1},
+		{Name: "devices", Labels: map[string]string{"outcome": "flaky"}, Value: 0},
+		{Name: "devices", Labels: map[string]string{"outcome": "failure"}, Value: 1},
+		{Name: "devices", Labels: map[string]string{"outcome": "inconclusive"}, Value: 0},
+		{Name: "devices", Labels: map[string]string{"outcome": "skipped"}, Value: 0},
+		{Name: "devices", Labels: map[string]string{"outcome": "none"}, Value: 1},
+	}
+	if got := MetricSamples(steps, timings); !reflect.DeepEqual(got, want) {
+		t.Errorf("MetricSamples() =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// Rule is a failure condition on the number of devices per outcome,
+// like: `failed > 0 OR skipped > 2 OR any crashed`.
+type Rule struct {
+	source string
+	root   ruleNode
+}
+
+// ruleCounters count the devices matching a name of a rule.
+var ruleCounters = map[string]func(step *client.Step) bool{
+	"devices":      func(step *client.Step) bool { return true },
+	"passed":       outcomeIs("success"),
+	"failed":       outcomeIs("failure"),
+	"flaky":        outcomeIs("flaky"),
+	"skipped":      outcomeIs("skipped"),
+	"inconclusive": outcomeIs("inconclusive"),
+	"crashed": failureDetail(func(detail *client.FailureDetail) bool {
+		return detail.Crashed || detail.OtherNativeCrash
+	}),
+	"timed_out": failureDetail(func(detail *client.FailureDetail) bool {
+		return detail.TimedOut
+	}),
+	"not_installed": failureDetail(func(detail *client.FailureDetail) bool {
+		return detail.NotInstalled
+	}),
+}
+
+func outcomeIs(summary string) func(step *client.Step) bool {
+	return func(step *client.Step) bool {
+		return OutcomeSummary(step) == summary
+	}
+}
+
+func failureDetail(matches func(detail *client.FailureDetail) bool) func(step *client.Step) bool {
+	return func(step *client.Step) bool {
+		return step.Outcome != nil && step.Outcome.FailureDetail != nil && matches(step.Outcome.FailureDetail)
+	}
+}
+
+// RuleCounterNames returns the names a rule can refer to.
+func RuleCounterNames() []string {
+	names := make([]string, 0, len(ruleCounters))
+	for name := range ruleCounters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseRule parses a failure condition. The condition compares device counts with numbers (`>`, `>=`, `<`, `<=`, `==`, `!=`),
+// `any name` is a shorthand for `name > 0`, and the conditions are combined with `AND`, `OR`, `NOT` and parentheses.
+func ParseRule(rule string) (*Rule, error) {
+	tokens, err := tokenizeRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	parser := &ruleParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, fmt.Errorf("unexpected %q", parser.peek())
+	}
+	return &Rule{source: rule, root: root}, nil
+}
+
+// String ...
+func (rule *Rule) String() string {
+	return rule.source
+}
+
+// Fails tells if the condition of the rule holds for the devices.
+func (rule *Rule) Fails(steps []*client.Step) bool {
+	counts := map[string]int{}
+	for name, counter := range ruleCounters {
+		for _, step := range steps {
+			if counter(step) {
+				counts[name]++
+			}
+		}
+	}
+	return rule.root.eval(counts)
+}
+
+type ruleNode interface {
+	eval(counts map[string]int) bool
+}
+
+type orNode struct{ left, right ruleNode }
+
+func (n orNode) eval(counts map[string]int) bool { return n.left.eval(counts) || n.right.eval(counts) }
+
+type andNode struct{ left, right ruleNode }
+
+func (n andNode) eval(counts map[string]int) bool { return n.left.eval(counts) && n.right.eval(counts) }
+
+type notNode struct{ operand ruleNode }
+
+func (n notNode) eval(counts map[string]int) bool { return !n.operand.eval(counts) }
+
+type comparisonNode struct {
+	name     string
+	operator string
+	value    int
+}
+
+func (n comparisonNode) eval(counts map[string]int) bool {
+	count := counts[n.name]
+	switch n.operator {
+	case ">":
+		return count > n.value
+	case ">=":
+		return count >= n.value
+	case "<":
+		return count < n.value
+	case "<=":
+		return count <= n.value
+	case "==":
+		return count == n.value
+	case "!=":
+		return count != n.value
+	}
+	return false
+}
+
+func tokenizeRule(rule string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(rule); {
+		c := rune(rule[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("<>=!", c):
+			j := i + 1
+			if j < len(rule) && rule[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, rule[i:j])
+			i = j
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i
+			for j < len(rule) && (rule[j] == '_' || unicode.IsLetter(rune(rule[j])) || unicode.IsDigit(rune(rule[j]))) {
+				j++
+			}
+			tokens = append(tokens, rule[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+type ruleParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *ruleParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *ruleParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("unexpected end of rule")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *ruleParser) isKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	switch {
+	case p.isKeyword("not"):
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	case p.peek() == "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token, err := p.next(); err != nil || token != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+	case p.isKeyword("any"):
+		p.pos++
+		name, err := p.counterName()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonNode{name: name, operator: ">", value: 0}, nil
+	}
+
+	name, err := p.counterName()
+	if err != nil {
+		return nil, err
+	}
+	operator, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected a comparison after %q, got %q", name, operator)
+	}
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := strconv.Atoi(token)
+	if err != nil {
+		return nil, fmt.Errorf("expected a number after %q, got %q", name+" "+operator, token)
+	}
+	return comparisonNode{name: name, operator: operator, value: value}, nil
+}
+
+func (p *ruleParser) counterName() (string, error) {
+	token, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	name := strings.ToLower(token)
+	if _, ok := ruleCounters[name]; !ok {
+		return "", fmt.Errorf("unknown name %q, should be one of: %s", token, strings.Join(RuleCounterNames(), ", "))
+	}
+	return name, nil
+}
@@ -0,0 +1,136 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// ruleTestSteps are devices with 2 passed, 4 failed (2 crashed, 1 timed out, 1 not installed), 1 flaky, 1 skipped and 1 inconclusive outcome.
+func ruleTestSteps() []*client.Step {
+	stepOf := func(summary string, detail *client.FailureDetail) *client.Step {
+		return &client.Step{Outcome: &client.Outcome{Summary: summary, FailureDetail: detail}}
+	}
+	return []*client.Step{
+		stepOf("success", nil),
+		stepOf("success", nil),
+		stepOf("failure", &client.FailureDetail{Crashed: true}),
+		stepOf("failure", &client.FailureDetail{OtherNativeCrash: true}),
+		stepOf("failure", &client.FailureDetail{TimedOut: true}),
+		stepOf("failure", &client.FailureDetail{NotInstalled: true}),
+		stepOf("flaky", nil),
+		stepOf("skipped", nil),
+		stepOf("inconclusive", nil),
+	}
+}
+
+func TestRuleCounters(t *testing.T) {
+	want := map[string]int{
+		"devices":       9,
+		"passed":        2,
+		"failed":        4,
+		"flaky":         1,
+		"skipped":       1,
+		"inconclusive":  1,
+		"crashed":       2,
+		"timed_out":     1,
+		"not_installed": 1,
+	}
+	if len(want) != len(RuleCounterNames()) {
+		t.Fatalf("RuleCounterNames() = %v, want a test case for each", RuleCounterNames())
+	}
+
+	for _, name := range RuleCounterNames() {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range []struct {
+				rule  string
+				fails bool
+			}{
+				{rule: fmt.Sprintf("%s == %d", name, want[name]), fails: true},
+				{rule: fmt.Sprintf("%s != %d", name, want[name]), fails: false},
+				{rule: fmt.Sprintf("%s > %d", name, want[name]-1), fails: true},
+				{rule: fmt.Sprintf("%s >= %d", name, want[name]+1), fails: false},
+				{rule: fmt.Sprintf("%s < %d", name, want[name]), fails: false},
+				{rule: fmt.Sprintf("%s <= %d", name, want[name]), fails: true},
+			} {
+				rule, err := ParseRule(tt.rule)
+				if err != nil {
+					t.Fatalf("ParseRule(%q) unexpected error: %s", tt.rule, err)
+				}
+				if got := rule.Fails(ruleTestSteps()); got != tt.fails {
+					t.Errorf("ParseRule(%q).Fails() = %t, want %t", tt.rule, got, tt.fails)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule  string
+		fails bool
+	}{
+		// AND binds tighter than OR
+		{rule: "passed == 2 OR failed == 0 AND flaky == 0", fails: true},
+		{rule: "failed == 0 AND flaky == 0 OR passed == 2", fails: true},
+		{rule: "(passed == 2 OR failed == 0) AND flaky == 0", fails: false},
+		// NOT binds tighter than AND and OR
+		{rule: "NOT any flaky OR any skipped", fails: true},
+		{rule: "NOT (any flaky OR any skipped)", fails: false},
+		{rule: "NOT NOT any failed", fails: true},
+		{rule: "not failed > 0 and passed == 2", fails: false},
+		{rule: "any crashed", fails: true},
+		{rule: "any flaky AND NOT any devices", fails: false},
+		{rule: "ANY Crashed and Passed>=2", fails: true},
+		{rule: "((failed>3))", fails: true},
+		{rule: "failed > 0 OR skipped > 2 OR any crashed", fails: true},
+	}
+
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q) unexpected error: %s", tt.rule, err)
+			continue
+		}
+		if got := rule.Fails(ruleTestSteps()); got != tt.fails {
+			t.Errorf("ParseRule(%q).Fails() = %t, want %t", tt.rule, got, tt.fails)
+		}
+		if rule.String() != tt.rule {
+			t.Errorf("ParseRule(%q).String() = %q", tt.rule, rule.String())
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr string
+	}{
+		{rule: "", wantErr: "empty rule"},
+		{rule: "  \t", wantErr: "empty rule"},
+		{rule: "broken > 0", wantErr: `unknown name "broken", should be one of:`},
+		{rule: "any broken", wantErr: `unknown name "broken"`},
+		{rule: "failed > 0 OR crashes > 0", wantErr: `unknown name "crashes"`},
+		{rule: "(failed > 0", wantErr: "missing closing parenthesis"},
+		{rule: "(failed > 0 OR (passed < 1)", wantErr: "missing closing parenthesis"},
+		{rule: "failed > 0)", wantErr: `unexpected ")"`},
+		{rule: "failed > 0 passed > 1", wantErr: `unexpected "passed"`},
+		{rule: "any failed any crashed", wantErr: `unexpected "any"`},
+		{rule: "failed >", wantErr: "unexpected end of rule"},
+		{rule: "failed > 0 OR", wantErr: "unexpected end of rule"},
+		{rule: "NOT", wantErr: "unexpected end of rule"},
+		{rule: "failed 0", wantErr: `expected a comparison after "failed", got "0"`},
+		{rule: "failed = 0", wantErr: `expected a comparison after "failed", got "="`},
+		{rule: "failed > many", wantErr: `expected a number after "failed >", got "many"`},
+		{rule: "failed > 0 && passed > 0", wantErr: "unexpected character '&'"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseRule(tt.rule)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+			t.Errorf("ParseRule(%q) error = %v, want prefix %q", tt.rule, err, tt.wantErr)
+		}
+	}
+}
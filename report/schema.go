@@ -0,0 +1,59 @@
+package report
+
+import (
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// knownStepStates are the step states handled by the step.
+var knownStepStates = []string{"pending", "inProgress", "complete"}
+
+// knownMatrixStates are the test matrix states the backend might expose, only INVALID and ERROR are acted on.
+var knownMatrixStates = []string{"", "VALIDATING", "PENDING", "RUNNING", "FINISHED", "ERROR", "INVALID", "CANCELLED"}
+
+// IsKnownOutcome returns true if the outcome summary is handled by the step.
+func IsKnownOutcome(summary string) bool {
+	return sliceutil.IsStringInSlice(summary, summaryOrder)
+}
+
+// IsComplete returns true if the step completed. A step in an unknown state is complete once it has an outcome,
+// so a new state of the API does not keep the step waiting until the timeout.
+func IsComplete(step *client.Step) bool {
+	if step.State == "complete" {
+		return true
+	}
+	return !sliceutil.IsStringInSlice(step.State, knownStepStates) && OutcomeSummary(step) != ""
+}
+
+// UnknownValues warns about the step states, outcome summaries and test matrix states of the API responses
+// which the step does not know, once for each value, with the raw value.
+type UnknownValues struct {
+	warned map[string]bool
+}
+
+// Check warns about the unknown values of the response.
+func (u *UnknownValues) Check(response *client.ListStepsResponse) {
+	if !sliceutil.IsStringInSlice(response.State, knownMatrixStates) {
+		u.warn("matrix:"+response.State, "Unknown test matrix state in the API response: %q, it is ignored", response.State)
+	}
+	for _, step := range response.Steps {
+		if !sliceutil.IsStringInSlice(step.State, knownStepStates) {
+			u.warn("state:"+step.State, "Unknown step state in the API response: %q (%s), the device counts as completed once it has an outcome", step.State, DeviceName(step))
+		}
+		if summary := OutcomeSummary(step); summary != "" && !IsKnownOutcome(summary) {
+			u.warn("outcome:"+summary, "Unknown outcome summary in the API response: %q (%s), it is reported as is and handled like inconclusive", summary, DeviceName(step))
+		}
+	}
+}
+
+func (u *UnknownValues) warn(key, format string, v ...interface{}) {
+	if u.warned == nil {
+		u.warned = map[string]bool{}
+	}
+	if u.warned[key] {
+		return
+	}
+	u.warned[key] = true
+	log.Warnf(format, v...)
+}
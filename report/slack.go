@@ -0,0 +1,106 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// SlackMessage ...
+type SlackMessage struct {
+	Channel     string             `json:"channel,omitempty"`
+	Text        string             `json:"text,omitempty"`
+	Attachments []*SlackAttachment `json:"attachments,omitempty"`
+}
+
+// SlackAttachment ...
+type SlackAttachment struct {
+	Fallback  string   `json:"fallback,omitempty"`
+	Color     string   `json:"color,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	TitleLink string   `json:"title_link,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	MrkdwnIn  []string `json:"mrkdwn_in,omitempty"`
+}
+
+// CreateSlackMessage creates a compact per-device summary, linking to the Firebase console page of the test matrix,
+// or to buildURL if the API does not expose it.
+func CreateSlackMessage(channel string, steps []*client.Step, successful bool, buildURL string) SlackMessage {
+	title := "Virtual device tests passed"
+	color := "good"
+	if !successful {
+		title = "Virtual device tests failed"
+		color = "danger"
+	}
+
+	lines := []string{}
+	for _, step := range steps {
+		dimensions := StepDimensions(step)
+		device := fmt.Sprintf("%s, API %s, %s, %s", dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"])
+
+		outcome := OutcomeSummary(step)
+		if details := OutcomeDetails(step.Outcome); len(details) > 0 {
+			outcome += " (" + strings.Join(details, ", ") + ")"
+		}
+
+		if outcome == "success" {
+			lines = append(lines, fmt.Sprintf("%s: %s", device, outcome))
+		} else {
+			lines = append(lines, fmt.Sprintf("*%s: %s*", device, outcome))
+		}
+	}
+
+	titleLink := MatrixConsoleURL(steps)
+	if titleLink == "" {
+		titleLink = buildURL
+	}
+
+	return SlackMessage{
+		Channel: channel,
+		Attachments: []*SlackAttachment{
+			{
+				Fallback:  title,
+				Color:     color,
+				Title:     title,
+				TitleLink: titleLink,
+				Text:      strings.Join(lines, "\n"),
+				MrkdwnIn:  []string{"text"},
+			},
+		},
+	}
+}
+
+// PostSlackMessage ...
+func PostSlackMessage(httpClient *http.Client, webhookURL string, message SlackMessage) error {
+	jsonByte, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal slack message, error: %s", err)
+	}
+
+	resp, err := httpClient.Post(webhookURL, "application/json", bytes.NewBuffer(jsonByte))
+	if err != nil {
+		return fmt.Errorf("Failed to send slack message, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to send slack message, status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+func TestCreateSlackMessageTitleLink(t *testing.T) {
+	const buildURL = "https://app.bitrise.io/build/build-slug"
+	stepOf := func(executionID, stepID string) *client.Step {
+		return &client.Step{
+			State:           "complete",
+			Outcome:         &client.Outcome{Summary: "success"},
+			ToolResultsStep: &client.ToolResultsStep{ProjectID: "project", HistoryID: "history", ExecutionID: executionID, StepID: stepID},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		steps []*client.Step
+		want  string
+	}{
+		{
+			name:  "test matrix",
+			steps: []*client.Step{stepOf("execution", "step-1"), stepOf("execution", "step-2")},
+			want:  "https://console.firebase.google.com/project/project/testlab/histories/history/matrices/execution",
+		},
+		{
+			name:  "no Tool Results IDs",
+			steps: []*client.Step{{State: "complete", Outcome: &client.Outcome{Summary: "success"}}},
+			want:  buildURL,
+		},
+		{
+			name:  "multiple test matrices",
+			steps: []*client.Step{stepOf("execution", "step-1"), stepOf("rerun-execution", "step-1")},
+			want:  buildURL,
+		},
+		{
+			name:  "no steps",
+			steps: nil,
+			want:  buildURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := CreateSlackMessage("", tt.steps, true, buildURL)
+			if got := message.Attachments[0].TitleLink; got != tt.want {
+				t.Errorf("TitleLink = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// nativeFramePattern matches a frame of a native crash backtrace, like:
+// `#00 pc 00000000000123ab  /data/app/com.example-1/lib/arm64/libnative.so (crash+20)`.
+var nativeFramePattern = regexp.MustCompile(`#\d+ pc ([0-9a-fA-F]+)\s+(\S+\.so)\b`)
+
+// deviceLibABIs maps the library directories of the installed APKs to the ABIs of the native debug symbols.
+var deviceLibABIs = map[string]string{
+	"arm":    "armeabi-v7a",
+	"arm64":  "arm64-v8a",
+	"x86":    "x86",
+	"x86_64": "x86_64",
+}
+
+// NativeSymbols are the unstripped native libraries (or their `.sym`/`.dbg` debug symbols) of the APK,
+// used to symbolize the printed native crash backtraces.
+type NativeSymbols struct {
+	symbolizer string
+	// files are the symbol files, keyed by the ABI and the library name
+	files   map[string]map[string]string
+	symbols map[string]string
+}
+
+var nativeSymbols *NativeSymbols
+
+// SetNativeSymbols sets the native symbols used to symbolize the printed native crash backtraces, nil disables symbolization.
+func SetNativeSymbols(symbols *NativeSymbols) {
+	nativeSymbols = symbols
+}
+
+// LoadNativeSymbols indexes the symbol files of the directory or zip archive at pth, like the
+// `native-debug-symbols.zip` or the `merged_native_libs` directory of an Android Gradle Plugin build.
+// It fails if neither `llvm-symbolizer` nor `addr2line` is available.
+func LoadNativeSymbols(pth string) (*NativeSymbols, error) {
+	symbolizer, err := findSymbolizer()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := pth
+	if strings.HasSuffix(strings.ToLower(pth), ".zip") {
+		tmpDir, err := pathutil.NormalizedOSTempDirPath("native_symbols")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temp dir, error: %s", err)
+		}
+		if err := command.UnZIP(pth, tmpDir); err != nil {
+			return nil, fmt.Errorf("Failed to unzip native symbols (%s), error: %s", pth, err)
+		}
+		dir = tmpDir
+	}
+
+	symbols := &NativeSymbols{
+		symbolizer: symbolizer,
+		files:      map[string]map[string]string{},
+		symbols:    map[string]string{},
+	}
+	if err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".sym"), ".dbg")
+		if !strings.HasSuffix(name, ".so") {
+			return nil
+		}
+		abi := filepath.Base(filepath.Dir(file))
+		if symbols.files[abi] == nil {
+			symbols.files[abi] = map[string]string{}
+		}
+		symbols.files[abi][name] = file
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to list native symbols (%s), error: %s", pth, err)
+	}
+	if len(symbols.files) == 0 {
+		return nil, fmt.Errorf("No native library found in %s", pth)
+	}
+	return symbols, nil
+}
+
+// findSymbolizer returns the path of llvm-symbolizer, from the PATH or the NDK, or falls back to addr2line.
+func findSymbolizer() (string, error) {
+	if pth, err := exec.LookPath("llvm-symbolizer"); err == nil {
+		return pth, nil
+	}
+
+	var ndkDirs []string
+	for _, env := range []string{"ANDROID_NDK_HOME", "ANDROID_NDK_ROOT"} {
+		if dir := os.Getenv(env); dir != "" {
+			ndkDirs = append(ndkDirs, dir)
+		}
+	}
+	for _, env := range []string{"ANDROID_HOME", "ANDROID_SDK_ROOT"} {
+		if dir := os.Getenv(env); dir != "" {
+			ndkDirs = append(ndkDirs, filepath.Join(dir, "ndk-bundle"))
+			if versions, err := filepath.Glob(filepath.Join(dir, "ndk", "*")); err == nil {
+				ndkDirs = append(ndkDirs, versions...)
+			}
+		}
+	}
+	for _, ndkDir := range ndkDirs {
+		matches, err := filepath.Glob(filepath.Join(ndkDir, "toolchains", "llvm", "prebuilt", "*", "bin", "llvm-symbolizer"))
+		if err == nil && len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+
+	if pth, err := exec.LookPath("addr2line"); err == nil {
+		return pth, nil
+	}
+	return "", fmt.Errorf("Neither llvm-symbolizer nor addr2line is found, install the NDK or set ANDROID_NDK_HOME")
+}
+
+// Symbolize returns the line with the function and the source location appended, if it is a native backtrace frame
+// of a library with symbols.
+func (symbols *NativeSymbols) Symbolize(line string) string {
+	if symbols == nil {
+		return line
+	}
+
+	match := nativeFramePattern.FindStringSubmatch(line)
+	if match == nil {
+		return line
+	}
+	file := symbols.file(match[2])
+	if file == "" {
+		return line
+	}
+
+	key := file + "@" + match[1]
+	symbol, ok := symbols.symbols[key]
+	if !ok {
+		symbol = symbols.symbolize(file, "0x"+match[1])
+		symbols.symbols[key] = symbol
+	}
+	if symbol == "" {
+		return line
+	}
+	return line + " => " + symbol
+}
+
+// file returns the symbol file of the library at the device path, preferring the ABI of the library directory.
+func (symbols *NativeSymbols) file(devicePath string) string {
+	name := filepath.Base(devicePath)
+	if abi, ok := deviceLibABIs[filepath.Base(filepath.Dir(devicePath))]; ok {
+		if file, ok := symbols.files[abi][name]; ok {
+			return file
+		}
+	}
+	for _, files := range symbols.files {
+		if file, ok := files[name]; ok {
+			return file
+		}
+	}
+	return ""
+}
+
+// symbolize returns `function file:line` of the address, empty if it is unknown.
+func (symbols *NativeSymbols) symbolize(file, address string) string {
+	args := []string{"--obj=" + file, address}
+	if filepath.Base(symbols.symbolizer) == "addr2line" {
+		args = []string{"-f", "-C", "-e", file, address}
+	}
+
+	output, err := command.New(symbols.symbolizer, args...).RunAndReturnTrimmedOutput()
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(output, "\n")
+	if len(lines) < 2 || strings.HasPrefix(lines[0], "??") {
+		return ""
+	}
+	return strings.TrimSpace(lines[0]) + " " + strings.TrimSpace(lines[1])
+}
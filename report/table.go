@@ -0,0 +1,131 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// the orders of the devices in the result tables
+const (
+	SortByOutcome  = "outcome"
+	SortByModel    = "model"
+	SortByDuration = "duration"
+)
+
+var resultsSort = SortByOutcome
+
+// SortResults sets the order of the devices in the result tables.
+func SortResults(by string) {
+	resultsSort = by
+}
+
+// outcomeOrder ranks the outcomes, the ones needing attention first.
+var outcomeOrder = map[string]int{
+	"failure":      0,
+	"inconclusive": 1,
+	"skipped":      2,
+	"flaky":        3,
+	"success":      4,
+}
+
+func outcomeRank(step *client.Step) int {
+	if rank, ok := outcomeOrder[OutcomeSummary(step)]; ok {
+		return rank
+	}
+	return len(outcomeOrder)
+}
+
+func lessByModel(a, b *client.Step) bool {
+	dimensionsA, dimensionsB := StepDimensions(a), StepDimensions(b)
+	for _, key := range []string{"Model", "Version", "Locale", "Orientation"} {
+		if dimensionsA[key] != dimensionsB[key] {
+			return dimensionsA[key] < dimensionsB[key]
+		}
+	}
+	return false
+}
+
+// SortSteps returns the steps sorted by their outcome (the failures first), model or duration (the longest first).
+// The order of the steps is kept for equal keys.
+func SortSteps(steps []*client.Step, by string) []*client.Step {
+	sorted := append([]*client.Step{}, steps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch by {
+		case SortByOutcome:
+			if outcomeRank(a) != outcomeRank(b) {
+				return outcomeRank(a) < outcomeRank(b)
+			}
+			return lessByModel(a, b)
+		case SortByModel:
+			return lessByModel(a, b)
+		case SortByDuration:
+			return StepDuration(a) > StepDuration(b)
+		}
+		return false
+	})
+	return sorted
+}
+
+// PrintTable prints the per-device results, the failed devices are grouped at the top,
+// followed by the total device time of the devices and the wall-clock time of the test.
+func PrintTable(out io.Writer, steps []*client.Step) error {
+	var failed, rest []*client.Step
+	for _, step := range SortSteps(steps, resultsSort) {
+		if OutcomeSummary(step) == "failure" {
+			failed = append(failed, step)
+		} else {
+			rest = append(rest, step)
+		}
+	}
+
+	t := table{header: []string{"Model", "API Level", "Locale", "Orientation", "Duration", "Tests", "Outcome"}}
+	for i, step := range append(failed, rest...) {
+		// the failed devices are separated from the rest
+		if i == len(failed) {
+			t.addSeparator()
+		}
+		dimensions := StepDimensions(step)
+
+		var color colorstring.ColorFunc
+		switch OutcomeSummary(step) {
+		case "success":
+			color = colorstring.Green
+		case "failure":
+			color = colorstring.Red
+		case "inconclusive":
+			color = colorstring.Yellow
+		case "skipped":
+			color = colorstring.Blue
+		case "flaky":
+			color = colorstring.Magenta
+		}
+
+		duration := "-"
+		if d := StepDuration(step); d > 0 {
+			duration = d.Round(time.Second).String()
+		}
+
+		t.addRow(color, dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"], duration, TestCounts(step).String(), OutcomeWithDetails(step))
+	}
+
+	lines := t.lines()
+	var deviceTime time.Duration
+	for _, step := range steps {
+		deviceTime += StepDuration(step)
+	}
+	total := fmt.Sprintf("Total device time: %s (%d billed minute(s))", deviceTime.Round(time.Second), BilledMinutes(steps))
+	if wallClock := WallClockDuration(steps); wallClock > 0 {
+		total += fmt.Sprintf(", wall-clock time: %s", wallClock.Round(time.Second))
+	}
+	lines = append(lines, total)
+
+	_, err := fmt.Fprintln(out, strings.Join(lines, "\n"))
+	return err
+}
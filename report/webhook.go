@@ -0,0 +1,100 @@
+package report
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-android/client"
+)
+
+// DeviceResult ...
+type DeviceResult struct {
+	Model           string   `json:"model"`
+	APILevel        string   `json:"api_level"`
+	Locale          string   `json:"locale"`
+	Orientation     string   `json:"orientation"`
+	Outcome         string   `json:"outcome"`
+	OutcomeDetails  []string `json:"outcome_details"`
+	DurationSeconds int64    `json:"duration_seconds"`
+}
+
+// WebhookPayload ...
+type WebhookPayload struct {
+	AppSlug    string          `json:"app_slug"`
+	BuildSlug  string          `json:"build_slug"`
+	TestType   string          `json:"test_type"`
+	Successful bool            `json:"successful"`
+	Devices    []*DeviceResult `json:"devices"`
+}
+
+// CreateDeviceResults ...
+func CreateDeviceResults(steps []*client.Step) []*DeviceResult {
+	results := []*DeviceResult{}
+	for _, step := range steps {
+		dimensions := StepDimensions(step)
+
+		results = append(results, &DeviceResult{
+			Model:           dimensions["Model"],
+			APILevel:        dimensions["Version"],
+			Locale:          dimensions["Locale"],
+			Orientation:     dimensions["Orientation"],
+			Outcome:         OutcomeSummary(step),
+			OutcomeDetails:  OutcomeDetails(step.Outcome),
+			DurationSeconds: int64(StepDuration(step).Seconds()),
+		})
+	}
+	return results
+}
+
+// PostWebhook POSTs the payload to webhookURL, signed with secret (if set).
+func PostWebhook(httpClient *http.Client, webhookURL string, headers map[string]string, secret string, payload WebhookPayload) error {
+	jsonByte, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal webhook payload, error: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(jsonByte))
+	if err != nil {
+		return fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		if _, err := mac.Write(jsonByte); err != nil {
+			return fmt.Errorf("Failed to sign webhook payload, error: %s", err)
+		}
+		req.Header.Set("X-Vdtesting-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to get http response, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Failed to get http response, status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State identifies the running test matrix of the step, saved once the matrix is started,
+// so a restarted step can wait for it instead of starting a duplicate matrix.
+type State struct {
+	BuildSlug    string `json:"build_slug"`
+	TestMatrixID string `json:"test_matrix_id,omitempty"`
+	// RunIndex is the index of the test run: a test APK tested on the devices sharing the same test timeout
+	RunIndex    int       `json:"run_index"`
+	TestApkPath string    `json:"test_apk_path,omitempty"`
+	Started     time.Time `json:"started"`
+}
+
+// Load reads the state from pth, it returns nil if the file does not exist.
+func Load(pth string) (*State, error) {
+	data, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read state file (%s), error: %s", pth, err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("Failed to parse state file (%s), error: %s", pth, err)
+	}
+	return &state, nil
+}
+
+// Save writes the state to pth. The file is replaced by a rename,
+// so a step interrupted while saving does not leave a truncated state behind.
+func (state State) Save(pth string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize state, error: %s", err)
+	}
+
+	tmpPth := filepath.Join(filepath.Dir(pth), "."+filepath.Base(pth)+".tmp")
+	if err := ioutil.WriteFile(tmpPth, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write state file (%s), error: %s", tmpPth, err)
+	}
+	if err := os.Rename(tmpPth, pth); err != nil {
+		return fmt.Errorf("Failed to write state file (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+// Remove deletes the state file, once the results of the test matrix are collected.
+func Remove(pth string) error {
+	if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove state file (%s), error: %s", pth, err)
+	}
+	return nil
+}
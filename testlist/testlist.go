@@ -0,0 +1,39 @@
+package testlist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// List is a set of test cases (`ClassName#name`) or devices (`<Model>-<Version>-<Locale>-<Orientation>`),
+// read from a file with one entry per line. Empty lines and lines starting with `#` are ignored.
+type List map[string]bool
+
+// Load reads the list from pth.
+func Load(pth string) (List, error) {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read test list (%s), error: %s", pth, err)
+	}
+
+	list := List{}
+	for _, line := range strings.Split(string(data), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		list[entry] = true
+	}
+	return list, nil
+}
+
+// ContainsAll returns true if every entry is listed, and there is at least one entry.
+func (list List) ContainsAll(entries []string) bool {
+	for _, entry := range entries {
+		if !list[entry] {
+			return false
+		}
+	}
+	return len(entries) > 0
+}
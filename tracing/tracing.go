@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	serviceName = "steps-virtual-device-testing-for-android"
+	// SPAN_KIND_INTERNAL of the OTLP specification
+	spanKindInternal = 1
+	// STATUS_CODE_ERROR of the OTLP specification
+	statusCodeError = 2
+)
+
+// Tracer records the spans of the phases of the step in a single trace, under a root span.
+// The methods of a nil Tracer do nothing, so the step does not have to check if tracing is enabled.
+type Tracer struct {
+	mu       sync.Mutex
+	traceID  string
+	root     *Span
+	spans    []*Span
+	resource map[string]string
+}
+
+// Span is a timed phase of the step.
+type Span struct {
+	tracer     *Tracer
+	id         string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	failed     bool
+}
+
+// NewTracer starts a new trace, its root span is named rootName.
+// The resource attributes describe the step run, like the build slug.
+func NewTracer(rootName string, resource map[string]string) *Tracer {
+	tracer := &Tracer{traceID: randomID(16), resource: resource}
+	tracer.root = &Span{tracer: tracer, id: randomID(8), name: rootName, start: time.Now(), attributes: map[string]string{}}
+	tracer.spans = append(tracer.spans, tracer.root)
+	return tracer
+}
+
+func randomID(length int) string {
+	id := make([]byte, length)
+	if _, err := rand.Read(id); err != nil {
+		// the IDs only have to be unique within the trace
+		now := time.Now().UnixNano()
+		for i := range id {
+			id[i] = byte(now >> (8 * uint(i%8)))
+		}
+	}
+	return hex.EncodeToString(id)
+}
+
+// Start starts a child span of the root span.
+func (tracer *Tracer) Start(name string) *Span {
+	if tracer == nil {
+		return nil
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	span := &Span{tracer: tracer, id: randomID(8), parentID: tracer.root.id, name: name, start: time.Now(), attributes: map[string]string{}}
+	tracer.spans = append(tracer.spans, span)
+	return span
+}
+
+// SetAttribute sets an attribute of the span, empty values are not recorded.
+func (span *Span) SetAttribute(key, value string) *Span {
+	if span == nil || value == "" {
+		return span
+	}
+
+	span.tracer.mu.Lock()
+	defer span.tracer.mu.Unlock()
+
+	span.attributes[key] = value
+	return span
+}
+
+// Fail marks the span as failed.
+func (span *Span) Fail() {
+	if span == nil {
+		return
+	}
+
+	span.tracer.mu.Lock()
+	defer span.tracer.mu.Unlock()
+
+	span.failed = true
+}
+
+// End ends the span, only the first call takes effect.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.tracer.mu.Lock()
+	defer span.tracer.mu.Unlock()
+
+	if span.end.IsZero() {
+		span.end = time.Now()
+	}
+}
+
+// Export ends the spans which are still running and sends the trace to the OTLP/HTTP endpoint (like: `http://localhost:4318`),
+// the `/v1/traces` path is appended to the endpoint unless it is already there.
+func (tracer *Tracer) Export(httpClient *http.Client, endpoint string, headers map[string]string, failed bool) error {
+	if tracer == nil {
+		return nil
+	}
+
+	if failed {
+		tracer.root.Fail()
+	}
+	body, err := json.Marshal(tracer.request())
+	if err != nil {
+		return fmt.Errorf("Failed to marshal traces, error: %s", err)
+	}
+
+	tracesURL := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(tracesURL, "/v1/traces") {
+		tracesURL += "/v1/traces"
+	}
+	req, err := http.NewRequest("POST", tracesURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to create http request, error: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to export traces, error: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body, error: %s", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Failed to export traces, status code: %d, body: %s", resp.StatusCode, string(responseBody))
+	}
+	return nil
+}
+
+// the OTLP/JSON request models
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code int `json:"code"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func attributes(values map[string]string) []keyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var kvs []keyValue
+	for _, key := range keys {
+		kvs = append(kvs, keyValue{Key: key, Value: anyValue{StringValue: values[key]}})
+	}
+	return kvs
+}
+
+func (tracer *Tracer) request() exportRequest {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	now := time.Now()
+	var spans []spanData
+	for _, span := range tracer.spans {
+		if span.end.IsZero() {
+			span.end = now
+		}
+		data := spanData{
+			TraceID:           tracer.traceID,
+			SpanID:            span.id,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        attributes(span.attributes),
+		}
+		if span.failed {
+			data.Status = &status{Code: statusCodeError}
+		}
+		spans = append(spans, data)
+	}
+
+	resourceAttributes := map[string]string{"service.name": serviceName}
+	for key, value := range tracer.resource {
+		resourceAttributes[key] = value
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: attributes(resourceAttributes)},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: serviceName}, Spans: spans}},
+	}}}
+}